@@ -0,0 +1,80 @@
+package libws
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// ReconnectPolicy holds the settings deciding how long the backoff layer waits before reconnecting.
+	ReconnectPolicy struct {
+		// Calculator returns the time to wait given the number of consecutive failed attempts.
+		Calculator func(attempts int) time.Duration
+		// ConnDurationThreshold is the minimum lifetime a connection must reach for its termination to be considered
+		// natural, which resets the attempts counter.
+		ConnDurationThreshold time.Duration
+	}
+
+	// SimEvent is a scripted disconnection: the connection lived for Lifetime and closed due to Err.
+	SimEvent struct {
+		Lifetime time.Duration
+		Err      error
+	}
+
+	// SimStep is the decision taken by a ReconnectPolicy after a SimEvent.
+	SimStep struct {
+		Attempts int
+		Wait     time.Duration
+		Reset    bool
+	}
+
+	// backoffState is the attempts counter of the backoff layer, carried across disconnections.
+	backoffState struct {
+		attempts int
+	}
+)
+
+// next decides how long to wait before reconnecting after a connection lived for lifetime and closed due to reason.
+// Connections closing naturally, that is, without error or because they were closed by either side, after living
+// longer than the threshold reset the counter; any other disconnection counts as a failed attempt. Connections torn
+// down for going silent, see WithIdleReadTimeout, close naturally and are reopened right away: the peer, not the
+// network, stopped sending. Rotated connections, see ErrRotated, were replaced on purpose: they are reopened right
+// away without counting as an attempt.
+func (s *backoffState) next(policy ReconnectPolicy, lifetime time.Duration, reason error) SimStep {
+	if errors.Is(reason, ErrRotated) {
+		return SimStep{Attempts: s.attempts}
+	}
+
+	idle := errors.Is(reason, ErrReadIdleTimeout)
+	natural := reason == nil || idle || errors.Is(reason, ErrConnectionClosed) || errors.Is(reason, ErrTerminated)
+
+	reset := natural && lifetime > policy.ConnDurationThreshold
+	if reset {
+		s.attempts = 0
+	} else {
+		s.attempts++
+	}
+
+	step := SimStep{Attempts: s.attempts, Wait: policy.Calculator(s.attempts), Reset: reset}
+	if idle {
+		step.Wait = 0
+	}
+
+	return step
+}
+
+// SimulateBackoff replays scenario through policy, with the same decision logic the backoff layer runs, and returns
+// the decision taken after every disconnection. Use it to review the wait schedule of a policy before deploying it.
+func SimulateBackoff(policy ReconnectPolicy, scenario []SimEvent) []SimStep {
+	var (
+		state backoffState
+		steps = make([]SimStep, 0, len(scenario))
+	)
+
+	for _, event := range scenario {
+		steps = append(steps, state.next(policy, event.Lifetime, event.Err))
+	}
+
+	return steps
+}
@@ -0,0 +1,118 @@
+package libws
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulateBackoff(t *testing.T) {
+	policy := ReconnectPolicy{Calculator: ExponentialBackoffSeconds, ConnDurationThreshold: time.Minute}
+
+	var (
+		short = time.Second
+		long  = time.Hour
+		other = errors.New("handshake refused")
+	)
+
+	tests := []struct {
+		name     string
+		scenario []SimEvent
+		want     []SimStep
+	}{
+		{
+			name: "flapping connection backs off exponentially",
+			scenario: []SimEvent{
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrConnectionClosed},
+			},
+			want: []SimStep{
+				{Attempts: 1, Wait: 0},
+				{Attempts: 2, Wait: time.Second},
+				{Attempts: 3, Wait: 3 * time.Second},
+				{Attempts: 4, Wait: 7 * time.Second},
+			},
+		},
+		{
+			name: "healthy connection resets the counter",
+			scenario: []SimEvent{
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: long, Err: ErrTerminated},
+				{Lifetime: short, Err: ErrConnectionClosed},
+			},
+			want: []SimStep{
+				{Attempts: 1, Wait: 0},
+				{Attempts: 2, Wait: time.Second},
+				{Attempts: 3, Wait: 3 * time.Second},
+				{Attempts: 0, Wait: 0, Reset: true},
+				{Attempts: 1, Wait: 0},
+			},
+		},
+		{
+			name: "clean close after a long lifetime resets the counter",
+			scenario: []SimEvent{
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: long},
+			},
+			want: []SimStep{
+				{Attempts: 1, Wait: 0},
+				{Attempts: 0, Wait: 0, Reset: true},
+			},
+		},
+		{
+			name: "other errors never reset the counter",
+			scenario: []SimEvent{
+				{Lifetime: long, Err: other},
+				{Lifetime: long, Err: other},
+				{Lifetime: long, Err: other},
+			},
+			want: []SimStep{
+				{Attempts: 1, Wait: 0},
+				{Attempts: 2, Wait: time.Second},
+				{Attempts: 3, Wait: 3 * time.Second},
+			},
+		},
+		{
+			name: "silent connections are reopened right away",
+			scenario: []SimEvent{
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrReadIdleTimeout},
+				{Lifetime: long, Err: ErrReadIdleTimeout},
+			},
+			want: []SimStep{
+				{Attempts: 1, Wait: 0},
+				{Attempts: 2, Wait: time.Second},
+				{Attempts: 3, Wait: 0},
+				{Attempts: 0, Wait: 0, Reset: true},
+			},
+		},
+		{
+			name: "rotated connections are reopened right away without counting",
+			scenario: []SimEvent{
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrConnectionClosed},
+				{Lifetime: short, Err: ErrRotated},
+				{Lifetime: short, Err: ErrConnectionClosed},
+			},
+			want: []SimStep{
+				{Attempts: 1, Wait: 0},
+				{Attempts: 2, Wait: time.Second},
+				{Attempts: 2, Wait: 0},
+				{Attempts: 3, Wait: 3 * time.Second},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, SimulateBackoff(policy, tt.scenario))
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package libws
+
+import (
+	"context"
+	"time"
+)
+
+// Sender sends messages, e.g. a Client, a ConnectionHandler or a SendLane.
+type Sender interface {
+	Send(m Message)
+}
+
+// TrySend sends m through s without waiting for room to send it: it returns false when s, or one of the layers below
+// it, cannot take m right away, e.g. while the connection is busy writing. Clients count such messages as rejected.
+// Messages held by a reconnecting layer, see NewBackoffConnectionHandlerFactory, count as sent. Senders unable to
+// tell are sent m with Send, which may block.
+func TrySend(s Sender, m Message) bool {
+	return sendContext(doneContext(), s, m) == nil
+}
+
+// SendTimeout sends m through s, waiting up to d for room to send it, as TrySend does without waiting. It returns
+// ErrBackpressure once d elapsed, or the reason m was refused. The clients and connection handlers of this package
+// honor d, other senders are sent m with Send, which may block past d.
+func SendTimeout(s Sender, m Message, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	return sendContext(ctx, s, m)
+}
+
+// doneContext returns a context which is already done, for sends not to wait.
+func doneContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return ctx
+}
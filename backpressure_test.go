@@ -0,0 +1,97 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWsConnection_TrySend(t *testing.T) {
+	// Until open, nothing takes messages off the send buffer.
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, serveUntilClosed), WithSendBufferSize(2))
+	defer conn.Close()
+
+	require.True(t, conn.TrySend(NewDataMessage([]byte("1"))))
+	require.True(t, conn.TrySend(NewDataMessage([]byte("2"))))
+	require.False(t, conn.TrySend(NewDataMessage([]byte("3"))))
+
+	start := time.Now()
+	require.ErrorIs(t, conn.SendTimeout(NewDataMessage([]byte("3")), 20*time.Millisecond), ErrBackpressure)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	unbuffered, _ := newTestWsConnection(t, newTestWsServer(t, serveUntilClosed))
+	defer unbuffered.Close()
+	require.False(t, unbuffered.TrySend(NewDataMessage([]byte("1"))))
+}
+
+func TestClient_TrySendUnderBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan []byte, 4096)
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		<-release
+		for {
+			_, bts, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- bts
+		}
+	})
+
+	u := testWsURL(t, srv)
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	cli := newBasicClient(
+		NewBackoffConnectionHandlerFactory(
+			logger,
+			NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(
+				logger, websocket.DefaultDialer, repo, ErrorAdapters{}, WithWriteTimeout(0), WithSendBufferSize(4),
+			)),
+			func(int) time.Duration { return 0 },
+			time.Minute,
+		),
+		func(Client, Message) {},
+		func(Client, EventType) {},
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	// Fill the socket, the connection buffer and the backoff queue, until the client pushes back.
+	payload := bytes.Repeat([]byte("x"), 64<<10)
+	accepted := 0
+	for ; accepted < 2048; accepted++ {
+		start := time.Now()
+		ok := TrySend(cli, NewDataMessage(payload))
+		require.Less(t, time.Since(start), 100*time.Millisecond, "TrySend blocked")
+		if !ok {
+			break
+		}
+	}
+	require.Less(t, accepted, 2048, "the pipeline never pushed back")
+	require.EqualValues(t, 1, cli.OutboundRejected())
+
+	// The socket may still drain meanwhile, making room.
+	if err := SendTimeout(cli, NewDataMessage(payload), 10*time.Millisecond); err != nil {
+		require.ErrorIs(t, err, ErrBackpressure)
+	} else {
+		accepted++
+	}
+
+	// Every accepted message is eventually written.
+	close(release)
+	for range accepted {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("accepted message never written")
+		}
+	}
+	require.True(t, TrySend(cli, NewDataMessage(payload)), "room is made once the peer reads")
+}
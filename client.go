@@ -22,7 +22,32 @@ type (
 
 	MessageHandler func(Client, Message)
 
+	// MessageHandlerE is a MessageHandler which reports processing failures. See NewBasicClientFactoryE.
+	MessageHandlerE func(Client, Message) error
+
+	// MessageHandlerCtx is a MessageHandler given a context to abort blocking work with, cancelled when the connection
+	// or the client closes. See NewBasicClientFactoryCtx.
+	MessageHandlerCtx func(context.Context, Client, Message)
+
+	// MessageErrorHandler is notified of errors returned by a MessageHandlerE.
+	MessageErrorHandler func(Client, Message, error)
+
+	// SendErrorHandler is notified of messages the client refused to send, along with the reason.
+	SendErrorHandler func(Client, Message, error)
+
+	// EventHandler is notified of the events of a client. It is not called anymore once Close returned, which waits
+	// for the calls in progress, hence Close must not be called from it.
 	EventHandler func(Client, EventType)
 
 	ClientFactory func() Client
 )
+
+// isClosed reports whether c has been closed, without blocking.
+func isClosed(c CloseChan) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,96 @@
+package libws
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// RecvInjector is implemented by clients able to pass a message through their inbound path as if it was read
+	// from the connection.
+	RecvInjector interface {
+		InjectRecv(m Message)
+	}
+
+	// clientConnectionHandler adapts a Client to the ConnectionHandler interface, see ClientAsConnectionHandler.
+	clientConnectionHandler struct {
+		client  Client
+		deliver func(Message)
+
+		closeOnce sync.Once
+		closeC    CloseChan
+	}
+)
+
+// ClientAsConnectionHandler adapts c to the ConnectionHandler interface, so that a fully composed client can be nested
+// as the leaf of another handler chain. Connect opens the client and Send sends through it. Recv injects messages
+// into the inbound path of the client when it is a RecvInjector, otherwise they are passed to deliver, if not nil.
+// Closing the handler closes the client, and the handler is closed once the client is.
+func ClientAsConnectionHandler(c Client, deliver func(Message)) ConnectionHandler {
+	return &clientConnectionHandler{
+		client:  c,
+		deliver: deliver,
+		closeC:  make(CloseChan),
+	}
+}
+
+func (h *clientConnectionHandler) Connect(ctx context.Context) error {
+	if err := h.client.Open(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case <-h.client.CloseChan():
+			h.Close()
+		case <-h.closeC:
+		}
+	}()
+
+	return nil
+}
+
+func (h *clientConnectionHandler) Send(m Message) {
+	h.client.Send(m)
+}
+
+// sendContext sends m through the client, which tells whether it was sent when it is a client of this package.
+func (h *clientConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	return sendContext(ctx, h.client, m)
+}
+
+func (h *clientConnectionHandler) Recv(m Message) {
+	if injector, ok := h.client.(RecvInjector); ok {
+		injector.InjectRecv(m)
+		return
+	}
+
+	if h.deliver != nil {
+		h.deliver(m)
+	}
+}
+
+func (h *clientConnectionHandler) Close() {
+	h.closeOnce.Do(func() {
+		h.client.Close()
+		close(h.closeC)
+	})
+}
+
+func (h *clientConnectionHandler) CloseChan() CloseChan {
+	return h.closeC
+}
+
+// CloseErr returns the close reason of the client, when it exposes one.
+func (h *clientConnectionHandler) CloseErr() error {
+	if c, ok := h.client.(interface{ CloseErr() error }); ok {
+		return c.CloseErr()
+	}
+
+	return nil
+}
+
+// ConnContext returns the connection-scoped context of the client, if it exposes one.
+func (h *clientConnectionHandler) ConnContext() context.Context {
+	return connContextOf(h.client)
+}
@@ -0,0 +1,109 @@
+package libws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newAdaptedClient returns a client over stub handlers, adapted to a ConnectionHandler, along with the messages its
+// handler received.
+func newAdaptedClient(t *testing.T) (ConnectionHandler, *stubConnectionHandlerFactory, *[]Message) {
+	t.Helper()
+
+	stubs := &stubConnectionHandlerFactory{}
+	received := &[]Message{}
+	cli := newBasicClient(stubs.Factory, func(_ Client, m Message) { *received = append(*received, m) }, func(Client, EventType) {})
+
+	return ClientAsConnectionHandler(cli, nil), stubs, received
+}
+
+func TestClientAsConnectionHandler(t *testing.T) {
+	testCases := []struct {
+		name string
+		run  func(t *testing.T, h ConnectionHandler, stubs *stubConnectionHandlerFactory, received *[]Message)
+	}{
+		{
+			name: "send reaches the connection",
+			run: func(t *testing.T, h ConnectionHandler, stubs *stubConnectionHandlerFactory, _ *[]Message) {
+				h.Send(NewDataMessage([]byte("order")))
+				require.Equal(t, []Message{NewDataMessage([]byte("order"))}, stubs.Last().Sent())
+			},
+		},
+		{
+			name: "recv goes through the inbound path",
+			run: func(t *testing.T, h ConnectionHandler, stubs *stubConnectionHandlerFactory, received *[]Message) {
+				h.Recv(NewDataMessage([]byte("trade")))
+				h.Recv(NewPingMessage(nil))
+
+				require.Equal(t, []Message{NewDataMessage([]byte("trade"))}, *received)
+				require.Equal(t, []Message{NewPingMessage(nil)}, stubs.Last().recv)
+			},
+		},
+		{
+			name: "close closes the client",
+			run: func(t *testing.T, h ConnectionHandler, stubs *stubConnectionHandlerFactory, _ *[]Message) {
+				h.Close()
+				h.Close()
+
+				require.True(t, isClosed(h.CloseChan()))
+				require.True(t, isClosed(stubs.Last().CloseChan()))
+			},
+		},
+		{
+			name: "client close closes the handler",
+			run: func(t *testing.T, h ConnectionHandler, stubs *stubConnectionHandlerFactory, _ *[]Message) {
+				stubs.Last().Kill(ErrConnectionClosed)
+
+				select {
+				case <-h.CloseChan():
+				case <-time.After(time.Second):
+					t.Fatal("handler must close along with the client")
+				}
+				require.ErrorIs(t, h.CloseErr(), ErrConnectionClosed)
+			},
+		},
+		{
+			name: "connection context",
+			run: func(t *testing.T, h ConnectionHandler, stubs *stubConnectionHandlerFactory, _ *[]Message) {
+				require.Equal(t, stubs.Last().ConnContext(), connContextOf(h))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, stubs, received := newAdaptedClient(t)
+			require.NoError(t, h.Connect(context.Background()))
+			defer h.Close()
+
+			tc.run(t, h, stubs, received)
+		})
+	}
+}
+
+func TestClientAsConnectionHandler_Nested(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	var received []Message
+	inner := newBasicClient(stubs.Factory, func(_ Client, m Message) { received = append(received, m) }, func(Client, EventType) {})
+
+	outer := newBasicClient(
+		func(Client, MessageHandler, emitter[EventType, EventType]) ConnectionHandler {
+			return ClientAsConnectionHandler(inner, nil)
+		},
+		func(Client, Message) {},
+		func(Client, EventType) {},
+	)
+	require.NoError(t, outer.Open(context.Background()))
+
+	outer.Send(NewDataMessage([]byte("nested")))
+	require.Equal(t, []Message{NewDataMessage([]byte("nested"))}, stubs.Last().Sent())
+
+	stubs.Last().Deliver(NewDataMessage([]byte("from the wire")))
+	require.Equal(t, []Message{NewDataMessage([]byte("from the wire"))}, received)
+
+	outer.Close()
+	require.True(t, isClosed(stubs.Last().CloseChan()))
+}
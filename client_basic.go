@@ -2,8 +2,155 @@ package libws
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	clientStateIdle int32 = iota
+	clientStateOpen
+	clientStateClosed
+)
+
+// defaultStrictHandlerBudget is the maximum time a message handler may take under strict mode.
+const defaultStrictHandlerBudget = 100 * time.Millisecond
+
+// ClientOption configures optional behavior of the clients created by NewBasicClientFactory.
+type ClientOption func(*basicClient)
+
+// WithStrictMode makes the client fail fast on misuse instead of silently degrading: nil constructor arguments and
+// calls out of order (Open twice, Send before Open...) panic or return errors with actionable messages, and message
+// handlers exceeding their duration budget panic. It is intended for tests and staging environments.
+func WithStrictMode() ClientOption {
+	return func(b *basicClient) {
+		b.strict = true
+	}
+}
+
+// WithHandlerBudget overrides the duration budget message handlers are held to under strict mode.
+func WithHandlerBudget(d time.Duration) ClientOption {
+	return func(b *basicClient) {
+		b.handlerBudget = d
+	}
+}
+
+// WithLogger sets the logger used by the client itself. By default, the client does not log.
+func WithLogger(l logger) ClientOption {
+	return func(b *basicClient) {
+		b.logger = orNop(l).WithField("type", "basicClient")
+	}
+}
+
+// WithOnMessageError sets the callback notified of errors returned by a MessageHandlerE, replacing the default one,
+// which logs a warning. Errors are counted and EventHandlerError is emitted regardless of the callback.
+func WithOnMessageError(fn MessageErrorHandler) ClientOption {
+	return func(b *basicClient) {
+		b.onMessageError = fn
+	}
+}
+
+// WithMessageErrorEscalation closes the connection once a MessageHandlerE returns errors for n consecutive messages.
+// A successfully handled message resets the streak.
+func WithMessageErrorEscalation(n int) ClientOption {
+	return func(b *basicClient) {
+		b.messageErrorEscalation = n
+	}
+}
+
+// WithOutboundValidator adds a validator run by Send on every non-control message before it is handed to the
+// connection. Rejected messages never reach the wire: they are counted, emitted as EventOutboundRejected and passed
+// to the callback set with WithOnSendError along with an error wrapping ErrInvalidOutbound. Validators run in the
+// order they were added.
+func WithOutboundValidator(v OutboundValidator) ClientOption {
+	return func(b *basicClient) {
+		b.outboundValidators = append(b.outboundValidators, v)
+	}
+}
+
+// WithOnSendError sets the callback notified of messages Send refused to send, replacing the default one, which logs
+// a warning.
+func WithOnSendError(fn SendErrorHandler) ClientOption {
+	return func(b *basicClient) {
+		b.onSendError = fn
+	}
+}
+
+// WithMaxOutboundSize makes Send refuse messages whose payload exceeds n bytes, e.g. the frame size limit of a venue,
+// rather than having the venue drop the connection. Refused messages never reach the wire: they are handled as those
+// rejected by an OutboundValidator, along with an error wrapping ErrMessageTooLarge. Control frames are not limited.
+func WithMaxOutboundSize(n int) ClientOption {
+	return func(b *basicClient) {
+		b.maxOutboundSize = n
+	}
+}
+
+// WithOutboundSplitter makes Send break messages over the WithMaxOutboundSize limit with split instead of refusing
+// them. Parts still over the limit are refused.
+func WithOutboundSplitter(split OutboundSplitter) ClientOption {
+	return func(b *basicClient) {
+		b.splitOutbound = split
+	}
+}
+
+// WithMessageClassifier makes the client keep statistics of inbound messages, data and control ones alike, per class
+// as named by classify. Retrieve them with MessageStats. At most 64 classes are tracked unless overridden with
+// WithMaxMessageClasses, further ones being accounted under MessageClassOverflow.
+func WithMessageClassifier(classify MessageClassifier) ClientOption {
+	return func(b *basicClient) {
+		b.classify = classify
+	}
+}
+
+// WithMaxMessageClasses overrides the maximum number of classes tracked by WithMessageClassifier.
+func WithMaxMessageClasses(n int) ClientOption {
+	return func(b *basicClient) {
+		b.maxMessageClasses = n
+	}
+}
+
+// WithTrafficAnomalyDetection makes the client watch the mix of inbound frame types, text, binary, control and
+// synthetic, over consecutive windows of window frames. When the share of a type changes by more than threshold,
+// from 0 to 1, from a window to the next one, e.g. binary frames going from none to 60% of them after a venue
+// upgrade, a warning is logged and EventTrafficAnomaly is emitted. Retrieve the anomaly with LastTrafficAnomaly.
+func WithTrafficAnomalyDetection(window int, threshold float64) ClientOption {
+	return func(b *basicClient) {
+		b.trafficAnomalies = newTrafficAnomalyDetector(window, threshold)
+	}
+}
+
+// WithMetricsCollector makes the client report its metrics to mc, e.g. the end-to-end latency of data messages, see
+// MetricsCollector.
+func WithMetricsCollector(mc MetricsCollector) ClientOption {
+	return func(b *basicClient) {
+		b.metrics = mc
+	}
+}
+
+// WithE2ELatencyBuckets overrides the upper bounds, in increasing order, of the buckets of the end-to-end latency
+// histogram, see E2ELatency. They default to DefaultE2ELatencyBuckets.
+func WithE2ELatencyBuckets(bounds ...time.Duration) ClientOption {
+	return func(b *basicClient) {
+		b.e2eLatencyBuckets = bounds
+	}
+}
+
+// WithCloseDump makes the client call fn exactly once when it terminally closes, be it because Close was called, the
+// connection gave up, or a message handler panicked, with a FinalReport of its state. See WriteFinalReport.
+func WithCloseDump(fn func(FinalReport)) ClientOption {
+	return func(b *basicClient) {
+		b.closeDump = fn
+	}
+}
+
+// withClock overrides the clock used by the client.
+func withClock(clk clock) ClientOption {
+	return func(b *basicClient) {
+		b.clock = clk
+	}
+}
+
 // basicClient is a client implementation with a single connection socket. It only forwards websocket 'data' messages to
 // the message messageHandler, whereas 'ping', 'pong' or 'close' messages will be passed down to connection handlers for handling.
 // IMPORTANT: Not to be wrapped with subscriber_static client. This client is intended to be use as a standalone client and
@@ -13,88 +160,735 @@ type basicClient struct {
 	connectionHandlerFactory ConnectionHandlerFactory
 	// connectionHandler is the active connection messageHandler
 	connectionHandler ConnectionHandler
+	// inbound is the entry of the inbound path, set on Open, see InjectRecv
+	inbound atomic.Pointer[MessageHandler]
 	// messageHandler is a messageHandler for processing incoming messages
 	messageHandler MessageHandler
 
 	eventHandler func(Client, EventType)
 
 	eventEmitter *EventEmitterCallback[EventType, EventType]
+	// dispatcher runs the queued event listeners registered by the layers
+	dispatcher *eventDispatcher
+
+	// handles holds the runtime-control handles registered by the connection handler layers
+	*handles
+
+	// state tracks the client lifecycle, see clientState* constants
+	state atomic.Int32
+	// listenOnce registers the event handler once, however many times Open is retried
+	listenOnce sync.Once
+	// strict turns misuse into panics and errors, see WithStrictMode
+	strict bool
+	// handlerBudget is the maximum duration of a message handler call under strict mode
+	handlerBudget time.Duration
+
+	logger logger
+
+	// onMessageError is notified of errors returned by a MessageHandlerE
+	onMessageError MessageErrorHandler
+	// messageErrors counts the errors returned by a MessageHandlerE
+	messageErrors atomic.Uint64
+	// consecutiveMessageErrors is the current streak of errors, reset by a successfully handled message
+	consecutiveMessageErrors atomic.Int64
+	// messageErrorEscalation is the streak length closing the connection, disabled when zero
+	messageErrorEscalation int
+
+	// handlersCtx is the parent of the contexts passed to a MessageHandlerCtx, cancelled on Close
+	handlersCtx    context.Context
+	cancelHandlers context.CancelFunc
+	// inFlight tracks the MessageHandlerCtx invocations Close waits for, up to drainTimeout
+	inFlight     *inFlightHandlers
+	drainTimeout time.Duration
+
+	// outboundValidators are run by Send before handing messages to the connection
+	outboundValidators []OutboundValidator
+	// onSendError is notified of messages Send refused to send
+	onSendError SendErrorHandler
+	// outboundRejected counts the messages Send refused to send
+	outboundRejected atomic.Uint64
+	// maxOutboundSize limits the payload of outbound messages, disabled when zero
+	maxOutboundSize int
+	// splitOutbound breaks messages over maxOutboundSize, which are refused when nil
+	splitOutbound OutboundSplitter
+	// lanes schedules outbound messages once a lane was created, see Lane
+	lanes   atomic.Pointer[laneScheduler]
+	lanesMu sync.Mutex
+	// lazyIdleClose enables connecting on demand, see WithLazyConnect; lazyMu serializes the implicit Open
+	lazyIdleClose time.Duration
+	lazyMu        sync.Mutex
+	// panicPolicy overrides the default PanicPolicy when set, see WithPanicPolicy
+	panicPolicy *PanicPolicy
+	// openProgress is told the stages of Open, see WithOpenProgress
+	openProgress OpenProgressFunc
+	// subscriptions restores persisted subscriptions on Open, see WithSubscriptionManager
+	subscriptions *SubscriptionManager
+	// rateBudget paces outbound messages when set, see WithRateBudget
+	rateBudget *RateBudget
+
+	clock clock
+
+	// classify and maxMessageClasses configure messageStats, see WithMessageClassifier
+	classify          MessageClassifier
+	maxMessageClasses int
+	// messageStats holds per class statistics of inbound messages, nil unless a classifier is set
+	messageStats *messageStats
+	// trafficAnomalies watches the mix of inbound frame types when set, see WithTrafficAnomalyDetection
+	trafficAnomalies *trafficAnomalyDetector
+	// metrics is notified of the client metrics when set, see WithMetricsCollector
+	metrics MetricsCollector
+	// e2eLatency is the end-to-end latency histogram of the current generation, bucketed by e2eLatencyBuckets
+	e2eLatency        *e2eLatency
+	e2eLatencyBuckets []time.Duration
+
+	// closeDump is called once on terminal close, see WithCloseDump
+	closeDump     func(FinalReport)
+	closeDumpOnce sync.Once
+	disconnects   disconnectHistory
+	// closeFrame is sent by the connections closed along with the client, see CloseWithReason
+	closeFrame atomic.Pointer[closeFrame]
+
+	// generations numbers the connections, generation is the newest one messages were delivered from
+	generations  atomic.Uint64
+	generation   atomic.Uint64
+	staleDropped atomic.Uint64
 }
 
 func (b *basicClient) createConnectionHandler(_ context.Context) {
-	handlerWrapper := func(cli Client, m Message) {
-		if m.Type().IsData() {
-			b.messageHandler(cli, m)
+	var handlerWrapper MessageHandler = func(cli Client, m Message) {
+		if b.closeDump != nil {
+			defer func() {
+				if r := recover(); r != nil {
+					b.dumpOnClose(fmt.Errorf("panic: %v", r))
+					panic(r)
+				}
+			}()
+		}
+
+		if b.messageStats != nil {
+			b.messageStats.observe(m)
+		}
+		b.observeGeneration(m)
+		b.observeTraffic(m)
+
+		if m.Type().IsData() || m.Type().IsSynthetic() {
+			b.handleMessage(cli, m)
+			b.observeE2ELatency(m)
 		} else {
 			b.connectionHandler.Recv(m)
 		}
 	}
 
-	b.connectionHandler = b.connectionHandlerFactory(b, handlerWrapper, b.eventEmitter)
+	factory := b.connectionHandlerFactory
+	if b.lazyIdleClose > 0 {
+		factory = newLazyConnectionHandlerFactory(b.logger, factory, b.lazyIdleClose, b.clock)
+	}
+
+	b.inbound.Store(&handlerWrapper)
+	b.connectionHandler = factory(b, handlerWrapper, b.eventEmitter)
 }
 
-func (b *basicClient) Open(ctx context.Context) error {
-	b.createConnectionHandler(ctx)
+func (b *basicClient) handleMessage(cli Client, m Message) {
+	if !b.strict {
+		b.messageHandler(cli, m)
+		return
+	}
 
-	b.eventEmitter.On(EventConnect, func(eventType EventType) {
-		b.eventHandler(b, eventType)
-	})
+	start := time.Now()
+	b.messageHandler(cli, m)
+	if elapsed := time.Since(start); elapsed > b.handlerBudget {
+		panic(fmt.Sprintf(
+			"libws: strict mode: message handler took %s, exceeding its budget of %s; "+
+				"offload slow work from the handler or raise the budget with WithHandlerBudget",
+			elapsed, b.handlerBudget,
+		))
+	}
+}
 
-	b.eventEmitter.On(EventClose, func(eventType EventType) {
-		b.eventHandler(b, eventType)
-	})
+// observeTraffic accounts for m in the mix of inbound frame types, reporting anomalies, see
+// WithTrafficAnomalyDetection.
+func (b *basicClient) observeTraffic(m Message) {
+	if b.trafficAnomalies == nil {
+		return
+	}
 
-	b.eventEmitter.On(EventReconnect, func(eventType EventType) {
-		b.eventHandler(b, eventType)
+	anomaly, ok := b.trafficAnomalies.observe(m)
+	if !ok {
+		return
+	}
+
+	b.logger.Warnf("inbound traffic shifted by %.0f%%: before %s, after %s",
+		100*anomaly.Shift, anomaly.Before, anomaly.After)
+	b.emit(EventTrafficAnomaly)
+}
+
+// observeE2ELatency accounts for the time from m being read from the socket to its handler returning. Messages which
+// were not read from a connection, e.g. injected ones, are not accounted.
+func (b *basicClient) observeE2ELatency(m Message) {
+	receivedAt := ReceivedAtOf(m)
+	if receivedAt.IsZero() {
+		return
+	}
+
+	generation := GenerationOf(m)
+	d := b.clock.Now().Sub(receivedAt)
+	b.e2eLatency.observe(generation, d)
+	if b.metrics != nil {
+		b.metrics.ObserveE2ELatency(generation, d)
+	}
+}
+
+// Open connects the client. A failed Open leaves the client idle, so that it can be retried. Opening a client open or
+// closed already does nothing, unless under strict mode, where it fails.
+func (b *basicClient) Open(ctx context.Context) (err error) {
+	if !b.state.CompareAndSwap(clientStateIdle, clientStateOpen) {
+		if !b.strict {
+			return nil
+		}
+		if b.state.Load() == clientStateClosed {
+			return fmt.Errorf("libws: strict mode: Open called after Close, create a new client instead: %w", ErrClientClosed)
+		}
+		return fmt.Errorf("libws: strict mode: Open called twice: %w", ErrAlreadyOpen)
+	}
+	defer func() {
+		if err != nil {
+			b.state.CompareAndSwap(clientStateOpen, clientStateIdle)
+		}
+	}()
+
+	if b.panicPolicy != nil {
+		ctx = withPanicPolicy(ctx, *b.panicPolicy)
+	}
+	if b.openProgress != nil {
+		progress := &openProgress{fn: b.openProgress}
+		defer progress.done.Store(true)
+		ctx = withOpenProgress(ctx, progress)
+	}
+
+	if b.subscriptions != nil {
+		if err := b.subscriptions.restoreStored(); err != nil {
+			return err
+		}
+	}
+
+	b.createConnectionHandler(ctx)
+
+	b.listenOnce.Do(func() {
+		for _, event := range []EventType{
+			EventConnect,
+			EventClose,
+			EventReconnect,
+			EventHandlerError,
+			EventOutboundRejected,
+			EventStandbyPromoted,
+			EventRotationAborted,
+			EventRotationTimeout,
+			EventRotationStitched,
+			EventSheddingStarted,
+			EventSheddingStopped,
+			EventOversizeSkipped,
+			EventLazyOpened,
+			EventIdleClosed,
+			EventInboundDropped,
+			EventTrafficAnomaly,
+			EventWriteDelayed,
+			EventLatencySample,
+			EventStaleTraffic,
+		} {
+			b.eventEmitter.On(event, func(eventType EventType) {
+				if eventType == EventReconnect || eventType == EventStandbyPromoted {
+					b.recordDisconnect(eventType)
+				}
+				b.eventHandler(b, eventType)
+			})
+		}
 	})
 
 	if err := b.connectionHandler.Connect(ctx); err != nil {
 		return err
 	}
+	ReportOpenProgress(ctx, OpenReady, "")
+
+	if b.closeDump != nil {
+		go func() {
+			<-b.connectionHandler.CloseChan()
+			b.dumpOnClose(b.connectionHandler.CloseErr())
+		}()
+	}
 
 	return nil
 }
 
 func (b *basicClient) Send(m Message) {
-	b.connectionHandler.Send(m)
+	_ = b.sendContext(context.Background(), m)
+}
+
+// sendContext sends m as Send does, giving up with ErrBackpressure once ctx is done, see TrySend. Refused messages
+// are rejected, see WithOnSendError.
+func (b *basicClient) sendContext(ctx context.Context, m Message) error {
+	if b.lazyIdleClose > 0 {
+		if err := b.openLazily(); err != nil {
+			b.rejectOutbound(m, err)
+			return err
+		}
+	}
+
+	if b.strict {
+		b.assertOpen("Send")
+	}
+
+	// Control frames are not subject to lanes, which would delay them.
+	if lanes := b.lanes.Load(); lanes != nil && !m.Type().IsControl() {
+		lane := lanes.lane(DefaultLaneName, 1)
+		if ctx.Done() == nil {
+			lane.Send(m)
+			return nil
+		}
+		if err := lane.sendContext(ctx, m); err != nil {
+			b.rejectOutbound(m, err)
+			return err
+		}
+		return nil
+	}
+
+	return b.sendAs(ctx, m, RatePriorityUser)
+}
+
+// Lane returns the send lane named name, creating it with the given weight if it does not exist yet. Once a lane
+// exists, outbound messages are queued per lane and handed to the connection with weighted round robin, so that a
+// lane of weight 3 gets three writes for every one of a saturated lane of weight 1. Send enqueues to the lane named
+// DefaultLaneName, of weight 1 unless created beforehand. Each lane holds up to 256 messages, dropping further ones.
+func (b *basicClient) Lane(name string, weight int) SendLane {
+	b.lanesMu.Lock()
+	defer b.lanesMu.Unlock()
+
+	lanes := b.lanes.Load()
+	if lanes == nil {
+		lanes = newLaneScheduler(b.logger, b.send)
+		b.lanes.Store(lanes)
+	}
+
+	return lanes.lane(name, weight)
+}
+
+// LaneStats returns the counters of every send lane, in creation order.
+func (b *basicClient) LaneStats() []LaneStats {
+	if lanes := b.lanes.Load(); lanes != nil {
+		return lanes.stats()
+	}
+
+	return nil
+}
+
+// openLazily opens the client on its first use, see WithLazyConnect.
+func (b *basicClient) openLazily() error {
+	b.lazyMu.Lock()
+	defer b.lazyMu.Unlock()
+
+	if b.state.Load() != clientStateIdle {
+		return nil
+	}
+
+	return b.Open(context.Background())
+}
+
+// send validates and hands m to the connection, as a user message.
+func (b *basicClient) send(m Message) {
+	_ = b.sendAs(context.Background(), m, RatePriorityUser)
+}
+
+// sendAs validates and hands m to the connection, drawing from the rate budget, if any, with priority p. It returns
+// the reason m, or the first of its parts when split, was rejected.
+func (b *basicClient) sendAs(ctx context.Context, m Message, p RatePriority) error {
+	if err := checkWritable(m); err != nil {
+		b.rejectOutbound(m, err)
+		return err
+	}
+
+	if err := b.validateOutbound(m); err != nil {
+		b.rejectOutbound(m, err)
+		return err
+	}
+
+	if c, ok := b.connectionHandler.(onDemandConnector); ok {
+		if err := c.ensureConnected(); err != nil {
+			b.rejectOutbound(m, err)
+			return err
+		}
+	}
+
+	if err := checkOutboundSize(m, b.maxOutboundSize); err != nil {
+		if b.splitOutbound == nil {
+			b.rejectOutbound(m, err)
+			return err
+		}
+
+		var first error
+		for _, part := range b.splitOutbound(m, b.maxOutboundSize) {
+			err := checkOutboundSize(part, b.maxOutboundSize)
+			if err != nil {
+				b.rejectOutbound(part, err)
+			} else {
+				err = b.handOver(ctx, part, p)
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	}
+
+	return b.handOver(ctx, m, p)
+}
+
+// handOver sends m through the connection handler once the rate budget allows it, rejecting it when the connection
+// reports it was not sent. Control frames always draw from the budget as such.
+func (b *basicClient) handOver(ctx context.Context, m Message, p RatePriority) error {
+	if b.rateBudget != nil {
+		if m.Type().IsControl() {
+			p = RatePriorityControl
+		}
+		if err := b.rateBudget.Wait(ctx, p); err != nil {
+			err = fmt.Errorf("%w: rate budget exhausted: %w", ErrBackpressure, err)
+			b.rejectOutbound(m, err)
+			return err
+		}
+	}
+
+	if err := sendContext(ctx, b.connectionHandler, m); err != nil {
+		b.rejectOutbound(m, err)
+		return err
+	}
+
+	return nil
+}
+
+func (b *basicClient) validateOutbound(m Message) error {
+	if m.Type().IsControl() {
+		return nil
+	}
+
+	for _, validate := range b.outboundValidators {
+		if err := validate(m); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidOutbound, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *basicClient) rejectOutbound(m Message, err error) {
+	b.outboundRejected.Add(1)
+	b.onSendError(b, m, err)
+	b.eventEmitter.Emit(EventOutboundRejected, EventOutboundRejected)
+}
+
+// MessageStats returns the statistics of inbound messages per class, or nil unless WithMessageClassifier was given.
+func (b *basicClient) MessageStats() map[string]MessageClassStats {
+	if b.messageStats == nil {
+		return nil
+	}
+
+	return b.messageStats.snapshot()
+}
+
+// LastTrafficAnomaly returns the latest shift of the mix of inbound frame types, if any was detected, see
+// WithTrafficAnomalyDetection.
+func (b *basicClient) LastTrafficAnomaly() (TrafficAnomaly, bool) {
+	if b.trafficAnomalies == nil {
+		return TrafficAnomaly{}, false
+	}
+
+	return b.trafficAnomalies.lastAnomaly()
+}
+
+// E2ELatency returns the histogram of the end-to-end latency, from socket read to the message handler returning, of
+// the data messages read on the newest connection generation. It starts over with every new generation, so that a
+// bad connection stands out.
+func (b *basicClient) E2ELatency() LatencyHistogram {
+	return b.e2eLatency.snapshot()
+}
+
+// InjectRecv passes m through the inbound path of the client as if it was read from the connection. It does nothing
+// before Open.
+func (b *basicClient) InjectRecv(m Message) {
+	if inbound := b.inbound.Load(); inbound != nil {
+		(*inbound)(b, m)
+	}
+}
+
+// CloseErr returns the reason the connection closed, nil before Open or while open.
+func (b *basicClient) CloseErr() error {
+	if b.connectionHandler == nil {
+		return nil
+	}
+
+	return b.connectionHandler.CloseErr()
+}
+
+// Generation returns the generation of the newest connection messages were delivered from, see MetaMessage.
+func (b *basicClient) Generation() uint64 {
+	return b.generation.Load()
+}
+
+// StaleDropped returns how many messages DropStale discarded.
+func (b *basicClient) StaleDropped() uint64 {
+	return b.staleDropped.Load()
+}
+
+func (b *basicClient) nextGeneration() uint64 {
+	return b.generations.Add(1)
+}
+
+func (b *basicClient) countStale() {
+	b.staleDropped.Add(1)
+}
+
+// observeGeneration advances the current generation to the one m was read on, if newer.
+func (b *basicClient) observeGeneration(m Message) {
+	generation := GenerationOf(m)
+	for {
+		current := b.generation.Load()
+		if generation <= current || b.generation.CompareAndSwap(current, generation) {
+			return
+		}
+	}
+}
+
+// listen registers a listener of a layer, see listenEvent.
+func (b *basicClient) listen(event EventType, mode listenerMode, fn func(EventType)) {
+	b.dispatcher.listen(b.eventEmitter, event, mode, fn)
+}
+
+// emit emits event as if a layer did, see emitEvent.
+func (b *basicClient) emit(event EventType) {
+	b.eventEmitter.Emit(event, event)
 }
 
+// OutboundRejected returns how many messages Send refused to send so far.
+func (b *basicClient) OutboundRejected() uint64 {
+	return b.outboundRejected.Load()
+}
+
+// Close closes the client. Once it returns, the EventHandler is not called anymore, events emitted meanwhile, e.g. by
+// a reconnection completing, being dropped. Opening it again is not supported.
 func (b *basicClient) Close() {
+	b.state.Store(clientStateClosed)
+	b.drainHandlers()
+
 	if b.eventEmitter != nil {
 		b.eventEmitter.Close()
 	}
+	b.dispatcher.close()
+	if lanes := b.lanes.Load(); lanes != nil {
+		lanes.close()
+	}
 	if b.connectionHandler != nil {
 		b.connectionHandler.Close()
+		b.dumpOnClose(b.connectionHandler.CloseErr())
 	}
 }
 
+// recordDisconnect adds eventType to the disconnect history, along with the latest dial when known.
+func (b *basicClient) recordDisconnect(eventType EventType) {
+	record := DisconnectRecord{At: b.clock.Now(), Event: eventType}
+	if control, ok := Handle[*ConnectionControl](b); ok {
+		if dial, ok := control.latestDial(); ok {
+			record.URL, record.Attempt = dial.url, dial.attempt
+		}
+	}
+
+	b.disconnects.add(record)
+}
+
+// dumpOnClose calls the close dump, if any, the first time it is called.
+func (b *basicClient) dumpOnClose(err error) {
+	if b.closeDump == nil {
+		return
+	}
+
+	b.closeDumpOnce.Do(func() {
+		report := FinalReport{
+			ClosedAt: b.clock.Now(),
+			Config:   b.Config(),
+			Stats: ClientStats{
+				MessageErrors:    b.MessageErrors(),
+				OutboundRejected: b.OutboundRejected(),
+				Messages:         b.MessageStats(),
+			},
+			Disconnects: b.disconnects.snapshot(),
+		}
+		if err != nil {
+			report.Error = err.Error()
+		}
+
+		b.closeDump(report)
+	})
+}
+
 func (b *basicClient) CloseChan() CloseChan {
+	if b.strict && b.connectionHandler == nil {
+		panic("libws: strict mode: CloseChan called before Open; there is no connection to wait for yet")
+	}
+
 	return b.connectionHandler.CloseChan()
 }
 
+// ConnContext returns the context scoped to the current physical connection, see ConnContextProvider. It returns nil
+// before Open or when the composed layers do not expose one.
+func (b *basicClient) ConnContext() context.Context {
+	if b.connectionHandler == nil {
+		return nil
+	}
+
+	return connContextOf(b.connectionHandler)
+}
+
+// Config returns a snapshot of the effective configuration of the client and the layers composing it. Layers are
+// only known once the client is open.
+func (b *basicClient) Config() ConfigSnapshot {
+	snapshot := ConfigSnapshot{
+		Client: ConfigSection{
+			Layer: "basic_client",
+			Settings: map[string]any{
+				"strict_mode":              b.strict,
+				"handler_budget":           b.handlerBudget.String(),
+				"message_error_escalation": b.messageErrorEscalation,
+			},
+		},
+	}
+
+	if b.connectionHandler != nil {
+		snapshot.Layers = collectConfig(b.connectionHandler)
+	}
+
+	return snapshot
+}
+
+// MessageErrors returns how many errors the MessageHandlerE has returned so far.
+func (b *basicClient) MessageErrors() uint64 {
+	return b.messageErrors.Load()
+}
+
+// adaptMessageHandlerE turns h into a MessageHandler routing its errors through the client.
+func (b *basicClient) adaptMessageHandlerE(h MessageHandlerE) MessageHandler {
+	return func(cli Client, m Message) {
+		err := h(cli, m)
+		if err == nil {
+			b.consecutiveMessageErrors.Store(0)
+			return
+		}
+
+		b.messageErrors.Add(1)
+		b.onMessageError(cli, m, err)
+		b.eventEmitter.Emit(EventHandlerError, EventHandlerError)
+
+		streak := b.consecutiveMessageErrors.Add(1)
+		if b.messageErrorEscalation > 0 && streak == int64(b.messageErrorEscalation) {
+			b.logger.Errorf("closing connection after %d consecutive message handler errors, last: %s", streak, err)
+			b.connectionHandler.Close()
+		}
+	}
+}
+
+func (b *basicClient) warnMessageError(_ Client, m Message, err error) {
+	b.logger.Warnf("message handler error: %s, message: %s", err, m)
+}
+
+func (b *basicClient) warnSendError(_ Client, m Message, err error) {
+	b.logger.Warnf("refused to send message: %s, message: %s", err, m)
+}
+
+func (b *basicClient) assertOpen(method string) {
+	switch b.state.Load() {
+	case clientStateIdle:
+		panic("libws: strict mode: " + method + " called before Open; open the client first")
+	case clientStateClosed:
+		panic("libws: strict mode: " + method + " called after Close; the client cannot be reused")
+	}
+}
+
+func (b *basicClient) validate() {
+	switch {
+	case b.connectionHandlerFactory == nil:
+		panic("libws: strict mode: nil ConnectionHandlerFactory passed to NewBasicClientFactory")
+	case b.messageHandler == nil:
+		panic("libws: strict mode: nil MessageHandler passed to NewBasicClientFactory")
+	case b.eventHandler == nil:
+		panic("libws: strict mode: nil EventHandler passed to NewBasicClientFactory; pass a no-op func to ignore events")
+	}
+}
+
 func newBasicClient(
 	connHandlerFactory ConnectionHandlerFactory,
 	messageHandler MessageHandler,
 	eventHandler EventHandler,
+	opts ...ClientOption,
 ) *basicClient {
-	return &basicClient{
+	b := &basicClient{
 		messageHandler:           messageHandler,
 		eventHandler:             eventHandler,
 		connectionHandlerFactory: connHandlerFactory,
 		eventEmitter:             NewEventEmitter[EventType, EventType](),
+		handles:                  newHandles(),
+		handlerBudget:            defaultStrictHandlerBudget,
+		logger:                   nopLogger{},
+		clock:                    realClock{},
+		maxMessageClasses:        defaultMaxMessageClasses,
+		e2eLatencyBuckets:        DefaultE2ELatencyBuckets,
+		drainTimeout:             defaultDrainTimeout,
 	}
+	b.onMessageError = b.warnMessageError
+	b.onSendError = b.warnSendError
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	b.dispatcher = newEventDispatcher(b.logger, defaultDispatchQueueSize)
+	b.e2eLatency = newE2ELatency(b.e2eLatencyBuckets)
+
+	if b.classify != nil {
+		b.messageStats = newMessageStats(b.classify, b.maxMessageClasses, b.clock)
+	}
+
+	if b.strict {
+		b.validate()
+	}
+
+	return b
 }
 
 func NewBasicClientFactory(
 	connHandlerFactory ConnectionHandlerFactory,
 	messageHandler MessageHandler,
 	eventHandler EventHandler,
+	opts ...ClientOption,
 ) ClientFactory {
 	return func() Client {
 		return newBasicClient(
 			connHandlerFactory,
 			messageHandler,
 			eventHandler,
+			opts...,
 		)
 	}
 }
+
+// NewBasicClientFactoryE is like NewBasicClientFactory but takes a MessageHandlerE. Errors it returns are counted,
+// emitted as EventHandlerError and passed to the callback set with WithOnMessageError.
+func NewBasicClientFactoryE(
+	connHandlerFactory ConnectionHandlerFactory,
+	messageHandler MessageHandlerE,
+	eventHandler EventHandler,
+	opts ...ClientOption,
+) ClientFactory {
+	adapt := func(b *basicClient) {
+		if messageHandler != nil {
+			b.messageHandler = b.adaptMessageHandlerE(messageHandler)
+		}
+	}
+	opts = append(opts[:len(opts):len(opts)], adapt)
+
+	return NewBasicClientFactory(connHandlerFactory, nil, eventHandler, opts...)
+}
@@ -0,0 +1,297 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newStrictTestClient(t *testing.T, messageHandler MessageHandler, opts ...ClientOption) *basicClient {
+	t.Helper()
+
+	stubs := &stubConnectionHandlerFactory{}
+	opts = append([]ClientOption{WithStrictMode()}, opts...)
+
+	return newBasicClient(stubs.Factory, messageHandler, func(Client, EventType) {}, opts...)
+}
+
+func TestStrictMode_ConstructorValidation(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	noopMessages := func(Client, Message) {}
+	noopEvents := func(Client, EventType) {}
+
+	require.PanicsWithValue(t,
+		"libws: strict mode: nil ConnectionHandlerFactory passed to NewBasicClientFactory",
+		func() { NewBasicClientFactory(nil, noopMessages, noopEvents, WithStrictMode())() },
+	)
+	require.PanicsWithValue(t,
+		"libws: strict mode: nil MessageHandler passed to NewBasicClientFactory",
+		func() { NewBasicClientFactory(stubs.Factory, nil, noopEvents, WithStrictMode())() },
+	)
+	require.PanicsWithValue(t,
+		"libws: strict mode: nil EventHandler passed to NewBasicClientFactory; pass a no-op func to ignore events",
+		func() { NewBasicClientFactory(stubs.Factory, noopMessages, nil, WithStrictMode())() },
+	)
+
+	// Lenient mode keeps accepting them.
+	require.NotPanics(t, func() { NewBasicClientFactory(stubs.Factory, nil, nil)() })
+}
+
+func TestStrictMode_OpenTwice(t *testing.T) {
+	cli := newStrictTestClient(t, func(Client, Message) {})
+
+	require.NoError(t, cli.Open(context.Background()))
+	err := cli.Open(context.Background())
+	require.ErrorIs(t, err, ErrAlreadyOpen)
+	require.EqualError(t, err, "libws: strict mode: Open called twice: client already open")
+}
+
+func TestStrictMode_OpenRetry(t *testing.T) {
+	errDial := errors.New("dial refused")
+	stubs := &stubConnectionHandlerFactory{FailConnects: 1, ConnectErr: errDial}
+	var events atomic.Int32
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(_ Client, eventType EventType) {
+		if eventType == EventConnect {
+			events.Add(1)
+		}
+	}, WithStrictMode())
+	defer cli.Close()
+
+	require.ErrorIs(t, cli.Open(context.Background()), errDial)
+	require.NoError(t, cli.Open(context.Background()), "a failed Open is to be retried")
+	require.ErrorIs(t, cli.Open(context.Background()), ErrAlreadyOpen)
+
+	cli.eventEmitter.Emit(EventConnect, EventConnect)
+	require.EqualValues(t, 1, events.Load(), "the event handler was registered by every Open")
+}
+
+func TestBasicClient_OpenTwice(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {})
+	defer cli.Close()
+
+	require.NoError(t, cli.Open(context.Background()))
+	stubs.FailConnects, stubs.ConnectErr = 2, errors.New("dial refused")
+	require.NoError(t, cli.Open(context.Background()))
+
+	require.Len(t, stubs.Handlers(), 1, "the running connection handler was replaced")
+	require.Equal(t, clientStateOpen, cli.state.Load())
+}
+
+func TestStrictMode_OpenAfterClose(t *testing.T) {
+	cli := newStrictTestClient(t, func(Client, Message) {})
+
+	require.NoError(t, cli.Open(context.Background()))
+	cli.Close()
+	require.ErrorIs(t, cli.Open(context.Background()), ErrClientClosed)
+}
+
+func TestStrictMode_SendOutOfOrder(t *testing.T) {
+	cli := newStrictTestClient(t, func(Client, Message) {})
+
+	require.PanicsWithValue(t,
+		"libws: strict mode: Send called before Open; open the client first",
+		func() { cli.Send(NewDataMessage(nil)) },
+	)
+
+	require.NoError(t, cli.Open(context.Background()))
+	require.NotPanics(t, func() { cli.Send(NewDataMessage(nil)) })
+
+	cli.Close()
+	require.PanicsWithValue(t,
+		"libws: strict mode: Send called after Close; the client cannot be reused",
+		func() { cli.Send(NewDataMessage(nil)) },
+	)
+}
+
+func TestStrictMode_CloseChanBeforeOpen(t *testing.T) {
+	cli := newStrictTestClient(t, func(Client, Message) {})
+
+	require.PanicsWithValue(t,
+		"libws: strict mode: CloseChan called before Open; there is no connection to wait for yet",
+		func() { cli.CloseChan() },
+	)
+}
+
+func TestStrictMode_HandlerBudget(t *testing.T) {
+	slow := func(Client, Message) { time.Sleep(20 * time.Millisecond) }
+	cli := newStrictTestClient(t, slow, WithHandlerBudget(5*time.Millisecond))
+	require.NoError(t, cli.Open(context.Background()))
+
+	defer func() {
+		r := recover()
+		require.NotNil(t, r, "a handler exceeding its budget must panic")
+		require.Contains(t, r, "libws: strict mode: message handler took")
+		require.Contains(t, r, "exceeding its budget of 5ms")
+	}()
+
+	cli.handleMessage(cli, NewDataMessage(nil))
+}
+
+func TestMessageHandlerE_ErrorsRoutedToCallback(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	errBoom := errors.New("boom")
+
+	var (
+		events []EventType
+		failed []Message
+	)
+
+	cli := NewBasicClientFactoryE(
+		stubs.Factory,
+		func(_ Client, m Message) error {
+			if string(m.Data()) == "bad" {
+				return errBoom
+			}
+			return nil
+		},
+		func(_ Client, e EventType) { events = append(events, e) },
+		WithOnMessageError(func(_ Client, m Message, err error) {
+			require.ErrorIs(t, err, errBoom)
+			failed = append(failed, m)
+		}),
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+
+	bad := NewDataMessage([]byte("bad"))
+	stubs.Last().Deliver(NewDataMessage([]byte("good")))
+	stubs.Last().Deliver(bad)
+	stubs.Last().Deliver(bad)
+
+	require.Equal(t, []Message{bad, bad}, failed)
+	require.Equal(t, []EventType{EventHandlerError, EventHandlerError}, events)
+	require.EqualValues(t, 2, cli.(interface{ MessageErrors() uint64 }).MessageErrors())
+}
+
+func TestMessageHandlerE_DefaultCallbackLogs(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	var out bytes.Buffer
+
+	cli := NewBasicClientFactoryE(
+		stubs.Factory,
+		func(Client, Message) error { return errors.New("boom") },
+		func(Client, EventType) {},
+		WithLogger(newTestLogger(&out)),
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+
+	stubs.Last().Deliver(NewDataMessage([]byte("payload")))
+
+	require.Contains(t, out.String(), "WARN")
+	require.Contains(t, out.String(), "message handler error: boom")
+}
+
+func TestMessageHandlerE_Escalation(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	fail := true
+
+	cli := NewBasicClientFactoryE(
+		stubs.Factory,
+		func(Client, Message) error {
+			if fail {
+				return errors.New("boom")
+			}
+			return nil
+		},
+		func(Client, EventType) {},
+		WithMessageErrorEscalation(3),
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+
+	conn := stubs.Last()
+	msg := NewDataMessage(nil)
+
+	conn.Deliver(msg)
+	conn.Deliver(msg)
+	fail = false
+	conn.Deliver(msg)
+	fail = true
+	conn.Deliver(msg)
+	conn.Deliver(msg)
+	require.NoError(t, conn.CloseErr(), "a success must reset the streak")
+
+	conn.Deliver(msg)
+	select {
+	case <-conn.CloseChan():
+	default:
+		t.Fatal("connection must be closed after 3 consecutive errors")
+	}
+}
+
+func TestNewBasicClientFactory_UntouchedByMessageErrors(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	var got []Message
+
+	cli := NewBasicClientFactory(
+		stubs.Factory,
+		func(_ Client, m Message) { got = append(got, m) },
+		func(Client, EventType) {},
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+
+	msg := NewDataMessage([]byte("hello"))
+	stubs.Last().Deliver(msg)
+	require.Equal(t, []Message{msg}, got)
+	require.Zero(t, cli.(interface{ MessageErrors() uint64 }).MessageErrors())
+}
+
+func TestBasicClient_NoEventsAfterClose(t *testing.T) {
+	for range 50 {
+		var (
+			closed     atomic.Bool
+			events     atomic.Int32
+			afterClose atomic.Int32
+		)
+		stubs := &stubConnectionHandlerFactory{}
+		cli := newBasicClient(
+			NewBackoffConnectionHandlerFactory(nil, stubs.Factory, func(int) time.Duration { return 0 }, time.Minute),
+			func(Client, Message) {},
+			func(Client, EventType) {
+				events.Add(1)
+				if closed.Load() {
+					afterClose.Add(1)
+				}
+			},
+		)
+		require.NoError(t, cli.Open(context.Background()))
+
+		stop := make(chan struct{})
+		stormed := make(chan struct{})
+		go func() {
+			defer close(stormed)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if h := stubs.Last(); h != nil {
+					h.Kill(ErrConnectionClosed)
+				}
+				time.Sleep(10 * time.Microsecond)
+			}
+		}()
+
+		require.Eventually(t, func() bool { return events.Load() > 0 }, 2*time.Second, 10*time.Microsecond)
+		cli.Close()
+		closed.Store(true)
+
+		// Reconnections completing after Close, and emissions already scheduled, must not reach the handler.
+		time.Sleep(5 * time.Millisecond)
+		cli.emit(EventReconnect)
+		close(stop)
+		<-stormed
+		require.Zero(t, afterClose.Load())
+
+		// Nor are handlers registered once closed.
+		require.NoError(t, cli.Open(context.Background()))
+		cli.emit(EventReconnect)
+		require.Zero(t, afterClose.Load())
+		cli.Close()
+	}
+}
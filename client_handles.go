@@ -0,0 +1,76 @@
+package libws
+
+import (
+	"reflect"
+	"sync"
+)
+
+type (
+	// handleRegistry is implemented by clients which let the layers composing them expose runtime-control handles.
+	handleRegistry interface {
+		// registerHandle registers h unless a handle of the same type already exists, and returns the registered one.
+		registerHandle(h any) any
+		// lookupHandle returns the handle registered for the given type.
+		lookupHandle(t reflect.Type) (any, bool)
+	}
+
+	// handles is a handleRegistry keyed by the dynamic type of each handle.
+	handles struct {
+		mu      sync.RWMutex
+		entries map[reflect.Type]any
+	}
+)
+
+func newHandles() *handles {
+	return &handles{entries: make(map[reflect.Type]any)}
+}
+
+func (r *handles) registerHandle(h any) any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := reflect.TypeOf(h)
+	if existing, ok := r.entries[t]; ok {
+		return existing
+	}
+
+	r.entries[t] = h
+	return h
+}
+
+func (r *handles) lookupHandle(t reflect.Type) (any, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	h, ok := r.entries[t]
+	return h, ok
+}
+
+// registerHandle registers h on the client, if it supports handles. When the client already holds a handle of the
+// same type, that one is returned instead, so that handles stay stable when layers are rebuilt on reconnection.
+func registerHandle[T any](c Client, h T) T {
+	registry, ok := c.(handleRegistry)
+	if !ok {
+		return h
+	}
+
+	return registry.registerHandle(h).(T)
+}
+
+// Handle returns the runtime-control handle of type T registered by one of the layers composing c, e.g.
+// Handle[*ReopenControl](c). Handles are registered when the layers are created, that is, once the client is open.
+func Handle[T any](c Client) (T, bool) {
+	var zero T
+
+	registry, ok := c.(handleRegistry)
+	if !ok {
+		return zero, false
+	}
+
+	h, ok := registry.lookupHandle(reflect.TypeOf((*T)(nil)).Elem())
+	if !ok {
+		return zero, false
+	}
+
+	return h.(T), true
+}
@@ -0,0 +1,62 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle_ComposedClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger := newTestLogger(io.Discard)
+	stubs := &stubConnectionHandlerFactory{}
+
+	factory := NewBackoffConnectionHandlerFactory(
+		logger,
+		NewReopenIntervalConnFactory(logger, time.Hour, stubs.Factory),
+		func(int) time.Duration { return 0 },
+		time.Minute,
+	)
+
+	cli := NewBasicClientFactory(factory, func(Client, Message) {}, func(Client, EventType) {})()
+
+	_, ok := Handle[*ReopenControl](cli)
+	require.False(t, ok, "handles are registered once the client is open")
+
+	require.NoError(t, cli.Open(ctx))
+	defer cli.Close()
+
+	reopen, ok := Handle[*ReopenControl](cli)
+	require.True(t, ok)
+	backoff, ok := Handle[*BackoffControl](cli)
+	require.True(t, ok)
+
+	require.Len(t, stubs.Handlers(), 1)
+	reopen.RotateNow()
+	require.Eventually(t, func() bool { return len(stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+
+	require.Equal(t, time.Minute, backoff.ConnDurationThreshold())
+	backoff.SetConnDurationThreshold(time.Second)
+	require.Equal(t, time.Second, backoff.ConnDurationThreshold())
+
+	// Killing the reopen layer's current connection rebuilds it, but the handle keeps working.
+	stubs.Last().Kill(ErrConnectionClosed)
+	require.Eventually(t, func() bool { return len(stubs.Handlers()) == 3 }, time.Second, time.Millisecond)
+
+	again, ok := Handle[*ReopenControl](cli)
+	require.True(t, ok)
+	require.Same(t, reopen, again)
+
+	reopen.RotateNow()
+	require.Eventually(t, func() bool { return len(stubs.Handlers()) == 4 }, time.Second, time.Millisecond)
+}
+
+func TestHandle_UnsupportedClient(t *testing.T) {
+	_, ok := Handle[*BackoffControl](&mockClient{})
+	require.False(t, ok)
+}
@@ -0,0 +1,80 @@
+package libws
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// clock abstracts time so that time-driven components can be exercised deterministically in tests.
+	clock interface {
+		Now() time.Time
+		AfterFunc(d time.Duration, f func()) clockTimer
+	}
+
+	// clockTimer is the subset of *time.Timer used by clock consumers.
+	clockTimer interface {
+		Stop() bool
+	}
+
+	realClock struct{}
+
+	// clockTicker is the equivalent of a time.Ticker driven by a clock: the time is sent on C every interval, ticks
+	// being dropped for slow receivers.
+	clockTicker struct {
+		C <-chan time.Time
+
+		c        chan time.Time
+		clock    clock
+		interval time.Duration
+		mu       sync.Mutex
+		timer    clockTimer
+		stopped  bool
+	}
+)
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// newClockTicker returns a ticker of clk. Like time.NewTicker, it panics unless interval is positive.
+func newClockTicker(clk clock, interval time.Duration) *clockTicker {
+	if interval <= 0 {
+		panic("libws: non-positive interval for newClockTicker")
+	}
+
+	c := make(chan time.Time, 1)
+	t := &clockTicker{C: c, c: c, clock: clk, interval: interval}
+
+	t.mu.Lock()
+	t.timer = clk.AfterFunc(interval, t.tick)
+	t.mu.Unlock()
+
+	return t
+}
+
+func (t *clockTicker) tick() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return
+	}
+
+	select {
+	case t.c <- t.clock.Now():
+	default:
+	}
+	t.timer = t.clock.AfterFunc(t.interval, t.tick)
+}
+
+// Stop turns off the ticker. Like time.Ticker.Stop, it does not close C.
+func (t *clockTicker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	t.timer.Stop()
+}
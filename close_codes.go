@@ -0,0 +1,147 @@
+package libws
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// DisconnectReason categorizes why a connection closed, see DisconnectReasonOf.
+type DisconnectReason int
+
+const (
+	DisconnectUnknown DisconnectReason = iota
+	DisconnectNormal
+	DisconnectServerRestart
+	DisconnectProtocol
+	DisconnectPolicy
+	DisconnectAuth
+	DisconnectRateLimit
+	DisconnectServerError
+	// DisconnectUserClose is a connection closed on our side, see ErrTerminated.
+	DisconnectUserClose
+	// DisconnectRotated is a connection replaced on purpose by a newer one, see ErrRotated. It is no failure.
+	DisconnectRotated
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectNormal:
+		return "normal"
+	case DisconnectServerRestart:
+		return "server_restart"
+	case DisconnectProtocol:
+		return "protocol"
+	case DisconnectPolicy:
+		return "policy"
+	case DisconnectAuth:
+		return "auth"
+	case DisconnectRateLimit:
+		return "rate_limit"
+	case DisconnectServerError:
+		return "server_error"
+	case DisconnectUserClose:
+		return "user_close"
+	case DisconnectRotated:
+		return "rotated"
+	default:
+		return "unknown"
+	}
+}
+
+type (
+	// CloseCodeInfo describes the meaning of a close code.
+	CloseCodeInfo struct {
+		// Label is a human-readable description of the code.
+		Label string
+		// Reason categorizes the code.
+		Reason DisconnectReason
+		// Recoverable tells whether reconnecting may succeed. Reconnecting layers give up on unrecoverable codes.
+		Recoverable bool
+	}
+
+	// CloseCodeTable maps close codes, usually application ones in the 4000–4999 range, to their meaning.
+	CloseCodeTable map[int]CloseCodeInfo
+
+	// CloseCodeError is the close reason of a connection closed by the peer with a close frame.
+	CloseCodeError struct {
+		Code int
+		Text string
+		Info CloseCodeInfo
+	}
+)
+
+// rfcCloseCodes holds the meaning of the codes defined by RFC 6455 and the IANA registry. They are all deemed
+// recoverable, as reconnecting is the historical behaviour.
+var rfcCloseCodes = CloseCodeTable{
+	1000: {Label: "normal closure", Reason: DisconnectNormal, Recoverable: true},
+	1001: {Label: "going away", Reason: DisconnectServerRestart, Recoverable: true},
+	1002: {Label: "protocol error", Reason: DisconnectProtocol, Recoverable: true},
+	1003: {Label: "unsupported data", Reason: DisconnectProtocol, Recoverable: true},
+	1005: {Label: "no status received", Reason: DisconnectUnknown, Recoverable: true},
+	1006: {Label: "abnormal closure", Reason: DisconnectUnknown, Recoverable: true},
+	1007: {Label: "invalid frame payload data", Reason: DisconnectProtocol, Recoverable: true},
+	1008: {Label: "policy violation", Reason: DisconnectPolicy, Recoverable: true},
+	1009: {Label: "message too big", Reason: DisconnectProtocol, Recoverable: true},
+	1010: {Label: "mandatory extension", Reason: DisconnectProtocol, Recoverable: true},
+	1011: {Label: "internal server error", Reason: DisconnectServerError, Recoverable: true},
+	1012: {Label: "service restart", Reason: DisconnectServerRestart, Recoverable: true},
+	1013: {Label: "try again later", Reason: DisconnectServerError, Recoverable: true},
+	1014: {Label: "bad gateway", Reason: DisconnectServerError, Recoverable: true},
+	1015: {Label: "TLS handshake", Reason: DisconnectProtocol, Recoverable: true},
+}
+
+// classify builds the close reason of code, looking it up in t first and in the RFC codes then. Unknown codes are
+// recoverable.
+func (t CloseCodeTable) classify(code int, text string) *CloseCodeError {
+	info, ok := t[code]
+	if !ok {
+		info, ok = rfcCloseCodes[code]
+	}
+	if !ok {
+		info = CloseCodeInfo{Label: fmt.Sprintf("close code %d", code), Recoverable: true}
+	}
+
+	return &CloseCodeError{Code: code, Text: text, Info: info}
+}
+
+func (e *CloseCodeError) Error() string {
+	msg := fmt.Sprintf("%s: %d %s (%s)", ErrConnectionClosed, e.Code, e.Info.Label, e.Info.Reason)
+	if e.Text != "" {
+		msg += ": " + e.Text
+	}
+
+	return msg
+}
+
+// Unwrap makes close codes match ErrConnectionClosed.
+func (e *CloseCodeError) Unwrap() error { return ErrConnectionClosed }
+
+// DisconnectReasonOf categorizes err, the close reason of a connection as told by CloseErr: the category of its close
+// code when the peer closed it, see CloseCodeError, DisconnectRotated when it was rotated, DisconnectUserClose when it
+// was closed on our side, and DisconnectUnknown otherwise.
+func DisconnectReasonOf(err error) DisconnectReason {
+	var cce *CloseCodeError
+	switch {
+	case errors.As(err, &cce):
+		return cce.Info.Reason
+	case errors.Is(err, ErrRotated):
+		return DisconnectRotated
+	case errors.Is(err, ErrTerminated):
+		return DisconnectUserClose
+	default:
+		return DisconnectUnknown
+	}
+}
+
+// isUnrecoverableClose reports whether err carries a close code flagged as unrecoverable, or was deemed so by an
+// error adapter, see ErrorAdapters.
+func isUnrecoverableClose(err error) bool {
+	var unrecoverable *ErrUnrecoverableConnection
+	if errors.As(err, &unrecoverable) {
+		return true
+	}
+
+	var cce *CloseCodeError
+	return errors.As(err, &cce) && !cce.Info.Recoverable
+}
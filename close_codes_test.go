@@ -0,0 +1,137 @@
+package libws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var testCloseCodes = CloseCodeTable{
+	4001: {Label: "invalid api key", Reason: DisconnectAuth, Recoverable: false},
+	4002: {Label: "session expired", Reason: DisconnectAuth, Recoverable: true},
+}
+
+func TestWsConnection_CloseCodeTable(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        int
+		wantLabel   string
+		wantReason  DisconnectReason
+		recoverable bool
+	}{
+		{name: "mapped", code: 4002, wantLabel: "session expired", wantReason: DisconnectAuth, recoverable: true},
+		{name: "unrecoverable", code: 4001, wantLabel: "invalid api key", wantReason: DisconnectAuth},
+		{name: "rfc", code: 1012, wantLabel: "service restart", wantReason: DisconnectServerRestart, recoverable: true},
+		{name: "unmapped", code: 4999, wantLabel: "close code 4999", wantReason: DisconnectUnknown, recoverable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestWsServer(t, func(conn *websocket.Conn) {
+				msg := websocket.FormatCloseMessage(tt.code, "bye")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				serveUntilClosed(conn)
+			})
+			conn, _ := newTestWsConnection(t, srv)
+			WithCloseCodeTable(testCloseCodes)(conn)
+
+			require.NoError(t, conn.Open(context.Background()))
+			select {
+			case <-conn.CloseChan():
+			case <-time.After(time.Second):
+				t.Fatal("connection not closed")
+			}
+
+			var cce *CloseCodeError
+			require.True(t, errors.As(conn.CloseErr(), &cce))
+			require.Equal(t, tt.code, cce.Code)
+			require.Equal(t, "bye", cce.Text)
+			require.Equal(t, tt.wantLabel, cce.Info.Label)
+			require.Equal(t, tt.wantReason, cce.Info.Reason)
+			require.Equal(t, !tt.recoverable, isUnrecoverableClose(conn.CloseErr()))
+			require.ErrorIs(t, conn.CloseErr(), ErrConnectionClosed)
+			require.Contains(t, conn.CloseErr().Error(), tt.wantLabel)
+		})
+	}
+}
+
+func TestBackoffConnectionHandler_CloseCodeRecoverability(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	h := newTestBackoffHandler(t, stubs.Factory, func(int) time.Duration { return 0 })
+
+	// Recoverable codes reconnect.
+	stubs.Last().Kill(testCloseCodes.classify(4002, ""))
+	require.Eventually(t, func() bool { return len(stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+
+	// Unrecoverable ones close the layer with the close reason.
+	closeErr := testCloseCodes.classify(4001, "")
+	stubs.Last().Kill(closeErr)
+
+	select {
+	case <-h.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("backoff handler did not give up")
+	}
+	require.Equal(t, closeErr, h.CloseErr())
+	require.Len(t, stubs.Handlers(), 2)
+}
+
+func TestDisconnectReasonOf(t *testing.T) {
+	require.Equal(t, DisconnectAuth, DisconnectReasonOf(testCloseCodes.classify(4001, "")))
+	require.Equal(t, DisconnectRotated, DisconnectReasonOf(withReconnectFailure(ErrRotated, ErrRateLimit)))
+	require.Equal(t, DisconnectUserClose, DisconnectReasonOf(ErrTerminated))
+	require.Equal(t, DisconnectUnknown, DisconnectReasonOf(ErrConnectionClosed))
+	require.Equal(t, DisconnectUnknown, DisconnectReasonOf(nil))
+	require.Equal(t, "rotated", DisconnectRotated.String())
+}
+
+func TestWsConnection_CloseMessageCode(t *testing.T) {
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "busy")
+		_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		serveUntilClosed(conn)
+	})
+	conn, recv := newTestWsConnection(t, srv)
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	select {
+	case m := <-recv:
+		require.Equal(t, CloseError, m.Type())
+		code, ok := CloseCodeOf(m)
+		require.True(t, ok)
+		require.Equal(t, websocket.CloseTryAgainLater, code)
+		require.Equal(t, "busy", string(m.Data()))
+	case <-time.After(time.Second):
+		t.Fatal("close message not delivered")
+	}
+
+	_, ok := CloseCodeOf(NewTextMessage([]byte("busy")))
+	require.False(t, ok)
+}
+
+func TestParseCloseFramePayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		wantCode int
+		wantText string
+	}{
+		{name: "empty", wantCode: websocket.CloseNoStatusReceived},
+		{name: "truncated", payload: []byte{0x03}, wantCode: websocket.CloseProtocolError},
+		{name: "code only", payload: websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), wantCode: websocket.CloseGoingAway},
+		{name: "code and reason", payload: websocket.FormatCloseMessage(4000, "kicked"), wantCode: 4000, wantText: "kicked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, text := parseCloseFramePayload(tt.payload)
+			require.Equal(t, tt.wantCode, code)
+			require.Equal(t, tt.wantText, text)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package libws
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxDisconnectHistory bounds the disconnections kept for the FinalReport.
+const maxDisconnectHistory = 32
+
+type (
+	// FinalReport is produced once when a client terminally closes, see WithCloseDump. It gathers what is needed to
+	// analyze an unexpected close after the process is gone.
+	FinalReport struct {
+		ClosedAt time.Time `json:"closed_at"`
+		// Error is the close reason of the connection, or the recovered panic driving the teardown.
+		Error       string             `json:"error,omitempty"`
+		Config      ConfigSnapshot     `json:"config"`
+		Stats       ClientStats        `json:"stats"`
+		Disconnects []DisconnectRecord `json:"disconnects"`
+	}
+
+	// ClientStats is a snapshot of the client counters.
+	ClientStats struct {
+		MessageErrors    uint64                       `json:"message_errors"`
+		OutboundRejected uint64                       `json:"outbound_rejected"`
+		Messages         map[string]MessageClassStats `json:"messages,omitempty"`
+	}
+
+	// DisconnectRecord is a connection lifecycle event which replaced the connection. URL and Attempt describe the
+	// latest dial of websocket connections, when known: the URL dialed, redacted with RedactURL, and its attempt
+	// number, see DialError.
+	DisconnectRecord struct {
+		At      time.Time `json:"at"`
+		Event   EventType `json:"event"`
+		URL     string    `json:"url,omitempty"`
+		Attempt int       `json:"attempt,omitempty"`
+	}
+
+	// disconnectHistory keeps the latest DisconnectRecords.
+	disconnectHistory struct {
+		mu      sync.Mutex
+		records []DisconnectRecord
+	}
+)
+
+func (h *disconnectHistory) add(r DisconnectRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.records) == maxDisconnectHistory {
+		h.records = h.records[1:]
+	}
+	h.records = append(h.records, r)
+}
+
+func (h *disconnectHistory) snapshot() []DisconnectRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]DisconnectRecord{}, h.records...)
+}
+
+// WriteFinalReport returns a WithCloseDump callback writing the report to w as indented JSON.
+func WriteFinalReport(w io.Writer) func(FinalReport) {
+	return func(r FinalReport) {
+		bts, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			bts = []byte(fmt.Sprintf(`{"error":%q}`, "cannot marshal final report: "+err.Error()))
+		}
+
+		_, _ = w.Write(append(bts, '\n'))
+	}
+}
+
+// WriteFinalReportFile returns a WithCloseDump callback writing the report as JSON to the file at path, which is
+// created or truncated.
+func WriteFinalReportFile(path string) func(FinalReport) {
+	return func(r FinalReport) {
+		f, err := os.Create(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		WriteFinalReport(f)(r)
+	}
+}
@@ -0,0 +1,90 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_CloseDump(t *testing.T) {
+	clk := newFakeClock()
+	reports := make(chan FinalReport, 2)
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {},
+		WithCloseDump(func(r FinalReport) { reports <- r }),
+		WithMessageClassifier(func(Message) string { return "data" }),
+		withClock(clk),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+
+	stubs.Last().Deliver(NewDataMessage([]byte("hello")))
+	clk.Advance(time.Minute)
+	cli.eventEmitter.Emit(EventReconnect, EventReconnect)
+	clk.Advance(time.Minute)
+
+	stubs.Last().Kill(ErrConnectionClosed)
+
+	var report FinalReport
+	select {
+	case report = <-reports:
+	case <-time.After(time.Second):
+		t.Fatal("no final report")
+	}
+
+	start := time.Unix(0, 0).UTC()
+	require.Equal(t, start.Add(2*time.Minute), report.ClosedAt)
+	require.Equal(t, ErrConnectionClosed.Error(), report.Error)
+	require.Equal(t, []DisconnectRecord{{At: start.Add(time.Minute), Event: EventReconnect}}, report.Disconnects)
+	require.EqualValues(t, 1, report.Stats.Messages["data"].Count)
+	require.Equal(t, "basic_client", report.Config.Client.Layer)
+
+	// The dump happens exactly once.
+	cli.Close()
+	select {
+	case <-reports:
+		t.Fatal("final report produced twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestClient_CloseDumpOnPanic(t *testing.T) {
+	var report *FinalReport
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) { panic("boom") }, func(Client, EventType) {},
+		WithCloseDump(func(r FinalReport) { report = &r }),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	require.PanicsWithValue(t, "boom", func() { stubs.Last().Deliver(NewDataMessage([]byte("hello"))) })
+	require.NotNil(t, report)
+	require.Equal(t, "panic: boom", report.Error)
+}
+
+func TestWriteFinalReport(t *testing.T) {
+	report := FinalReport{
+		ClosedAt:    time.Unix(0, 0).UTC(),
+		Error:       "connection has been closed",
+		Disconnects: []DisconnectRecord{{At: time.Unix(0, 0).UTC(), Event: EventReconnect}},
+	}
+
+	var buf bytes.Buffer
+	WriteFinalReport(&buf)(report)
+
+	var decoded FinalReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, report, decoded)
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	WriteFinalReportFile(path)(report)
+
+	bts, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), bts)
+}
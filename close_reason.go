@@ -0,0 +1,55 @@
+package libws
+
+type (
+	// reasonCloser is implemented by connections and connection handlers able to close gracefully, sending a close
+	// frame carrying a status code and a reason first.
+	reasonCloser interface {
+		CloseWithReason(code int, reason string) error
+	}
+
+	// closeFrame is the close frame to send when a client closes, see CloseWithReason.
+	closeFrame struct {
+		code   int
+		reason string
+	}
+
+	// closeFrameProvider is implemented by clients closing with a close frame of their choosing.
+	closeFrameProvider interface {
+		pendingCloseFrame() (closeFrame, bool)
+	}
+
+	// errCloser is implemented by connections and connection handlers able to close with a close reason of the
+	// caller's choosing, e.g. ErrRotated, reported by CloseErr in place of ErrTerminated.
+	errCloser interface {
+		CloseWithErr(err error)
+	}
+)
+
+// closeWithErr closes c with err as its close reason when c supports it, see errCloser, or as Close does otherwise.
+func closeWithErr(c interface{ Close() }, err error) {
+	if ec, ok := c.(errCloser); ok {
+		ec.CloseWithErr(err)
+		return
+	}
+
+	c.Close()
+}
+
+// CloseWithReason closes c as Close does, closing its connections gracefully: a close frame carrying code and reason,
+// e.g. 1000 and "resubscribing", is sent and the reply of the peer awaited before they are torn down, see
+// WsConnection.CloseWithReason. Clients or connections unable to do so are closed as usual.
+func CloseWithReason(c Client, code int, reason string) {
+	if b, ok := c.(*basicClient); ok {
+		b.closeFrame.CompareAndSwap(nil, &closeFrame{code: code, reason: reason})
+	}
+
+	c.Close()
+}
+
+func (b *basicClient) pendingCloseFrame() (closeFrame, bool) {
+	if f := b.closeFrame.Load(); f != nil {
+		return *f, true
+	}
+
+	return closeFrame{}, false
+}
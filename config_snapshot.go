@@ -0,0 +1,95 @@
+package libws
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const redactedValue = "REDACTED"
+
+type (
+	// ConfigSnapshot is a read-only, JSON-serializable view of the effective configuration of a client and every
+	// layer composing it, suitable for attaching to bug reports.
+	ConfigSnapshot struct {
+		Client ConfigSection `json:"client"`
+		// Layers lists the connection handler layers from the outermost to the innermost one.
+		Layers []ConfigSection `json:"layers"`
+	}
+
+	// ConfigSection is the configuration contributed by a single layer. Settings must be JSON-serializable and free
+	// of secrets; use RedactURL for endpoints.
+	ConfigSection struct {
+		Layer    string         `json:"layer"`
+		Settings map[string]any `json:"settings"`
+	}
+
+	// ConfigContributor is implemented by connection handlers contributing a section to ConfigSnapshot.
+	ConfigContributor interface {
+		ConfigSection() ConfigSection
+	}
+
+	// handlerUnwrapper is implemented by decorators to expose the connection handler they currently wrap.
+	handlerUnwrapper interface {
+		unwrapHandler() ConnectionHandler
+	}
+)
+
+// String returns the snapshot as JSON. It is safe to log.
+func (s ConfigSnapshot) String() string {
+	bts, err := json.Marshal(s)
+	if err != nil {
+		return "ConfigSnapshot{" + err.Error() + "}"
+	}
+
+	return string(bts)
+}
+
+// collectConfig walks the handler chain starting at h, collecting the sections of the layers contributing one.
+func collectConfig(h ConnectionHandler) []ConfigSection {
+	var sections []ConfigSection
+
+	for h != nil {
+		if c, ok := h.(ConfigContributor); ok {
+			sections = append(sections, c.ConfigSection())
+		}
+
+		u, ok := h.(handlerUnwrapper)
+		if !ok {
+			break
+		}
+		h = u.unwrapHandler()
+	}
+
+	return sections
+}
+
+// RedactURL renders u without credentials and with every query value replaced, keeping the scheme, host, path and
+// query keys so that the endpoint can still be identified.
+func RedactURL(u url.URL) string {
+	u.User = nil
+	u.Fragment = ""
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		keys := make([]string, 0, len(query))
+		for key := range query {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var sb strings.Builder
+		for i, key := range keys {
+			if i > 0 {
+				sb.WriteByte('&')
+			}
+			sb.WriteString(url.QueryEscape(key))
+			sb.WriteByte('=')
+			sb.WriteString(redactedValue)
+		}
+		u.RawQuery = sb.String()
+	}
+
+	return u.String()
+}
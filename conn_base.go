@@ -0,0 +1,194 @@
+package libws
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// baseConnectionRecvBufferSize is the capacity of the channel a base handler's connection delivers messages to.
+const baseConnectionRecvBufferSize = 64
+
+// baseConnectionControlBufferSize is the capacity of the channel a base handler's connection delivers control frames
+// to, when it supports delivering them apart.
+const baseConnectionControlBufferSize = 8
+
+// baseConnectionHandler is the innermost connection handler: it adapts a Connection, delivering every message read
+// from it to the message handler. Decorators are composed on top of it.
+type baseConnectionHandler struct {
+	logger      logger
+	client      Client
+	handler     MessageHandler
+	connFactory ConnectionFactory
+	conn        Connection
+	emitter     emitter[EventType, EventType]
+	// generation is the generation of conn, messages read on it are tagged with
+	generation uint64
+	// panicErr is the close reason once a panic was recovered while handling messages, see PanicPolicy
+	panicErr atomic.Pointer[error]
+}
+
+func newBaseConnectionHandler(
+	logger logger,
+	client Client,
+	handler MessageHandler,
+	emitter emitter[EventType, EventType],
+	connFactory ConnectionFactory,
+) *baseConnectionHandler {
+	return &baseConnectionHandler{
+		logger:      orNop(logger).WithField("type", "baseConnectionHandler"),
+		client:      client,
+		handler:     handler,
+		emitter:     emitter,
+		connFactory: connFactory,
+	}
+}
+
+// NewBaseConnectionHandlerFactory returns a factory of handlers opening a connection built by connFactory.
+func NewBaseConnectionHandlerFactory(logger logger, connFactory ConnectionFactory) ConnectionHandlerFactory {
+	return func(client Client, handler MessageHandler, emitter emitter[EventType, EventType]) ConnectionHandler {
+		return newBaseConnectionHandler(logger, client, handler, emitter, connFactory)
+	}
+}
+
+func (h *baseConnectionHandler) Connect(ctx context.Context) error {
+	recv := make(chan Message, baseConnectionRecvBufferSize)
+	control := make(chan Message, baseConnectionControlBufferSize)
+
+	conn := h.connFactory(ctx, recv)
+	if c, ok := conn.(interface{ bindControlRecv(chan<- Message) }); ok {
+		c.bindControlRecv(control)
+	}
+	if c, ok := conn.(interface{ Control() *ConnectionControl }); ok {
+		registerHandle(h.client, c.Control())
+	}
+	if c, ok := conn.(interface {
+		bindEmitter(emitter[EventType, EventType])
+	}); ok && h.emitter != nil {
+		c.bindEmitter(h.emitter)
+	}
+
+	// Connections able to tell their generation get it allocated once open, before EventConnect.
+	generated := false
+	if c, ok := conn.(interface{ bindGeneration(func() uint64) }); ok {
+		c.bindGeneration(func() uint64 {
+			generated, h.generation = true, nextGeneration(h.client)
+			return h.generation
+		})
+	}
+
+	if err := conn.Open(ctx); err != nil {
+		return err
+	}
+	h.conn = conn
+	if !generated {
+		h.generation = nextGeneration(h.client)
+	}
+
+	// Control frames are delivered by a goroutine of their own: a ping waiting for the handler to be done with a
+	// data message would be answered too late for the peer.
+	go h.run(ctx, recv)
+	go h.run(ctx, control)
+
+	return nil
+}
+
+// run delivers the messages of c to the handler until the connection closes.
+func (h *baseConnectionHandler) run(ctx context.Context, c <-chan Message) {
+	defer recoverPanic(ctx, h.logger, h.closeWith)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.conn.CloseChan():
+			return
+		case m := <-c:
+			h.handler(h.client, withGeneration(m, h.generation))
+		}
+	}
+}
+
+// Recv does nothing: messages reaching the innermost handler have no further consumer.
+func (h *baseConnectionHandler) Recv(Message) {}
+
+func (h *baseConnectionHandler) Send(m Message) {
+	if err := h.sendContext(context.Background(), m); err != nil {
+		h.logger.Warnf("cannot write message: %s", err)
+	}
+}
+
+// sendContext writes m, returning the error of the connection, giving up once ctx is done when the connection supports it, see WsConnection.SendTimeout.
+func (h *baseConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	if c, ok := h.conn.(contextSender); ok {
+		return c.sendContext(ctx, m)
+	}
+
+	return h.conn.Write(m)
+}
+
+func (h *baseConnectionHandler) Close() {
+	if p, ok := h.client.(closeFrameProvider); ok {
+		if f, ok := p.pendingCloseFrame(); ok {
+			if err := h.CloseWithReason(f.code, f.reason); err != nil {
+				h.logger.Debugf("cannot close gracefully: %s", err)
+			}
+			return
+		}
+	}
+
+	if h.conn != nil {
+		h.conn.Close()
+	}
+}
+
+// CloseWithErr closes the connection with err as its close reason, see errCloser.
+func (h *baseConnectionHandler) CloseWithErr(err error) {
+	if h.conn == nil {
+		return
+	}
+
+	if c, ok := h.conn.(errCloser); ok {
+		c.CloseWithErr(err)
+		return
+	}
+
+	h.closeWith(err)
+}
+
+// CloseWithReason closes the connection gracefully with a close frame carrying code and reason, when the connection
+// supports it, see WsConnection.CloseWithReason, or as Close does otherwise.
+func (h *baseConnectionHandler) CloseWithReason(code int, reason string) error {
+	if h.conn == nil {
+		return ErrConnectionClosed
+	}
+
+	if c, ok := h.conn.(reasonCloser); ok {
+		return c.CloseWithReason(code, reason)
+	}
+
+	h.conn.Close()
+	return nil
+}
+
+func (h *baseConnectionHandler) CloseChan() CloseChan {
+	return h.conn.CloseChan()
+}
+
+func (h *baseConnectionHandler) CloseErr() error {
+	if err := h.panicErr.Load(); err != nil {
+		return *err
+	}
+
+	return h.conn.CloseErr()
+}
+
+// closeWith closes the connection with err as the close reason.
+func (h *baseConnectionHandler) closeWith(err error) {
+	h.panicErr.CompareAndSwap(nil, &err)
+	h.conn.Close()
+}
+
+// ConnContext returns the connection-scoped context of the connection, if it exposes one.
+func (h *baseConnectionHandler) ConnContext() context.Context {
+	return connContextOf(h.conn)
+}
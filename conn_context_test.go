@@ -0,0 +1,62 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnContext_CancelledOnReconnect(t *testing.T) {
+	logger := newTestLogger(io.Discard)
+
+	tests := []struct {
+		name    string
+		factory func(ConnectionHandlerFactory) ConnectionHandlerFactory
+	}{
+		{
+			name: "backoff",
+			factory: func(inner ConnectionHandlerFactory) ConnectionHandlerFactory {
+				return NewBackoffConnectionHandlerFactory(logger, inner, func(int) time.Duration { return 0 }, time.Minute)
+			},
+		},
+		{
+			name: "reopen interval",
+			factory: func(inner ConnectionHandlerFactory) ConnectionHandlerFactory {
+				return NewReopenIntervalConnFactory(logger, time.Hour, inner)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			stubs := &stubConnectionHandlerFactory{}
+			factory := NewPassiveKeepAliveConnectionHandlerFactory(
+				tt.factory(stubs.Factory),
+				KeepAliveHandlerReplyPingWithPong,
+			)
+			cli := newBasicClient(factory, func(Client, Message) {}, func(Client, EventType) {})
+			require.Nil(t, cli.ConnContext())
+
+			require.NoError(t, cli.Open(clientCtx))
+			defer cli.Close()
+
+			first := cli.ConnContext()
+			require.NotNil(t, first)
+			require.NoError(t, first.Err())
+
+			stubs.Last().Kill(ErrConnectionClosed)
+			require.Eventually(t, func() bool { return len(stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+			require.Eventually(t, func() bool { return cli.ConnContext() != first }, time.Second, time.Millisecond)
+
+			require.ErrorIs(t, first.Err(), context.Canceled)
+			require.NoError(t, cli.ConnContext().Err())
+			require.NoError(t, clientCtx.Err())
+		})
+	}
+}
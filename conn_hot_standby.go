@@ -0,0 +1,302 @@
+package libws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// hotStandbyRetryInterval is the time waited before trying again to build a standby that failed to connect or warm up.
+const hotStandbyRetryInterval = time.Second
+
+type (
+	// StandbyWarmup prepares a freshly connected standby handler, e.g. authenticating and subscribing, so that it can
+	// take over without delay. Returning an error discards the standby and a new one is built.
+	StandbyWarmup func(ConnectionHandler) error
+
+	// hotStandbyConnectionHandler keeps two inner handlers connected: the primary, whose inbound messages are
+	// forwarded, and a warmed-up standby, whose inbound messages are discarded. When the primary closes, the standby
+	// is promoted right away and a new standby is built in the background.
+	hotStandbyConnectionHandler struct {
+		logger             logger
+		client             Client
+		handler            MessageHandler
+		emitter            emitter[EventType, EventType]
+		connHandlerFactory ConnectionHandlerFactory
+		warmup             StandbyWarmup
+
+		primary   *standbySlot
+		primaryMu sync.RWMutex
+
+		// standbyC hands standby handlers, once connected and warmed up, over to run
+		standbyC chan *standbySlot
+
+		closeC    CloseChan
+		closeOnce sync.Once
+	}
+
+	// standbySlot identifies an inner handler so that inbound messages can be attributed to it.
+	standbySlot struct {
+		ConnectionHandler
+	}
+)
+
+func newHotStandbyConnectionHandler(
+	logger logger,
+	client Client,
+	handler MessageHandler,
+	emitter emitter[EventType, EventType],
+	connHandlerFactory ConnectionHandlerFactory,
+	warmup StandbyWarmup,
+) *hotStandbyConnectionHandler {
+	return &hotStandbyConnectionHandler{
+		logger:             orNop(logger).WithField("type", "hotStandbyConnectionHandler"),
+		client:             client,
+		handler:            handler,
+		emitter:            emitter,
+		connHandlerFactory: connHandlerFactory,
+		warmup:             warmup,
+		standbyC:           make(chan *standbySlot),
+		closeC:             make(CloseChan),
+	}
+}
+
+// NewHotStandbyConnectionHandlerFactory returns a factory of handlers keeping a warm standby connection, built by
+// factory and prepared by warmup, ready to replace the primary one as soon as it closes. Keep-alive for both
+// connections must be composed within factory, since the standby's inbound messages never leave it.
+func NewHotStandbyConnectionHandlerFactory(
+	logger logger,
+	factory ConnectionHandlerFactory,
+	warmup StandbyWarmup,
+) ConnectionHandlerFactory {
+	return func(client Client, handler MessageHandler, emitter emitter[EventType, EventType]) ConnectionHandler {
+		return newHotStandbyConnectionHandler(logger, client, handler, emitter, factory, warmup)
+	}
+}
+
+// Connect opens the primary connection synchronously, then builds the standby in the background.
+func (h *hotStandbyConnectionHandler) Connect(ctx context.Context) error {
+	primary := h.newSlot()
+	if err := primary.Connect(ctx); err != nil {
+		primary.Close()
+		return err
+	}
+
+	h.primaryMu.Lock()
+	h.primary = primary
+	h.primaryMu.Unlock()
+
+	go h.buildStandby(ctx)
+	go h.run(ctx)
+
+	return nil
+}
+
+// Send sends the message through the primary connection.
+func (h *hotStandbyConnectionHandler) Send(m Message) {
+	if err := h.sendContext(context.Background(), m); err != nil {
+		h.logger.Warnf("cannot send message: %s", err)
+	}
+}
+
+func (h *hotStandbyConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	h.primaryMu.RLock()
+	defer h.primaryMu.RUnlock()
+
+	if h.primary == nil {
+		return ErrConnectionClosed
+	}
+	return sendContext(ctx, h.primary.ConnectionHandler, m)
+}
+
+// Recv passes the message to the primary connection handler, dropping it when there is none.
+func (h *hotStandbyConnectionHandler) Recv(m Message) {
+	h.primaryMu.RLock()
+	defer h.primaryMu.RUnlock()
+
+	if h.primary == nil {
+		discard(m)
+		return
+	}
+	h.primary.Recv(m)
+}
+
+// Close closes both the primary and the standby connections.
+func (h *hotStandbyConnectionHandler) Close() {
+	h.closeOnce.Do(func() {
+		close(h.closeC)
+
+		h.primaryMu.RLock()
+		if h.primary != nil {
+			h.primary.Close()
+		}
+		h.primaryMu.RUnlock()
+	})
+}
+
+func (h *hotStandbyConnectionHandler) CloseChan() CloseChan {
+	return h.closeC
+}
+
+// CloseErr returns the close reason of the primary connection, nil if it never connected.
+func (h *hotStandbyConnectionHandler) CloseErr() error {
+	h.primaryMu.RLock()
+	defer h.primaryMu.RUnlock()
+
+	if h.primary == nil {
+		return nil
+	}
+	return h.primary.CloseErr()
+}
+
+// ConnContext returns the connection-scoped context of the primary connection, if any.
+func (h *hotStandbyConnectionHandler) ConnContext() context.Context {
+	h.primaryMu.RLock()
+	defer h.primaryMu.RUnlock()
+
+	if h.primary == nil {
+		return nil
+	}
+	return connContextOf(h.primary.ConnectionHandler)
+}
+
+// ConfigSection reports the layer, which has no tunable settings.
+func (h *hotStandbyConnectionHandler) ConfigSection() ConfigSection {
+	return ConfigSection{Layer: "hot_standby", Settings: map[string]any{}}
+}
+
+func (h *hotStandbyConnectionHandler) unwrapHandler() ConnectionHandler {
+	h.primaryMu.RLock()
+	defer h.primaryMu.RUnlock()
+
+	if h.primary == nil {
+		return nil
+	}
+	return h.primary.ConnectionHandler
+}
+
+// newSlot creates an inner handler whose inbound messages are only forwarded while it is the primary.
+func (h *hotStandbyConnectionHandler) newSlot() *standbySlot {
+	slot := &standbySlot{}
+	slot.ConnectionHandler = h.connHandlerFactory(h.client, func(c Client, m Message) {
+		if h.isPrimary(slot) {
+			h.handler(c, m)
+			return
+		}
+		discard(m)
+	}, h.emitter)
+
+	return slot
+}
+
+func (h *hotStandbyConnectionHandler) isPrimary(slot *standbySlot) bool {
+	h.primaryMu.RLock()
+	defer h.primaryMu.RUnlock()
+
+	return h.primary == slot
+}
+
+// buildStandby connects and warms up a new standby, retrying until it succeeds or the handler is closed.
+func (h *hotStandbyConnectionHandler) buildStandby(ctx context.Context) {
+	defer recoverPanic(ctx, h.logger, func(error) { h.Close() })
+
+	for !isClosed(h.closeC) {
+		slot := h.newSlot()
+
+		err := slot.Connect(ctx)
+		if err == nil {
+			err = h.warmup(slot.ConnectionHandler)
+		}
+
+		if err == nil {
+			select {
+			case h.standbyC <- slot:
+			case <-h.closeC:
+				slot.Close()
+			case <-ctx.Done():
+				slot.Close()
+			}
+			return
+		}
+
+		h.logger.Warnf("cannot prepare standby connection, retrying in %s: %s", hotStandbyRetryInterval, err)
+		slot.Close()
+
+		select {
+		case <-time.After(hotStandbyRetryInterval):
+		case <-h.closeC:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *hotStandbyConnectionHandler) run(ctx context.Context) {
+	defer recoverPanic(ctx, h.logger, func(error) { h.Close() })
+
+	var standby *standbySlot
+
+	defer func() {
+		if standby != nil {
+			standby.Close()
+		}
+	}()
+
+	h.primaryMu.RLock()
+	primaryCloseC := h.primary.CloseChan()
+	h.primaryMu.RUnlock()
+
+	for {
+		var standbyCloseC CloseChan
+		if standby != nil {
+			standbyCloseC = standby.CloseChan()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.closeC:
+			return
+		case slot := <-h.standbyC:
+			standby = slot
+		case <-standbyCloseC:
+			if isClosed(h.closeC) {
+				return
+			}
+			h.logger.Warnf("standby connection closed due to %s, rebuilding it", standby.CloseErr())
+			standby.Close()
+			standby = nil
+			go h.buildStandby(ctx)
+		case <-primaryCloseC:
+			// Close closes the primary connection as well, which is not to be replaced then.
+			if isClosed(h.closeC) {
+				return
+			}
+			if standby == nil {
+				// No standby is ready yet: wait for the one being built.
+				h.logger.Warnln("primary connection closed before a standby was ready")
+				select {
+				case standby = <-h.standbyC:
+				case <-h.closeC:
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			h.primaryMu.Lock()
+			previous := h.primary
+			h.primary = standby
+			h.primaryMu.Unlock()
+
+			h.logger.Infof("promoted standby connection, primary closed due to %s", previous.CloseErr())
+			previous.Close()
+			primaryCloseC = standby.CloseChan()
+			standby = nil
+
+			go h.emitter.Emit(EventStandbyPromoted, EventStandbyPromoted)
+			go h.buildStandby(ctx)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotStandbyConnectionHandler_Failover(t *testing.T) {
+	const dialDelay = 200 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		received []string
+		warmed   []ConnectionHandler
+		promoted = make(chan struct{}, 1)
+	)
+
+	emitter := NewEventEmitter[EventType, EventType]()
+	emitter.On(EventStandbyPromoted, func(EventType) { promoted <- struct{}{} })
+
+	stubs := &stubConnectionHandlerFactory{ConnectDelay: dialDelay}
+	h := newHotStandbyConnectionHandler(
+		newTestLogger(io.Discard),
+		nil,
+		func(_ Client, m Message) {
+			mu.Lock()
+			received = append(received, string(m.Data()))
+			mu.Unlock()
+		},
+		emitter,
+		stubs.Factory,
+		func(ch ConnectionHandler) error {
+			ch.Send(NewDataMessage([]byte("subscribe")))
+			mu.Lock()
+			warmed = append(warmed, ch)
+			mu.Unlock()
+			return nil
+		},
+	)
+	require.NoError(t, h.Connect(ctx))
+	defer h.Close()
+
+	require.Eventually(t, func() bool { return len(stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+	primary, standby := stubs.Handlers()[0], stubs.Handlers()[1]
+	require.Eventually(t, func() bool { return len(standby.Sent()) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond) // let run pick the standby up
+
+	// Only the primary delivers inbound messages and receives outbound ones.
+	primary.Deliver(NewDataMessage([]byte("p1")))
+	standby.Deliver(NewDataMessage([]byte("s1")))
+	h.Send(NewDataMessage([]byte("out1")))
+	require.Equal(t, []Message{NewDataMessage([]byte("out1"))}, primary.Sent())
+
+	start := time.Now()
+	primary.Kill(ErrConnectionClosed)
+
+	select {
+	case <-promoted:
+	case <-time.After(time.Second):
+		t.Fatal("standby was not promoted")
+	}
+	standby.Deliver(NewDataMessage([]byte("s2")))
+	require.Less(t, time.Since(start), dialDelay, "failover must not wait for a dial")
+
+	h.Send(NewDataMessage([]byte("out2")))
+	require.Equal(t, NewDataMessage([]byte("out2")), standby.Sent()[1])
+
+	// A new standby is built and warmed up in the background.
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(warmed) == 2
+	}, time.Second, time.Millisecond)
+	require.Len(t, stubs.Handlers(), 3)
+
+	mu.Lock()
+	require.Equal(t, []string{"p1", "s2"}, received)
+	mu.Unlock()
+
+	h.Close()
+	for _, stub := range stubs.Handlers() {
+		select {
+		case <-stub.CloseChan():
+		case <-time.After(time.Second):
+			t.Fatal("inner handler left open")
+		}
+	}
+}
@@ -2,20 +2,138 @@ package libws
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type KeepAliveMessageFactory func() Message
+// keepAliveEWMAWeight is the weight of the latest inter-arrival time in the average driving adaptive ping intervals.
+const keepAliveEWMAWeight = 0.2
+
+type (
+	KeepAliveMessageFactory func() Message
+
+	// KeepAliveOption configures the active keep-alive layer, see NewActiveKeepAliveConnectionHandlerFactory.
+	KeepAliveOption func(*activeKeepAliveConnectionHandler)
+
+	// pongWatch is the pong timeout armed by a ping written to the connection of generation.
+	pongWatch struct {
+		timer      clockTimer
+		generation uint64
+	}
+)
+
+// WithGenerationTaggedPings makes ping keep-alive messages carry the generation of the connection they are written to,
+// prefixing their payload with "g<generation>:", so that pongs answering the pings of another connection, e.g. one
+// replaced by a rotation, are told apart. Those are dropped and counted, see KeepAliveControl.StalePongs and
+// LatencyStats.Stale, rather than taken as answers. Pings are only tagged when the client numbers its connections and
+// the connection exposes a connection-scoped context, see ConnectionInfo.Generation.
+func WithGenerationTaggedPings() KeepAliveOption {
+	return func(h *activeKeepAliveConnectionHandler) {
+		h.tagPings = true
+	}
+}
+
+// WithPongTimeout closes the current connection with ErrPongTimeout when no pong is received within timeout of a
+// keep-alive message, for a reconnecting layer below to replace it. Stale pongs do not count, see
+// WithGenerationTaggedPings. No timeout applies by default.
+func WithPongTimeout(timeout time.Duration) KeepAliveOption {
+	return func(h *activeKeepAliveConnectionHandler) {
+		h.pongTimeout = timeout
+	}
+}
+
+// linkStateProvider is implemented by layers which may be left without a live connection for a while, e.g. while
+// reconnecting. It tells whether a connection is live, along with a channel closed once that changes.
+type linkStateProvider interface {
+	linkState() (live bool, changed <-chan struct{})
+}
+
+// linkStateOf returns the first layer of the handler chain starting at h reporting its link state, if any.
+func linkStateOf(h ConnectionHandler) (linkStateProvider, bool) {
+	for h != nil {
+		if p, ok := h.(linkStateProvider); ok {
+			return p, true
+		}
+
+		u, ok := h.(handlerUnwrapper)
+		if !ok {
+			break
+		}
+		h = u.unwrapHandler()
+	}
+
+	return nil, false
+}
+
+// KeepAliveControl is the runtime-control handle of the active keep-alive layer. Retrieve it with
+// Handle[*KeepAliveControl](client).
+type KeepAliveControl struct {
+	mu      sync.RWMutex
+	handler *activeKeepAliveConnectionHandler
+}
+
+// Interval returns the ping interval currently in effect, or zero when the client is not open.
+func (c *KeepAliveControl) Interval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.handler == nil {
+		return 0
+	}
+
+	return time.Duration(c.handler.interval.Load())
+}
+
+// StalePongs returns how many pongs answering the pings of another connection were dropped, see
+// WithGenerationTaggedPings.
+func (c *KeepAliveControl) StalePongs() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.handler == nil {
+		return 0
+	}
+
+	return c.handler.stalePongs.Load()
+}
+
+func (c *KeepAliveControl) bind(h *activeKeepAliveConnectionHandler) {
+	c.mu.Lock()
+	c.handler = h
+	c.mu.Unlock()
+}
 
 // activeKeepAliveConnectionHandler is a type of ConnectionHandler that automatically sends
 // periodic ping messages to keep the connection alive.
 // It embeds the ConnectionHandler interface to inherit its methods.
+// In adaptive mode, that is, when maxInterval exceeds pingInterval, the interval ranges from pingInterval on idle
+// connections to maxInterval on busy ones, as inbound messages already prove the connection alive.
 type activeKeepAliveConnectionHandler struct {
 	ConnectionHandler
 	pingInterval            time.Duration
+	maxInterval             time.Duration
 	keepAliveMessageFactory KeepAliveMessageFactory
 	logger                  logger
+	clock                   clock
+	// budget paces the keep-alive messages when the client has a RateBudget
+	budget *RateBudget
+	// tagPings makes pings carry the generation of their connection, see WithGenerationTaggedPings
+	tagPings   bool
+	stalePongs atomic.Uint64
+	// pongTimeout is the time a pong is awaited for once a keep-alive message was sent, see WithPongTimeout
+	pongTimeout time.Duration
+	watchMu     sync.Mutex
+	watch       *pongWatch
+
+	// interval is the ping interval in effect
+	interval atomic.Int64
+	// arrivalsMu guards the inbound inter-arrival average, only tracked in adaptive mode
+	arrivalsMu  sync.Mutex
+	lastArrival time.Time
+	avgArrival  time.Duration
 
 	connectOnce sync.Once
 	closeOnce   sync.Once
@@ -44,24 +162,252 @@ func (h *activeKeepAliveConnectionHandler) Close() {
 	})
 }
 
-// run initiates the routine that sends keep-alive messages at regular intervals defined by pingInterval.
+// CloseWithErr closes as Close does, the underlying ConnectionHandler with err as its close reason, see errCloser.
+func (h *activeKeepAliveConnectionHandler) CloseWithErr(err error) {
+	h.closeOnce.Do(func() {
+		closeWithErr(h.ConnectionHandler, err)
+		close(h.closeC)
+	})
+}
+
+// ConnContext returns the connection-scoped context of the underlying ConnectionHandler, if any.
+func (h *activeKeepAliveConnectionHandler) ConnContext() context.Context {
+	return connContextOf(h.ConnectionHandler)
+}
+
+// ConfigSection reports the ping interval.
+func (h *activeKeepAliveConnectionHandler) ConfigSection() ConfigSection {
+	settings := map[string]any{"ping_interval": h.pingInterval.String()}
+	if h.adaptive() {
+		settings["max_ping_interval"] = h.maxInterval.String()
+	}
+
+	return ConfigSection{Layer: "active_keep_alive", Settings: settings}
+}
+
+// Recv observes inbound control messages before passing them down. Pongs answering the pings of another connection
+// are dropped, the others disarm the pong timeout.
+func (h *activeKeepAliveConnectionHandler) Recv(m Message) {
+	h.observeArrival()
+
+	if m.Type().IsPong() {
+		if h.tagPings && stalePong(m) {
+			h.stalePongs.Add(1)
+			h.logger.Debugf("dropping pong %q read on connection %d", m.Data(), GenerationOf(m))
+			discard(m)
+			return
+		}
+		h.disarmWatch()
+	}
+
+	h.ConnectionHandler.Recv(m)
+}
+
+// sendContext sends m through the underlying ConnectionHandler, which the embedding would otherwise hide.
+func (h *activeKeepAliveConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	return sendContext(ctx, h.ConnectionHandler, m)
+}
+
+func (h *activeKeepAliveConnectionHandler) unwrapHandler() ConnectionHandler {
+	return h.ConnectionHandler
+}
+
+// run initiates the routine that sends keep-alive messages at regular intervals defined by pingInterval, re-evaluated
+// after every ping in adaptive mode.
+// Keep-alive messages are suspended while a layer below, such as the backoff one, has no live connection, rather than
+// being held until it reconnects, and resume one interval after it did.
 // It stops when the context is done or the connection is closed.
 func (h *activeKeepAliveConnectionHandler) run(ctx context.Context) {
-	ticker := time.NewTicker(h.pingInterval)
-	defer ticker.Stop()
+	defer recoverPanic(ctx, h.logger, func(error) { h.Close() })
+
+	tick := make(chan struct{}, 1)
+	schedule := func() clockTimer {
+		return h.clock.AfterFunc(time.Duration(h.interval.Load()), func() {
+			select {
+			case tick <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	live, linkChanged := true, (<-chan struct{})(nil)
+	link, hasLink := linkStateOf(h.ConnectionHandler)
+	if hasLink {
+		live, linkChanged = link.linkState()
+	}
+
+	var timer clockTimer
+	if live {
+		timer = schedule()
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		h.disarmWatch()
+	}()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			h.ConnectionHandler.Send(h.keepAliveMessageFactory())
+		case <-linkChanged:
+			wasLive := live
+			live, linkChanged = link.linkState()
+			if live == wasLive {
+				continue
+			}
+
+			if timer != nil {
+				timer.Stop()
+				timer = nil
+			}
+			h.disarmWatch()
+			select {
+			case <-tick:
+			default:
+			}
+
+			if live {
+				h.logger.Debugf("connection is back, resuming keep-alive messages")
+				timer = schedule()
+			} else {
+				h.logger.Debugf("no live connection, suspending keep-alive messages")
+			}
+		case <-tick:
+			// The link may have gone down since the tick was scheduled, not yet noticed above: the ticker then resumes
+			// with the link.
+			if hasLink {
+				if up, _ := link.linkState(); !up {
+					live, timer = false, nil
+					continue
+				}
+			}
+			if h.budget != nil && h.budget.Wait(ctx, RatePriorityControl) != nil {
+				return
+			}
+			generation := connGenerationOf(h.ConnContext())
+			if err := sendChecked(h.ConnectionHandler, h.keepAliveMessage(generation)); err != nil {
+				h.logger.Debugf("keep-alive message not sent: %s", err)
+			} else {
+				h.armWatch(generation)
+			}
+			h.adapt()
+			timer = schedule()
 		case <-h.closeC:
 			return
 		}
 	}
 }
 
+// keepAliveMessage returns the next keep-alive message, tagged with generation when a ping and tagging is enabled.
+func (h *activeKeepAliveConnectionHandler) keepAliveMessage(generation uint64) Message {
+	m := h.keepAliveMessageFactory()
+	if !h.tagPings || generation == 0 || !m.Type().IsPing() {
+		return m
+	}
+
+	return NewPingMessage(tagPing(generation, m.Data()))
+}
+
+// armWatch starts awaiting a pong for the keep-alive message just written to the connection of generation, unless one
+// is awaited already.
+func (h *activeKeepAliveConnectionHandler) armWatch(generation uint64) {
+	if h.pongTimeout <= 0 {
+		return
+	}
+
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+
+	if h.watch != nil {
+		return
+	}
+	watch := &pongWatch{generation: generation}
+	watch.timer = h.clock.AfterFunc(h.pongTimeout, func() { h.pongTimedOut(watch) })
+	h.watch = watch
+}
+
+func (h *activeKeepAliveConnectionHandler) disarmWatch() {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+
+	if h.watch != nil {
+		h.watch.timer.Stop()
+		h.watch = nil
+	}
+}
+
+// pongTimedOut closes the current connection, unless watch was disarmed since or the connection it is about was
+// replaced already.
+func (h *activeKeepAliveConnectionHandler) pongTimedOut(watch *pongWatch) {
+	h.watchMu.Lock()
+	if h.watch != watch {
+		h.watchMu.Unlock()
+		return
+	}
+	h.watch = nil
+	h.watchMu.Unlock()
+
+	if watch.generation != connGenerationOf(h.ConnContext()) {
+		return
+	}
+
+	h.logger.Warnf("no pong within %s, closing the connection", h.pongTimeout)
+	closeWithErr(innermostHandler(h.ConnectionHandler), fmt.Errorf("%w: none within %s", ErrPongTimeout, h.pongTimeout))
+}
+
+func (h *activeKeepAliveConnectionHandler) adaptive() bool {
+	return h.maxInterval > h.pingInterval
+}
+
+// observeArrival folds the time elapsed since the previous inbound message into the inter-arrival average.
+func (h *activeKeepAliveConnectionHandler) observeArrival() {
+	if !h.adaptive() {
+		return
+	}
+
+	now := h.clock.Now()
+
+	h.arrivalsMu.Lock()
+	defer h.arrivalsMu.Unlock()
+
+	if !h.lastArrival.IsZero() {
+		gap := now.Sub(h.lastArrival)
+		if h.avgArrival == 0 {
+			h.avgArrival = gap
+		} else {
+			h.avgArrival = time.Duration(keepAliveEWMAWeight*float64(gap) + (1-keepAliveEWMAWeight)*float64(h.avgArrival))
+		}
+	}
+	h.lastArrival = now
+}
+
+// adapt updates the ping interval from the inbound inter-arrival average: at most pingInterval between messages
+// yields maxInterval, at least maxInterval yields pingInterval, and the interval shrinks linearly in between. The
+// time elapsed since the last message counts as an inter-arrival time so that going idle is noticed.
+func (h *activeKeepAliveConnectionHandler) adapt() {
+	if !h.adaptive() {
+		return
+	}
+
+	h.arrivalsMu.Lock()
+	gap := h.avgArrival
+	if h.lastArrival.IsZero() {
+		gap = h.maxInterval
+	} else if idle := h.clock.Now().Sub(h.lastArrival); idle > gap {
+		gap = idle
+	}
+	h.arrivalsMu.Unlock()
+
+	gap = min(max(gap, h.pingInterval), h.maxInterval)
+	next := h.pingInterval + h.maxInterval - gap
+
+	if previous := time.Duration(h.interval.Swap(int64(next))); previous != next {
+		h.logger.Debugf("ping interval adapted from %s to %s", previous, next)
+	}
+}
+
 // newActiveKeepAliveConnectionHandler initializes and returns a new ActiveKeepAliveConnectionHandler.
 // It takes a ConnectionHandler, a time.Duration, and a KeepAliveMessageFactory as parameters.
 // The time.Duration parameter defines the interval between each keep-alive message.
@@ -72,13 +418,17 @@ func newActiveKeepAliveConnectionHandler(
 	interval time.Duration,
 	keepAliveMessageFactory KeepAliveMessageFactory,
 ) *activeKeepAliveConnectionHandler {
-	return &activeKeepAliveConnectionHandler{
+	h := &activeKeepAliveConnectionHandler{
 		ConnectionHandler:       ch,
-		logger:                  logger,
+		logger:                  orNop(logger),
 		pingInterval:            interval,
 		keepAliveMessageFactory: keepAliveMessageFactory,
+		clock:                   realClock{},
 		closeC:                  make(chan struct{}),
 	}
+	h.interval.Store(int64(interval))
+
+	return h
 }
 
 // NewActiveKeepAliveConnectionHandlerFactory returns a factory function for creating ActiveKeepAliveConnectionHandlers.
@@ -86,19 +436,68 @@ func newActiveKeepAliveConnectionHandler(
 // The ConnectionHandlerFactory is used to create the underlying ConnectionHandler.
 // The time.Duration parameter sets the interval between each keep-alive message.
 // The KeepAliveMessageFactory generates the keep-alive message to be sent.
+// The KeepAliveOptions tell pongs of other connections apart and time out unanswered ones, see
+// WithGenerationTaggedPings and WithPongTimeout.
 func NewActiveKeepAliveConnectionHandlerFactory(
 	logger logger,
 	factory ConnectionHandlerFactory,
 	interval time.Duration,
 	keepAliveMessageFactory KeepAliveMessageFactory,
+	opts ...KeepAliveOption,
+) ConnectionHandlerFactory {
+	return newAdaptiveKeepAliveConnectionHandlerFactory(logger, factory, interval, interval, keepAliveMessageFactory, realClock{}, opts...)
+}
+
+// NewAdaptiveKeepAliveConnectionHandlerFactory is like NewActiveKeepAliveConnectionHandlerFactory, but the interval
+// between keep-alive messages adapts to the inbound message rate, observed through an exponentially weighted moving
+// average of inter-arrival times: from minInterval on idle connections to maxInterval on busy ones, which need fewer
+// keep-alive messages. The interval is re-evaluated after every keep-alive message; retrieve the current one with
+// Handle[*KeepAliveControl](client).
+func NewAdaptiveKeepAliveConnectionHandlerFactory(
+	logger logger,
+	factory ConnectionHandlerFactory,
+	minInterval, maxInterval time.Duration,
+	keepAliveMessageFactory KeepAliveMessageFactory,
+	opts ...KeepAliveOption,
+) ConnectionHandlerFactory {
+	return newAdaptiveKeepAliveConnectionHandlerFactory(logger, factory, minInterval, maxInterval, keepAliveMessageFactory, realClock{}, opts...)
+}
+
+func newAdaptiveKeepAliveConnectionHandlerFactory(
+	logger logger,
+	factory ConnectionHandlerFactory,
+	minInterval, maxInterval time.Duration,
+	keepAliveMessageFactory KeepAliveMessageFactory,
+	clk clock,
+	opts ...KeepAliveOption,
 ) ConnectionHandlerFactory {
 	return func(client Client, handler MessageHandler, emitter emitter[EventType, EventType]) ConnectionHandler {
-		return newActiveKeepAliveConnectionHandler(
-			logger.WithField("subtype", "activeKeepAliveConnectionHandler"),
-			factory(client, handler, emitter),
-			interval,
+		h := newActiveKeepAliveConnectionHandler(
+			orNop(logger).WithField("subtype", "activeKeepAliveConnectionHandler"),
+			nil,
+			minInterval,
 			keepAliveMessageFactory,
 		)
+		h.maxInterval = maxInterval
+		h.clock = clk
+		for _, opt := range opts {
+			opt(h)
+		}
+		h.budget, _ = Handle[*RateBudget](client)
+
+		// Inbound data messages are observed on their way up, control ones through Recv.
+		observed := handler
+		if h.adaptive() {
+			observed = func(cli Client, m Message) {
+				h.observeArrival()
+				handler(cli, m)
+			}
+		}
+		h.ConnectionHandler = factory(client, observed, emitter)
+
+		registerHandle(client, &KeepAliveControl{}).bind(h)
+
+		return h
 	}
 }
 
@@ -112,3 +511,12 @@ func NewKeepAliveMessageFactory(
 		return NewMessage(mt, contentFactory())
 	}
 }
+
+// PingTokens returns a content factory for NewKeepAliveMessageFactory making every ping carry a token of its own, for
+// its pong to be told apart from the ones of the other pings by peers echoing ping payloads, see PongByPayload.
+func PingTokens() func() []byte {
+	var seq atomic.Uint64
+	return func() []byte {
+		return strconv.AppendUint(nil, seq.Add(1), 10)
+	}
+}
@@ -0,0 +1,201 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveKeepAlive_AdaptiveInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	stubs := &stubConnectionHandlerFactory{}
+	factory := newAdaptiveKeepAliveConnectionHandlerFactory(
+		newTestLogger(io.Discard),
+		stubs.Factory,
+		time.Second,
+		10*time.Second,
+		NewKeepAliveMessageFactory(PingMessage, func() []byte { return nil }),
+		clk,
+	)
+
+	cli := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	h := factory(cli, func(Client, Message) {}, nil)
+	require.NoError(t, h.Connect(ctx))
+	defer h.Close()
+
+	control, ok := Handle[*KeepAliveControl](cli)
+	require.True(t, ok)
+	require.Equal(t, time.Second, control.Interval())
+
+	awaitTimer := func() {
+		require.Eventually(t, func() bool { return clk.Pending() == 1 }, time.Second, time.Millisecond)
+	}
+	awaitInterval := func(d time.Duration) {
+		require.Eventually(t, func() bool { return control.Interval() == d }, time.Second, time.Millisecond)
+	}
+
+	// Busy phase: a message every 100ms, the connection needs no frequent pings.
+	awaitTimer()
+	for i := 0; i < 10; i++ {
+		clk.Advance(100 * time.Millisecond)
+		stubs.Last().Deliver(NewDataMessage([]byte("update")))
+	}
+	awaitInterval(10 * time.Second)
+
+	// Idle phase: nothing for a whole interval, pings go back to the minimum interval.
+	awaitTimer()
+	clk.Advance(10 * time.Second)
+	awaitInterval(time.Second)
+
+	// Moderate phase: control messages, observed through Recv, every 4s land in between.
+	awaitTimer()
+	for i := 0; i < 12; i++ {
+		clk.Advance(time.Second)
+		if i%4 == 3 {
+			h.Recv(NewPongMessage(nil))
+		}
+	}
+	require.Eventually(t, func() bool {
+		d := control.Interval()
+		return d > time.Second && d < 10*time.Second
+	}, time.Second, time.Millisecond)
+
+	require.Eventually(t, func() bool { return len(stubs.Last().Sent()) >= 3 }, time.Second, time.Millisecond)
+}
+
+func TestActiveKeepAlive_SuspendedWhileReconnecting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	stubs := &stubConnectionHandlerFactory{}
+	// Keep-alive outside backoff: pings sent while reconnecting would be held and flushed on reconnection.
+	factory := newAdaptiveKeepAliveConnectionHandlerFactory(
+		newTestLogger(io.Discard),
+		NewBackoffConnectionHandlerFactory(nil, stubs.Factory, func(int) time.Duration { return 0 }, time.Minute),
+		time.Second,
+		time.Second,
+		NewKeepAliveMessageFactory(PingMessage, func() []byte { return nil }),
+		clk,
+	)
+
+	h := factory(&mockClient{}, func(Client, Message) {}, NewEventEmitter[EventType, EventType]())
+	require.NoError(t, h.Connect(ctx))
+	defer h.Close()
+
+	link, ok := linkStateOf(h)
+	require.True(t, ok)
+	awaitLink := func(want bool) {
+		require.Eventually(t, func() bool {
+			live, _ := link.linkState()
+			return live == want
+		}, 2*time.Second, time.Millisecond)
+	}
+	awaitTimers := func(n int) {
+		require.Eventually(t, func() bool { return clk.Pending() == n }, time.Second, time.Millisecond)
+	}
+
+	first := stubs.Last()
+	awaitTimers(1)
+	clk.Advance(time.Second)
+	require.Eventually(t, func() bool { return len(first.Sent()) == 1 }, time.Second, time.Millisecond)
+
+	// A 30s outage: the reconnection takes long enough for the whole outage to elapse on the fake clock.
+	stubs.ConnectDelay = 500 * time.Millisecond
+	first.Kill(ErrConnectionClosed)
+	awaitLink(false)
+	awaitTimers(0)
+	for range 30 {
+		clk.Advance(time.Second)
+	}
+
+	awaitLink(true)
+	second := stubs.Last()
+	require.NotSame(t, first, second)
+	require.Never(t, func() bool { return len(second.Sent()) > 0 }, 100*time.Millisecond, time.Millisecond)
+
+	// Pings resume one interval after the reconnection.
+	awaitTimers(1)
+	clk.Advance(time.Second)
+	require.Eventually(t, func() bool { return len(second.Sent()) == 1 }, time.Second, time.Millisecond)
+	require.Never(t, func() bool { return len(second.Sent()) > 1 }, 50*time.Millisecond, time.Millisecond)
+}
+
+func TestActiveKeepAlive_GenerationTaggedPings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	// The first connection is the second one of the client, generation 1 being a previous one.
+	stubs := &stubConnectionHandlerFactory{FirstGeneration: 2}
+	factory := newAdaptiveKeepAliveConnectionHandlerFactory(
+		newTestLogger(io.Discard),
+		NewBackoffConnectionHandlerFactory(nil, stubs.Factory, func(int) time.Duration { return 0 }, time.Minute),
+		time.Second,
+		time.Second,
+		NewKeepAliveMessageFactory(PingMessage, PingTokens()),
+		clk,
+		WithGenerationTaggedPings(),
+		WithPongTimeout(3*time.Second),
+	)
+
+	cli := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	h := factory(cli, func(Client, Message) {}, NewEventEmitter[EventType, EventType]())
+	require.NoError(t, h.Connect(ctx))
+	defer h.Close()
+
+	control, ok := Handle[*KeepAliveControl](cli)
+	require.True(t, ok)
+	first := stubs.Last()
+	awaitTimers := func(n int) {
+		require.Eventually(t, func() bool { return clk.Pending() == n }, time.Second, time.Millisecond)
+	}
+	ping := func(n int) {
+		clk.Advance(time.Second)
+		require.Eventually(t, func() bool { return len(first.Sent()) == n }, time.Second, time.Millisecond)
+		// The next tick and the pong timeout.
+		awaitTimers(2)
+	}
+	pong := func(payload string) {
+		h.Recv(withGeneration(NewPongMessage([]byte(payload)), 2))
+	}
+
+	awaitTimers(1)
+	ping(1)
+	require.Equal(t, "g2:1", string(first.Sent()[0].Data()))
+
+	// Answered: the pong timeout is disarmed.
+	pong("g2:1")
+	awaitTimers(1)
+
+	// Unanswered but for a pong of the previous connection, which is dropped and leaves the pong timeout armed.
+	ping(2)
+	pong("g1:2")
+	require.EqualValues(t, 1, control.StalePongs())
+	ping(3)
+	ping(4)
+	require.NoError(t, first.CloseErr())
+	require.Eventually(t, func() bool { return len(first.Received()) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, "g2:1", string(first.Received()[0].Data()))
+
+	clk.Advance(time.Second)
+	require.ErrorIs(t, first.CloseErr(), ErrPongTimeout)
+
+	// The connection replacing it tags its pings with its own generation.
+	require.Eventually(t, func() bool { return stubs.Last() != first }, time.Second, time.Millisecond)
+	// A ping queued while reconnecting may still carry the previous generation.
+	second := stubs.Last()
+	require.Eventually(t, func() bool {
+		clk.Advance(time.Second)
+		return slices.ContainsFunc(second.Sent(), func(m Message) bool { return strings.HasPrefix(string(m.Data()), "g3:") })
+	}, time.Second, 10*time.Millisecond)
+	require.EqualValues(t, 1, control.StalePongs())
+}
@@ -1,5 +1,7 @@
 package libws
 
+import "context"
+
 type (
 	PingMessageFactory func(content []byte) Message
 
@@ -21,6 +23,30 @@ func (h *passiveKeepAliveConnectionHandler) Recv(m Message) {
 	h.ConnectionHandler.Recv(m)
 }
 
+// sendContext sends m through the underlying ConnectionHandler, which the embedding would otherwise hide.
+func (h *passiveKeepAliveConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	return sendContext(ctx, h.ConnectionHandler, m)
+}
+
+// CloseWithErr closes the underlying ConnectionHandler with err as its close reason, see errCloser.
+func (h *passiveKeepAliveConnectionHandler) CloseWithErr(err error) {
+	closeWithErr(h.ConnectionHandler, err)
+}
+
+// ConnContext returns the connection-scoped context of the underlying ConnectionHandler, if any.
+func (h *passiveKeepAliveConnectionHandler) ConnContext() context.Context {
+	return connContextOf(h.ConnectionHandler)
+}
+
+// ConfigSection reports the layer, which has no tunable settings.
+func (h *passiveKeepAliveConnectionHandler) ConfigSection() ConfigSection {
+	return ConfigSection{Layer: "passive_keep_alive", Settings: map[string]any{}}
+}
+
+func (h *passiveKeepAliveConnectionHandler) unwrapHandler() ConnectionHandler {
+	return h.ConnectionHandler
+}
+
 func newPassiveKeepAliveConnectionHandler(
 	c ConnectionHandler,
 	h PassiveKeepAliveHandler,
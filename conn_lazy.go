@@ -0,0 +1,324 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// lazyConnectionHandler opens its inner handler on the first outbound message rather than on Connect, and closes
+	// it after a period without any inbound or outbound message. The next outbound message opens a new one. Idle
+	// closes are not terminal: the handler itself only closes on Close.
+	lazyConnectionHandler struct {
+		logger             logger
+		client             Client
+		handler            MessageHandler
+		emitter            emitter[EventType, EventType]
+		connHandlerFactory ConnectionHandlerFactory
+		idleClose          time.Duration
+		clock              clock
+
+		mu sync.Mutex
+		// ctx is the context connections are opened under, cancelled by Close to abort a connection in progress
+		ctx    context.Context
+		cancel context.CancelFunc
+		inner  ConnectionHandler
+		// dialing is the connection in progress, if any, which concurrent senders wait for
+		dialing *lazyDial
+		// inflight counts the sends in progress, which defer idle closes
+		inflight int
+		// activity is bumped on every message, invalidating the idle timers armed before
+		activity  uint64
+		idleTimer clockTimer
+		closed    bool
+
+		closeC    CloseChan
+		closeOnce sync.Once
+	}
+
+	// lazyDial is a connection in progress, err telling its outcome once done is closed.
+	lazyDial struct {
+		done chan struct{}
+		err  error
+	}
+
+	// onDemandConnector is implemented by connection handlers connecting on demand, so that the client can report
+	// connection failures to the sender.
+	onDemandConnector interface {
+		ensureConnected() error
+	}
+)
+
+// WithLazyConnect defers connecting until the first message is sent, Send opening the client transparently if needed,
+// and closes the connection after idleClose without any inbound or outbound message. The next message sent opens a
+// new connection. Sends in progress complete before an idle close. EventLazyOpened and EventIdleClosed are emitted on
+// every transition, and connection failures are reported to the SendErrorHandler along with the message that
+// triggered them.
+func WithLazyConnect(idleClose time.Duration) ClientOption {
+	return func(b *basicClient) {
+		b.lazyIdleClose = idleClose
+	}
+}
+
+func newLazyConnectionHandlerFactory(
+	logger logger,
+	connHandlerFactory ConnectionHandlerFactory,
+	idleClose time.Duration,
+	clk clock,
+) ConnectionHandlerFactory {
+	return func(client Client, handler MessageHandler, emitter emitter[EventType, EventType]) ConnectionHandler {
+		h := &lazyConnectionHandler{
+			logger:             orNop(logger).WithField("type", "lazyConnectionHandler"),
+			ctx:                context.Background(),
+			cancel:             func() {},
+			client:             client,
+			emitter:            emitter,
+			connHandlerFactory: connHandlerFactory,
+			idleClose:          idleClose,
+			clock:              clk,
+			closeC:             make(CloseChan),
+		}
+		h.handler = func(cli Client, m Message) {
+			h.touch()
+			handler(cli, m)
+		}
+
+		return h
+	}
+}
+
+// Connect keeps ctx for the connections to come, without connecting.
+func (h *lazyConnectionHandler) Connect(ctx context.Context) error {
+	h.mu.Lock()
+	h.cancel()
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	h.mu.Unlock()
+
+	return nil
+}
+
+// Send connects if needed and sends m. Connection failures are logged and m is dropped.
+func (h *lazyConnectionHandler) Send(m Message) {
+	if err := h.sendContext(context.Background(), m); err != nil {
+		h.logger.Warnf("dropping outbound message: %s", err)
+	}
+}
+
+// sendContext connects if needed, then sends m through the connection until ctx is done, see contextSender.
+func (h *lazyConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	inner, err := h.connected(true)
+	if err != nil {
+		return fmt.Errorf("cannot connect: %w", err)
+	}
+
+	err = sendContext(ctx, inner, m)
+
+	h.mu.Lock()
+	h.inflight--
+	h.touchLocked()
+	h.mu.Unlock()
+
+	return err
+}
+
+// Recv passes m to the current connection, if any.
+func (h *lazyConnectionHandler) Recv(m Message) {
+	h.mu.Lock()
+	inner := h.inner
+	h.touchLocked()
+	h.mu.Unlock()
+
+	if inner != nil {
+		inner.Recv(m)
+	}
+}
+
+func (h *lazyConnectionHandler) ensureConnected() error {
+	_, err := h.connected(false)
+	return err
+}
+
+// connected returns the current connection, connecting first when there is none, along with a send accounted in
+// progress on it when sending. Connections are opened without mu held, concurrent callers waiting for the same one.
+func (h *lazyConnectionHandler) connected(sending bool) (ConnectionHandler, error) {
+	h.mu.Lock()
+	for {
+		switch {
+		case h.closed:
+			h.mu.Unlock()
+			return nil, ErrConnectionClosed
+		case h.inner != nil:
+			inner := h.inner
+			if sending {
+				h.inflight++
+			}
+			h.mu.Unlock()
+			return inner, nil
+		case h.dialing != nil:
+			dial := h.dialing
+			h.mu.Unlock()
+			<-dial.done
+			if dial.err != nil {
+				return nil, dial.err
+			}
+			h.mu.Lock()
+		default:
+			dial := &lazyDial{done: make(chan struct{})}
+			h.dialing = dial
+			ctx := h.ctx
+			h.mu.Unlock()
+
+			h.dial(ctx, dial)
+			if dial.err != nil {
+				return nil, dial.err
+			}
+			h.mu.Lock()
+		}
+	}
+}
+
+// dial opens a new connection under ctx, installing it unless the handler was closed meanwhile, and tells the outcome
+// through dial.
+func (h *lazyConnectionHandler) dial(ctx context.Context, dial *lazyDial) {
+	defer close(dial.done)
+
+	inner := h.connHandlerFactory(h.client, h.handler, h.emitter)
+	err := inner.Connect(ctx)
+
+	h.mu.Lock()
+	h.dialing = nil
+	if h.closed {
+		err = ErrConnectionClosed
+	}
+	if err != nil {
+		h.mu.Unlock()
+		inner.Close()
+		dial.err = err
+		return
+	}
+
+	h.logger.Debugf("connected on demand")
+	h.inner = inner
+	h.touchLocked()
+	h.mu.Unlock()
+
+	go h.watch(inner)
+	go h.emitter.Emit(EventLazyOpened, EventLazyOpened)
+}
+
+// watch forgets inner once it closes on its own, so that the next message sent opens a new connection.
+func (h *lazyConnectionHandler) watch(inner ConnectionHandler) {
+	select {
+	case <-inner.CloseChan():
+	case <-h.closeC:
+		return
+	}
+
+	h.mu.Lock()
+	if h.inner == inner {
+		h.inner = nil
+		h.stopIdleTimerLocked()
+	}
+	h.mu.Unlock()
+}
+
+func (h *lazyConnectionHandler) touch() {
+	h.mu.Lock()
+	h.touchLocked()
+	h.mu.Unlock()
+}
+
+// touchLocked records activity, re-arming the idle timer while connected.
+func (h *lazyConnectionHandler) touchLocked() {
+	h.activity++
+	h.stopIdleTimerLocked()
+
+	if h.inner == nil || h.closed {
+		return
+	}
+
+	activity := h.activity
+	h.idleTimer = h.clock.AfterFunc(h.idleClose, func() { h.closeIdle(activity) })
+}
+
+func (h *lazyConnectionHandler) stopIdleTimerLocked() {
+	if h.idleTimer != nil {
+		h.idleTimer.Stop()
+		h.idleTimer = nil
+	}
+}
+
+// closeIdle closes the current connection unless there was activity since the timer was armed, or sends are in
+// progress, in which case the last one to complete re-arms it.
+func (h *lazyConnectionHandler) closeIdle(activity uint64) {
+	h.mu.Lock()
+	if activity != h.activity || h.inflight > 0 || h.inner == nil {
+		h.mu.Unlock()
+		return
+	}
+	inner := h.inner
+	h.inner = nil
+	h.idleTimer = nil
+	h.mu.Unlock()
+
+	h.logger.Debugf("closing connection idle for %s", h.idleClose)
+	inner.Close()
+	go h.emitter.Emit(EventIdleClosed, EventIdleClosed)
+}
+
+// Close closes the current connection, if any, and the handler for good, aborting a connection in progress.
+func (h *lazyConnectionHandler) Close() {
+	h.closeOnce.Do(func() {
+		h.mu.Lock()
+		h.closed = true
+		inner, cancel := h.inner, h.cancel
+		h.stopIdleTimerLocked()
+		h.mu.Unlock()
+
+		cancel()
+		if inner != nil {
+			inner.Close()
+		}
+		close(h.closeC)
+	})
+}
+
+// CloseChan is closed on Close only, idle closes are not reported.
+func (h *lazyConnectionHandler) CloseChan() CloseChan {
+	return h.closeC
+}
+
+// CloseErr returns the close reason of the current connection, nil while idle.
+func (h *lazyConnectionHandler) CloseErr() error {
+	if inner := h.unwrapHandler(); inner != nil {
+		return inner.CloseErr()
+	}
+
+	return nil
+}
+
+// ConnContext returns the connection-scoped context of the current connection, nil while idle.
+func (h *lazyConnectionHandler) ConnContext() context.Context {
+	if inner := h.unwrapHandler(); inner != nil {
+		return connContextOf(inner)
+	}
+
+	return nil
+}
+
+// ConfigSection reports the idle close delay.
+func (h *lazyConnectionHandler) ConfigSection() ConfigSection {
+	return ConfigSection{
+		Layer:    "lazy_connect",
+		Settings: map[string]any{"idle_close": h.idleClose.String()},
+	}
+}
+
+func (h *lazyConnectionHandler) unwrapHandler() ConnectionHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.inner
+}
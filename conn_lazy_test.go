@@ -0,0 +1,205 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testIdleClose = time.Minute
+
+func newLazyTestClient(
+	t *testing.T,
+	factory ConnectionHandlerFactory,
+	opts ...ClientOption,
+) (*basicClient, *fakeClock, <-chan EventType) {
+	t.Helper()
+
+	clk := newFakeClock()
+	events := make(chan EventType, 16)
+
+	cli := newBasicClient(
+		factory,
+		func(Client, Message) {},
+		func(_ Client, e EventType) {
+			if e == EventLazyOpened || e == EventIdleClosed {
+				events <- e
+			}
+		},
+		append([]ClientOption{WithLazyConnect(testIdleClose), withClock(clk)}, opts...)...,
+	)
+	t.Cleanup(cli.Close)
+
+	return cli, clk, events
+}
+
+func requireEvent(t *testing.T, events <-chan EventType, want EventType) {
+	t.Helper()
+
+	select {
+	case got := <-events:
+		require.Equal(t, want, got)
+	case <-time.After(time.Second):
+		t.Fatalf("event %d not emitted", want)
+	}
+}
+
+func TestLazyConnect_OpensOnSend(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	cli, _, events := newLazyTestClient(t, stubs.Factory)
+
+	require.Empty(t, stubs.Handlers())
+
+	cli.Send(NewDataMessage([]byte("hello")))
+
+	require.Len(t, stubs.Handlers(), 1)
+	require.Equal(t, []Message{NewDataMessage([]byte("hello"))}, stubs.Last().Sent())
+	requireEvent(t, events, EventLazyOpened)
+}
+
+func TestLazyConnect_IdleCloseAndReopen(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	cli, clk, events := newLazyTestClient(t, stubs.Factory)
+
+	cli.Send(NewDataMessage([]byte("first")))
+	requireEvent(t, events, EventLazyOpened)
+	first := stubs.Last()
+
+	// Inbound messages count as activity.
+	clk.Advance(testIdleClose - time.Second)
+	first.Deliver(NewDataMessage([]byte("update")))
+	clk.Advance(testIdleClose - time.Second)
+	require.False(t, isClosed(first.CloseChan()))
+
+	clk.Advance(time.Second)
+	require.True(t, isClosed(first.CloseChan()))
+	requireEvent(t, events, EventIdleClosed)
+	require.False(t, isClosed(cli.CloseChan()), "idle closes are not terminal")
+	require.Zero(t, clk.Pending())
+
+	cli.Send(NewDataMessage([]byte("second")))
+	requireEvent(t, events, EventLazyOpened)
+	require.Len(t, stubs.Handlers(), 2)
+	require.Equal(t, []Message{NewDataMessage([]byte("second"))}, stubs.Last().Sent())
+	require.Empty(t, first.Dropped())
+}
+
+func TestLazyConnect_InflightSendDefersIdleClose(t *testing.T) {
+	var (
+		entered = make(chan struct{})
+		gate    = make(chan struct{})
+		closeC  = make(CloseChan)
+	)
+	conn := &mockConnectionHandler{
+		ConnectFunc: func(context.Context) error { return nil },
+		CloseFunc:   func() { close(closeC) },
+		SendFunc: func(Message) {
+			close(entered)
+			<-gate
+		},
+		RecvFunc:      func(Message) {},
+		CloseChanFunc: func() CloseChan { return closeC },
+		CloseErrFunc:  func() error { return nil },
+	}
+
+	cli, clk, events := newLazyTestClient(t, func(Client, MessageHandler, emitter[EventType, EventType]) ConnectionHandler {
+		return conn
+	})
+
+	sent := make(chan struct{})
+	go func() {
+		cli.Send(NewDataMessage([]byte("slow")))
+		close(sent)
+	}()
+	<-entered
+	requireEvent(t, events, EventLazyOpened)
+
+	clk.Advance(testIdleClose)
+	require.False(t, isClosed(closeC), "a send in progress must complete before an idle close")
+
+	close(gate)
+	<-sent
+
+	clk.Advance(testIdleClose)
+	require.True(t, isClosed(closeC))
+	requireEvent(t, events, EventIdleClosed)
+}
+
+func TestLazyConnect_ConnectErrorReportedToSender(t *testing.T) {
+	errDial := errors.New("dial refused")
+
+	attempts := 0
+	factory := func(Client, MessageHandler, emitter[EventType, EventType]) ConnectionHandler {
+		attempts++
+		return &mockConnectionHandler{
+			ConnectFunc: func(context.Context) error { return errDial },
+			CloseFunc:   func() {},
+		}
+	}
+
+	var sendErrs []error
+	cli, _, _ := newLazyTestClient(t, factory, WithOnSendError(func(_ Client, _ Message, err error) {
+		sendErrs = append(sendErrs, err)
+	}))
+
+	cli.Send(NewDataMessage([]byte("a")))
+	cli.Send(NewDataMessage([]byte("b")))
+
+	require.Len(t, sendErrs, 2)
+	for _, err := range sendErrs {
+		require.ErrorIs(t, err, errDial)
+	}
+	require.Equal(t, 2, attempts, "every send retries to connect")
+	require.EqualValues(t, 2, cli.OutboundRejected())
+}
+
+func TestLazyConnect_CloseAbortsSlowConnect(t *testing.T) {
+	dialing := make(chan struct{})
+	var attempts atomic.Int32
+	factory := func(Client, MessageHandler, emitter[EventType, EventType]) ConnectionHandler {
+		attempts.Add(1)
+		return &mockConnectionHandler{
+			ConnectFunc: func(ctx context.Context) error {
+				close(dialing)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			CloseFunc: func() {},
+		}
+	}
+
+	h := newLazyConnectionHandlerFactory(nil, factory, testIdleClose, newFakeClock())(
+		&mockClient{}, func(Client, Message) {}, NewEventEmitter[EventType, EventType](),
+	)
+	require.NoError(t, h.Connect(context.Background()))
+
+	// Two senders share the connection in progress.
+	sendErrs := make(chan error, 2)
+	for range 2 {
+		go func() { sendErrs <- sendContext(context.Background(), h, NewDataMessage([]byte("a"))) }()
+	}
+	<-dialing
+
+	// The handler is not held by the connection in progress.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		h.Recv(NewPingMessage(nil))
+		_ = h.CloseErr()
+		h.Close()
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked by a connection in progress")
+	}
+
+	for range 2 {
+		require.ErrorIs(t, <-sendErrs, ErrConnectionClosed)
+	}
+	require.EqualValues(t, 1, attempts.Load())
+}
@@ -1,12 +1,19 @@
 package libws
 
-import "context"
+import (
+	"context"
+	"sync/atomic"
+)
 
 type (
 	emitter[K comparable, V any] interface {
 		Emit(K, V)
 	}
 
+	// Emitter is the emitter of client events given to connection handlers, e.g. by ConnectionHandlerFactory
+	// implementations outside of this package.
+	Emitter = emitter[EventType, EventType]
+
 	// ConnectionHandler defines the interactions with a connection.
 	ConnectionHandler interface {
 		// Recv is called when a message from the server is received.
@@ -34,6 +41,80 @@ type (
 		Close()
 	}
 
+	// ConnContextProvider is an optional interface implemented by connections and connection handlers which expose a
+	// context scoped to the current physical connection. The context is created when the connection is dialed and
+	// cancelled when it closes, so a fresh one exists for each reconnection. Use it to bind per-connection resources,
+	// such as goroutines, to the lifetime of the connection rather than the client.
+	ConnContextProvider interface {
+		ConnContext() context.Context
+	}
+
+	// contextSender is implemented by clients and connection handlers able to tell whether a message was handed to
+	// the connection, e.g. to keep it for the next connection when the current one is found closed, and to give up
+	// waiting for room to send it once ctx is done, returning ErrBackpressure.
+	contextSender interface {
+		sendContext(ctx context.Context, m Message) error
+	}
+
 	// ConnectionHandlerFactory is a function type that takes a MessageHandler and an EventEmitter and returns a ConnectionHandler.
 	ConnectionHandlerFactory func(Client, MessageHandler, emitter[EventType, EventType]) ConnectionHandler
 )
+
+// sendChecked sends m through h, returning the error of the connection when h reports it.
+func sendChecked(h ConnectionHandler, m Message) error {
+	return sendContext(context.Background(), h, m)
+}
+
+// sendContext sends m through s, waiting for room to send it until ctx is done, see contextSender. Senders unable to
+// tell are sent m with Send, which may block.
+func sendContext(ctx context.Context, s Sender, m Message) error {
+	if cs, ok := s.(contextSender); ok {
+		return cs.sendContext(ctx, m)
+	}
+
+	s.Send(m)
+	return nil
+}
+
+// connContextOf returns the connection-scoped context exposed by v, or nil if it does not expose one.
+func connContextOf(v any) context.Context {
+	if p, ok := v.(ConnContextProvider); ok {
+		return p.ConnContext()
+	}
+
+	return nil
+}
+
+// closeErrWithDialErr returns the close reason of inner, the current inner handler of a reconnecting layer, along with
+// dialErr, the error of its last failed attempt to replace it. dialErr alone is returned when no attempt succeeded.
+func closeErrWithDialErr(inner ConnectionHandler, dialErr *atomic.Pointer[error]) error {
+	last := dialErr.Load()
+	if inner == nil {
+		if last == nil {
+			return nil
+		}
+		return *last
+	}
+
+	reason := inner.CloseErr()
+	if reason == nil || last == nil {
+		return reason
+	}
+	return withReconnectFailure(reason, *last)
+}
+
+// innermostHandler returns the last handler of the chain starting at h, that is, the one closest to the connection.
+func innermostHandler(h ConnectionHandler) ConnectionHandler {
+	for {
+		u, ok := h.(handlerUnwrapper)
+		if !ok {
+			return h
+		}
+
+		inner := u.unwrapHandler()
+		if inner == nil {
+			return h
+		}
+		h = inner
+	}
+}
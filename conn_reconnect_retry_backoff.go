@@ -4,6 +4,7 @@ import (
 	"context"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -11,116 +12,294 @@ import (
 
 type backoffCalculator func(attempts int) (time time.Duration)
 
+// BackoffControl is the runtime-control handle of the backoff reconnection layer. Retrieve it with
+// Handle[*BackoffControl](client). It remains valid when the layer is rebuilt, always targeting the latest one.
+type BackoffControl struct {
+	mu      sync.RWMutex
+	handler *backoffConnectionHandler
+}
+
+// SetConnDurationThreshold changes the minimum lifetime a connection must reach for its termination to be considered
+// natural, which resets the attempts counter. It takes effect on the next disconnection.
+func (c *BackoffControl) SetConnDurationThreshold(d time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.handler != nil {
+		c.handler.connDurationThreshold.Store(int64(d))
+	}
+}
+
+// ConnDurationThreshold returns the threshold currently in effect, or zero when the client is not open.
+func (c *BackoffControl) ConnDurationThreshold() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.handler == nil {
+		return 0
+	}
+
+	return time.Duration(c.handler.connDurationThreshold.Load())
+}
+
+func (c *BackoffControl) bind(h *backoffConnectionHandler) {
+	c.mu.Lock()
+	c.handler = h
+	c.mu.Unlock()
+}
+
+// backoffConnectionHandler reconnects whenever its inner handler closes, waiting as computed by a backoffCalculator.
+// Outbound messages are forwarded in submission order, reconnections included: messages submitted while the inner
+// handler is down are held, without blocking the sender, and flushed to the next one before any newer message is
+// forwarded. Nothing is forwarded to a closed inner handler; inbound messages are dropped meanwhile. Connections
+// closed with a close code flagged as unrecoverable are not reopened; the handler closes with that reason instead.
+// The inner handler is owned by a connSupervisor.
 type backoffConnectionHandler struct {
-	client                Client
+	sup                   *connSupervisor
 	emitter               emitter[EventType, EventType]
 	logger                logger
-	inner                 ConnectionHandler
-	connHandlerFactory    ConnectionHandlerFactory
 	calculator            backoffCalculator
-	closeC                CloseChan
-	closeOnce             sync.Once
-	closeReason           error
-	send                  chan Message
 	recv                  chan Message
 	handler               MessageHandler
-	connDurationThreshold time.Duration
+	connDurationThreshold atomic.Int64
+	// queue holds outbound messages in submission order. Send blocks while it is full, unless reconnecting: messages
+	// sent meanwhile are held until the next inner handler is up.
+	queue        []Message
+	queueCap     int
+	queueMu      sync.Mutex
+	queueCond    *sync.Cond
+	reconnecting bool
+	// reconnectingC is closed and replaced whenever reconnecting changes, see linkState
+	reconnectingC chan struct{}
+	// wake signals run that messages were queued
+	wake chan struct{}
+	// budget accounts for the messages queued, nil unless the client has a MemoryBudget
+	budget *budgetAccount
 }
 
-func (b *backoffConnectionHandler) newConnHandler(ctx context.Context) ConnectionHandler {
-	var (
-		attempts = 0
-		ch       ConnectionHandler
-	)
-
-	for {
-		attempts++
+// newConnHandler connects a new inner handler, retrying until it succeeds. It gives up with an error wrapping
+// ErrTerminated once ctx is done or the handler closed.
+func (b *backoffConnectionHandler) newConnHandler(ctx context.Context) (ConnectionHandler, error) {
+	return b.sup.connect(ctx, b.handler, b.retryWait())
+}
 
-		ch = b.connHandlerFactory(b.client, b.handler, b.emitter)
+// retryWait returns how long to wait after each failed attempt of a single newConnHandler call, counting the attempts
+// from the first.
+func (b *backoffConnectionHandler) retryWait() retryWait {
+	attempts := 0
 
-		if err := ch.Connect(ctx); err != nil {
-			if errors.Is(err, ErrCannotConnect) {
-				b.logger.Infof("cannot connect, reconnecting asap due to: %s", err)
-				// Try to establish the connection asap
-				time.Sleep(time.Second)
-				continue
-			}
-
-			ttw := b.calculator(attempts)
+	return func(err error) time.Duration {
+		var ttw time.Duration
+		if errors.Is(err, ErrParamsUnavailable) {
+			// No dial happened, the attempt is not charged: the source of params retries on its own, see
+			// NewRetryingParamsRepo.
+			ttw = b.calculator(max(attempts, 1))
+			b.logger.Infof("cannot get connection params after %s, waiting %s", err, ttw)
+		} else if errors.Is(err, ErrCannotConnect) {
+			attempts++
+			b.logger.Infof("cannot connect, reconnecting asap due to: %s", err)
+			// Try to establish the connection asap
+			ttw = time.Second
+		} else {
+			attempts++
+			ttw = b.calculator(attempts)
 			b.logger.Infof("cannot connect after %s, waiting %s", err, ttw)
-			time.Sleep(ttw)
-			continue
 		}
 
-		return ch
+		return ttw
 	}
 }
 
 func (b *backoffConnectionHandler) run(ctx context.Context) {
 	var (
-		innerCloseChan = b.inner.CloseChan()
-		attempts       = 0
+		innerCloseChan = b.sup.current().CloseChan()
+		state          backoffState
 		then           = time.Now().UTC()
+		// reconnected delivers the next inner handler while a reconnection is in progress
+		reconnected chan ConnectionHandler
 	)
 
-	defer b.inner.Close()
+	defer func() { b.unwrapHandler().Close() }()
+	defer recoverPanic(ctx, b.logger, func(err error) {
+		b.sup.panicked(err)
+		b.Close()
+	})
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-b.closeC:
+		case <-b.sup.closeC:
 			return
 		case msg := <-b.recv:
-			if b.inner != nil {
-				// TODO: queue to buffer messages to send while reconnecting. Procrastinated as of now since
-				// we are not sending messages to exchanges but ping/pongs
-				b.inner.Recv(msg)
-			}
-		case msg := <-b.send:
-			if b.inner != nil {
-				// TODO: queue to buffer messages to send while reconnecting. Procrastinated as of now since
-				// we are not sending messages to exchanges but ping/pongs
-				b.inner.Send(msg)
+			if reconnected != nil || isClosed(innerCloseChan) {
+				b.logger.Debugf("dropping inbound message while reconnecting")
+				continue
 			}
+			b.sup.recv(msg)
+		case <-b.wake:
+			b.forward(innerCloseChan)
 		case <-innerCloseChan:
+			// Close closes the inner handler as well, which is not to be replaced then.
+			if isClosed(b.sup.closeC) {
+				return
+			}
+			b.setReconnecting(true)
+
 			// Ensure resource clean-up
-			b.inner.Close()
-			b.closeReason = b.inner.CloseErr()
-
-			if b.closeReason != nil {
-				if errors.Is(b.closeReason, ErrConnectionClosed) ||
-					errors.Is(b.closeReason, ErrTerminated) {
-					// If this connection terminated because the connection died naturally, or we
-					// have terminated it, reset counter to 0.
-					delta := time.Since(then)
-					if delta > b.connDurationThreshold {
-						// We assume that the connection was healthy for `connDurationThreshold` and that it
-						// was terminated due to natural reasons, so we should try to reconnect asap
-						attempts = 0
-					} else {
-						attempts++
-					}
-				}
+			inner := b.sup.current()
+			inner.Close()
+			closeReason := inner.CloseErr()
+
+			if isUnrecoverableClose(closeReason) {
+				b.logger.Errorf("not reconnecting, connection closed due to %s", closeReason)
+				b.Close()
+				return
 			}
 
-			ttw := b.calculator(attempts)
-			b.logger.Infof("retrying to connect after %s due to %s", ttw, b.closeReason)
-			time.Sleep(ttw)
+			ttw := state.next(b.policy(), time.Since(then), closeReason).Wait
+			b.logger.Infof("retrying to connect after %s due to %s", ttw, closeReason)
 
-			// Reopen the client
-			b.inner = b.newConnHandler(ctx)
-			innerCloseChan = b.inner.CloseChan()
+			// Reopen the client in the background, so that messages keep being accepted meanwhile.
+			innerCloseChan = nil
+			reconnected = make(chan ConnectionHandler)
+			go b.reconnect(ctx, ttw, reconnected)
+		case inner := <-reconnected:
+			if _, ok := b.sup.swap(inner); !ok {
+				return
+			}
+			innerCloseChan = inner.CloseChan()
+			reconnected = nil
 			then = time.Now().UTC()
 
+			// Flush held messages, in order, to the new handler.
+			b.setReconnecting(false)
+			b.forward(innerCloseChan)
+
 			go b.emitter.Emit(EventReconnect, EventReconnect)
 		}
 	}
 }
 
+// forward sends queued messages to the inner handler until the queue is empty or a reconnection is in progress. It
+// stops forwarding as soon as the inner handler is found closed.
+func (b *backoffConnectionHandler) forward(innerCloseChan CloseChan) {
+	for {
+		b.queueMu.Lock()
+		if b.reconnecting || len(b.queue) == 0 {
+			b.queueMu.Unlock()
+			return
+		}
+
+		if isClosed(innerCloseChan) {
+			b.reconnecting = true
+			b.queueCond.Broadcast()
+			b.queueMu.Unlock()
+			return
+		}
+
+		msg := b.dequeueLocked()
+		b.queueMu.Unlock()
+
+		b.budget.release(len(msg.Data()))
+		if err := sendChecked(b.sup.current(), msg); err != nil {
+			if errors.Is(err, ErrConnectionClosed) {
+				b.requeue(msg)
+				return
+			}
+			b.logger.Warnf("cannot send message: %s", err)
+		}
+	}
+}
+
+// requeue puts m back at the head of the queue, as the connection was found closed while sending it. It is forwarded
+// again on the next wake up, once the inner handler replaced its connection or was itself replaced.
+func (b *backoffConnectionHandler) requeue(m Message) {
+	if !b.budget.reserve(len(m.Data())) {
+		b.logger.Warnf("dropping outbound message: %s", ErrMemoryBudgetExceeded)
+		return
+	}
+
+	b.queueMu.Lock()
+	b.queue = append([]Message{m}, b.queue...)
+	b.queueMu.Unlock()
+}
+
+// setReconnecting sets whether the inner handler is down, releasing senders waiting for room in the queue.
+func (b *backoffConnectionHandler) setReconnecting(reconnecting bool) {
+	b.queueMu.Lock()
+	if b.reconnecting != reconnecting {
+		close(b.reconnectingC)
+		b.reconnectingC = make(chan struct{})
+	}
+	b.reconnecting = reconnecting
+	b.queueCond.Broadcast()
+	b.queueMu.Unlock()
+}
+
+// linkState tells whether a connection is live, that is, neither reconnecting nor closed, along with a channel closed
+// once that changes.
+func (b *backoffConnectionHandler) linkState() (bool, <-chan struct{}) {
+	b.queueMu.Lock()
+	defer b.queueMu.Unlock()
+
+	return !b.reconnecting && !isClosed(b.sup.closeC), b.reconnectingC
+}
+
+// dequeueLocked removes and returns the oldest queued message. The queue must not be empty.
+func (b *backoffConnectionHandler) dequeueLocked() Message {
+	m := b.queue[0]
+	b.queue[0] = nil
+	b.queue = b.queue[1:]
+	b.queueCond.Broadcast()
+	return m
+}
+
+// reconnect waits ttw, then opens a new inner handler and hands it over to run through reconnected. The handler is
+// closed when the backoff handler is closed in the meantime.
+func (b *backoffConnectionHandler) reconnect(ctx context.Context, ttw time.Duration, reconnected chan<- ConnectionHandler) {
+	defer recoverPanic(ctx, b.logger, func(error) { b.Close() })
+
+	select {
+	case <-time.After(ttw):
+	case <-b.sup.closeC:
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	inner, err := b.newConnHandler(ctx)
+	if err != nil {
+		b.logger.Infof("not reconnecting: %s", err)
+		return
+	}
+
+	select {
+	case reconnected <- inner:
+	case <-b.sup.closeC:
+		inner.Close()
+	case <-ctx.Done():
+		inner.Close()
+	}
+}
+
+// policy returns the reconnect policy currently in effect.
+func (b *backoffConnectionHandler) policy() ReconnectPolicy {
+	return ReconnectPolicy{
+		Calculator:            b.calculator,
+		ConnDurationThreshold: time.Duration(b.connDurationThreshold.Load()),
+	}
+}
+
 func (b *backoffConnectionHandler) Connect(ctx context.Context) error {
 	// open the first connection synchronously.
-	b.inner = b.newConnHandler(ctx)
+	inner, err := b.newConnHandler(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := b.sup.swap(inner); !ok {
+		return gaveUpConnecting(errHandlerClosed, nil)
+	}
 
 	// once the first connection has been established, spawn goro and return.
 	go b.run(ctx)
@@ -128,28 +307,124 @@ func (b *backoffConnectionHandler) Connect(ctx context.Context) error {
 	return nil
 }
 
+// Recv passes m to the inner handler, from the run goroutine. Messages received once closed are dropped.
 func (b *backoffConnectionHandler) Recv(m Message) {
-	b.recv <- m
+	select {
+	case b.recv <- m:
+	case <-b.sup.closeC:
+	}
 }
 
 func (b *backoffConnectionHandler) Send(m Message) {
-	b.send <- m
+	if err := b.sendContext(context.Background(), m); err != nil && !errors.Is(err, ErrConnectionClosed) {
+		b.logger.Warnf("dropping outbound message: %s", err)
+	}
 }
 
-func (b *backoffConnectionHandler) Close() {
-	b.closeOnce.Do(func() {
-		close(b.closeC)
+// sendContext queues m, waiting for room in the queue until ctx is done. Messages are queued regardless of room while
+// reconnecting, to be sent once reconnected.
+func (b *backoffConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	if !b.budget.reserve(len(m.Data())) {
+		return ErrMemoryBudgetExceeded
+	}
 
-		b.inner.Close()
+	// Waiters are to be woken up once ctx is done, as they would be by room in the queue.
+	stop := context.AfterFunc(ctx, func() {
+		b.queueMu.Lock()
+		b.queueCond.Broadcast()
+		b.queueMu.Unlock()
 	})
+	defer stop()
+
+	b.queueMu.Lock()
+	for len(b.queue) >= b.queueCap && !b.reconnecting && !isClosed(b.sup.closeC) && ctx.Err() == nil {
+		b.queueCond.Wait()
+	}
+
+	var err error
+	switch {
+	case isClosed(b.sup.closeC):
+		err = ErrConnectionClosed
+	case len(b.queue) >= b.queueCap && !b.reconnecting:
+		err = ErrBackpressure
+	}
+	if err != nil {
+		b.queueMu.Unlock()
+		b.budget.release(len(m.Data()))
+		return err
+	}
+
+	b.queue = append(b.queue, m)
+	b.queueMu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// evictOldest drops the oldest queued outbound message to make room in the memory budget.
+func (b *backoffConnectionHandler) evictOldest() bool {
+	b.queueMu.Lock()
+	if len(b.queue) == 0 {
+		b.queueMu.Unlock()
+		return false
+	}
+	m := b.dequeueLocked()
+	b.queueMu.Unlock()
+
+	b.budget.release(len(m.Data()))
+	return true
+}
+
+func (b *backoffConnectionHandler) Close() {
+	b.closeWithErr(nil)
+}
+
+// CloseWithErr closes as Close does, the inner handler with err as its close reason, see errCloser.
+func (b *backoffConnectionHandler) CloseWithErr(err error) {
+	b.closeWithErr(err)
+}
+
+// closeWithErr closes the handler, the inner one with err as its close reason unless nil.
+func (b *backoffConnectionHandler) closeWithErr(err error) {
+	b.sup.close(err)
+	b.setReconnecting(false)
+	b.budget.close()
 }
 
 func (b *backoffConnectionHandler) CloseChan() CloseChan {
-	return b.closeC
+	return b.sup.closeC
+}
+
+// ConnContext returns the context of the current innermost connection, which is cancelled when that connection closes
+// and replaced on every reconnection. It returns nil when the inner handler does not expose one.
+func (b *backoffConnectionHandler) ConnContext() context.Context {
+	return connContextOf(b.sup.current())
+}
+
+// ConfigSection reports the connection duration threshold currently in effect and the queue sizes.
+func (b *backoffConnectionHandler) ConfigSection() ConfigSection {
+	return ConfigSection{
+		Layer: "backoff",
+		Settings: map[string]any{
+			"conn_duration_threshold": time.Duration(b.connDurationThreshold.Load()).String(),
+			"send_buffer_size":        b.queueCap,
+			"recv_buffer_size":        cap(b.recv),
+		},
+	}
+}
+
+func (b *backoffConnectionHandler) unwrapHandler() ConnectionHandler {
+	return b.sup.current()
 }
 
+// CloseErr returns the close reason of the current inner handler, which is the one that closed while reconnecting,
+// along with the error of the last failed attempt to reconnect, if any.
 func (b *backoffConnectionHandler) CloseErr() error {
-	return b.closeReason
+	return b.sup.closeErr()
 }
 
 func newBackoffConnectionHandler(
@@ -161,20 +436,26 @@ func newBackoffConnectionHandler(
 	calculator backoffCalculator,
 	connDurationThreshold time.Duration,
 ) ConnectionHandler {
-	return &backoffConnectionHandler{
-		logger: logger.WithField(
+	h := &backoffConnectionHandler{
+		logger: orNop(logger).WithField(
 			"type", "conn_handler_reconnect_exp_backoff",
 		),
-		client:                client,
-		emitter:               emitter,
-		handler:               handler,
-		connHandlerFactory:    connHandlerFactory,
-		calculator:            calculator,
-		connDurationThreshold: connDurationThreshold,
-		send:                  make(chan Message, 32),
-		recv:                  make(chan Message, 32),
-		closeC:                make(CloseChan),
+		emitter:       emitter,
+		handler:       handler,
+		calculator:    calculator,
+		queueCap:      32,
+		recv:          make(chan Message, 32),
+		wake:          make(chan struct{}, 1),
+		reconnectingC: make(chan struct{}),
 	}
+	h.sup = newConnSupervisor(h.logger, client, connHandlerFactory, emitter)
+	h.queueCond = sync.NewCond(&h.queueMu)
+	h.connDurationThreshold.Store(int64(connDurationThreshold))
+	h.budget = budgetOf(client, "backoff_send_queue", h.evictOldest)
+
+	registerHandle(client, &BackoffControl{}).bind(h)
+
+	return h
 }
 
 func NewBackoffConnectionHandlerFactory(
@@ -0,0 +1,146 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBackoffHandler(
+	t *testing.T,
+	factory ConnectionHandlerFactory,
+	calculator backoffCalculator,
+) ConnectionHandler {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	h := newBackoffConnectionHandler(
+		newTestLogger(io.Discard),
+		nil,
+		NewEventEmitter[EventType, EventType](),
+		factory,
+		func(Client, Message) {},
+		calculator,
+		time.Minute,
+	)
+	require.NoError(t, h.Connect(ctx))
+	t.Cleanup(h.Close)
+
+	return h
+}
+
+// selfKillingStub drops its connection right after sending any of the messages in killAfter, then stalls briefly so
+// that more messages pile up in front of the handler under test.
+type selfKillingStub struct {
+	*stubConnectionHandler
+	killAfter map[string]bool
+}
+
+func (s *selfKillingStub) Send(m Message) {
+	s.stubConnectionHandler.Send(m)
+
+	if s.killAfter[string(m.Data())] {
+		s.Kill(ErrConnectionClosed)
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestBackoffConnectionHandler_OutboundOrderAcrossReconnects(t *testing.T) {
+	const total = 400
+
+	stubs := &stubConnectionHandlerFactory{}
+	killAfter := map[string]bool{"99": true, "199": true, "299": true}
+	factory := func(c Client, h MessageHandler, e emitter[EventType, EventType]) ConnectionHandler {
+		return &selfKillingStub{
+			stubConnectionHandler: stubs.Factory(c, h, e).(*stubConnectionHandler),
+			killAfter:             killAfter,
+		}
+	}
+
+	h := newTestBackoffHandler(t, factory, func(int) time.Duration { return 5 * time.Millisecond })
+
+	for i := 0; i < total; i++ {
+		h.Send(NewDataMessage([]byte(strconv.Itoa(i))))
+	}
+
+	delivered := func() int {
+		n := 0
+		for _, stub := range stubs.Handlers() {
+			n += len(stub.Sent())
+		}
+		return n
+	}
+	require.Eventually(t, func() bool { return delivered() == total }, 2*time.Second, time.Millisecond)
+	require.Len(t, stubs.Handlers(), len(killAfter)+1)
+
+	var received []string
+	for _, stub := range stubs.Handlers() {
+		require.Empty(t, stub.Dropped(), "no message may be handed to a closed connection")
+		for _, m := range stub.Sent() {
+			received = append(received, string(m.Data()))
+		}
+	}
+
+	for i, got := range received {
+		require.Equal(t, strconv.Itoa(i), got)
+	}
+}
+
+func TestBackoffConnectionHandler_NothingForwardedWhileReconnecting(t *testing.T) {
+	const (
+		// total exceeds the capacity of the send queue, so Send blocks unless it is drained while reconnecting
+		total   = 100
+		backoff = 300 * time.Millisecond
+	)
+
+	stubs := &stubConnectionHandlerFactory{}
+	h := newTestBackoffHandler(t, stubs.Factory, func(int) time.Duration { return backoff })
+
+	dead := stubs.Last()
+	dead.Kill(ErrConnectionClosed)
+
+	start := time.Now()
+	for i := 0; i < total; i++ {
+		h.Send(NewDataMessage([]byte(strconv.Itoa(i))))
+		h.Recv(NewDataMessage([]byte("inbound")))
+	}
+	require.Less(t, time.Since(start), backoff, "sends must not wait for the reconnection")
+
+	require.Eventually(t, func() bool { return len(stubs.Handlers()) == 2 }, 2*time.Second, time.Millisecond)
+	next := stubs.Last()
+	require.Eventually(t, func() bool { return len(next.Sent()) == total }, time.Second, time.Millisecond)
+
+	require.Empty(t, dead.Sent())
+	require.Empty(t, dead.Dropped(), "no message may be handed to a closed connection")
+	require.Empty(t, dead.Received(), "no message may be handed to a closed connection")
+
+	for i, m := range next.Sent() {
+		require.Equal(t, strconv.Itoa(i), string(m.Data()))
+	}
+
+	// Forwarding resumes once the new handler is in place.
+	h.Recv(NewDataMessage([]byte("inbound")))
+	require.Eventually(t, func() bool { return len(next.Received()) == 1 }, time.Second, time.Millisecond)
+}
+
+func TestBackoffConnectionHandler_CloseErrWhileTearingDown(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		stubs := &stubConnectionHandlerFactory{}
+		h := newTestBackoffHandler(t, stubs.Factory, func(int) time.Duration { return 0 })
+		stop := pollCloseErr(h.CloseErr)
+
+		// The connection drops while the handler is closed, reconnecting racing the close.
+		go stubs.Last().Kill(ErrConnectionClosed)
+		h.Close()
+		<-h.CloseChan()
+		stop()
+
+		require.ErrorIs(t, h.CloseErr(), ErrTerminated, "iteration %d", i)
+	}
+}
@@ -2,56 +2,124 @@ package libws
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// defaultRotationReadinessTimeout bounds how long a rotation waits for the new connection to be ready.
+const defaultRotationReadinessTimeout = 10 * time.Second
+
 type (
 
 	// reopenIntervalConnectionHandler is a ConnectionHandler implementation that
 	// automatically reopens the connection after a fixed interval.
-	// The struct uses a RWMutex to ensure safe concurrent access to the inner ConnectionHandler.
+	// The inner ConnectionHandler is owned by a connSupervisor, retrying failed attempts right away.
 	reopenIntervalConnectionHandler struct {
-		client Client
-
-		connHandlerFactory ConnectionHandlerFactory
-
-		reopenIntervalTicker *time.Ticker
+		sup *connSupervisor
 
-		inner   ConnectionHandler
-		innerMu sync.RWMutex
+		reopenInterval time.Duration
 
 		logger logger
 
 		handler MessageHandler
 
-		closeC    CloseChan
-		closeOnce sync.Once
+		// rotateC requests an immediate rotation, see ReopenControl.RotateNow
+		rotateC chan struct{}
+
+		// ready gates the swap to a new connection on rotation, see WithRotationReadiness
+		ready            RotationReadiness
+		readinessTimeout time.Duration
+
+		// seamless stitches the inbound streams of rotated connections, see WithSeamlessRotation
+		seamless *SeamlessRotation
+
+		// certExpiryOf and certLeeway schedule rotations ahead of certificate expiry, see WithCertExpiryRotation
+		certExpiryOf func() time.Time
+		certLeeway   time.Duration
+		clock        clock
+
+		// timersMu guards the timers driving rotations, which only run from a successful Connect to Close or ctx
+		// being done
+		timersMu             sync.Mutex
+		reopenIntervalTicker *clockTicker
+		certTimer            clockTimer
 
 		emitter emitter[EventType, EventType]
 	}
+
+	// RotationReadiness tells whether a freshly connected handler is ready to replace the current one, e.g. once it
+	// has confirmed all of its subscriptions. It must return when ctx is done.
+	RotationReadiness func(newConn ConnectionHandler, ctx context.Context) error
+
+	// ReopenOption customizes the reopen interval layer.
+	ReopenOption func(*reopenIntervalConnectionHandler)
+
+	// ReopenControl is the runtime-control handle of the reopen interval layer. Retrieve it with
+	// Handle[*ReopenControl](client). It remains valid when the layer is rebuilt, always targeting the latest one.
+	ReopenControl struct {
+		mu      sync.RWMutex
+		handler *reopenIntervalConnectionHandler
+	}
 )
 
+// RotateNow asks the reopen interval layer to open a new connection and swap it in, as if the interval had elapsed.
+// Requests issued while a rotation is pending are coalesced. It has no effect until the client is open.
+func (c *ReopenControl) RotateNow() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.handler == nil {
+		return
+	}
+
+	select {
+	case c.handler.rotateC <- struct{}{}:
+	default:
+	}
+}
+
+func (c *ReopenControl) bind(h *reopenIntervalConnectionHandler) {
+	c.mu.Lock()
+	c.handler = h
+	c.mu.Unlock()
+}
+
 // newReopenIntervalConn returns a new instance of reopenIntervalConnectionHandler.
 // It takes a logger, the interval after which the connection should be reopened,
 // and a ConnectionHandlerFactory as parameters.
 func newReopenIntervalConn(
 	logger logger,
 	client Client,
-	reopenIntervalTicker *time.Ticker,
+	reopenInterval time.Duration,
 	handler MessageHandler,
 	emitter emitter[EventType, EventType],
 	connFactory ConnectionHandlerFactory,
+	opts ...ReopenOption,
 ) *reopenIntervalConnectionHandler {
-	return &reopenIntervalConnectionHandler{
-		logger:               logger.WithField("type", "reopenIntervalConnectionHandler"),
-		client:               client,
-		reopenIntervalTicker: reopenIntervalTicker,
-		connHandlerFactory:   connFactory,
-		closeC:               make(CloseChan),
-		emitter:              emitter,
-		handler:              handler,
+	h := &reopenIntervalConnectionHandler{
+		logger:           orNop(logger).WithField("type", "reopenIntervalConnectionHandler"),
+		reopenInterval:   reopenInterval,
+		rotateC:          make(chan struct{}, 1),
+		emitter:          emitter,
+		handler:          handler,
+		readinessTimeout: defaultRotationReadinessTimeout,
+		clock:            realClock{},
 	}
+	h.sup = newConnSupervisor(h.logger, client, connFactory, emitter)
+
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.seamless != nil {
+		h.seamless.bind(handler)
+	}
+
+	registerHandle(client, &ReopenControl{}).bind(h)
+
+	return h
 }
 
 // NewReopenIntervalConnFactory returns a function (ConnectionHandlerFactory) that
@@ -62,125 +130,351 @@ func NewReopenIntervalConnFactory(
 	logger logger,
 	reopenInterval time.Duration,
 	connFactory ConnectionHandlerFactory,
+	opts ...ReopenOption,
 ) ConnectionHandlerFactory {
 	return func(client Client, handler MessageHandler, emitter emitter[EventType, EventType]) ConnectionHandler {
 		return newReopenIntervalConn(
 			logger,
 			client,
-			time.NewTicker(reopenInterval),
+			reopenInterval,
 			handler,
 			emitter,
 			connFactory,
+			opts...,
 		)
 	}
 }
 
+// WithRotationReadiness makes rotations wait for ready to accept the new connection before swapping it in. When ready
+// fails or times out, the new connection is discarded, the current one is kept, EventRotationAborted or
+// EventRotationTimeout is emitted, and the rotation is retried on the next tick. Reconnections after the current
+// connection closed on its own are not gated.
+func WithRotationReadiness(ready RotationReadiness) ReopenOption {
+	return func(h *reopenIntervalConnectionHandler) {
+		h.ready = ready
+	}
+}
+
+// WithRotationReadinessTimeout overrides how long rotations wait for readiness, 10 seconds by default.
+func WithRotationReadinessTimeout(d time.Duration) ReopenOption {
+	return func(h *reopenIntervalConnectionHandler) {
+		h.readinessTimeout = d
+	}
+}
+
+// WithCertExpiryRotation rotates the connection leeway before the client certificate it was opened with expires, as
+// told by expiryOf, which is called once every connection is established. Connections are meant to fetch the fresh
+// certificate on every dial, e.g. through OpenConnectionParams.TLSConfig. No rotation is scheduled when a connection is
+// established past expiry minus leeway.
+func WithCertExpiryRotation(leeway time.Duration, expiryOf func() time.Time) ReopenOption {
+	return func(h *reopenIntervalConnectionHandler) {
+		h.certLeeway = leeway
+		h.certExpiryOf = expiryOf
+	}
+}
+
+// withReopenClock sets the clock driving the reopen interval and scheduling certificate expiry rotations.
+func withReopenClock(clk clock) ReopenOption {
+	return func(h *reopenIntervalConnectionHandler) {
+		h.clock = clk
+	}
+}
+
 // Connect opens the initial connection and starts the run goroutine.
 func (b *reopenIntervalConnectionHandler) Connect(ctx context.Context) error {
 	b.logger.Infof("spawning and opening #0 conn")
-	b.innerMu.Lock()
-	b.inner = b.newConnectionHandler(ctx)
-	b.innerMu.Unlock()
-	go b.run(ctx)
+	inner, err := b.sup.connect(ctx, b.directHandler(), b.retryWait)
+	if err != nil {
+		return err
+	}
+	if _, ok := b.sup.swap(inner); !ok {
+		return gaveUpConnecting(errHandlerClosed, nil)
+	}
+
+	ticker := b.startTicker()
+	if ticker == nil {
+		return gaveUpConnecting(errHandlerClosed, nil)
+	}
+	b.scheduleCertRotation()
+	go b.run(ctx, ticker)
 	return nil
 }
 
 // Send sends a message to the server over the current connection.
 func (b *reopenIntervalConnectionHandler) Send(m Message) {
-	b.innerMu.RLock()
-	b.inner.Send(m)
-	b.innerMu.RUnlock()
+	if err := b.sendContext(context.Background(), m); err != nil {
+		b.logger.Warnf("cannot send message: %s", err)
+	}
+}
+
+func (b *reopenIntervalConnectionHandler) sendContext(ctx context.Context, m Message) error {
+	return b.sup.send(ctx, m)
 }
 
 // Recv receives a message from the server over the current connection.
 func (b *reopenIntervalConnectionHandler) Recv(m Message) {
-	b.innerMu.RLock()
-	b.inner.Recv(m)
-	b.innerMu.RUnlock()
+	b.sup.recv(m)
 }
 
-// Close terminates the current connection and stops the run goroutine.
+// Close terminates the current connection and stops the run goroutine, the close reason being ErrTerminated.
 func (b *reopenIntervalConnectionHandler) Close() {
-	b.safeClose()
+	b.sup.close(ErrTerminated)
+	b.stopTimers()
 }
 
 // CloseChan returns a channel that can be used to receive a signal when the connection is closed.
 func (b *reopenIntervalConnectionHandler) CloseChan() CloseChan {
-	return b.closeC
+	return b.sup.closeC
+}
+
+// ConnContext returns the context of the current innermost connection, which is cancelled when that connection closes
+// and replaced on every rotation. It returns nil when the inner handler does not expose one.
+func (b *reopenIntervalConnectionHandler) ConnContext() context.Context {
+	return connContextOf(b.sup.current())
+}
+
+// ConfigSection reports the reopen interval.
+func (b *reopenIntervalConnectionHandler) ConfigSection() ConfigSection {
+	return ConfigSection{
+		Layer:    "reopen_interval",
+		Settings: map[string]any{"reopen_interval": b.reopenInterval.String()},
+	}
+}
+
+func (b *reopenIntervalConnectionHandler) unwrapHandler() ConnectionHandler {
+	return b.sup.current()
 }
 
-// CloseErr returns the error that caused the connection to close.
+// CloseErr returns the error that caused the connection to close, along with the error of the last failed attempt to
+// reopen it, if any.
 func (b *reopenIntervalConnectionHandler) CloseErr() error {
-	return b.inner.CloseErr()
+	return b.sup.closeErr()
 }
 
-func (b *reopenIntervalConnectionHandler) safeClose() {
-	b.closeOnce.Do(b.close)
+// directHandler returns the message handler of a connection replacing the current one right away.
+func (b *reopenIntervalConnectionHandler) directHandler() MessageHandler {
+	if b.seamless != nil {
+		return b.seamless.direct()
+	}
+
+	return b.handler
 }
 
-func (b *reopenIntervalConnectionHandler) close() {
-	close(b.closeC)
-	b.innerMu.RLock()
-	b.inner.Close()
-	b.innerMu.RUnlock()
+// retryWait retries failed attempts to connect right away, see connSupervisor.connect.
+func (b *reopenIntervalConnectionHandler) retryWait(err error) time.Duration {
+	b.logger.Errorf("conn user data stream was closed due to %s", err)
+	return 0
 }
 
-// newConnectionHandler creates a new ConnectionHandler and attempts to establish a connection.
-// If the connection attempt fails, it will retry indefinitely.
-func (b *reopenIntervalConnectionHandler) newConnectionHandler(
-	ctx context.Context,
-) ConnectionHandler {
-	for {
-		conn := b.connHandlerFactory(b.client, b.handler, b.emitter)
+// startTicker starts the reopen interval ticker, returning nil once closed.
+func (b *reopenIntervalConnectionHandler) startTicker() *clockTicker {
+	b.timersMu.Lock()
+	defer b.timersMu.Unlock()
 
-		if err := conn.Connect(ctx); err != nil {
-			b.logger.Errorf("conn user data stream was closed due to %s", err)
-			// cleanup resources
-			conn.Close()
-			continue
-		}
+	if isClosed(b.sup.closeC) {
+		return nil
+	}
+
+	b.reopenIntervalTicker = newClockTicker(b.clock, b.reopenInterval)
+	return b.reopenIntervalTicker
+}
 
-		return conn
+// stopTimers stops the reopen interval ticker and the certificate expiry timer, if started.
+func (b *reopenIntervalConnectionHandler) stopTimers() {
+	b.timersMu.Lock()
+	defer b.timersMu.Unlock()
+
+	if b.reopenIntervalTicker != nil {
+		b.reopenIntervalTicker.Stop()
+	}
+	if b.certTimer != nil {
+		b.certTimer.Stop()
+		b.certTimer = nil
 	}
 }
 
-// run is a goroutine that manages reopening of the connection at a fixed interval,
-// or when the current connection closes unexpectedly.
-func (b *reopenIntervalConnectionHandler) run(ctx context.Context) {
-	defer b.reopenIntervalTicker.Stop()
+// run is a goroutine that manages reopening of the connection at a fixed interval, ticked by ticker, or when the
+// current connection closes unexpectedly. It returns once the handler is closed or ctx done.
+func (b *reopenIntervalConnectionHandler) run(ctx context.Context, ticker *clockTicker) {
+	defer b.stopTimers()
+	defer recoverPanic(ctx, b.logger, func(err error) {
+		b.sup.panicked(err)
+		b.Close()
+	})
 
 	connCount := 0
-	closeChan := b.inner.CloseChan()
+	closeChan := b.sup.current().CloseChan()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-b.reopenIntervalTicker.C:
+		case <-b.sup.closeC:
+			return
+		case <-ticker.C:
 			connCount++
-			// Time to spawn a new conn. When a new one is opened, close the previous one. Order matters
-			// to prevent data loss (duplicated data is preferred above lack of it)
-			b.logger.Infof("spawning and opening #%d conn due to reopen trigger", connCount)
-
-			nextConnectionHandler := b.newConnectionHandler(ctx)
-			nextCloseChan := nextConnectionHandler.CloseChan()
-			b.innerMu.Lock()
-			b.inner.Close()
-			b.inner = nextConnectionHandler
-			b.innerMu.Unlock()
-			closeChan = nextCloseChan
+			b.rotate(ctx, connCount, "reopen trigger")
+			closeChan = b.sup.current().CloseChan()
+		case <-b.rotateC:
+			connCount++
+			b.rotate(ctx, connCount, "rotation request")
+			closeChan = b.sup.current().CloseChan()
 		case <-closeChan:
+			// Close closes the current connection as well, which is not to be replaced then.
+			if isClosed(b.sup.closeC) {
+				return
+			}
 			connCount++
 			b.logger.Infof(
 				"spawning and opening #%d conn due to previous conn closed",
 				connCount,
 			)
 			// inner conn closed unexpectedly. Open a new one
-			conn := b.newConnectionHandler(ctx)
+			conn, err := b.sup.connect(ctx, b.directHandler(), b.retryWait)
+			if err != nil {
+				b.logger.Infof("not reopening: %s", err)
+				return
+			}
+			if _, ok := b.sup.swap(conn); !ok {
+				return
+			}
 			closeChan = conn.CloseChan()
-			b.innerMu.Lock()
-			b.inner = conn
-			b.innerMu.Unlock()
+			b.scheduleCertRotation()
+		}
+	}
+}
+
+// rotate opens a new connection and, once it is established and ready, closes the previous one. Order matters
+// to prevent data loss (duplicated data is preferred above lack of it). The previous connection is kept when the
+// rotation is aborted.
+func (b *reopenIntervalConnectionHandler) rotate(ctx context.Context, connCount int, reason string) {
+	b.logger.Infof("spawning and opening #%d conn due to %s", connCount, reason)
+
+	handler, stitched := b.handler, (<-chan struct{})(nil)
+	if b.seamless != nil {
+		handler, stitched = b.seamless.overlap()
+	}
+
+	nextConnectionHandler, err := b.sup.connect(ctx, handler, b.retryWait)
+	if err != nil {
+		if b.seamless != nil {
+			b.seamless.abort()
+		}
+		b.logger.Infof("aborting rotation to #%d conn: %s", connCount, err)
+		return
+	}
+
+	err = b.awaitReady(ctx, nextConnectionHandler)
+	if err == nil && stitched != nil {
+		err = b.awaitStitch(ctx, nextConnectionHandler, stitched)
+	}
+
+	if err != nil {
+		if b.seamless != nil {
+			b.seamless.abort()
+		}
+
+		event := EventRotationAborted
+		if errors.Is(err, context.DeadlineExceeded) {
+			event = EventRotationTimeout
 		}
+
+		b.logger.Warnf("aborting rotation to #%d conn, retrying next tick: %s", connCount, err)
+		nextConnectionHandler.Close()
+		go b.emitter.Emit(event, event)
+		return
+	}
+
+	prev, ok := b.sup.swap(nextConnectionHandler)
+	if !ok {
+		return
 	}
+	closeWithErr(prev, ErrRotated)
+	b.scheduleCertRotation()
+
+	if stitched != nil {
+		go b.emitter.Emit(EventRotationStitched, EventRotationStitched)
+	}
+}
+
+// scheduleCertRotation schedules the rotation of the connection just established ahead of its certificate expiry,
+// replacing the one scheduled for the previous connection.
+func (b *reopenIntervalConnectionHandler) scheduleCertRotation() {
+	if b.certExpiryOf == nil {
+		return
+	}
+
+	b.timersMu.Lock()
+	defer b.timersMu.Unlock()
+
+	if b.certTimer != nil {
+		b.certTimer.Stop()
+		b.certTimer = nil
+	}
+
+	select {
+	case <-b.sup.closeC:
+		return
+	default:
+	}
+
+	expiry := b.certExpiryOf()
+	wait := expiry.Add(-b.certLeeway).Sub(b.clock.Now())
+	if wait <= 0 {
+		b.logger.Warnf("certificate expiring at %s is within the %s rotation leeway, not rotating ahead of it", expiry, b.certLeeway)
+		return
+	}
+
+	b.logger.Debugf("rotating in %s, ahead of certificate expiry at %s", wait, expiry)
+	b.certTimer = b.clock.AfterFunc(wait, func() {
+		select {
+		case b.rotateC <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// awaitStitch waits for the seamless rotation to stitch the stream of conn to the current one. When the current
+// connection closes or the overlap outlasts the readiness timeout, conn takes over anyway.
+func (b *reopenIntervalConnectionHandler) awaitStitch(
+	ctx context.Context,
+	conn ConnectionHandler,
+	stitched <-chan struct{},
+) error {
+	currentCloseChan := b.sup.current().CloseChan()
+
+	timer := time.NewTimer(b.readinessTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-stitched:
+	case <-currentCloseChan:
+		b.seamless.force()
+	case <-timer.C:
+		b.logger.Warnf("streams not stitched after %s, switching over, sequenced messages may be missed", b.readinessTimeout)
+		b.seamless.force()
+	case <-conn.CloseChan():
+		return errors.New("new connection closed before being stitched")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// awaitReady waits for the readiness check, if any, to accept conn.
+func (b *reopenIntervalConnectionHandler) awaitReady(ctx context.Context, conn ConnectionHandler) error {
+	if b.ready == nil {
+		return nil
+	}
+
+	readyCtx, cancel := context.WithTimeout(ctx, b.readinessTimeout)
+	defer cancel()
+
+	err := b.ready(conn, readyCtx)
+	if err != nil && errors.Is(readyCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: rotation readiness timed out: %w", context.DeadlineExceeded, err)
+	}
+
+	return err
 }
@@ -0,0 +1,362 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReopenIntervalConnectionHandler_RotationReadiness(t *testing.T) {
+	tests := []struct {
+		name      string
+		ready     RotationReadiness
+		wantEvent EventType
+		swapped   bool
+	}{
+		{
+			name:    "ready",
+			ready:   func(ConnectionHandler, context.Context) error { return nil },
+			swapped: true,
+		},
+		{
+			name: "timeout",
+			ready: func(_ ConnectionHandler, ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantEvent: EventRotationTimeout,
+		},
+		{
+			name:      "abort",
+			ready:     func(ConnectionHandler, context.Context) error { return errors.New("subscriptions not confirmed") },
+			wantEvent: EventRotationAborted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			events := make(chan EventType, 1)
+			emitter := NewEventEmitter[EventType, EventType]()
+			for _, event := range []EventType{EventRotationAborted, EventRotationTimeout} {
+				emitter.On(event, func(e EventType) { events <- e })
+			}
+
+			var readyFor atomic.Value
+			stubs := &stubConnectionHandlerFactory{}
+			h := newReopenIntervalConn(
+				newTestLogger(io.Discard),
+				nil,
+				time.Hour,
+				func(Client, Message) {},
+				emitter,
+				stubs.Factory,
+				WithRotationReadiness(func(conn ConnectionHandler, ctx context.Context) error {
+					readyFor.Store(conn)
+					return tt.ready(conn, ctx)
+				}),
+				WithRotationReadinessTimeout(20*time.Millisecond),
+			)
+			require.NoError(t, h.Connect(ctx))
+			defer h.Close()
+
+			current := stubs.Last()
+			h.rotateC <- struct{}{}
+
+			require.Eventually(t, func() bool { return len(stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+			next := stubs.Last()
+
+			if tt.swapped {
+				require.Eventually(t, func() bool { return h.unwrapHandler() == next }, time.Second, time.Millisecond)
+				require.Eventually(t, func() bool { return isClosed(current.CloseChan()) }, time.Second, time.Millisecond)
+				require.Equal(t, next, readyFor.Load())
+				return
+			}
+
+			select {
+			case event := <-events:
+				require.Equal(t, tt.wantEvent, event)
+			case <-time.After(time.Second):
+				t.Fatal("no event emitted")
+			}
+
+			require.Equal(t, next, readyFor.Load())
+			require.Equal(t, current, h.unwrapHandler())
+			require.True(t, isClosed(next.CloseChan()), "discarded connection must be closed")
+			require.False(t, isClosed(current.CloseChan()))
+
+			// The current connection is still watched after an aborted rotation.
+			current.Kill(ErrConnectionClosed)
+			require.Eventually(t, func() bool { return len(stubs.Handlers()) == 3 }, time.Second, time.Millisecond)
+		})
+	}
+}
+
+func TestReopenIntervalConnectionHandler_CertExpiryRotation(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serveUntilClosed(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	// Every dial fetches params anew, each generation of TLS config standing for a freshly rotated certificate.
+	clk := newFakeClock()
+	var (
+		mu          sync.Mutex
+		generations int
+		handshakes  []int
+		expiry      time.Time
+	)
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		generations++
+		generation := generations
+		expiry = clk.Now().Add(time.Hour)
+
+		return OpenConnectionParams{URL: u, TLSConfig: &tls.Config{
+			RootCAs: roots,
+			VerifyConnection: func(tls.ConnectionState) error {
+				mu.Lock()
+				handshakes = append(handshakes, generation)
+				mu.Unlock()
+				return nil
+			},
+		}}, nil
+	})
+	expiryOf := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return expiry
+	}
+	dialed := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), handshakes...)
+	}
+
+	// The dialer itself does not trust the server: only the config of the params lets the handshake succeed.
+	h := newReopenIntervalConn(
+		newTestLogger(io.Discard),
+		nil,
+		24*time.Hour,
+		func(Client, Message) {},
+		NewEventEmitter[EventType, EventType](),
+		NewBaseConnectionHandlerFactory(nil, NewWebsocketFactory(nil, websocket.DefaultDialer, repo, ErrorAdapters{})),
+		WithCertExpiryRotation(10*time.Minute, expiryOf),
+		withReopenClock(clk),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, h.Connect(ctx))
+	defer h.Close()
+
+	first := h.unwrapHandler()
+	require.Equal(t, []int{1}, dialed())
+
+	clk.Advance(49 * time.Minute)
+	require.Never(t, func() bool { return len(dialed()) > 1 }, 50*time.Millisecond, 5*time.Millisecond)
+
+	clk.Advance(time.Minute)
+	require.Eventually(t, func() bool { return h.unwrapHandler() != first }, time.Second, time.Millisecond)
+	require.Equal(t, []int{1, 2}, dialed(), "the rotation dials with fresh params")
+	require.Eventually(t, func() bool { return isClosed(first.CloseChan()) }, time.Second, time.Millisecond)
+
+	// The next rotation is scheduled ahead of the expiry of the new certificate, along with the reopen interval tick.
+	second := h.unwrapHandler()
+	require.Eventually(t, func() bool { return clk.Pending() == 2 }, time.Second, time.Millisecond)
+	clk.Advance(49 * time.Minute)
+	require.Never(t, func() bool { return len(dialed()) > 2 }, 50*time.Millisecond, 5*time.Millisecond)
+	clk.Advance(time.Minute)
+	require.Eventually(t, func() bool { return h.unwrapHandler() != second }, time.Second, time.Millisecond)
+	require.Equal(t, []int{1, 2, 3}, dialed())
+
+	h.Close()
+	require.Zero(t, clk.Pending(), "no rotation scheduled once closed")
+}
+
+func TestReopenIntervalConnectionHandler_RotationCloseReason(t *testing.T) {
+	noWait := func(int) time.Duration { return 0 }
+	compositions := map[string]func(ConnectionHandlerFactory) ConnectionHandlerFactory{
+		"base": func(base ConnectionHandlerFactory) ConnectionHandlerFactory { return base },
+		"keep-alive over backoff": func(base ConnectionHandlerFactory) ConnectionHandlerFactory {
+			return NewActiveKeepAliveConnectionHandlerFactory(
+				nil,
+				NewPassiveKeepAliveConnectionHandlerFactory(
+					NewBackoffConnectionHandlerFactory(nil, base, noWait, time.Minute),
+					KeepAliveHandlerReplyPingWithPong,
+				),
+				time.Hour,
+				NewKeepAliveMessageFactory(PingMessage, func() []byte { return nil }),
+			)
+		},
+	}
+
+	for name, build := range compositions {
+		t.Run(name, func(t *testing.T) {
+			srv := newTestWsServer(t, serveUntilClosed)
+			u := testWsURL(t, srv)
+			repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+				return OpenConnectionParams{URL: u}, nil
+			})
+			base := NewBaseConnectionHandlerFactory(nil, NewWebsocketFactory(nil, websocket.DefaultDialer, repo, ErrorAdapters{}))
+
+			var (
+				mu       sync.Mutex
+				handlers []ConnectionHandler
+			)
+			recording := func(client Client, handler MessageHandler, emitter emitter[EventType, EventType]) ConnectionHandler {
+				h := build(base)(client, handler, emitter)
+				mu.Lock()
+				handlers = append(handlers, h)
+				mu.Unlock()
+				return h
+			}
+			nth := func(i int) ConnectionHandler {
+				mu.Lock()
+				defer mu.Unlock()
+				if i >= len(handlers) {
+					return nil
+				}
+				return handlers[i]
+			}
+
+			cli := newBasicClient(NewReopenIntervalConnFactory(nil, time.Hour, recording), func(Client, Message) {}, func(Client, EventType) {})
+			require.NoError(t, cli.Open(context.Background()))
+			control, ok := Handle[*ReopenControl](cli)
+			require.True(t, ok)
+
+			control.RotateNow()
+			rotated := nth(0)
+			require.Eventually(t, func() bool { return isClosed(rotated.CloseChan()) }, 2*time.Second, time.Millisecond)
+			require.ErrorIs(t, rotated.CloseErr(), ErrRotated)
+			require.NotErrorIs(t, rotated.CloseErr(), ErrTerminated)
+			require.Equal(t, DisconnectRotated, DisconnectReasonOf(rotated.CloseErr()))
+
+			current := nth(1)
+			require.NotNil(t, current)
+			cli.Close()
+			require.Eventually(t, func() bool { return isClosed(current.CloseChan()) }, time.Second, time.Millisecond)
+			require.Equal(t, DisconnectUserClose, DisconnectReasonOf(current.CloseErr()))
+		})
+	}
+}
+
+// requireGoroutineExited asserts that no goroutine is eventually left running fn, as named in stack traces.
+func requireGoroutineExited(t *testing.T, fn string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		buf := make([]byte, 1<<20)
+		return !bytes.Contains(buf[:runtime.Stack(buf, true)], []byte(fn))
+	}, time.Second, time.Millisecond, "%s still running", fn)
+}
+
+func TestReopenIntervalConnectionHandler_Lifecycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing bool
+		run     func(t *testing.T, h *reopenIntervalConnectionHandler, clk *fakeClock, ctx context.Context, cancel context.CancelFunc)
+	}{
+		{
+			name: "close before connect",
+			run: func(t *testing.T, h *reopenIntervalConnectionHandler, _ *fakeClock, ctx context.Context, _ context.CancelFunc) {
+				h.Close()
+				require.ErrorIs(t, h.Connect(ctx), ErrTerminated)
+			},
+		},
+		{
+			name: "close after connect",
+			run: func(t *testing.T, h *reopenIntervalConnectionHandler, clk *fakeClock, ctx context.Context, _ context.CancelFunc) {
+				require.NoError(t, h.Connect(ctx))
+				require.Equal(t, 2, clk.Pending(), "the reopen interval and certificate expiry are not both scheduled")
+				h.Close()
+				require.Zero(t, clk.Pending())
+			},
+		},
+		{
+			name: "context cancelled",
+			run: func(t *testing.T, h *reopenIntervalConnectionHandler, clk *fakeClock, ctx context.Context, cancel context.CancelFunc) {
+				require.NoError(t, h.Connect(ctx))
+				cancel()
+				require.Eventually(t, func() bool { return clk.Pending() == 0 }, time.Second, time.Millisecond)
+			},
+		},
+		{
+			name:    "connect failed",
+			failing: true,
+			run: func(t *testing.T, h *reopenIntervalConnectionHandler, _ *fakeClock, ctx context.Context, _ context.CancelFunc) {
+				ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+				defer cancel()
+				require.ErrorIs(t, h.Connect(ctx), context.DeadlineExceeded)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			clk := newFakeClock()
+			stubs := &stubConnectionHandlerFactory{}
+			if tt.failing {
+				stubs.FailConnects, stubs.ConnectErr, stubs.ConnectDelay = 1<<30, ErrCannotConnect, time.Millisecond
+			}
+			h := newReopenIntervalConn(
+				newTestLogger(io.Discard),
+				nil,
+				time.Hour,
+				func(Client, Message) {},
+				NewEventEmitter[EventType, EventType](),
+				stubs.Factory,
+				WithCertExpiryRotation(time.Minute, func() time.Time { return clk.Now().Add(time.Hour) }),
+				withReopenClock(clk),
+			)
+
+			tt.run(t, h, clk, ctx, cancel)
+
+			require.Zero(t, clk.Pending(), "a timer was left running")
+			requireGoroutineExited(t, "(*reopenIntervalConnectionHandler).run")
+			if tt.name != "context cancelled" {
+				requireAllClosed(t, stubs)
+			}
+		})
+	}
+}
+
+func TestReopenIntervalConnectionHandler_CloseErrAfterClose(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	h := newReopenIntervalConn(newTestLogger(io.Discard), nil, time.Hour, func(Client, Message) {},
+		NewEventEmitter[EventType, EventType](), stubs.Factory)
+	require.NoError(t, h.Connect(context.Background()))
+
+	h.Close()
+	require.Equal(t, ErrTerminated, h.CloseErr())
+}
@@ -0,0 +1,199 @@
+package libws
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// connSupervisor owns the inner handler of a layer replacing it over time: it connects new ones, retrying as the
+	// layer tells, swaps them in and closes the one in use along with itself. Layers only decide when to replace the
+	// inner handler and how long to wait between attempts.
+	connSupervisor struct {
+		logger  logger
+		client  Client
+		factory ConnectionHandlerFactory
+		emitter emitter[EventType, EventType]
+
+		// mu guards inner, which is swapped in and out only while closeC is open
+		mu    sync.RWMutex
+		inner ConnectionHandler
+
+		closeC    CloseChan
+		closeOnce sync.Once
+		// reason is the close reason close was given, if any
+		reason atomic.Pointer[error]
+
+		// dialErr is the error of the last failed attempt to connect, cleared once one succeeds
+		dialErr atomic.Pointer[error]
+		// panicErr is the close reason once a panic was recovered, see PanicPolicy
+		panicErr atomic.Pointer[error]
+	}
+
+	// retryWait tells how long to wait before attempting to connect again after an attempt failed with err.
+	retryWait func(err error) time.Duration
+)
+
+func newConnSupervisor(
+	logger logger,
+	client Client,
+	factory ConnectionHandlerFactory,
+	emitter emitter[EventType, EventType],
+) *connSupervisor {
+	return &connSupervisor{
+		logger:  logger,
+		client:  client,
+		factory: factory,
+		emitter: emitter,
+		closeC:  make(CloseChan),
+	}
+}
+
+// connect creates a new inner handler delivering to handler and connects it, waiting as told by wait and retrying
+// whenever it fails. Handlers failing to connect are closed. It gives up with an error wrapping ErrTerminated once ctx
+// is done or the supervisor closed. The handler connected is not swapped in, see swap.
+func (s *connSupervisor) connect(ctx context.Context, handler MessageHandler, wait retryWait) (ConnectionHandler, error) {
+	var last error
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, gaveUpConnecting(ctx.Err(), last)
+		case <-s.closeC:
+			return nil, gaveUpConnecting(errHandlerClosed, last)
+		default:
+		}
+
+		conn := s.factory(s.client, handler, s.emitter)
+
+		err := conn.Connect(ctx)
+		if err == nil {
+			s.dialErr.Store(nil)
+			return conn, nil
+		}
+
+		// cleanup resources
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, gaveUpConnecting(ctx.Err(), last)
+		}
+		last = err
+		s.dialErr.Store(&err)
+
+		ttw := wait(err)
+		if ttw <= 0 {
+			continue
+		}
+
+		timer := time.NewTimer(ttw)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, gaveUpConnecting(ctx.Err(), last)
+		case <-s.closeC:
+			timer.Stop()
+			return nil, gaveUpConnecting(errHandlerClosed, last)
+		}
+	}
+}
+
+// swap makes next the inner handler, returning the previous one, if any, for the caller to close. Once the supervisor
+// is closed, next is closed instead and swap returns false.
+func (s *connSupervisor) swap(next ConnectionHandler) (ConnectionHandler, bool) {
+	s.mu.Lock()
+	if isClosed(s.closeC) {
+		s.mu.Unlock()
+		next.Close()
+		return nil, false
+	}
+
+	prev := s.inner
+	s.inner = next
+	s.mu.Unlock()
+
+	return prev, true
+}
+
+// current returns the inner handler, nil until one connected.
+func (s *connSupervisor) current() ConnectionHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.inner
+}
+
+// send sends m to the inner handler, which is not swapped out until it returns.
+func (s *connSupervisor) send(ctx context.Context, m Message) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.inner == nil {
+		return ErrConnectionClosed
+	}
+	return sendContext(ctx, s.inner, m)
+}
+
+// recv passes m to the inner handler, if any.
+func (s *connSupervisor) recv(m Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.inner != nil {
+		s.inner.Recv(m)
+	}
+}
+
+// close closes the supervisor and its inner handler, with err as its close reason unless nil. Handlers connected
+// afterwards are closed as they are swapped in.
+func (s *connSupervisor) close(err error) {
+	s.closeOnce.Do(func() {
+		if err != nil {
+			s.reason.Store(&err)
+		}
+
+		s.mu.Lock()
+		close(s.closeC)
+		inner := s.inner
+		s.mu.Unlock()
+
+		// There is no inner handler when Connect gave up.
+		switch {
+		case inner == nil:
+		case err != nil:
+			closeWithErr(inner, err)
+		default:
+			inner.Close()
+		}
+	})
+}
+
+// panicked records err, a recovered panic, as the close reason.
+func (s *connSupervisor) panicked(err error) {
+	s.panicErr.CompareAndSwap(nil, &err)
+}
+
+// closeErr returns the recovered panic, if any, or else the close reason of the inner handler, along with the error
+// of the last failed attempt to replace it, if any. The close reason close was given, if any, comes first, unless the
+// inner handler reports it already.
+func (s *connSupervisor) closeErr() error {
+	if err := s.panicErr.Load(); err != nil {
+		return *err
+	}
+
+	err := closeErrWithDialErr(s.current(), &s.dialErr)
+	reason := s.reason.Load()
+	switch {
+	case reason == nil:
+		return err
+	case err == nil:
+		return *reason
+	case errors.Is(err, *reason):
+		return err
+	default:
+		return closedWhileDown(*reason, err)
+	}
+}
@@ -0,0 +1,135 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// supervisedLayers are the layers replacing their inner handler through a connSupervisor, built on top of stubs.
+var supervisedLayers = []struct {
+	name string
+	// waits tells whether the layer waits as told by calculator between failed attempts, rather than retrying at once
+	waits bool
+	build func(stubs *stubConnectionHandlerFactory, calculator backoffCalculator) ConnectionHandler
+}{
+	{
+		name: "reopen",
+		build: func(stubs *stubConnectionHandlerFactory, _ backoffCalculator) ConnectionHandler {
+			return newReopenIntervalConn(newTestLogger(io.Discard), nil, time.Hour, func(Client, Message) {},
+				NewEventEmitter[EventType, EventType](), stubs.Factory)
+		},
+	},
+	{
+		name:  "backoff",
+		waits: true,
+		build: func(stubs *stubConnectionHandlerFactory, calculator backoffCalculator) ConnectionHandler {
+			return newBackoffConnectionHandler(newTestLogger(io.Discard), nil, NewEventEmitter[EventType, EventType](),
+				stubs.Factory, func(Client, Message) {}, calculator, time.Minute)
+		},
+	},
+}
+
+// requireAllClosed asserts that every handler built by stubs is eventually closed.
+func requireAllClosed(t *testing.T, stubs *stubConnectionHandlerFactory) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		for _, h := range stubs.Handlers() {
+			if !isClosed(h.CloseChan()) {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond, "a connection was left open")
+}
+
+func TestConnSupervisor_RetriesUntilConnected(t *testing.T) {
+	errFlaky := errors.New("503 service unavailable")
+
+	for _, layer := range supervisedLayers {
+		t.Run(layer.name, func(t *testing.T) {
+			stubs := &stubConnectionHandlerFactory{FailConnects: 2, ConnectErr: errFlaky}
+			h := layer.build(stubs, func(int) time.Duration { return 0 })
+			require.NoError(t, h.Connect(context.Background()))
+			defer h.Close()
+
+			handlers := stubs.Handlers()
+			require.Len(t, handlers, 3)
+			require.True(t, isClosed(handlers[0].CloseChan()), "failed attempts are closed")
+			require.True(t, isClosed(handlers[1].CloseChan()), "failed attempts are closed")
+			require.False(t, isClosed(handlers[2].CloseChan()))
+			require.NoError(t, h.CloseErr(), "the last dial error is cleared once connected")
+		})
+	}
+}
+
+func TestConnSupervisor_GivesUpOnContext(t *testing.T) {
+	errFlaky := errors.New("503 service unavailable")
+
+	for _, layer := range supervisedLayers {
+		t.Run(layer.name, func(t *testing.T) {
+			stubs := &stubConnectionHandlerFactory{FailConnects: 1 << 30, ConnectErr: errFlaky, ConnectDelay: time.Millisecond}
+			h := layer.build(stubs, func(int) time.Duration { return time.Hour })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+			defer cancel()
+			err := h.Connect(ctx)
+			require.ErrorIs(t, err, ErrTerminated)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.ErrorIs(t, err, errFlaky)
+
+			if layer.waits {
+				require.Len(t, stubs.Handlers(), 1, "dialed again once ctx was done")
+			}
+			requireAllClosed(t, stubs)
+		})
+	}
+}
+
+func TestConnSupervisor_CloseWhileReplacing(t *testing.T) {
+	replacements := []struct {
+		name    string
+		layer   string
+		replace func(h ConnectionHandler, current *stubConnectionHandler)
+	}{
+		{
+			name:    "reconnection",
+			replace: func(_ ConnectionHandler, current *stubConnectionHandler) { current.Kill(errors.New("dropped")) },
+		},
+		{
+			name:  "rotation",
+			layer: "reopen",
+			replace: func(h ConnectionHandler, _ *stubConnectionHandler) {
+				h.(*reopenIntervalConnectionHandler).rotateC <- struct{}{}
+			},
+		},
+	}
+
+	for _, layer := range supervisedLayers {
+		for _, r := range replacements {
+			if r.layer != "" && r.layer != layer.name {
+				continue
+			}
+
+			t.Run(layer.name+"/"+r.name, func(t *testing.T) {
+				stubs := &stubConnectionHandlerFactory{ConnectDelay: 20 * time.Millisecond}
+				h := layer.build(stubs, func(int) time.Duration { return 0 })
+				require.NoError(t, h.Connect(context.Background()))
+
+				r.replace(h, stubs.Last())
+				require.Eventually(t, func() bool { return len(stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+				h.Close()
+
+				require.True(t, isClosed(h.CloseChan()))
+				requireAllClosed(t, stubs)
+				time.Sleep(50 * time.Millisecond)
+				require.Len(t, stubs.Handlers(), 2, "connected again once closed")
+			})
+		}
+	}
+}
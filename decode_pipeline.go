@@ -0,0 +1,145 @@
+package libws
+
+import (
+	"sync"
+)
+
+// orderedDecodeWindowPerWorker bounds how many messages may be in flight per decode worker before Handle blocks.
+const orderedDecodeWindowPerWorker = 16
+
+type (
+	// OrderedDecodePipeline decodes inbound messages in parallel while delivering the decoded values strictly in
+	// arrival order. Messages enter through Handle, which can be used as a MessageHandler, are decoded by a pool of
+	// workers and are delivered by a single goroutine, so deliver never runs concurrently with itself.
+	// The number of in-flight messages is bounded; when the window is full Handle blocks, applying backpressure to
+	// the read path.
+	OrderedDecodePipeline[T any] struct {
+		decode  func([]byte) (T, error)
+		deliver func(T)
+		onError func(Message, error)
+
+		// inflight is a semaphore bounding the number of messages between Handle and delivery.
+		inflight chan struct{}
+		// slots is the reorder buffer: it holds one slot per in-flight message in arrival order.
+		slots chan *decodeSlot[T]
+		jobs  chan *decodeSlot[T]
+
+		// stopC is closed by Close, messages handled afterwards being dropped. mu excludes sends on slots and jobs
+		// from closing them.
+		stopC     CloseChan
+		mu        sync.RWMutex
+		closeOnce sync.Once
+		done      chan struct{}
+	}
+
+	decodeSlot[T any] struct {
+		msg     Message
+		value   T
+		err     error
+		decoded chan struct{}
+	}
+)
+
+// NewOrderedDecodePipeline creates a pipeline with the given number of decode workers and starts it. Close must be
+// called to release its goroutines.
+func NewOrderedDecodePipeline[T any](
+	decode func([]byte) (T, error),
+	workers int,
+	deliver func(T),
+) *OrderedDecodePipeline[T] {
+	if workers < 1 {
+		workers = 1
+	}
+
+	window := workers * orderedDecodeWindowPerWorker
+
+	p := &OrderedDecodePipeline[T]{
+		decode:   decode,
+		deliver:  deliver,
+		onError:  func(Message, error) {},
+		inflight: make(chan struct{}, window),
+		slots:    make(chan *decodeSlot[T], window),
+		jobs:     make(chan *decodeSlot[T], window),
+		stopC:    make(CloseChan),
+		done:     make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	go p.run()
+
+	return p
+}
+
+// SetErrorHandler sets the callback invoked, in arrival order and from the delivery goroutine, for messages that
+// could not be decoded. It must be called before the first message is handled.
+func (p *OrderedDecodePipeline[T]) SetErrorHandler(fn func(Message, error)) {
+	p.onError = fn
+}
+
+// Handle enqueues a message for decoding. It blocks while the in-flight window is full. Messages handled once the
+// pipeline is closed are dropped.
+func (p *OrderedDecodePipeline[T]) Handle(_ Client, m Message) {
+	select {
+	case p.inflight <- struct{}{}:
+	case <-p.stopC:
+		discard(m)
+		return
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if isClosed(p.stopC) {
+		<-p.inflight
+		discard(m)
+		return
+	}
+
+	slot := &decodeSlot[T]{msg: m, decoded: make(chan struct{})}
+
+	// Reserve the position in the delivery order before handing the message to any worker. Neither send blocks: the
+	// in-flight window is the capacity of both channels.
+	p.slots <- slot
+	p.jobs <- slot
+}
+
+// Close stops accepting messages and waits until every in-flight message has been delivered. Handle may still be
+// called concurrently, e.g. by the read loop of a connection, messages being dropped from then on.
+func (p *OrderedDecodePipeline[T]) Close() {
+	p.closeOnce.Do(func() {
+		close(p.stopC)
+
+		p.mu.Lock()
+		close(p.jobs)
+		close(p.slots)
+		p.mu.Unlock()
+	})
+
+	<-p.done
+}
+
+func (p *OrderedDecodePipeline[T]) work() {
+	for slot := range p.jobs {
+		slot.value, slot.err = p.decode(slot.msg.Data())
+		close(slot.decoded)
+	}
+}
+
+func (p *OrderedDecodePipeline[T]) run() {
+	defer close(p.done)
+
+	for slot := range p.slots {
+		<-slot.decoded
+
+		if slot.err != nil {
+			p.onError(slot.msg, slot.err)
+		} else {
+			p.deliver(slot.value)
+		}
+
+		<-p.inflight
+	}
+}
@@ -0,0 +1,178 @@
+package libws
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderedDecodePipeline_PreservesOrder(t *testing.T) {
+	const total = 2000
+
+	var got []int
+	p := NewOrderedDecodePipeline(func(data []byte) (int, error) {
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		return strconv.Atoi(string(data))
+	}, 8, func(v int) {
+		got = append(got, v)
+	})
+
+	for i := 0; i < total; i++ {
+		p.Handle(nil, NewDataMessage([]byte(strconv.Itoa(i))))
+	}
+	p.Close()
+
+	require.Len(t, got, total)
+	for i, v := range got {
+		require.Equal(t, i, v)
+	}
+}
+
+func TestOrderedDecodePipeline_ErrorsInOrder(t *testing.T) {
+	var events []string
+
+	p := NewOrderedDecodePipeline(func(data []byte) (int, error) {
+		time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+		return strconv.Atoi(string(data))
+	}, 4, func(v int) {
+		events = append(events, "ok:"+strconv.Itoa(v))
+	})
+	p.SetErrorHandler(func(m Message, err error) {
+		var numErr *strconv.NumError
+		if errors.As(err, &numErr) {
+			events = append(events, "err:"+string(m.Data()))
+		}
+	})
+
+	for _, in := range []string{"1", "x", "2", "y", "3"} {
+		p.Handle(nil, NewDataMessage([]byte(in)))
+	}
+	p.Close()
+
+	require.Equal(t, []string{"ok:1", "err:x", "ok:2", "err:y", "ok:3"}, events)
+}
+
+func TestOrderedDecodePipeline_Backpressure(t *testing.T) {
+	const workers = 2
+
+	release := make(chan struct{})
+	p := NewOrderedDecodePipeline(func(data []byte) (int, error) {
+		<-release
+		return 0, nil
+	}, workers, func(int) {})
+
+	window := workers * orderedDecodeWindowPerWorker
+	for i := 0; i < window; i++ {
+		p.Handle(nil, NewDataMessage(nil))
+	}
+
+	blocked := make(chan struct{})
+	go func() {
+		p.Handle(nil, NewDataMessage(nil))
+		close(blocked)
+	}()
+
+	select {
+	case <-blocked:
+		t.Fatal("Handle must block while the window is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-blocked:
+	case <-time.After(time.Second):
+		t.Fatal("Handle must resume once messages are delivered")
+	}
+
+	p.Close()
+}
+
+func TestOrderedDecodePipeline_HandleWhileClosing(t *testing.T) {
+	for range 50 {
+		var delivered atomic.Int32
+		p := NewOrderedDecodePipeline(func(data []byte) (int, error) {
+			return len(data), nil
+		}, 2, func(int) { delivered.Add(1) })
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 100 {
+					p.Handle(nil, NewDataMessage([]byte("x")))
+				}
+			}()
+		}
+
+		p.Close()
+		handled := delivered.Load()
+		wg.Wait()
+
+		require.Equal(t, handled, delivered.Load(), "a message was delivered once closed")
+		p.Handle(nil, NewDataMessage([]byte("x")))
+	}
+}
+
+type benchDecodePayload struct {
+	Symbol string     `json:"s"`
+	Bids   [][]string `json:"b"`
+	Asks   [][]string `json:"a"`
+}
+
+func benchDecodeMessage() Message {
+	payload := benchDecodePayload{Symbol: "BTCUSDT"}
+	for i := 0; i < 50; i++ {
+		level := []string{strconv.Itoa(30000 + i), "1.2345"}
+		payload.Bids = append(payload.Bids, level)
+		payload.Asks = append(payload.Asks, level)
+	}
+	bts, _ := json.Marshal(payload)
+	return NewDataMessage(bts)
+}
+
+func benchDecode(data []byte) (benchDecodePayload, error) {
+	var v benchDecodePayload
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+func BenchmarkInlineDecode(b *testing.B) {
+	m := benchDecodeMessage()
+	deliver := func(benchDecodePayload) {}
+
+	handler := func(_ Client, m Message) {
+		v, err := benchDecode(m.Data())
+		if err != nil {
+			b.Fatal(err)
+		}
+		deliver(v)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler(nil, m)
+	}
+}
+
+func BenchmarkOrderedDecodePipeline(b *testing.B) {
+	m := benchDecodeMessage()
+	p := NewOrderedDecodePipeline(benchDecode, 4, func(benchDecodePayload) {})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Handle(nil, m)
+	}
+	p.Close()
+}
@@ -0,0 +1,114 @@
+package libws
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultE2ELatencyBuckets are the upper bounds of the buckets of the end-to-end latency histogram unless
+// WithE2ELatencyBuckets says otherwise.
+var DefaultE2ELatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+type (
+	// MetricsCollector is notified of the metrics measured by the client, see WithMetricsCollector. It is called on
+	// the read path, so it must be cheap and must not block.
+	MetricsCollector interface {
+		// ObserveE2ELatency reports the time from a data message being read from the socket to the message handler
+		// returning, along with the generation of the connection the message was read on.
+		ObserveE2ELatency(generation uint64, d time.Duration)
+	}
+
+	// LatencyHistogram is the distribution of the end-to-end latency of the data messages read on a connection
+	// generation, from socket read to the message handler returning.
+	LatencyHistogram struct {
+		// Generation is the connection generation the histogram is about, zero until a message was observed.
+		Generation uint64
+		// Bounds are the inclusive upper bounds of the buckets, in increasing order.
+		Bounds []time.Duration
+		// Counts holds the number of observations per bucket, the last one counting those over every bound.
+		Counts []uint64
+		// Count and Sum are the number of observations and their total.
+		Count uint64
+		Sum   time.Duration
+		// Max is the largest observation.
+		Max time.Duration
+	}
+
+	// e2eLatency accumulates the LatencyHistogram of the newest generation, starting over with every new one.
+	e2eLatency struct {
+		mu   sync.Mutex
+		hist LatencyHistogram
+	}
+)
+
+func newE2ELatency(bounds []time.Duration) *e2eLatency {
+	return &e2eLatency{hist: LatencyHistogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}}
+}
+
+// observe accounts for d under generation. Observations of generations older than the current one are discarded.
+func (l *e2eLatency) observe(generation uint64, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case generation < l.hist.Generation:
+		return
+	case generation > l.hist.Generation:
+		l.hist = LatencyHistogram{
+			Generation: generation,
+			Bounds:     l.hist.Bounds,
+			Counts:     make([]uint64, len(l.hist.Bounds)+1),
+		}
+	}
+
+	i := 0
+	for i < len(l.hist.Bounds) && d > l.hist.Bounds[i] {
+		i++
+	}
+	l.hist.Counts[i]++
+	l.hist.Count++
+	l.hist.Sum += d
+	l.hist.Max = max(l.hist.Max, d)
+}
+
+// snapshot returns a copy of the histogram.
+func (l *e2eLatency) snapshot() LatencyHistogram {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := l.hist
+	res.Bounds = append([]time.Duration(nil), l.hist.Bounds...)
+	res.Counts = append([]uint64(nil), l.hist.Counts...)
+	return res
+}
+
+// Within returns the fraction of the observations which took at most d, counting whole buckets only: d is rounded
+// down to the nearest bound. It is zero without observations.
+func (h LatencyHistogram) Within(d time.Duration) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+
+	var n uint64
+	for i, bound := range h.Bounds {
+		if bound > d {
+			break
+		}
+		n += h.Counts[i]
+	}
+	return float64(n) / float64(h.Count)
+}
@@ -0,0 +1,99 @@
+package libws
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingMetricsCollector struct {
+	mu        sync.Mutex
+	latencies map[uint64][]time.Duration
+}
+
+func (r *recordingMetricsCollector) ObserveE2ELatency(generation uint64, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.latencies == nil {
+		r.latencies = make(map[uint64][]time.Duration)
+	}
+	r.latencies[generation] = append(r.latencies[generation], d)
+}
+
+func TestClient_E2ELatency(t *testing.T) {
+	clk := newFakeClock()
+	stubs := &stubConnectionHandlerFactory{}
+	collector := &recordingMetricsCollector{}
+
+	// The handler takes as many microseconds as the message payload says.
+	handler := func(_ Client, m Message) {
+		us, err := strconv.Atoi(string(m.Data()))
+		require.NoError(t, err)
+		clk.Advance(time.Duration(us) * time.Microsecond)
+	}
+	cli := newBasicClient(stubs.Factory, handler, func(Client, EventType) {},
+		withClock(clk),
+		WithMetricsCollector(collector),
+		WithE2ELatencyBuckets(time.Millisecond, 2*time.Millisecond),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	deliver := func(generation uint64, queued time.Duration, handlerUs int) {
+		m := NewDataMessage([]byte(strconv.Itoa(handlerUs)))
+		m = withReceivedAt(m, clk.Now().Add(-queued))
+		stubs.Last().Deliver(withGeneration(m, generation))
+	}
+
+	deliver(1, 0, 500)
+	deliver(1, time.Millisecond, 500)
+	deliver(1, 2*time.Millisecond, 1000)
+	// Injected messages were not read from a socket.
+	stubs.Last().Deliver(NewDataMessage([]byte("5000")))
+
+	require.Equal(t, LatencyHistogram{
+		Generation: 1,
+		Bounds:     []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		Counts:     []uint64{1, 1, 1},
+		Count:      3,
+		Sum:        5 * time.Millisecond,
+		Max:        3 * time.Millisecond,
+	}, cli.E2ELatency())
+	require.InDelta(t, 2.0/3, cli.E2ELatency().Within(2*time.Millisecond), 1e-9)
+
+	// A new generation starts over, late messages of the previous one are not accounted.
+	deliver(2, 0, 100)
+	deliver(1, 0, 100)
+
+	hist := cli.E2ELatency()
+	require.EqualValues(t, 2, hist.Generation)
+	require.Equal(t, []uint64{1, 0, 0}, hist.Counts)
+	require.Equal(t, 100*time.Microsecond, hist.Sum)
+
+	require.Equal(t, map[uint64][]time.Duration{
+		1: {500 * time.Microsecond, 1500 * time.Microsecond, 3 * time.Millisecond, 100 * time.Microsecond},
+		2: {100 * time.Microsecond},
+	}, collector.latencies)
+}
+
+func TestWsConnection_StampsReceivedAt(t *testing.T) {
+	srv := newTestWsServer(t, func(c *websocket.Conn) {
+		_ = c.WriteMessage(websocket.TextMessage, []byte("stamped"))
+		serveUntilClosed(c)
+	})
+	conn, recv := newTestWsConnection(t, srv)
+
+	before := time.Now()
+	require.NoError(t, conn.Open(context.Background()))
+
+	m := <-recv
+	require.Equal(t, "stamped", string(m.Data()))
+	require.False(t, ReceivedAtOf(m).Before(before))
+	require.False(t, ReceivedAtOf(m).After(time.Now()))
+}
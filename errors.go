@@ -1,7 +1,9 @@
 package libws
 
 import (
+	"net/http"
 	"net/url"
+	"time"
 
 	"fmt"
 
@@ -9,12 +11,116 @@ import (
 )
 
 var (
-	ErrConnectionClosed = errors.New("connection has been closed")
-	ErrCannotConnect    = errors.New("connection cannot be established")
-	ErrTerminated       = errors.New("program exit")
-	ErrRateLimit        = errors.New("rate limit exceeded")
+	ErrConnectionClosed         = errors.New("connection has been closed")
+	ErrCannotConnect            = errors.New("connection cannot be established")
+	ErrTerminated               = errors.New("program exit")
+	ErrRateLimit                = errors.New("rate limit exceeded")
+	ErrAlreadyOpen              = errors.New("client already open")
+	ErrClientClosed             = errors.New("client has been closed")
+	ErrInvalidOutbound          = errors.New("invalid outbound message")
+	ErrMemoryBudgetExceeded     = errors.New("memory budget exceeded")
+	ErrRedirectLoop             = errors.New("handshake redirect loop")
+	ErrTooManyRedirects         = errors.New("too many handshake redirects")
+	ErrMessageTooLarge          = errors.New("message too large")
+	ErrSyntheticMessage         = errors.New("synthetic message cannot be written")
+	ErrInternalPanic            = errors.New("internal panic")
+	ErrBackpressure             = errors.New("no room to send the message")
+	ErrSlowConsumer             = errors.New("inbound messages not consumed fast enough")
+	ErrReadIdleTimeout          = errors.New("nothing read within the idle timeout")
+	ErrRotated                  = errors.New("connection rotated")
+	ErrParamsUnavailable        = errors.New("connection params unavailable")
+	ErrWriteStalled             = errors.New("write stalled")
+	ErrProxy                    = errors.New("proxy error")
+	ErrSubprotocolNotNegotiated = errors.New("subprotocol not negotiated")
+	ErrPongTimeout              = errors.New("no pong within the timeout")
 )
 
+// errHandlerClosed is the reason a layer gives up connecting once closed.
+var errHandlerClosed = errors.New("handler closed")
+
+// gaveUpConnecting is the error of a layer giving up connecting because of cause, wrapping last, the error of its last
+// attempt, if any, so that what prevented connecting is still matched with errors.Is and errors.As.
+func gaveUpConnecting(cause, last error) error {
+	if last == nil {
+		return fmt.Errorf("%w: gave up connecting: %w", ErrTerminated, cause)
+	}
+	return fmt.Errorf("%w: gave up connecting: %w, last attempt: %w", ErrTerminated, cause, last)
+}
+
+// withReconnectFailure returns reason, the close reason of a connection, along with dialErr, the error of the last
+// failed attempt to replace it, both being matched with errors.Is and errors.As.
+func withReconnectFailure(reason, dialErr error) error {
+	return fmt.Errorf("%w, then reconnecting: %w", reason, dialErr)
+}
+
+// closedWhileDown returns reason, the reason a layer was closed with, along with down, the reason its connection was
+// down then, both being matched with errors.Is and errors.As.
+func closedWhileDown(reason, down error) error {
+	return fmt.Errorf("%w, while down: %w", reason, down)
+}
+
+// DialError is the error of a failed dial, telling which URL was dialed and on which attempt. Retrieve it with
+// errors.As, it unwraps to the error classifying the failure, e.g. ErrCannotConnect or ErrRateLimit.
+type DialError struct {
+	// URL is the URL dialed, redacted with RedactURL.
+	URL string
+	// Attempt is the number of consecutive dials of the connection factory, this one included, since the last
+	// successful one.
+	Attempt int
+	Err     error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("dial %s (attempt %d): %s", e.URL, e.Attempt, e.Err)
+}
+
+func (e *DialError) Unwrap() error { return e.Err }
+
+// DialHTTPError is the error of a handshake the server answered with a non-101 status, carrying the beginning of the
+// response body, which often explains the rejection. Retrieve it with errors.As, it unwraps to ErrCannotConnect.
+type DialHTTPError struct {
+	StatusCode int
+	// Body is the beginning of the response body, see maxDialErrorBody.
+	Body   []byte
+	Header http.Header
+}
+
+func (e *DialHTTPError) Error() string {
+	msg := fmt.Sprintf("%s: handshake rejected with status %d", ErrCannotConnect, e.StatusCode)
+	if len(e.Body) > 0 {
+		msg += ": " + string(e.Body)
+	}
+	return msg
+}
+
+func (e *DialHTTPError) Unwrap() error { return ErrCannotConnect }
+
+// RateLimitError is the error of a handshake rejected with 429 Too Many Requests. Retrieve it with errors.As, it
+// unwraps to an error wrapping ErrRateLimit and the DialHTTPError of the handshake.
+type RateLimitError struct {
+	// RetryAfter is the wait the server asked for with the Retry-After header, zero when it did not.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s (retry after %s)", e.Err, e.RetryAfter)
+	}
+	return e.Err.Error()
+}
+
+func (e *RateLimitError) Unwrap() error { return e.Err }
+
+// retryAfterOf returns the wait a rate limited server asked for through err, if any.
+func retryAfterOf(err error) (time.Duration, bool) {
+	var rl *RateLimitError
+	if errors.As(err, &rl) && rl.RetryAfter > 0 {
+		return rl.RetryAfter, true
+	}
+	return 0, false
+}
+
 type ErrUnrecoverableConnection struct {
 	err error
 	url url.URL
@@ -27,7 +133,7 @@ func (e ErrUnrecoverableConnection) Error() string {
 func (e ErrUnrecoverableConnection) Unwrap() error { return e.err }
 
 func WrapErrorUnrecoverableConnection(err error, url url.URL) *ErrUnrecoverableConnection {
-	if err != nil {
+	if err == nil {
 		return nil
 	}
 	return &ErrUnrecoverableConnection{
@@ -0,0 +1,183 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newFlakyWsServer serves the first connection with serve, then rejects every handshake with 429 Too Many Requests,
+// returning the server along with the number of handshakes rejected so far.
+func newFlakyWsServer(t *testing.T, serve func(conn *websocket.Conn)) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var (
+		served   atomic.Bool
+		rejected atomic.Int32
+		upgrader websocket.Upgrader
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Swap(true) {
+			rejected.Add(1)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serve(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &rejected
+}
+
+func TestCloseErr_MatchableThroughLayers(t *testing.T) {
+	failures := map[string]struct {
+		serve func(conn *websocket.Conn)
+		opts  []WsConnectionOption
+		want  []error
+		as    func(err error) bool
+	}{
+		"close code": {
+			serve: func(conn *websocket.Conn) {
+				msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "busy")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				serveUntilClosed(conn)
+			},
+			want: []error{ErrConnectionClosed},
+			as: func(err error) bool {
+				var cce *CloseCodeError
+				return errors.As(err, &cce) && cce.Code == websocket.CloseTryAgainLater
+			},
+		},
+		"abrupt drop": {
+			serve: func(conn *websocket.Conn) { _ = conn.NetConn().Close() },
+			want:  []error{ErrConnectionClosed},
+		},
+		"oversized message": {
+			serve: func(conn *websocket.Conn) {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 64)))
+				serveUntilClosed(conn)
+			},
+			opts: []WsConnectionOption{WithMaxMessageSize(16)},
+			want: []error{ErrConnectionClosed, ErrMessageTooLarge},
+		},
+		"idle read timeout": {
+			serve: serveUntilClosed,
+			opts:  []WsConnectionOption{WithIdleReadTimeout(50 * time.Millisecond)},
+			want:  []error{ErrReadIdleTimeout},
+		},
+	}
+
+	noWait := func(int) time.Duration { return 0 }
+	compositions := map[string]struct {
+		build        func(ConnectionHandlerFactory) ConnectionHandlerFactory
+		reconnecting bool
+	}{
+		"base": {
+			build: func(base ConnectionHandlerFactory) ConnectionHandlerFactory { return base },
+		},
+		"backoff": {
+			build: func(base ConnectionHandlerFactory) ConnectionHandlerFactory {
+				return NewBackoffConnectionHandlerFactory(nil, base, noWait, time.Minute)
+			},
+			reconnecting: true,
+		},
+		"reopen interval": {
+			build: func(base ConnectionHandlerFactory) ConnectionHandlerFactory {
+				return NewReopenIntervalConnFactory(nil, time.Hour, base)
+			},
+			reconnecting: true,
+		},
+		"keep-alive over backoff": {
+			build: func(base ConnectionHandlerFactory) ConnectionHandlerFactory {
+				return NewPassiveKeepAliveConnectionHandlerFactory(
+					NewBackoffConnectionHandlerFactory(nil, base, noWait, time.Minute),
+					KeepAliveHandlerReplyPingWithPong,
+				)
+			},
+			reconnecting: true,
+		},
+	}
+
+	for failureName, failure := range failures {
+		for compositionName, composition := range compositions {
+			t.Run(failureName+"/"+compositionName, func(t *testing.T) {
+				srv, rejected := newFlakyWsServer(t, failure.serve)
+				u := testWsURL(t, srv)
+				logger := newTestLogger(io.Discard)
+				repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+					return OpenConnectionParams{URL: u}, nil
+				})
+				base := NewBaseConnectionHandlerFactory(
+					logger,
+					NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}, failure.opts...),
+				)
+
+				cli := newBasicClient(composition.build(base), func(Client, Message) {}, func(Client, EventType) {})
+				require.NoError(t, cli.Open(context.Background()))
+
+				want := failure.want
+				if composition.reconnecting {
+					// Reconnecting fails on the rate limit, which must be told along with the close reason.
+					want = append(want[:len(want):len(want)], ErrRateLimit, ErrCannotConnect)
+				}
+				matches := func() bool {
+					err := cli.CloseErr()
+					for _, target := range want {
+						if !errors.Is(err, target) {
+							return false
+						}
+					}
+					return failure.as == nil || failure.as(err)
+				}
+
+				require.Eventually(t, matches, 2*time.Second, time.Millisecond, "before Close")
+				if composition.reconnecting {
+					require.Positive(t, rejected.Load())
+				}
+
+				cli.Close()
+				require.True(t, matches(), "after Close: %v", cli.CloseErr())
+
+				var httpErr *DialHTTPError
+				require.Equal(t, composition.reconnecting, errors.As(cli.CloseErr(), &httpErr))
+			})
+		}
+	}
+}
+
+func TestGaveUpConnecting(t *testing.T) {
+	err := gaveUpConnecting(context.Canceled, &RateLimitError{Err: ErrRateLimit})
+	require.ErrorIs(t, err, ErrTerminated)
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, err, ErrRateLimit)
+
+	err = gaveUpConnecting(errHandlerClosed, nil)
+	require.ErrorIs(t, err, ErrTerminated)
+	require.EqualError(t, err, "program exit: gave up connecting: handler closed")
+}
+
+func TestWrapErrorUnrecoverableConnection(t *testing.T) {
+	u := url.URL{Scheme: "wss", Host: "example.com"}
+	require.Nil(t, WrapErrorUnrecoverableConnection(nil, u))
+
+	err := WrapErrorUnrecoverableConnection(ErrRateLimit, u)
+	require.ErrorIs(t, err, ErrRateLimit)
+}
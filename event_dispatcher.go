@@ -0,0 +1,126 @@
+package libws
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// defaultDispatchQueueSize bounds the events waiting for queued listeners.
+const defaultDispatchQueueSize = 256
+
+const (
+	// listenInline listeners run synchronously within Emit. Use it for cheap listeners only.
+	listenInline listenerMode = iota
+	// listenQueued listeners run on the dispatcher goroutine of the client, so that they do not delay Emit.
+	listenQueued
+)
+
+type (
+	// listenerMode decides where a listener registered by a layer runs.
+	listenerMode int
+
+	// listenerRegistry is implemented by clients which let the layers composing them listen to events.
+	listenerRegistry interface {
+		listen(event EventType, mode listenerMode, fn func(EventType))
+	}
+
+	// eventSink is implemented by clients which let components outside the layers emit events.
+	eventSink interface {
+		emit(event EventType)
+	}
+
+	// queuedEvent is an event waiting for a queued listener.
+	queuedEvent struct {
+		fn    func(EventType)
+		event EventType
+	}
+
+	// eventDispatcher runs queued listeners on a single goroutine, in the order their events were emitted. The
+	// goroutine starts with the first queued listener and stops on close.
+	eventDispatcher struct {
+		logger logger
+		queue  chan queuedEvent
+
+		startOnce sync.Once
+		closeOnce sync.Once
+		stopC     chan struct{}
+		done      chan struct{}
+
+		dropped atomic.Uint64
+	}
+)
+
+func newEventDispatcher(logger logger, size int) *eventDispatcher {
+	return &eventDispatcher{
+		logger: logger,
+		queue:  make(chan queuedEvent, size),
+		stopC:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// listen registers fn for event on e, running it as mode tells.
+func (d *eventDispatcher) listen(e *EventEmitterCallback[EventType, EventType], event EventType, mode listenerMode, fn func(EventType)) {
+	if mode == listenInline {
+		e.On(event, fn)
+		return
+	}
+
+	d.startOnce.Do(func() { go d.run() })
+	e.On(event, func(event EventType) {
+		d.enqueue(queuedEvent{fn: fn, event: event})
+	})
+}
+
+// enqueue hands ev to the dispatcher goroutine without blocking, dropping it when the queue is full.
+func (d *eventDispatcher) enqueue(ev queuedEvent) {
+	select {
+	case <-d.stopC:
+		return
+	default:
+	}
+
+	select {
+	case d.queue <- ev:
+	default:
+		d.dropped.Add(1)
+		d.logger.Warnf("event dispatcher queue is full, dropping event %d", ev.event)
+	}
+}
+
+func (d *eventDispatcher) run() {
+	defer close(d.done)
+
+	for {
+		select {
+		case <-d.stopC:
+			return
+		case ev := <-d.queue:
+			ev.fn(ev.event)
+		}
+	}
+}
+
+// close stops the dispatcher goroutine. Events still queued are discarded.
+func (d *eventDispatcher) close() {
+	d.closeOnce.Do(func() { close(d.stopC) })
+}
+
+// listenEvent registers fn for event on the client, if it supports it, and returns whether it does.
+func listenEvent(c Client, event EventType, mode listenerMode, fn func(EventType)) bool {
+	registry, ok := c.(listenerRegistry)
+	if !ok {
+		return false
+	}
+
+	registry.listen(event, mode, fn)
+	return true
+}
+
+// emitEvent emits event through the client, if it supports it. It is meant for components, such as message handler
+// decorators, which are not handed an emitter.
+func emitEvent(c Client, event EventType) {
+	if sink, ok := c.(eventSink); ok {
+		sink.emit(event)
+	}
+}
@@ -0,0 +1,80 @@
+package libws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventDispatcher_SlowQueuedListenerDoesNotDelayEmit(t *testing.T) {
+	c := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	defer c.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	received := make(chan EventType, 8)
+
+	require.True(t, listenEvent(c, EventReconnect, listenQueued, func(event EventType) {
+		<-release
+		received <- event
+	}))
+	var inline []EventType
+	listenEvent(c, EventReconnect, listenInline, func(event EventType) { inline = append(inline, event) })
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		c.eventEmitter.Emit(EventReconnect, EventReconnect)
+	}
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+	require.Len(t, inline, 4)
+	require.Empty(t, received)
+}
+
+func TestEventDispatcher_PreservesOrder(t *testing.T) {
+	c := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	defer c.Close()
+
+	received := make(chan EventType, 8)
+	for _, event := range []EventType{EventConnect, EventReconnect, EventClose} {
+		listenEvent(c, event, listenQueued, func(event EventType) { received <- event })
+	}
+
+	emitted := []EventType{EventConnect, EventReconnect, EventReconnect, EventClose, EventConnect}
+	for _, event := range emitted {
+		c.eventEmitter.Emit(event, event)
+	}
+
+	for _, want := range emitted {
+		select {
+		case got := <-received:
+			require.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatalf("queued listener did not receive %d", want)
+		}
+	}
+}
+
+func TestEventDispatcher_BoundedQueueAndShutdown(t *testing.T) {
+	d := newEventDispatcher(nopLogger{}, 2)
+	e := NewEventEmitter[EventType, EventType]()
+
+	release := make(chan struct{})
+	d.listen(e, EventClose, listenQueued, func(EventType) { <-release })
+
+	// The first event is taken by the goroutine, the next two fill the queue and the last one is dropped.
+	e.Emit(EventClose, EventClose)
+	require.Eventually(t, func() bool { return len(d.queue) == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		e.Emit(EventClose, EventClose)
+	}
+	require.EqualValues(t, 1, d.dropped.Load())
+
+	d.close()
+	close(release)
+	select {
+	case <-d.done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher did not stop")
+	}
+}
@@ -13,6 +13,8 @@ type callback[T any] func(T)
 type EventEmitterCallback[K comparable, V any] struct {
 	listeners map[K][]callback[V]
 	lock      sync.RWMutex
+	// closed drops the listeners registered after Close
+	closed bool
 }
 
 // NewEventEmitter creates a new EventEmitterCallback and returns a pointer to it.
@@ -22,11 +24,15 @@ func NewEventEmitter[K comparable, V any]() *EventEmitterCallback[K, V] {
 	}
 }
 
-// On registers a new listener for the given event.
+// On registers a new listener for the given event. Listeners registered after Close are never called.
 func (e *EventEmitterCallback[K, V]) On(event K, listener callback[V]) {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
+	if e.closed {
+		return
+	}
+
 	e.listeners[event] = append(e.listeners[event], listener)
 }
 
@@ -47,10 +53,12 @@ func (e *EventEmitterCallback[K, V]) Emit(event K, data V) {
 	}
 }
 
-// Close closes all listener channels and removes all listeners to prevent memory leaks.
+// Close removes all listeners to prevent memory leaks. Once it returns, no listener is called anymore: it waits for
+// the emissions in progress, later ones being dropped. Hence, it must not be called from a listener.
 func (e *EventEmitterCallback[K, V]) Close() {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
+	e.closed = true
 	e.listeners = make(map[K][]callback[V])
 }
@@ -5,7 +5,50 @@ type (
 )
 
 const (
+	// EventConnect is emitted whenever a connection is established, see ConnectionControl.LatestInfo for its
+	// handshake response, negotiated subprotocol and generation.
 	EventConnect EventType = iota
 	EventReconnect
 	EventClose
+	// EventRetryExhausted is emitted when an in-band retry gives up on a message.
+	EventRetryExhausted
+	// EventHandlerError is emitted when a MessageHandlerE returns an error.
+	EventHandlerError
+	// EventOutboundRejected is emitted when the client refuses to send a message, e.g. because it is invalid.
+	EventOutboundRejected
+	// EventStandbyPromoted is emitted when a hot standby connection replaces the primary one.
+	EventStandbyPromoted
+	// EventRotationAborted is emitted when a rotation is abandoned because the new connection was not ready.
+	EventRotationAborted
+	// EventRotationTimeout is emitted when a rotation is abandoned because the new connection was not ready in time.
+	EventRotationTimeout
+	// EventSheddingStarted is emitted when an OverloadShedder starts dropping inbound messages.
+	EventSheddingStarted
+	// EventSheddingStopped is emitted when an OverloadShedder no longer drops inbound messages of any class.
+	EventSheddingStopped
+	// EventOversizeSkipped is emitted when a connection skips an inbound message over its size limit, see
+	// WithOversizeRecovery.
+	EventOversizeSkipped
+	// EventRotationStitched is emitted when a seamless rotation switches over to the new connection, see
+	// SeamlessRotation.StitchPoint.
+	EventRotationStitched
+	// EventLazyOpened is emitted when a client connects on demand, see WithLazyConnect.
+	EventLazyOpened
+	// EventIdleClosed is emitted when a client connecting on demand closes its idle connection, see WithLazyConnect.
+	EventIdleClosed
+	// EventInboundDropped is emitted when a connection drops an inbound message not consumed fast enough, see
+	// WithSlowConsumerPolicy.
+	EventInboundDropped
+	// EventTrafficAnomaly is emitted when the mix of inbound frame types shifts, see WithTrafficAnomalyDetection.
+	EventTrafficAnomaly
+	// EventWriteDelayed is emitted when a connection holds a write back longer than its threshold to stay within its
+	// rate limit, see WithWriteRateLimit.
+	EventWriteDelayed
+	// EventLatencySample is emitted when a connection reads the pong of one of its pings, see LatencyStats. The event
+	// does not carry the round trip measured: poll it from Latency right after the event, LatencyStats.Last being
+	// that round trip unless a newer pong was read meanwhile.
+	EventLatencySample
+	// EventStaleTraffic is emitted when the ratio of stale inbound messages goes over the threshold of a
+	// FreshnessGuard.
+	EventStaleTraffic
 )
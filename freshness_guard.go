@@ -0,0 +1,164 @@
+package libws
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultStaleWindow is the number of latest timestamped messages the stale ratio is computed over.
+	defaultStaleWindow = 100
+	// defaultStaleRatio is the stale ratio over which EventStaleTraffic is emitted.
+	defaultStaleRatio = 0.1
+)
+
+type (
+	// FreshnessGuard drops inbound messages whose embedded timestamp is older than a freshness window, e.g. stale
+	// trades a venue replays after a reconnect, before they reach the message handler. Messages without a timestamp
+	// pass. Wrap the message handler with FreshnessGuard.Wrap.
+	FreshnessGuard struct {
+		extractTime func(Message) (time.Time, bool)
+		maxAge      time.Duration
+		onStale     func(Message, time.Duration)
+		clock       clock
+		window      int
+		threshold   float64
+
+		mu     sync.Mutex
+		counts FreshnessCounts
+		// recent tells whether each of the latest timestamped messages was stale, next being where the next one goes
+		recent      []bool
+		next        int
+		recentStale int
+		// alarmed tells whether the stale ratio is over the threshold
+		alarmed bool
+	}
+
+	// FreshnessCounts are the counts of the messages a FreshnessGuard let through or dropped so far.
+	FreshnessCounts struct {
+		Fresh uint64
+		Stale uint64
+		// Untimed counts the messages passed without a timestamp
+		Untimed uint64
+	}
+
+	// FreshnessOption customizes a FreshnessGuard.
+	FreshnessOption func(*FreshnessGuard)
+)
+
+// NewFreshnessGuard returns a guard dropping the messages whose time, as told by extractTime, is older than maxAge.
+// onStale, when not nil, is called for every dropped message with its age.
+func NewFreshnessGuard(
+	extractTime func(Message) (time.Time, bool),
+	maxAge time.Duration,
+	onStale func(Message, time.Duration),
+	opts ...FreshnessOption,
+) *FreshnessGuard {
+	return newFreshnessGuard(extractTime, maxAge, onStale, realClock{}, opts...)
+}
+
+func newFreshnessGuard(
+	extractTime func(Message) (time.Time, bool),
+	maxAge time.Duration,
+	onStale func(Message, time.Duration),
+	clk clock,
+	opts ...FreshnessOption,
+) *FreshnessGuard {
+	g := &FreshnessGuard{
+		extractTime: extractTime,
+		maxAge:      maxAge,
+		onStale:     onStale,
+		clock:       clk,
+		window:      defaultStaleWindow,
+		threshold:   defaultStaleRatio,
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.recent = make([]bool, 0, g.window)
+
+	return g
+}
+
+// WithStaleTrafficThreshold overrides the ratio of stale messages among the latest window timestamped ones over which
+// EventStaleTraffic is emitted, 0.1 of 100 by default.
+func WithStaleTrafficThreshold(ratio float64, window int) FreshnessOption {
+	return func(g *FreshnessGuard) {
+		g.threshold = ratio
+		g.window = max(window, 1)
+	}
+}
+
+// Wrap returns a MessageHandler passing to next the fresh messages and the ones without a timestamp.
+// EventStaleTraffic is emitted through the client when the stale ratio goes over the threshold, then again once it
+// went back under it first.
+func (g *FreshnessGuard) Wrap(next MessageHandler) MessageHandler {
+	return func(cli Client, m Message) {
+		at, ok := g.extractTime(m)
+		if !ok {
+			g.mu.Lock()
+			g.counts.Untimed++
+			g.mu.Unlock()
+
+			next(cli, m)
+			return
+		}
+
+		age := g.clock.Now().Sub(at)
+		stale := age > g.maxAge
+		if g.observe(stale) {
+			emitEvent(cli, EventStaleTraffic)
+		}
+
+		if stale {
+			if g.onStale != nil {
+				g.onStale(m, age)
+			}
+			discard(m)
+			return
+		}
+
+		next(cli, m)
+	}
+}
+
+// Counts returns how many messages were let through or dropped so far.
+func (g *FreshnessGuard) Counts() FreshnessCounts {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.counts
+}
+
+// observe accounts for a timestamped message, stale or not, telling whether the stale ratio just went over the
+// threshold.
+func (g *FreshnessGuard) observe(stale bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if stale {
+		g.counts.Stale++
+	} else {
+		g.counts.Fresh++
+	}
+
+	if len(g.recent) < g.window {
+		g.recent = append(g.recent, stale)
+	} else {
+		if g.recent[g.next] {
+			g.recentStale--
+		}
+		g.recent[g.next] = stale
+		g.next = (g.next + 1) % g.window
+	}
+	if stale {
+		g.recentStale++
+	}
+
+	over := float64(g.recentStale) > g.threshold*float64(len(g.recent))
+	crossed := over && !g.alarmed
+	g.alarmed = over
+
+	return crossed
+}
@@ -0,0 +1,118 @@
+package libws
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// unixSecondsOf extracts the time of messages reading "<unix seconds>:<payload>".
+func unixSecondsOf(m Message) (time.Time, bool) {
+	ts, _, ok := strings.Cut(string(m.Data()), ":")
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+func TestFreshnessGuard(t *testing.T) {
+	const maxAge = time.Minute
+
+	tests := []struct {
+		name string
+		// ages are the ages of the messages of the stream, in seconds, -1 meaning untimed
+		ages       []int
+		wantPassed int
+		wantCounts FreshnessCounts
+		wantEvents int
+	}{
+		{
+			name:       "fresh",
+			ages:       []int{0, 30, 60, -1},
+			wantPassed: 4,
+			wantCounts: FreshnessCounts{Fresh: 3, Untimed: 1},
+		},
+		{
+			name:       "stale",
+			ages:       []int{61, 3600, 3599},
+			wantCounts: FreshnessCounts{Stale: 3},
+			wantEvents: 1,
+		},
+		{
+			// A replay after reconnecting: stale messages catch up with fresh ones.
+			name:       "mixed",
+			ages:       []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3600, 1800, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3600, 3600, -1},
+			wantPassed: 29,
+			wantCounts: FreshnessCounts{Fresh: 28, Stale: 4, Untimed: 1},
+			wantEvents: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := newFakeClock()
+			c := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+			defer c.Close()
+
+			events := 0
+			listenEvent(c, EventStaleTraffic, listenInline, func(EventType) { events++ })
+
+			var staleAges []time.Duration
+			g := newFreshnessGuard(unixSecondsOf, maxAge, func(_ Message, age time.Duration) {
+				staleAges = append(staleAges, age)
+			}, clk)
+
+			passed := 0
+			handle := g.Wrap(func(Client, Message) { passed++ })
+
+			var wantStaleAges []time.Duration
+			for _, age := range tt.ages {
+				if age < 0 {
+					handle(c, NewDataMessage([]byte("untimed")))
+					continue
+				}
+				ts := clk.Now().Add(-time.Duration(age) * time.Second).Unix()
+				handle(c, NewDataMessage([]byte(strconv.FormatInt(ts, 10)+":trade")))
+				if time.Duration(age)*time.Second > maxAge {
+					wantStaleAges = append(wantStaleAges, time.Duration(age)*time.Second)
+				}
+			}
+
+			require.Equal(t, tt.wantPassed, passed)
+			require.Equal(t, tt.wantCounts, g.Counts())
+			require.Equal(t, wantStaleAges, staleAges)
+			require.Equal(t, tt.wantEvents, events)
+		})
+	}
+}
+
+func TestFreshnessGuard_StaleTrafficThreshold(t *testing.T) {
+	clk := newFakeClock()
+	c := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	defer c.Close()
+
+	events := 0
+	listenEvent(c, EventStaleTraffic, listenInline, func(EventType) { events++ })
+
+	g := newFreshnessGuard(unixSecondsOf, time.Minute, nil, clk, WithStaleTrafficThreshold(0.5, 4))
+	handle := g.Wrap(func(Client, Message) {})
+	fresh := NewDataMessage([]byte(strconv.FormatInt(clk.Now().Unix(), 10) + ":trade"))
+	stale := NewDataMessage([]byte(strconv.FormatInt(clk.Now().Add(-time.Hour).Unix(), 10) + ":trade"))
+
+	for _, m := range []Message{fresh, fresh, stale, fresh, stale} {
+		handle(c, m)
+	}
+	require.Zero(t, events, "half of the window stale is not over the threshold")
+
+	handle(c, stale)
+	require.Equal(t, 1, events)
+	handle(c, stale)
+	require.Equal(t, 1, events, "emitted again while over the threshold")
+}
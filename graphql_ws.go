@@ -0,0 +1,371 @@
+package libws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The message types of the graphql-transport-ws protocol.
+const (
+	graphQLWSConnectionInit = "connection_init"
+	graphQLWSConnectionAck  = "connection_ack"
+	graphQLWSPing           = "ping"
+	graphQLWSPong           = "pong"
+	graphQLWSSubscribe      = "subscribe"
+	graphQLWSNext           = "next"
+	graphQLWSError          = "error"
+	graphQLWSComplete       = "complete"
+)
+
+// defaultGraphQLWSBufferSize is the capacity of the channel of every operation, see WithGraphQLWSBufferSize.
+const defaultGraphQLWSBufferSize = 64
+
+// ErrGraphQLWSClosed is the error of subscribing through a closed GraphQLWSClient.
+var ErrGraphQLWSClosed = errors.New("graphql-ws client closed")
+
+type (
+	// GraphQLWSClient speaks the graphql-transport-ws protocol over a client: it initializes the connection, runs
+	// subscriptions under operation IDs of its own, routing their results, answers pings and subscribes again after a
+	// reconnection. Wire Handle as the MessageHandler, or call it from yours, and HandleEvent from the EventHandler.
+	GraphQLWSClient struct {
+		client      Client
+		initPayload json.RawMessage
+		// initErr tells why initPayload cannot be sent, if so
+		initErr      error
+		bufferSize   int
+		errorHandler func(*GraphQLWSError)
+
+		// sendMu orders the messages sent in the order they were built, which is with mu held, without holding mu
+		// while sending: Handle takes it on the read path.
+		sendMu sync.Mutex
+
+		mu sync.Mutex
+		// initSent tells whether connection_init was sent on the current connection
+		initSent bool
+		// acked is closed once the current connection is acknowledged
+		acked  chan struct{}
+		nextID uint64
+		ops    map[string]*graphQLWSOperation
+		closed bool
+	}
+
+	// GraphQLWSOption customizes the client returned by NewGraphQLWSClient.
+	GraphQLWSOption func(*GraphQLWSClient)
+
+	// GraphQLWSError is an operation the server ended with an error message.
+	GraphQLWSError struct {
+		// ID is the ID of the operation.
+		ID    string
+		Query string
+		// Errors are the GraphQL errors of the server, as sent.
+		Errors json.RawMessage
+	}
+
+	graphQLWSOperation struct {
+		id      string
+		payload json.RawMessage
+		out     chan json.RawMessage
+		done    chan struct{}
+		once    sync.Once
+		// mu orders deliveries with closing out
+		mu     sync.Mutex
+		closed bool
+	}
+
+	graphQLWSMessage struct {
+		ID      string          `json:"id,omitempty"`
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+)
+
+// NewGraphQLWSClient returns a graphql-transport-ws client over c, initializing connections with initPayload, e.g.
+// credentials, unless nil. Subscribe fails with an error wrapping ErrInvalidOutbound when initPayload is not valid JSON.
+func NewGraphQLWSClient(c Client, initPayload json.RawMessage, opts ...GraphQLWSOption) *GraphQLWSClient {
+	g := &GraphQLWSClient{
+		client:      c,
+		initPayload: initPayload,
+		bufferSize:  defaultGraphQLWSBufferSize,
+		acked:       make(chan struct{}),
+		ops:         make(map[string]*graphQLWSOperation),
+	}
+	if initPayload != nil && !json.Valid(initPayload) {
+		g.initErr = fmt.Errorf("%w: graphql-ws init payload is not valid JSON", ErrInvalidOutbound)
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return g
+}
+
+// WithGraphQLWSBufferSize sets the capacity of the channel of every operation, 64 by default. Once full, Handle
+// blocks until the operation is read from or stopped.
+func WithGraphQLWSBufferSize(n int) GraphQLWSOption {
+	return func(g *GraphQLWSClient) {
+		g.bufferSize = max(n, 0)
+	}
+}
+
+// WithGraphQLWSErrorHandler sets a handler notified of the operations the server ended with an error, whose channel
+// is closed right after.
+func WithGraphQLWSErrorHandler(h func(*GraphQLWSError)) GraphQLWSOption {
+	return func(g *GraphQLWSClient) {
+		g.errorHandler = h
+	}
+}
+
+func (e *GraphQLWSError) Error() string {
+	return fmt.Sprintf("graphql-ws operation %s failed: %s", e.ID, e.Errors)
+}
+
+// Subscribe runs the subscription query with vars, returning the channel its results are delivered to and a function
+// stopping it. The channel is closed once the operation is stopped, completed by the server or ended with an error,
+// see WithGraphQLWSErrorHandler. The connection is initialized first, if needed: Subscribe waits for it to be
+// acknowledged until ctx is done, returning the error of ctx then.
+func (g *GraphQLWSClient) Subscribe(ctx context.Context, query string, vars map[string]any) (<-chan json.RawMessage, func(), error) {
+	if g.initErr != nil {
+		return nil, nil, g.initErr
+	}
+
+	payload, err := json.Marshal(struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables,omitempty"`
+	}{Query: query, Variables: vars})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encode subscription: %w", err)
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil, nil, ErrGraphQLWSClosed
+	}
+
+	g.nextID++
+	op := &graphQLWSOperation{
+		id:      strconv.FormatUint(g.nextID, 10),
+		payload: payload,
+		out:     make(chan json.RawMessage, g.bufferSize),
+		done:    make(chan struct{}),
+	}
+	g.ops[op.id] = op
+	msgs := g.initLocked(nil)
+	acked := g.acked
+	if isClosed(acked) {
+		// Otherwise, it is sent once the connection is acknowledged.
+		msgs = append(msgs, graphQLWSMessage{ID: op.id, Type: graphQLWSSubscribe, Payload: op.payload})
+	}
+	err = g.unlockAndSend(msgs...)
+
+	stop := func() { g.stop(op, true) }
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+
+	select {
+	case <-acked:
+		return op.out, stop, nil
+	case <-ctx.Done():
+		stop()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// Handle is the MessageHandler of the client. Messages which are not part of the protocol are ignored.
+func (g *GraphQLWSClient) Handle(_ Client, m Message) {
+	if !m.Type().IsText() {
+		return
+	}
+
+	var msg graphQLWSMessage
+	if err := json.Unmarshal(m.Data(), &msg); err != nil {
+		return
+	}
+
+	switch msg.Type {
+	case graphQLWSConnectionAck:
+		g.ack()
+	case graphQLWSPing:
+		_ = g.send(graphQLWSMessage{Type: graphQLWSPong})
+	case graphQLWSNext:
+		if op, ok := g.operation(msg.ID); ok {
+			op.deliver(msg.Payload)
+		}
+	case graphQLWSError:
+		op, ok := g.operation(msg.ID)
+		if !ok {
+			return
+		}
+		g.stop(op, false)
+		if g.errorHandler != nil {
+			var query struct {
+				Query string `json:"query"`
+			}
+			_ = json.Unmarshal(op.payload, &query)
+			g.errorHandler(&GraphQLWSError{ID: op.id, Query: query.Query, Errors: msg.Payload})
+		}
+	case graphQLWSComplete:
+		if op, ok := g.operation(msg.ID); ok {
+			g.stop(op, false)
+		}
+	}
+}
+
+// HandleEvent initializes the new connection on reconnection, running the operations in progress again once it is
+// acknowledged. Call it from the EventHandler.
+func (g *GraphQLWSClient) HandleEvent(_ Client, event EventType) {
+	if event != EventReconnect && event != EventStandbyPromoted {
+		return
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return
+	}
+
+	g.initSent = false
+	if isClosed(g.acked) {
+		g.acked = make(chan struct{})
+	}
+	var msgs []graphQLWSMessage
+	if len(g.ops) > 0 {
+		msgs = g.initLocked(msgs)
+	}
+	_ = g.unlockAndSend(msgs...)
+}
+
+// Close stops every operation, closing their channels. Subscribe fails with ErrGraphQLWSClosed afterwards.
+func (g *GraphQLWSClient) Close() {
+	g.mu.Lock()
+	g.closed = true
+	ops := make([]*graphQLWSOperation, 0, len(g.ops))
+	for _, op := range g.ops {
+		ops = append(ops, op)
+	}
+	g.mu.Unlock()
+
+	for _, op := range ops {
+		g.stop(op, true)
+	}
+}
+
+// initLocked appends connection_init to msgs, unless it was sent on the current connection. It must be called with mu
+// held.
+func (g *GraphQLWSClient) initLocked(msgs []graphQLWSMessage) []graphQLWSMessage {
+	if g.initSent {
+		return msgs
+	}
+
+	g.initSent = true
+	return append(msgs, graphQLWSMessage{Type: graphQLWSConnectionInit, Payload: g.initPayload})
+}
+
+// ack marks the current connection acknowledged, running the operations in progress.
+func (g *GraphQLWSClient) ack() {
+	g.mu.Lock()
+	if isClosed(g.acked) {
+		g.mu.Unlock()
+		return
+	}
+	close(g.acked)
+
+	msgs := make([]graphQLWSMessage, 0, len(g.ops))
+	for _, op := range g.ops {
+		msgs = append(msgs, graphQLWSMessage{ID: op.id, Type: graphQLWSSubscribe, Payload: op.payload})
+	}
+	_ = g.unlockAndSend(msgs...)
+}
+
+func (g *GraphQLWSClient) operation(id string) (*graphQLWSOperation, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	op, ok := g.ops[id]
+	return op, ok
+}
+
+// stop forgets op and closes its channel, telling the server when it was stopped on our side.
+func (g *GraphQLWSClient) stop(op *graphQLWSOperation, tell bool) {
+	g.mu.Lock()
+	_, running := g.ops[op.id]
+	delete(g.ops, op.id)
+	var msgs []graphQLWSMessage
+	if running && tell && isClosed(g.acked) {
+		msgs = append(msgs, graphQLWSMessage{ID: op.id, Type: graphQLWSComplete})
+	}
+	_ = g.unlockAndSend(msgs...)
+
+	op.finish()
+}
+
+// unlockAndSend releases mu, then sends msgs, built while holding it, ahead of any message built afterwards.
+func (g *GraphQLWSClient) unlockAndSend(msgs ...graphQLWSMessage) error {
+	if len(msgs) == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+
+	g.sendMu.Lock()
+	g.mu.Unlock()
+	defer g.sendMu.Unlock()
+
+	return g.write(msgs)
+}
+
+// send sends msgs, returning the first error encoding them.
+func (g *GraphQLWSClient) send(msgs ...graphQLWSMessage) error {
+	g.sendMu.Lock()
+	defer g.sendMu.Unlock()
+
+	return g.write(msgs)
+}
+
+func (g *GraphQLWSClient) write(msgs []graphQLWSMessage) error {
+	for _, msg := range msgs {
+		bts, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("%w: cannot encode graphql-ws %s message: %w", ErrInvalidOutbound, msg.Type, err)
+		}
+
+		g.client.Send(NewTextMessage(bts))
+	}
+
+	return nil
+}
+
+// deliver hands payload over to the reader of the operation, unless it was stopped meanwhile.
+func (op *graphQLWSOperation) deliver(payload json.RawMessage) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	if op.closed {
+		return
+	}
+
+	select {
+	case op.out <- payload:
+	case <-op.done:
+	}
+}
+
+// finish closes the channel of the operation, once a delivery in progress, if any, gave up.
+func (op *graphQLWSOperation) finish() {
+	op.once.Do(func() { close(op.done) })
+
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	if !op.closed {
+		op.closed = true
+		close(op.out)
+	}
+}
@@ -0,0 +1,343 @@
+package libws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedGraphQLWSServer implements the server side of graphql-transport-ws: trades sends three results then
+// completes, fail ends with an error and ticks sends results until completed by the client.
+type scriptedGraphQLWSServer struct {
+	// dropFirst drops the first connection once it sent the first tick
+	dropFirst bool
+
+	conns atomic.Int32
+	pongs atomic.Int32
+
+	mu         sync.Mutex
+	inits      []json.RawMessage
+	subscribes []scriptedGraphQLWSSubscribe
+	completes  []string
+}
+
+type scriptedGraphQLWSSubscribe struct {
+	conn  int32
+	id    string
+	query string
+	vars  map[string]any
+}
+
+func (s *scriptedGraphQLWSServer) serve(conn *websocket.Conn) {
+	connIdx := s.conns.Add(1)
+	done := make(chan struct{})
+	defer close(done)
+
+	var wmu sync.Mutex
+	write := func(msg graphQLWSMessage) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		_ = conn.WriteJSON(msg)
+	}
+
+	stops := make(map[string]chan struct{})
+	for {
+		var msg graphQLWSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case graphQLWSConnectionInit:
+			s.mu.Lock()
+			s.inits = append(s.inits, msg.Payload)
+			s.mu.Unlock()
+			write(graphQLWSMessage{Type: graphQLWSConnectionAck})
+			write(graphQLWSMessage{Type: graphQLWSPing})
+		case graphQLWSPong:
+			s.pongs.Add(1)
+		case graphQLWSSubscribe:
+			var p struct {
+				Query     string         `json:"query"`
+				Variables map[string]any `json:"variables"`
+			}
+			_ = json.Unmarshal(msg.Payload, &p)
+			sub := scriptedGraphQLWSSubscribe{conn: connIdx, id: msg.ID, query: p.Query, vars: p.Variables}
+			s.mu.Lock()
+			s.subscribes = append(s.subscribes, sub)
+			s.mu.Unlock()
+
+			stop := make(chan struct{})
+			stops[msg.ID] = stop
+			go s.run(conn, sub, write, stop, done)
+		case graphQLWSComplete:
+			s.mu.Lock()
+			s.completes = append(s.completes, msg.ID)
+			s.mu.Unlock()
+			if stop, ok := stops[msg.ID]; ok {
+				close(stop)
+				delete(stops, msg.ID)
+			}
+		}
+	}
+}
+
+func (s *scriptedGraphQLWSServer) run(conn *websocket.Conn, sub scriptedGraphQLWSSubscribe, write func(graphQLWSMessage), stop, done chan struct{}) {
+	next := func(payload string) {
+		write(graphQLWSMessage{ID: sub.id, Type: graphQLWSNext, Payload: json.RawMessage(payload)})
+	}
+
+	switch sub.query {
+	case "subscription { trades }":
+		for _, payload := range []string{`{"trade":1}`, `{"trade":2}`, `{"trade":3}`} {
+			next(payload)
+		}
+		write(graphQLWSMessage{ID: sub.id, Type: graphQLWSComplete})
+	case "subscription { fail }":
+		write(graphQLWSMessage{ID: sub.id, Type: graphQLWSError, Payload: json.RawMessage(`[{"message":"boom"}]`)})
+	case "subscription { ticks }":
+		for {
+			select {
+			case <-stop:
+				return
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+
+			next(fmt.Sprintf(`{"conn":%d,"symbol":%q}`, sub.conn, sub.vars["symbol"]))
+			if s.dropFirst && sub.conn == 1 {
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *scriptedGraphQLWSServer) snapshot() ([]json.RawMessage, []scriptedGraphQLWSSubscribe, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]json.RawMessage(nil), s.inits...),
+		append([]scriptedGraphQLWSSubscribe(nil), s.subscribes...),
+		append([]string(nil), s.completes...)
+}
+
+// newGraphQLWSTestClient opens a reconnecting client of srv speaking graphql-transport-ws.
+func newGraphQLWSTestClient(t *testing.T, srv *scriptedGraphQLWSServer, opts ...GraphQLWSOption) *GraphQLWSClient {
+	t.Helper()
+
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, newTestWsServer(t, srv.serve))
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	base := NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}))
+	noWait := func(int) time.Duration { return 0 }
+
+	var gql *GraphQLWSClient
+	cli := newBasicClient(
+		NewBackoffConnectionHandlerFactory(nil, base, noWait, time.Minute),
+		func(c Client, m Message) { gql.Handle(c, m) },
+		func(c Client, event EventType) { gql.HandleEvent(c, event) },
+	)
+	gql = NewGraphQLWSClient(cli, json.RawMessage(`{"token":"secret"}`), opts...)
+	require.NoError(t, cli.Open(context.Background()))
+	t.Cleanup(cli.Close)
+	t.Cleanup(gql.Close)
+
+	return gql
+}
+
+// receive returns the next n results of c.
+func receive(t *testing.T, c <-chan json.RawMessage, n int) []string {
+	t.Helper()
+
+	var results []string
+	for range n {
+		select {
+		case payload, ok := <-c:
+			require.True(t, ok, "closed after %v", results)
+			results = append(results, string(payload))
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %v, waiting for %d results", results, n)
+		}
+	}
+	return results
+}
+
+func requireClosed(t *testing.T, c <-chan json.RawMessage) {
+	t.Helper()
+
+	select {
+	case payload, ok := <-c:
+		require.False(t, ok, "got %s", payload)
+	case <-time.After(2 * time.Second):
+		t.Fatal("channel not closed")
+	}
+}
+
+func TestGraphQLWSClient(t *testing.T) {
+	srv := &scriptedGraphQLWSServer{}
+	errs := make(chan *GraphQLWSError, 1)
+	gql := newGraphQLWSTestClient(t, srv, WithGraphQLWSErrorHandler(func(err *GraphQLWSError) { errs <- err }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ticks, stopTicks, err := gql.Subscribe(ctx, "subscription { ticks }", map[string]any{"symbol": "BTC"})
+	require.NoError(t, err)
+	trades, _, err := gql.Subscribe(ctx, "subscription { trades }", nil)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{`{"trade":1}`, `{"trade":2}`, `{"trade":3}`}, receive(t, trades, 3))
+	requireClosed(t, trades)
+	require.Equal(t, []string{`{"conn":1,"symbol":"BTC"}`, `{"conn":1,"symbol":"BTC"}`}, receive(t, ticks, 2))
+
+	stopTicks()
+	stopTicks()
+	requireClosed(t, ticks)
+
+	failing, _, err := gql.Subscribe(ctx, "subscription { fail }", nil)
+	require.NoError(t, err)
+	requireClosed(t, failing)
+	select {
+	case err := <-errs:
+		require.Equal(t, "3", err.ID)
+		require.Equal(t, "subscription { fail }", err.Query)
+		require.JSONEq(t, `[{"message":"boom"}]`, string(err.Errors))
+	case <-time.After(2 * time.Second):
+		t.Fatal("error not reported")
+	}
+
+	inits, subscribes, completes := srv.snapshot()
+	require.Equal(t, []json.RawMessage{json.RawMessage(`{"token":"secret"}`)}, inits, "initialized once")
+	require.Len(t, subscribes, 3)
+	require.Equal(t, "1", subscribes[0].id)
+	require.Equal(t, map[string]any{"symbol": "BTC"}, subscribes[0].vars)
+	require.Eventually(t, func() bool {
+		_, _, completes = srv.snapshot()
+		return len(completes) == 1
+	}, 2*time.Second, time.Millisecond)
+	require.Equal(t, []string{"1"}, completes, "only the stopped operation is completed by the client")
+	require.EqualValues(t, 1, srv.pongs.Load())
+
+	gql.Close()
+	_, _, err = gql.Subscribe(ctx, "subscription { trades }", nil)
+	require.ErrorIs(t, err, ErrGraphQLWSClosed)
+}
+
+func TestGraphQLWSClient_Reconnect(t *testing.T) {
+	srv := &scriptedGraphQLWSServer{dropFirst: true}
+	gql := newGraphQLWSTestClient(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	ticks, stop, err := gql.Subscribe(ctx, "subscription { ticks }", map[string]any{"symbol": "ETH"})
+	require.NoError(t, err)
+	defer stop()
+
+	require.Equal(t, []string{`{"conn":1,"symbol":"ETH"}`, `{"conn":2,"symbol":"ETH"}`}, receive(t, ticks, 2))
+
+	inits, subscribes, _ := srv.snapshot()
+	require.Len(t, inits, 2, "initialized again")
+	require.Equal(t, []scriptedGraphQLWSSubscribe{
+		{conn: 1, id: "1", query: "subscription { ticks }", vars: map[string]any{"symbol": "ETH"}},
+		{conn: 2, id: "1", query: "subscription { ticks }", vars: map[string]any{"symbol": "ETH"}},
+	}, subscribes)
+	require.Eventually(t, func() bool { return srv.pongs.Load() == 2 }, 2*time.Second, time.Millisecond)
+}
+
+func TestGraphQLWSClient_SubscribeTimeout(t *testing.T) {
+	// The server never acknowledges the connection.
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+	gql := NewGraphQLWSClient(cli, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := gql.Subscribe(ctx, "subscription { trades }", nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.Equal(t, []Message{NewTextMessage([]byte(`{"type":"connection_init"}`))}, stubs.Last().Sent(),
+		"subscribed before the connection was acknowledged")
+}
+
+func TestGraphQLWSClient_InvalidInitPayload(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+	gql := NewGraphQLWSClient(cli, json.RawMessage(`{"token":`))
+
+	_, _, err := gql.Subscribe(context.Background(), "subscription { trades }", nil)
+	require.ErrorIs(t, err, ErrInvalidOutbound)
+	require.Empty(t, stubs.Last().Sent())
+}
+
+// blockingSendClient holds sends back while blocking is set, as a client under backpressure does.
+type blockingSendClient struct {
+	Client
+	blocking atomic.Bool
+	sending  chan Message
+	release  chan struct{}
+}
+
+func (c *blockingSendClient) Send(m Message) {
+	if c.blocking.Load() {
+		c.sending <- m
+		<-c.release
+	}
+}
+
+func TestGraphQLWSClient_SendOutsideLock(t *testing.T) {
+	cli := &blockingSendClient{sending: make(chan Message), release: make(chan struct{})}
+	gql := NewGraphQLWSClient(cli, nil)
+	defer gql.Close()
+
+	acked := make(chan struct{})
+	go func() {
+		defer close(acked)
+		gql.Handle(cli, NewTextMessage([]byte(`{"type":"connection_ack"}`)))
+	}()
+	<-acked
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, stopTrades, err := gql.Subscribe(ctx, "subscription { trades }", nil)
+	require.NoError(t, err)
+	ticks, _, err := gql.Subscribe(ctx, "subscription { ticks }", nil)
+	require.NoError(t, err)
+
+	// Completing trades blocks on the client.
+	cli.blocking.Store(true)
+	go stopTrades()
+	select {
+	case m := <-cli.sending:
+		require.JSONEq(t, `{"id":"1","type":"complete"}`, string(m.Data()))
+	case <-time.After(2 * time.Second):
+		t.Fatal("complete not sent")
+	}
+
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		gql.Handle(cli, NewTextMessage([]byte(`{"id":"2","type":"next","payload":{"tick":1}}`)))
+	}()
+	require.Equal(t, []string{`{"tick":1}`}, receive(t, ticks, 1), "the read path waits for a send")
+	<-handled
+
+	cli.blocking.Store(false)
+	close(cli.release)
+}
@@ -0,0 +1,185 @@
+package libws
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const inbandRetryMaxTracked = 1024
+
+type (
+	// InbandRetryClassifier inspects an inbound message. When it represents a retryable error it returns the key
+	// of the outbound message to resend, the delay to wait before doing so and ok=true.
+	InbandRetryClassifier func(Message) (retryOf string, delay time.Duration, ok bool)
+
+	// InbandRetryKeyFunc extracts the key used to correlate an outbound message with in-band errors. Messages
+	// yielding an empty key are not tracked.
+	InbandRetryKeyFunc func(outbound Message) string
+
+	// InbandRetry resends outbound messages that the server rejected with an in-band, transient error while keeping
+	// the socket open, e.g. `{"error":"too many requests, retry"}`. Outbound messages must be sent through
+	// InbandRetry.Send and inbound ones observed through InbandRetry.Wrap so that errors can be correlated.
+	InbandRetry struct {
+		client     Client
+		classify   InbandRetryClassifier
+		keyOf      InbandRetryKeyFunc
+		maxRetries int
+		clock      clock
+		emitter    *EventEmitterCallback[EventType, Message]
+
+		mu      sync.Mutex
+		pending map[string]*list.Element
+		order   *list.List
+	}
+
+	inbandRetryEntry struct {
+		key      string
+		msg      Message
+		attempts int
+		timer    clockTimer
+	}
+)
+
+// NewInbandRetry returns an InbandRetry that resends through client at most maxRetries times per message.
+func NewInbandRetry(
+	client Client,
+	classify InbandRetryClassifier,
+	keyOf InbandRetryKeyFunc,
+	maxRetries int,
+) *InbandRetry {
+	return newInbandRetry(client, classify, keyOf, maxRetries, realClock{})
+}
+
+func newInbandRetry(
+	client Client,
+	classify InbandRetryClassifier,
+	keyOf InbandRetryKeyFunc,
+	maxRetries int,
+	clock clock,
+) *InbandRetry {
+	return &InbandRetry{
+		client:     client,
+		classify:   classify,
+		keyOf:      keyOf,
+		maxRetries: maxRetries,
+		clock:      clock,
+		emitter:    NewEventEmitter[EventType, Message](),
+		pending:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Send remembers the message by its key and sends it through the client. Sending a message with an already tracked
+// key resets its retry budget.
+func (r *InbandRetry) Send(m Message) {
+	if key := r.keyOf(m); key != "" {
+		r.track(key, m)
+	}
+
+	r.client.Send(m)
+}
+
+// Recv inspects an inbound message and reports whether it was consumed as a retryable error of a tracked message.
+func (r *InbandRetry) Recv(m Message) bool {
+	key, delay, ok := r.classify(m)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+
+	el, found := r.pending[key]
+	if !found {
+		r.mu.Unlock()
+		return false
+	}
+
+	entry := el.Value.(*inbandRetryEntry)
+	if entry.attempts >= r.maxRetries {
+		r.remove(el)
+		r.mu.Unlock()
+
+		// Emit outside the lock so that listeners may use InbandRetry freely.
+		r.emitter.Emit(EventRetryExhausted, entry.msg)
+		return true
+	}
+
+	entry.attempts++
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	entry.timer = r.clock.AfterFunc(delay, func() {
+		r.client.Send(entry.msg)
+	})
+	r.mu.Unlock()
+
+	return true
+}
+
+// Wrap returns a MessageHandler that consumes retryable errors and forwards everything else to next.
+func (r *InbandRetry) Wrap(next MessageHandler) MessageHandler {
+	return func(c Client, m Message) {
+		if r.Recv(m) {
+			discard(m)
+			return
+		}
+
+		next(c, m)
+	}
+}
+
+// Forget stops tracking the message with the given key, cancelling any scheduled resend. It is meant to be called
+// once the server acknowledges the message.
+func (r *InbandRetry) Forget(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if el, found := r.pending[key]; found {
+		r.remove(el)
+	}
+}
+
+// On registers a listener for retry events. Listeners of EventRetryExhausted receive the message that was given up on.
+func (r *InbandRetry) On(event EventType, listener func(Message)) {
+	r.emitter.On(event, listener)
+}
+
+// Close cancels every scheduled resend and forgets all tracked messages.
+func (r *InbandRetry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.order.Len() > 0 {
+		r.remove(r.order.Front())
+	}
+
+	r.emitter.Close()
+}
+
+func (r *InbandRetry) track(key string, m Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if el, found := r.pending[key]; found {
+		r.remove(el)
+	}
+
+	r.pending[key] = r.order.PushBack(&inbandRetryEntry{key: key, msg: m})
+
+	// Keep memory bounded by evicting the oldest tracked messages.
+	for r.order.Len() > inbandRetryMaxTracked {
+		r.remove(r.order.Front())
+	}
+}
+
+// remove must be called with mu held.
+func (r *InbandRetry) remove(el *list.Element) {
+	entry := el.Value.(*inbandRetryEntry)
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+
+	r.order.Remove(el)
+	delete(r.pending, entry.key)
+}
@@ -0,0 +1,113 @@
+package libws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInbandRetry(t *testing.T, maxRetries int) (*InbandRetry, *mockClient, *fakeClock) {
+	t.Helper()
+
+	cli := &mockClient{}
+	cli.On("Send", mock.Anything).Return()
+
+	classify := func(m Message) (string, time.Duration, bool) {
+		var payload struct {
+			Error string `json:"error"`
+			ID    string `json:"id"`
+		}
+		if err := json.Unmarshal(m.Data(), &payload); err != nil || payload.Error == "" {
+			return "", 0, false
+		}
+		return payload.ID, time.Second, true
+	}
+
+	keyOf := func(m Message) string {
+		var payload struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(m.Data(), &payload)
+		return payload.ID
+	}
+
+	clk := newFakeClock()
+	return newInbandRetry(cli, classify, keyOf, maxRetries, clk), cli, clk
+}
+
+func sentMessages(cli *mockClient) []Message {
+	var res []Message
+	for _, call := range cli.Calls {
+		if call.Method == "Send" {
+			res = append(res, call.Arguments.Get(0).(Message))
+		}
+	}
+	return res
+}
+
+func TestInbandRetry_ResendAfterDelay(t *testing.T) {
+	retry, cli, clk := newTestInbandRetry(t, 3)
+
+	var forwarded []Message
+	handler := retry.Wrap(func(_ Client, m Message) { forwarded = append(forwarded, m) })
+
+	sub := NewDataMessage([]byte(`{"id":"1","op":"subscribe"}`))
+	retry.Send(sub)
+	require.Len(t, sentMessages(cli), 1)
+
+	handler(cli, NewDataMessage([]byte(`{"error":"too many requests, retry","id":"1"}`)))
+	require.Empty(t, forwarded, "retryable errors must be consumed")
+
+	clk.Advance(500 * time.Millisecond)
+	require.Len(t, sentMessages(cli), 1, "resend must wait for the delay")
+
+	clk.Advance(500 * time.Millisecond)
+	sent := sentMessages(cli)
+	require.Len(t, sent, 2)
+	require.Equal(t, sub, sent[1])
+
+	ack := NewDataMessage([]byte(`{"id":"1","result":"ok"}`))
+	handler(cli, ack)
+	retry.Forget("1")
+	require.Equal(t, []Message{ack}, forwarded)
+}
+
+func TestInbandRetry_Exhausted(t *testing.T) {
+	retry, cli, clk := newTestInbandRetry(t, 2)
+
+	var exhausted []Message
+	retry.On(EventRetryExhausted, func(m Message) { exhausted = append(exhausted, m) })
+
+	sub := NewDataMessage([]byte(`{"id":"7"}`))
+	retry.Send(sub)
+
+	errMsg := NewDataMessage([]byte(`{"error":"busy","id":"7"}`))
+	for i := 0; i < 2; i++ {
+		require.True(t, retry.Recv(errMsg))
+		clk.Advance(time.Second)
+	}
+	require.Len(t, sentMessages(cli), 3)
+	require.Empty(t, exhausted)
+
+	require.True(t, retry.Recv(errMsg))
+	require.Equal(t, []Message{sub}, exhausted)
+
+	// Once exhausted the message is no longer tracked.
+	require.False(t, retry.Recv(errMsg))
+	clk.Advance(time.Minute)
+	require.Len(t, sentMessages(cli), 3)
+}
+
+func TestInbandRetry_ForgetCancelsScheduledResend(t *testing.T) {
+	retry, cli, clk := newTestInbandRetry(t, 3)
+
+	retry.Send(NewDataMessage([]byte(`{"id":"9"}`)))
+	require.True(t, retry.Recv(NewDataMessage([]byte(`{"error":"busy","id":"9"}`))))
+
+	retry.Forget("9")
+	clk.Advance(time.Minute)
+	require.Len(t, sentMessages(cli), 1)
+}
@@ -0,0 +1,312 @@
+package libwstest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sonirico/libws"
+)
+
+// conformanceTimeout bounds every wait of the conformance suite.
+const conformanceTimeout = 2 * time.Second
+
+type (
+	// HandlerDecorator builds the connection handler under test on top of inner, the factory of the scripted handlers
+	// the suite controls in place of connections. Handlers at the bottom of a chain, which open connections themselves,
+	// ignore inner.
+	HandlerDecorator func(inner libws.ConnectionHandlerFactory) libws.ConnectionHandlerFactory
+
+	// scriptedHandlers is the factory of the scripted inner handlers, keeping track of every handler it built.
+	scriptedHandlers struct {
+		t *testing.T
+		// refuse makes every handler built fail to connect
+		refuse   bool
+		mu       sync.Mutex
+		handlers []*scriptedHandler
+	}
+
+	// scriptedHandler is an inner handler connecting right away, unless refusing to, and closing when told to.
+	scriptedHandler struct {
+		connects   atomic.Int32
+		connectErr error
+
+		mu       sync.Mutex
+		closeErr error
+		closeC   libws.CloseChan
+	}
+)
+
+var (
+	errScriptedDrop    = errors.New("libwstest: scripted connection drop")
+	errScriptedRefused = errors.New("libwstest: scripted connection refused")
+)
+
+// RunConnectionHandlerTests checks that the handlers built by decorate honor the implicit contracts of
+// libws.ConnectionHandler, as the layers composing a client rely on them:
+//
+//   - Connect returns once connected, and inner handlers are connected at most once each.
+//   - Close may be called any number of times, concurrently, and CloseChan is closed once it returns or shortly
+//     after, for good.
+//   - Once closed, the handler closes every inner handler it built and builds no more.
+//   - CloseErr is set before CloseChan is closed, when the handler closes on its own.
+//   - Recv and Send do not block forever, even once closed.
+//   - No goroutine outlives the handler once closed.
+//   - A handler which failed to connect can still be used and closed, e.g. by a client whose Open is to be retried.
+//
+// Run it with the race detector enabled to have the contracts checked for data races as well.
+func RunConnectionHandlerTests(t *testing.T, decorate HandlerDecorator) {
+	t.Helper()
+
+	t.Run("connect", func(t *testing.T) {
+		inner, h := newHandlerUnderTest(t, decorate)
+		defer h.Close()
+
+		if err := h.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect: %s", err)
+		}
+		inner.checkConnectedOnce()
+	})
+
+	t.Run("close is idempotent", func(t *testing.T) {
+		_, h := newHandlerUnderTest(t, decorate)
+		if err := h.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect: %s", err)
+		}
+
+		var closers sync.WaitGroup
+		for range 3 {
+			closers.Add(1)
+			go func() {
+				defer closers.Done()
+				h.Close()
+			}()
+		}
+		closers.Wait()
+		h.Close()
+
+		waitClosed(t, h.CloseChan(), "CloseChan not closed after Close")
+		waitClosed(t, h.CloseChan(), "CloseChan reopened after Close")
+	})
+
+	t.Run("close releases inner handlers", func(t *testing.T) {
+		inner, h := newHandlerUnderTest(t, decorate)
+		if err := h.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect: %s", err)
+		}
+
+		h.Close()
+		waitClosed(t, h.CloseChan(), "CloseChan not closed after Close")
+		built := inner.waitAllClosed()
+
+		time.Sleep(50 * time.Millisecond)
+		if got := len(inner.snapshot()); got != built {
+			t.Errorf("%d inner handlers built after Close", got-built)
+		}
+		inner.checkConnectedOnce()
+	})
+
+	t.Run("close err set before close chan", func(t *testing.T) {
+		inner, h := newHandlerUnderTest(t, decorate)
+		if err := h.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect: %s", err)
+		}
+		defer h.Close()
+
+		// Handlers may reconnect rather than close on their own, only the ones closing are checked.
+		inner.dropAll()
+		select {
+		case <-h.CloseChan():
+			if h.CloseErr() == nil {
+				t.Error("CloseErr is nil once CloseChan is closed after a connection drop")
+			}
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+
+	t.Run("recv and send do not block", func(t *testing.T) {
+		_, h := newHandlerUnderTest(t, decorate)
+		if err := h.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect: %s", err)
+		}
+
+		exchange := func(when string) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for range 256 {
+					h.Recv(libws.NewPingMessage(nil))
+					h.Send(libws.NewDataMessage([]byte("conformance")))
+				}
+			}()
+			waitClosed(t, done, "Recv or Send blocked "+when)
+		}
+
+		exchange("while open")
+		h.Close()
+		waitClosed(t, h.CloseChan(), "CloseChan not closed after Close")
+		exchange("once closed")
+	})
+
+	t.Run("use after failed connect", func(t *testing.T) {
+		inner := &scriptedHandlers{t: t, refuse: true}
+		h := inner.build(decorate)
+
+		// Handlers at the bottom of a chain connect regardless of inner.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_ = h.Connect(ctx)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_ = h.CloseErr()
+			h.Send(libws.NewDataMessage([]byte("conformance")))
+			h.Recv(libws.NewPingMessage(nil))
+			h.Close()
+			_ = h.CloseErr()
+			h.Send(libws.NewDataMessage([]byte("conformance")))
+			h.Recv(libws.NewPingMessage(nil))
+		}()
+		waitClosed(t, done, "handler blocked after a failed Connect")
+		waitClosed(t, h.CloseChan(), "CloseChan not closed after Close")
+		inner.waitAllClosed()
+	})
+
+	t.Run("no goroutine leak", func(t *testing.T) {
+		baseline := runtime.NumGoroutine()
+
+		inner, h := newHandlerUnderTest(t, decorate)
+		if err := h.Connect(context.Background()); err != nil {
+			t.Fatalf("Connect: %s", err)
+		}
+		h.Send(libws.NewDataMessage([]byte("conformance")))
+		h.Close()
+		waitClosed(t, h.CloseChan(), "CloseChan not closed after Close")
+		inner.waitAllClosed()
+
+		deadline := time.Now().Add(conformanceTimeout)
+		for runtime.NumGoroutine() > baseline {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Fatalf("%d goroutines leaked:\n%s", runtime.NumGoroutine()-baseline, buf[:runtime.Stack(buf, true)])
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func newHandlerUnderTest(t *testing.T, decorate HandlerDecorator) (*scriptedHandlers, libws.ConnectionHandler) {
+	inner := &scriptedHandlers{t: t}
+	return inner, inner.build(decorate)
+}
+
+// build returns the handler decorate builds on top of the scripted handlers.
+func (s *scriptedHandlers) build(decorate HandlerDecorator) libws.ConnectionHandler {
+	return decorate(s.factory)(
+		NewFakeClient(nil, nil),
+		func(libws.Client, libws.Message) {},
+		libws.NewEventEmitter[libws.EventType, libws.EventType](),
+	)
+}
+
+func (s *scriptedHandlers) factory(
+	libws.Client,
+	libws.MessageHandler,
+	libws.Emitter,
+) libws.ConnectionHandler {
+	h := &scriptedHandler{closeC: make(libws.CloseChan)}
+	if s.refuse {
+		h.connectErr = errScriptedRefused
+	}
+
+	s.mu.Lock()
+	s.handlers = append(s.handlers, h)
+	s.mu.Unlock()
+
+	return h
+}
+
+func (s *scriptedHandlers) snapshot() []*scriptedHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*scriptedHandler(nil), s.handlers...)
+}
+
+// checkConnectedOnce reports the inner handlers connected more than once.
+func (s *scriptedHandlers) checkConnectedOnce() {
+	for i, h := range s.snapshot() {
+		if n := h.connects.Load(); n > 1 {
+			s.t.Errorf("inner handler #%d connected %d times", i, n)
+		}
+	}
+}
+
+// dropAll closes every inner handler, as if their connections dropped.
+func (s *scriptedHandlers) dropAll() {
+	for _, h := range s.snapshot() {
+		h.drop(errScriptedDrop)
+	}
+}
+
+// waitAllClosed waits for every inner handler built so far to be closed, returning how many there are.
+func (s *scriptedHandlers) waitAllClosed() int {
+	handlers := s.snapshot()
+	for i, h := range handlers {
+		waitClosed(s.t, h.closeC, fmt.Sprintf("inner handler #%d not closed", i))
+	}
+
+	return len(handlers)
+}
+
+func (h *scriptedHandler) Connect(context.Context) error {
+	h.connects.Add(1)
+	return h.connectErr
+}
+
+func (h *scriptedHandler) Send(libws.Message) {}
+
+func (h *scriptedHandler) Recv(libws.Message) {}
+
+func (h *scriptedHandler) Close() {
+	h.drop(libws.ErrTerminated)
+}
+
+func (h *scriptedHandler) drop(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closeErr != nil {
+		return
+	}
+
+	h.closeErr = err
+	close(h.closeC)
+}
+
+func (h *scriptedHandler) CloseChan() libws.CloseChan {
+	return h.closeC
+}
+
+func (h *scriptedHandler) CloseErr() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.closeErr
+}
+
+func waitClosed[T any](t *testing.T, c <-chan T, msg string) {
+	t.Helper()
+
+	select {
+	case <-c:
+	case <-time.After(conformanceTimeout):
+		t.Fatal(msg)
+	}
+}
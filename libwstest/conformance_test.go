@@ -0,0 +1,43 @@
+package libwstest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sonirico/libws"
+	"github.com/sonirico/libws/libwstest"
+)
+
+func TestBuiltinHandlersConformance(t *testing.T) {
+	pings := libws.NewKeepAliveMessageFactory(libws.PingMessage, func() []byte { return nil })
+
+	handlers := map[string]libwstest.HandlerDecorator{
+		"base": func(libws.ConnectionHandlerFactory) libws.ConnectionHandlerFactory {
+			return libws.NewBaseConnectionHandlerFactory(
+				nil,
+				libws.NewSimulatedConnectionFactory(libws.NewSliceMessageSource(nil), &libws.MessageRecorder{}),
+			)
+		},
+		"backoff": func(inner libws.ConnectionHandlerFactory) libws.ConnectionHandlerFactory {
+			return libws.NewBackoffConnectionHandlerFactory(nil, inner, func(int) time.Duration { return 0 }, time.Minute)
+		},
+		"reopen interval": func(inner libws.ConnectionHandlerFactory) libws.ConnectionHandlerFactory {
+			return libws.NewReopenIntervalConnFactory(nil, time.Hour, inner)
+		},
+		"active keep-alive": func(inner libws.ConnectionHandlerFactory) libws.ConnectionHandlerFactory {
+			return libws.NewActiveKeepAliveConnectionHandlerFactory(nil, inner, time.Millisecond, pings)
+		},
+		"passive keep-alive": func(inner libws.ConnectionHandlerFactory) libws.ConnectionHandlerFactory {
+			return libws.NewPassiveKeepAliveConnectionHandlerFactory(inner, libws.KeepAliveHandlerReplyPingWithPong)
+		},
+		"hot standby": func(inner libws.ConnectionHandlerFactory) libws.ConnectionHandlerFactory {
+			return libws.NewHotStandbyConnectionHandlerFactory(nil, inner, func(libws.ConnectionHandler) error { return nil })
+		},
+	}
+
+	for name, decorate := range handlers {
+		t.Run(name, func(t *testing.T) {
+			libwstest.RunConnectionHandlerTests(t, decorate)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package libwstest_test
+
+import (
+	"fmt"
+
+	"github.com/sonirico/libws"
+	"github.com/sonirico/libws/libwstest"
+)
+
+// quoter is an example of code built on libws: it answers every price update with an order.
+type quoter struct {
+	orders int
+}
+
+func (q *quoter) onMessage(cli libws.Client, m libws.Message) {
+	q.orders++
+	cli.Send(libws.NewDataMessage([]byte(fmt.Sprintf(`{"order":%d,"after":%s}`, q.orders, m.Data()))))
+}
+
+func ExampleFakeClient() {
+	q := &quoter{}
+	cli := libwstest.NewFakeClient(q.onMessage, nil)
+
+	cli.Deliver(
+		libws.NewDataMessage([]byte(`{"price":100}`)),
+		libws.NewPingMessage(nil),
+		libws.NewDataMessage([]byte(`{"price":101}`)),
+	)
+
+	for _, data := range cli.SentData() {
+		fmt.Println(data)
+	}
+	// Output:
+	// {"order":1,"after":{"price":100}}
+	// {"order":2,"after":{"price":101}}
+}
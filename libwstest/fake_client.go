@@ -0,0 +1,257 @@
+// Package libwstest provides test doubles for code built on libws, free of any assertion library dependency.
+package libwstest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sonirico/libws"
+)
+
+type (
+	// TB is the subset of testing.TB used by the assertion helpers.
+	TB interface {
+		Helper()
+		Errorf(format string, args ...any)
+	}
+
+	// FakeClient is a libws.Client without any connection. Inbound messages are scripted with Deliver, outbound ones
+	// are captured and the close and events of the connection are driven by the test. It is safe for concurrent use.
+	FakeClient struct {
+		handler libws.MessageHandler
+		events  libws.EventHandler
+
+		mu       sync.Mutex
+		openErr  error
+		opened   bool
+		sent     []libws.Message
+		closeErr error
+
+		closeC    libws.CloseChan
+		closeOnce sync.Once
+	}
+)
+
+var (
+	_ libws.Client       = (*FakeClient)(nil)
+	_ libws.RecvInjector = (*FakeClient)(nil)
+)
+
+// NewFakeClient returns a FakeClient passing inbound data messages to handler and events to events, both optional.
+func NewFakeClient(handler libws.MessageHandler, events libws.EventHandler) *FakeClient {
+	return &FakeClient{
+		handler: handler,
+		events:  events,
+		closeC:  make(libws.CloseChan),
+	}
+}
+
+// FailOpen makes the next calls to Open return err, until called again with nil.
+func (c *FakeClient) FailOpen(err error) {
+	c.mu.Lock()
+	c.openErr = err
+	c.mu.Unlock()
+}
+
+// Open returns the error set with FailOpen, if any, and marks the client as open otherwise.
+func (c *FakeClient) Open(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.openErr != nil {
+		return c.openErr
+	}
+
+	c.opened = true
+	return nil
+}
+
+// Opened reports whether Open succeeded.
+func (c *FakeClient) Opened() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.opened
+}
+
+// Send captures m, unless the client is closed or m is synthetic, in which case m is discarded as a real client would.
+func (c *FakeClient) Send(m libws.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isClosed() || m.Type().IsSynthetic() {
+		return
+	}
+
+	c.sent = append(c.sent, m)
+}
+
+// Sent returns the messages captured so far, in order.
+func (c *FakeClient) Sent() []libws.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]libws.Message(nil), c.sent...)
+}
+
+// SentData returns the payloads of the messages captured so far, in order.
+func (c *FakeClient) SentData() []string {
+	sent := c.Sent()
+
+	data := make([]string, len(sent))
+	for i, m := range sent {
+		data[i] = string(m.Data())
+	}
+	return data
+}
+
+// ResetSent forgets the messages captured so far.
+func (c *FakeClient) ResetSent() {
+	c.mu.Lock()
+	c.sent = nil
+	c.mu.Unlock()
+}
+
+// Deliver passes messages to the MessageHandler, synchronously and in order, as if they were read from the connection.
+// Like a real client, only text, binary and synthetic messages are passed, others being handled within its connection
+// layers.
+// Messages delivered after Close are discarded.
+func (c *FakeClient) Deliver(messages ...libws.Message) {
+	for _, m := range messages {
+		if c.handler == nil || !(m.Type().IsData() || m.Type().IsSynthetic()) || c.isClosed() {
+			continue
+		}
+
+		c.handler(c, m)
+	}
+}
+
+// InjectRecv delivers m, see Deliver.
+func (c *FakeClient) InjectRecv(m libws.Message) {
+	c.Deliver(m)
+}
+
+// Emit passes event to the EventHandler, as if the connection layers emitted it.
+func (c *FakeClient) Emit(event libws.EventType) {
+	if c.events != nil {
+		c.events(c, event)
+	}
+}
+
+// Close closes the client, see Drop.
+func (c *FakeClient) Close() {
+	c.Drop(libws.ErrTerminated)
+}
+
+// Drop closes the client with err as the close reason, as if the connection dropped. Only the first close counts.
+func (c *FakeClient) Drop(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closeErr = err
+		close(c.closeC)
+		c.mu.Unlock()
+	})
+}
+
+// CloseChan returns a channel closed once the client is closed.
+func (c *FakeClient) CloseChan() libws.CloseChan {
+	return c.closeC
+}
+
+// CloseErr returns the close reason, nil while open.
+func (c *FakeClient) CloseErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closeErr
+}
+
+// AssertSent reports an error on t unless the messages captured so far have, in order, the types and payloads of
+// want.
+func (c *FakeClient) AssertSent(t TB, want ...libws.Message) bool {
+	t.Helper()
+
+	got := c.Sent()
+	if len(got) != len(want) {
+		t.Errorf("libwstest: %d messages sent, want %d: %s", len(got), len(want), describe(got))
+		return false
+	}
+
+	for i := range want {
+		if got[i].Type() != want[i].Type() || string(got[i].Data()) != string(want[i].Data()) {
+			t.Errorf("libwstest: message #%d sent is %s, want %s", i, describeOne(got[i]), describeOne(want[i]))
+			return false
+		}
+	}
+
+	return true
+}
+
+// AssertSentData reports an error on t unless the payloads of the messages captured so far are, in order, want.
+func (c *FakeClient) AssertSentData(t TB, want ...string) bool {
+	t.Helper()
+
+	got := c.SentData()
+	if len(got) != len(want) {
+		t.Errorf("libwstest: %d messages sent, want %d: %q", len(got), len(want), got)
+		return false
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("libwstest: message #%d sent is %q, want %q", i, got[i], want[i])
+			return false
+		}
+	}
+
+	return true
+}
+
+// AssertNothingSent reports an error on t if any message was captured.
+func (c *FakeClient) AssertNothingSent(t TB) bool {
+	t.Helper()
+
+	if got := c.Sent(); len(got) > 0 {
+		t.Errorf("libwstest: %d messages sent, want none: %s", len(got), describe(got))
+		return false
+	}
+
+	return true
+}
+
+// AssertClosed reports an error on t unless the client is closed.
+func (c *FakeClient) AssertClosed(t TB) bool {
+	t.Helper()
+
+	if !c.isClosed() {
+		t.Errorf("libwstest: client is not closed")
+		return false
+	}
+
+	return true
+}
+
+func (c *FakeClient) isClosed() bool {
+	select {
+	case <-c.closeC:
+		return true
+	default:
+		return false
+	}
+}
+
+func describe(messages []libws.Message) string {
+	s := "["
+	for i, m := range messages {
+		if i > 0 {
+			s += ", "
+		}
+		s += describeOne(m)
+	}
+	return s + "]"
+}
+
+func describeOne(m libws.Message) string {
+	return fmt.Sprintf("%d:%q", m.Type(), m.Data())
+}
@@ -0,0 +1,179 @@
+package libwstest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sonirico/libws"
+	"github.com/sonirico/libws/libwstest"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedClient is a client along with the means to script its connection.
+type scriptedClient struct {
+	libws.Client
+	// deliver passes inbound messages once the client is open
+	deliver func(messages ...libws.Message)
+	// sent returns the payloads written so far
+	sent func() []string
+}
+
+type recordingHandler struct {
+	mu       sync.Mutex
+	clients  []libws.Client
+	received []string
+}
+
+func (h *recordingHandler) handle(cli libws.Client, m libws.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients = append(h.clients, cli)
+	h.received = append(h.received, string(m.Data()))
+}
+
+func (h *recordingHandler) snapshot() ([]libws.Client, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]libws.Client(nil), h.clients...), append([]string(nil), h.received...)
+}
+
+// TestFakeClient_Fidelity runs the same scenario against a real client over a simulated connection and a FakeClient.
+func TestFakeClient_Fidelity(t *testing.T) {
+	inbound := []libws.Message{
+		libws.NewDataMessage([]byte("a")),
+		libws.NewPingMessage([]byte("ping")),
+		libws.NewBinaryMessage([]byte("b")),
+		libws.NewDataMessage([]byte("c")),
+	}
+
+	clients := map[string]func(handler libws.MessageHandler) scriptedClient{
+		"real": func(handler libws.MessageHandler) scriptedClient {
+			timed := make([]libws.TimedMessage, len(inbound))
+			for i, m := range inbound {
+				timed[i] = libws.TimedMessage{Message: m}
+			}
+
+			recorder := &libws.MessageRecorder{}
+			factory := libws.NewBaseConnectionHandlerFactory(
+				nil,
+				libws.NewSimulatedConnectionFactory(libws.NewSliceMessageSource(timed), recorder),
+			)
+
+			return scriptedClient{
+				Client:  libws.NewBasicClientFactory(factory, handler, func(libws.Client, libws.EventType) {})(),
+				deliver: func(...libws.Message) {},
+				sent:    func() []string { return payloads(recorder.Messages()) },
+			}
+		},
+		"fake": func(handler libws.MessageHandler) scriptedClient {
+			cli := libwstest.NewFakeClient(handler, nil)
+
+			return scriptedClient{Client: cli, deliver: cli.Deliver, sent: cli.SentData}
+		},
+	}
+
+	for name, newClient := range clients {
+		t.Run(name, func(t *testing.T) {
+			handler := &recordingHandler{}
+			cli := newClient(handler.handle)
+
+			require.NoError(t, cli.Open(context.Background()))
+			cli.deliver(inbound...)
+
+			require.Eventually(t, func() bool {
+				_, received := handler.snapshot()
+				return len(received) == 3
+			}, time.Second, time.Millisecond)
+
+			clients, received := handler.snapshot()
+			require.Equal(t, []string{"a", "b", "c"}, received, "only text and binary messages reach the handler, in order")
+			for _, got := range clients {
+				require.Same(t, cli.Client, got, "the handler is given the client itself")
+			}
+
+			cli.Send(libws.NewDataMessage([]byte("x")))
+			cli.Send(libws.NewDataMessage([]byte("y")))
+			require.Eventually(t, func() bool { return len(cli.sent()) == 2 }, time.Second, time.Millisecond)
+			require.Equal(t, []string{"x", "y"}, cli.sent())
+
+			cli.Close()
+			cli.Close()
+			select {
+			case <-cli.CloseChan():
+			case <-time.After(time.Second):
+				t.Fatal("CloseChan not closed")
+			}
+		})
+	}
+}
+
+func payloads(messages []libws.Message) []string {
+	data := make([]string, len(messages))
+	for i, m := range messages {
+		data[i] = string(m.Data())
+	}
+	return data
+}
+
+// fakeTB records the errors reported by the assertion helpers.
+type fakeTB struct {
+	errors []string
+}
+
+func (t *fakeTB) Helper() {}
+
+func (t *fakeTB) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestFakeClient_Assertions(t *testing.T) {
+	cli := libwstest.NewFakeClient(nil, nil)
+
+	tb := &fakeTB{}
+	require.True(t, cli.AssertNothingSent(tb))
+	require.False(t, cli.AssertClosed(tb))
+	require.Len(t, tb.errors, 1)
+
+	cli.Send(libws.NewDataMessage([]byte("subscribe")))
+
+	tb = &fakeTB{}
+	require.True(t, cli.AssertSent(tb, libws.NewDataMessage([]byte("subscribe"))))
+	require.True(t, cli.AssertSentData(tb, "subscribe"))
+	require.False(t, cli.AssertSent(tb, libws.NewBinaryMessage([]byte("subscribe"))))
+	require.False(t, cli.AssertSentData(tb, "subscribe", "again"))
+	require.False(t, cli.AssertNothingSent(tb))
+	require.Len(t, tb.errors, 3)
+
+	cli.ResetSent()
+	require.True(t, cli.AssertNothingSent(tb))
+}
+
+func TestFakeClient_OpenDropAndEvents(t *testing.T) {
+	var events []libws.EventType
+	cli := libwstest.NewFakeClient(nil, func(_ libws.Client, e libws.EventType) { events = append(events, e) })
+
+	errDial := errors.New("dial refused")
+	cli.FailOpen(errDial)
+	require.ErrorIs(t, cli.Open(context.Background()), errDial)
+	require.False(t, cli.Opened())
+
+	cli.FailOpen(nil)
+	require.NoError(t, cli.Open(context.Background()))
+	require.True(t, cli.Opened())
+
+	cli.Emit(libws.EventReconnect)
+	require.Equal(t, []libws.EventType{libws.EventReconnect}, events)
+
+	cli.Drop(libws.ErrConnectionClosed)
+	require.True(t, cli.AssertClosed(t))
+	require.ErrorIs(t, cli.CloseErr(), libws.ErrConnectionClosed)
+
+	cli.Send(libws.NewDataMessage([]byte("late")))
+	require.Empty(t, cli.Sent(), "messages sent after close are discarded")
+}
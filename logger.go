@@ -1,5 +1,31 @@
 package libws
 
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// levelEnabler is optionally implemented by loggers able to report whether entries of a level would be written.
+// Hot paths check it before building log entries, so that filtered out levels cost no allocations. Loggers not
+// implementing it are assumed to have every level enabled.
+type levelEnabler interface {
+	Enabled(level LogLevel) bool
+}
+
+// logEnabled reports whether l writes entries of the given level.
+func logEnabled(l logger, level LogLevel) bool {
+	if e, ok := l.(levelEnabler); ok {
+		return e.Enabled(level)
+	}
+
+	return true
+}
+
 type logger interface {
 	WithField(key string, value any) logger
 	Debug(args ...any)
@@ -15,3 +41,35 @@ type logger interface {
 	Errorf(format string, args ...any)
 	Errorln(args ...any)
 }
+
+// NopLogger returns a logger discarding everything. Constructors given a nil logger use it instead.
+func NopLogger() logger {
+	return nopLogger{}
+}
+
+// orNop returns l, or a logger discarding everything when l is nil.
+func orNop(l logger) logger {
+	if l == nil {
+		return nopLogger{}
+	}
+
+	return l
+}
+
+// nopLogger is a logger discarding everything.
+type nopLogger struct{}
+
+func (l nopLogger) WithField(string, any) logger { return l }
+func (nopLogger) Enabled(LogLevel) bool          { return false }
+func (nopLogger) Debug(...any)                   {}
+func (nopLogger) Debugf(string, ...any)          {}
+func (nopLogger) Debugln(...any)                 {}
+func (nopLogger) Info(...any)                    {}
+func (nopLogger) Infof(string, ...any)           {}
+func (nopLogger) Infoln(...any)                  {}
+func (nopLogger) Warn(...any)                    {}
+func (nopLogger) Warnf(string, ...any)           {}
+func (nopLogger) Warnln(...any)                  {}
+func (nopLogger) Error(...any)                   {}
+func (nopLogger) Errorf(string, ...any)          {}
+func (nopLogger) Errorln(...any)                 {}
@@ -0,0 +1,110 @@
+package libws
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilLogger_FullComposition(t *testing.T) {
+	var conns atomic.Int32
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		// Drop the first connection shortly, so that reconnection paths log too.
+		if conns.Add(1) == 1 {
+			time.AfterFunc(50*time.Millisecond, func() { _ = conn.Close() })
+		}
+
+		for {
+			mt, bts, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, bts); err != nil {
+				return
+			}
+		}
+	})
+
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	factory := NewBackoffConnectionHandlerFactory(
+		nil,
+		NewReopenIntervalConnFactory(
+			nil,
+			time.Hour,
+			NewActiveKeepAliveConnectionHandlerFactory(
+				nil,
+				NewHotStandbyConnectionHandlerFactory(
+					nil,
+					NewBaseConnectionHandlerFactory(nil, NewWebsocketFactory(nil, websocket.DefaultDialer, repo, ErrorAdapters{})),
+					func(ConnectionHandler) error { return nil },
+				),
+				time.Hour,
+				NewKeepAliveMessageFactory(PingMessage, func() []byte { return nil }),
+			),
+		),
+		func(int) time.Duration { return 0 },
+		time.Minute,
+	)
+
+	received := make(chan string, 8)
+	events := make(chan EventType, 8)
+	cli := NewBasicClientFactory(
+		factory,
+		func(_ Client, m Message) { received <- string(m.Data()) },
+		func(_ Client, e EventType) {
+			if e == EventStandbyPromoted || e == EventReconnect {
+				events <- e
+			}
+		},
+		WithLogger(nil),
+		WithLazyConnect(time.Hour),
+	)()
+
+	require.NotPanics(t, func() {
+		require.NoError(t, cli.Open(context.Background()))
+		defer cli.Close()
+
+		requireEcho(t, cli, received)
+
+		select {
+		case <-events:
+		case <-time.After(2 * time.Second):
+			t.Fatal("dropped connection not replaced")
+		}
+
+		requireEcho(t, cli, received)
+	})
+}
+
+// requireEcho sends messages through cli until one is echoed back, messages sent to a dying connection being lost.
+func requireEcho(t *testing.T, cli Client, received <-chan string) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		cli.Send(NewDataMessage([]byte("echo")))
+		select {
+		case got := <-received:
+			return got == "echo"
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 2*time.Second, time.Millisecond)
+}
+
+func TestNopLogger(t *testing.T) {
+	l := NopLogger()
+
+	require.NotPanics(t, func() {
+		l.WithField("key", "value").Errorf("discarded %d", 1)
+	})
+	require.False(t, logEnabled(l, LevelError))
+	require.Equal(t, l, orNop(nil))
+}
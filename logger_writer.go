@@ -6,17 +6,31 @@ import (
 	"time"
 )
 
+var levelsByName = map[string]LogLevel{
+	"DEBUG": LevelDebug,
+	"INFO":  LevelInfo,
+	"WARN":  LevelWarn,
+	"ERROR": LevelError,
+}
+
 // testLogger implements the logger interface using an io.Writer
 type testLogger struct {
 	writer io.Writer
 	fields map[string]any
+	level  LogLevel
 }
 
 // NewTestLogger creates a new logger that writes to the provided writer
 func newTestLogger(writer io.Writer) logger {
+	return newLeveledTestLogger(writer, LevelDebug)
+}
+
+// newLeveledTestLogger creates a new logger that writes entries of at least the given level to the provided writer
+func newLeveledTestLogger(writer io.Writer, level LogLevel) logger {
 	return &testLogger{
 		writer: writer,
 		fields: make(map[string]any),
+		level:  level,
 	}
 }
 
@@ -24,6 +38,7 @@ func (l *testLogger) WithField(key string, value any) logger {
 	newLogger := &testLogger{
 		writer: l.writer,
 		fields: make(map[string]any),
+		level:  l.level,
 	}
 	// Copy existing fields
 	for k, v := range l.fields {
@@ -33,6 +48,10 @@ func (l *testLogger) WithField(key string, value any) logger {
 	return newLogger
 }
 
+func (l *testLogger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
 func (l *testLogger) formatFields() string {
 	if len(l.fields) == 0 {
 		return ""
@@ -52,6 +71,9 @@ func (l *testLogger) formatFields() string {
 }
 
 func (l *testLogger) log(level, msg string) {
+	if !l.Enabled(levelsByName[level]) {
+		return
+	}
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
 	fields := l.formatFields()
 	fmt.Fprintf(l.writer, "[%s] %s%s: %s\n", timestamp, level, fields, msg)
@@ -0,0 +1,168 @@
+package libws
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// BudgetOverflowPolicy decides what happens when buffering a message would exceed a MemoryBudget.
+type BudgetOverflowPolicy int
+
+const (
+	// BudgetRefuseNew refuses to buffer the new message, which is dropped with ErrMemoryBudgetExceeded.
+	BudgetRefuseNew BudgetOverflowPolicy = iota
+	// BudgetDropOldest evicts the oldest messages of the component holding the most bytes, across every component
+	// sharing the budget, until the new message fits.
+	BudgetDropOldest
+)
+
+type (
+	// MemoryBudget bounds the payload bytes held by the buffers of every client it is shared with. Pass it to clients
+	// with WithMemoryBudget. Accounting is made of atomic additions on enqueue and dequeue.
+	MemoryBudget struct {
+		limit  int64
+		policy BudgetOverflowPolicy
+		used   atomic.Int64
+
+		dropped atomic.Uint64
+		refused atomic.Uint64
+
+		mu       sync.Mutex
+		accounts map[*budgetAccount]struct{}
+	}
+
+	// budgetAccount tracks the bytes held by a single buffering component.
+	budgetAccount struct {
+		budget *MemoryBudget
+		name   string
+		used   atomic.Int64
+		closed atomic.Bool
+		// evict drops the oldest message held by the component, releasing it, and returns false when it holds none.
+		evict func() bool
+	}
+)
+
+// NewMemoryBudget returns a budget of limit bytes, enforced with the given policy.
+func NewMemoryBudget(limit int64, policy BudgetOverflowPolicy) *MemoryBudget {
+	return &MemoryBudget{
+		limit:    limit,
+		policy:   policy,
+		accounts: make(map[*budgetAccount]struct{}),
+	}
+}
+
+// WithMemoryBudget makes the buffers of the client layers account for, and be bounded by, budget. Share the same
+// budget across clients to bound their total usage. As of now, the outbound queue of the backoff layer, which holds
+// messages while reconnecting, is accounted for.
+func WithMemoryBudget(budget *MemoryBudget) ClientOption {
+	return func(b *basicClient) {
+		registerHandle(b, budget)
+	}
+}
+
+// Limit returns the budget size in bytes.
+func (b *MemoryBudget) Limit() int64 { return b.limit }
+
+// Used returns the bytes currently held.
+func (b *MemoryBudget) Used() int64 { return b.used.Load() }
+
+// Dropped returns how many buffered messages were evicted under BudgetDropOldest.
+func (b *MemoryBudget) Dropped() uint64 { return b.dropped.Load() }
+
+// Refused returns how many messages could not be buffered.
+func (b *MemoryBudget) Refused() uint64 { return b.refused.Load() }
+
+// Usage returns the bytes held by each registered component, summed by component name.
+func (b *MemoryBudget) Usage() map[string]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	usage := make(map[string]int64, len(b.accounts))
+	for a := range b.accounts {
+		usage[a.name] += a.used.Load()
+	}
+	return usage
+}
+
+// budgetOf returns the account of a new component of the client, or nil when the client has no budget.
+func budgetOf(c Client, name string, evict func() bool) *budgetAccount {
+	budget, ok := Handle[*MemoryBudget](c)
+	if !ok {
+		return nil
+	}
+
+	a := &budgetAccount{budget: budget, name: name, evict: evict}
+
+	budget.mu.Lock()
+	budget.accounts[a] = struct{}{}
+	budget.mu.Unlock()
+
+	return a
+}
+
+// evictOldest asks the components, from the one holding the most bytes, to drop their oldest message until one does.
+func (b *MemoryBudget) evictOldest() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	candidates := make([]*budgetAccount, 0, len(b.accounts))
+	for a := range b.accounts {
+		candidates = append(candidates, a)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].used.Load() > candidates[j].used.Load() })
+
+	for _, a := range candidates {
+		if a.evict() {
+			b.dropped.Add(1)
+			return true
+		}
+	}
+
+	return false
+}
+
+// reserve accounts for n bytes about to be buffered, returning false when they do not fit. A nil account has no
+// budget and always succeeds.
+func (a *budgetAccount) reserve(n int) bool {
+	if a == nil || a.closed.Load() {
+		return true
+	}
+
+	b := a.budget
+	for {
+		if b.used.Add(int64(n)) <= b.limit {
+			a.used.Add(int64(n))
+			return true
+		}
+		b.used.Add(-int64(n))
+
+		if b.policy != BudgetDropOldest || !b.evictOldest() {
+			b.refused.Add(1)
+			return false
+		}
+	}
+}
+
+// release accounts for n bytes no longer buffered.
+func (a *budgetAccount) release(n int) {
+	if a == nil || a.closed.Load() {
+		return
+	}
+
+	a.used.Add(-int64(n))
+	a.budget.used.Add(-int64(n))
+}
+
+// close unregisters the component, releasing whatever it still holds.
+func (a *budgetAccount) close() {
+	if a == nil || a.closed.Swap(true) {
+		return
+	}
+
+	a.budget.used.Add(-a.used.Swap(0))
+
+	a.budget.mu.Lock()
+	delete(a.budget.accounts, a)
+	a.budget.mu.Unlock()
+}
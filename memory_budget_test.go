@@ -0,0 +1,103 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// budgetTestClient is a client whose backoff layer is reconnecting, hence queueing every message sent.
+type budgetTestClient struct {
+	Client
+	stubs *stubConnectionHandlerFactory
+}
+
+func newBudgetTestClient(t *testing.T, budget *MemoryBudget) *budgetTestClient {
+	t.Helper()
+
+	var (
+		once     sync.Once
+		sleeping = make(chan struct{})
+		stubs    = &stubConnectionHandlerFactory{}
+	)
+
+	factory := NewBackoffConnectionHandlerFactory(
+		newTestLogger(io.Discard),
+		stubs.Factory,
+		func(int) time.Duration {
+			once.Do(func() { close(sleeping) })
+			return 200 * time.Millisecond
+		},
+		time.Minute,
+	)
+
+	cli := newBasicClient(factory, func(Client, Message) {}, func(Client, EventType) {}, WithMemoryBudget(budget))
+	require.NoError(t, cli.Open(context.Background()))
+	t.Cleanup(cli.Close)
+
+	stubs.Last().Kill(ErrConnectionClosed)
+	<-sleeping
+
+	return &budgetTestClient{Client: cli, stubs: stubs}
+}
+
+// sendN sends n messages of 10 bytes each.
+func (c *budgetTestClient) sendN(n int) {
+	for i := 0; i < n; i++ {
+		c.Send(NewDataMessage([]byte(fmt.Sprintf("message-%02d", i))))
+	}
+}
+
+// delivered returns the messages received by the connection opened after the reconnection.
+func (c *budgetTestClient) delivered(t *testing.T, n int) []string {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		return len(c.stubs.Handlers()) == 2 && len(c.stubs.Last().Sent()) == n
+	}, time.Second, time.Millisecond)
+
+	var res []string
+	for _, m := range c.stubs.Last().Sent() {
+		res = append(res, string(m.Data()))
+	}
+	return res
+}
+
+func TestMemoryBudget_RefuseNew(t *testing.T) {
+	budget := NewMemoryBudget(100, BudgetRefuseNew)
+	a, b := newBudgetTestClient(t, budget), newBudgetTestClient(t, budget)
+
+	a.sendN(6)
+	b.sendN(6)
+
+	require.EqualValues(t, 100, budget.Used())
+	require.EqualValues(t, 2, budget.Refused())
+	require.Zero(t, budget.Dropped())
+	require.Equal(t, map[string]int64{"backoff_send_queue": 100}, budget.Usage())
+
+	require.Len(t, a.delivered(t, 6), 6)
+	require.Equal(t, []string{"message-00", "message-01", "message-02", "message-03"}, b.delivered(t, 4))
+	require.Zero(t, budget.Used())
+}
+
+func TestMemoryBudget_DropOldest(t *testing.T) {
+	budget := NewMemoryBudget(100, BudgetDropOldest)
+	a, b := newBudgetTestClient(t, budget), newBudgetTestClient(t, budget)
+
+	a.sendN(6)
+	b.sendN(5)
+
+	require.EqualValues(t, 100, budget.Used())
+	require.EqualValues(t, 1, budget.Dropped())
+	require.Zero(t, budget.Refused())
+
+	// The client holding the most bytes lost its oldest message.
+	require.Equal(t, []string{"message-01", "message-02", "message-03", "message-04", "message-05"}, a.delivered(t, 5))
+	require.Len(t, b.delivered(t, 5), 5)
+	require.Zero(t, budget.Used())
+}
@@ -1,23 +1,49 @@
 package libws
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
+// MessageType is the type of a Message. The values of the wire types are the websocket opcodes and are stable, as is
+// the synthetic range starting at SyntheticMessageTypeMin.
 type MessageType byte
 
 const (
 	PingMessage   MessageType = 9
 	PongMessage   MessageType = 10
 	BinaryMessage MessageType = 2
-	DataMessage   MessageType = 1
+	TextMessage   MessageType = 1
 	CloseError    MessageType = 8
 )
 
+// DataMessage is the former name of TextMessage.
+//
+// Deprecated: use TextMessage, or IsData to tell text and binary messages apart from the others.
+const DataMessage = TextMessage
+
+// SyntheticMessageTypeMin is the first of the message types reserved for applications, up to 255. This package never
+// defines types in that range. Synthetic messages, e.g. a detected gap, flow through the inbound path and reach the
+// MessageHandler as data messages do, but are never written: sending one is rejected with ErrSyntheticMessage.
+const SyntheticMessageTypeMin MessageType = 128
+
 func (t MessageType) Is(other MessageType) bool {
 	return t == other
 }
 
+// IsData reports whether t carries application data, either text or binary. Such messages reach the MessageHandler.
 func (t MessageType) IsData() bool {
-	return t.Is(DataMessage)
+	return t.IsText() || t.IsBinary()
+}
+
+// IsText reports whether t is a text frame.
+func (t MessageType) IsText() bool {
+	return t.Is(TextMessage)
+}
+
+// IsBinary reports whether t is a binary frame.
+func (t MessageType) IsBinary() bool {
+	return t.Is(BinaryMessage)
 }
 
 func (t MessageType) IsPing() bool {
@@ -32,6 +58,16 @@ func (t MessageType) IsClose() bool {
 	return t.Is(CloseError)
 }
 
+// IsControl reports whether t is a websocket control frame: ping, pong or close.
+func (t MessageType) IsControl() bool {
+	return t.IsPing() || t.IsPong() || t.IsClose()
+}
+
+// IsSynthetic reports whether t is in the range reserved for applications, see SyntheticMessageTypeMin.
+func (t MessageType) IsSynthetic() bool {
+	return t >= SyntheticMessageTypeMin
+}
+
 type Message interface {
 	Type() MessageType
 	Data() []byte
@@ -43,9 +79,45 @@ type ErrorMessage interface {
 	Error() string
 }
 
+// MetaMessage is a Message carrying metadata about how it was received. Messages built by this package implement it.
+type MetaMessage interface {
+	Message
+	// Generation is the generation of the connection the message was read on, starting at 1 for the first connection
+	// of the client and increasing with every new one. It is zero for messages which were not read from a connection.
+	Generation() uint64
+	// ReceivedAt is when the message was read from the socket. It is the zero time for messages which were not read
+	// from a connection.
+	ReceivedAt() time.Time
+}
+
+// SequencedMessage is a Message numbered in the order it was read on its connection. Data messages read by
+// WsConnection implement it.
+type SequencedMessage interface {
+	Message
+	// ReadSeq is the position of the message among the data messages read on its connection, starting at 1 for the
+	// first one, so that a gap tells messages were dropped on the way, e.g. by SlowConsumerDropOldest. It is zero for
+	// messages which were not read from a connection.
+	ReadSeq() uint64
+}
+
 type message struct {
 	MessageType MessageType
 	MessageData []byte
+	generation  uint64
+	receivedAt  time.Time
+	readSeq     uint64
+}
+
+func (m message) Generation() uint64 {
+	return m.generation
+}
+
+func (m message) ReceivedAt() time.Time {
+	return m.receivedAt
+}
+
+func (m message) ReadSeq() uint64 {
+	return m.readSeq
 }
 
 func (m message) Type() MessageType {
@@ -79,10 +151,17 @@ func NewMessage(mt MessageType, data []byte) Message {
 	return message{MessageType: mt, MessageData: data}
 }
 
+// NewTextMessage returns a message written as a text frame.
+func NewTextMessage(data []byte) Message {
+	return NewMessage(TextMessage, data)
+}
+
+// NewDataMessage returns a message written as a text frame, as NewTextMessage does.
 func NewDataMessage(data []byte) Message {
-	return NewMessage(DataMessage, data)
+	return NewTextMessage(data)
 }
 
+// NewBinaryMessage returns a message written as a binary frame.
 func NewBinaryMessage(data []byte) Message {
 	return NewMessage(BinaryMessage, data)
 }
@@ -101,3 +180,94 @@ func NewCloseMessage(code int, data []byte) ErrorMessage {
 		Code:    code,
 	}
 }
+
+// CloseCodeOf returns the status code of m, when it is a close message, see NewCloseMessage.
+func CloseCodeOf(m Message) (int, bool) {
+	if cm, ok := m.(closeMessage); ok {
+		return cm.Code, true
+	}
+
+	return 0, false
+}
+
+// GenerationOf returns the generation of the connection m was read on, or zero when unknown. See MetaMessage.
+func GenerationOf(m Message) uint64 {
+	if mm, ok := m.(MetaMessage); ok {
+		return mm.Generation()
+	}
+
+	return 0
+}
+
+// ReceivedAtOf returns when m was read from the socket, or the zero time when unknown. See MetaMessage.
+func ReceivedAtOf(m Message) time.Time {
+	if mm, ok := m.(MetaMessage); ok {
+		return mm.ReceivedAt()
+	}
+
+	return time.Time{}
+}
+
+// ReadSeqOf returns the position of m among the data messages read on its connection, or zero when unknown. See
+// SequencedMessage.
+func ReadSeqOf(m Message) uint64 {
+	if sm, ok := m.(SequencedMessage); ok {
+		return sm.ReadSeq()
+	}
+
+	return 0
+}
+
+// withReadSeq numbers m, the seq-th data message read on its connection. Messages built outside this package are
+// returned as is.
+func withReadSeq(m Message, seq uint64) Message {
+	switch mm := m.(type) {
+	case message:
+		mm.readSeq = seq
+		return mm
+	case closeMessage:
+		mm.readSeq = seq
+		return mm
+	case *StreamMessage:
+		mm.readSeq = seq
+		return mm
+	default:
+		return m
+	}
+}
+
+// withReceivedAt stamps m with the time it was read from the socket. Messages built outside this package are returned
+// as is.
+func withReceivedAt(m Message, at time.Time) Message {
+	switch mm := m.(type) {
+	case message:
+		mm.receivedAt = at
+		return mm
+	case closeMessage:
+		mm.receivedAt = at
+		return mm
+	case *StreamMessage:
+		mm.receivedAt = at
+		return mm
+	default:
+		return m
+	}
+}
+
+// withGeneration tags m with the generation of the connection it was read on. Messages built outside this package
+// are returned as is.
+func withGeneration(m Message, generation uint64) Message {
+	switch mm := m.(type) {
+	case message:
+		mm.generation = generation
+		return mm
+	case closeMessage:
+		mm.generation = generation
+		return mm
+	case *StreamMessage:
+		mm.generation = generation
+		return mm
+	default:
+		return m
+	}
+}
@@ -0,0 +1,104 @@
+package libws
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// generationTracker is implemented by clients which number their connections, see MetaMessage.
+type generationTracker interface {
+	// nextGeneration allocates the generation of a new connection.
+	nextGeneration() uint64
+	// Generation returns the current generation, that is, the newest one messages were delivered from.
+	Generation() uint64
+	// countStale accounts for a message dropped by DropStale.
+	countStale()
+}
+
+// nextGeneration allocates the generation of a new connection of c, or returns zero when c does not number them.
+func nextGeneration(c Client) uint64 {
+	if t, ok := c.(generationTracker); ok {
+		return t.nextGeneration()
+	}
+
+	return 0
+}
+
+// DropStale returns a MessageHandler discarding messages read on a connection older than the current one of the
+// client, which may still be delivered after a reconnection when messages are buffered, and passing the others to
+// inner. Dropped messages are counted, see the StaleDropped method of the client. Messages without a generation
+// always pass.
+func DropStale(inner MessageHandler) MessageHandler {
+	return func(cli Client, m Message) {
+		t, ok := cli.(generationTracker)
+		if !ok {
+			inner(cli, m)
+			return
+		}
+
+		if generation := GenerationOf(m); generation != 0 && generation < t.Generation() {
+			t.countStale()
+			discard(m)
+			return
+		}
+
+		inner(cli, m)
+	}
+}
+
+// connGenerationKey is the key of the generation of a connection in its connection-scoped context, see ConnContext.
+type connGenerationKey struct{}
+
+// withConnGeneration returns ctx carrying generation, the generation of the connection ctx is scoped to.
+func withConnGeneration(ctx context.Context, generation uint64) context.Context {
+	if generation == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, connGenerationKey{}, generation)
+}
+
+// connGenerationOf returns the generation of the connection ctx is scoped to, or zero when unknown.
+func connGenerationOf(ctx context.Context) uint64 {
+	if ctx == nil {
+		return 0
+	}
+
+	generation, _ := ctx.Value(connGenerationKey{}).(uint64)
+	return generation
+}
+
+// tagPing prefixes payload with the generation tag of the connection the ping is written to, see
+// WithGenerationTaggedPings.
+func tagPing(generation uint64, payload []byte) []byte {
+	tagged := strconv.AppendUint([]byte{'g'}, generation, 10)
+	tagged = append(tagged, ':')
+	return append(tagged, payload...)
+}
+
+// pingGeneration returns the generation tag payload, a ping or pong payload, carries, if any, see tagPing.
+func pingGeneration(payload string) (uint64, bool) {
+	tag, _, ok := strings.Cut(payload, ":")
+	if !ok || !strings.HasPrefix(tag, "g") {
+		return 0, false
+	}
+
+	generation, err := strconv.ParseUint(tag[1:], 10, 64)
+	if err != nil || generation == 0 {
+		return 0, false
+	}
+	return generation, true
+}
+
+// stalePong tells whether m, a pong read on a connection of a known generation, answers a ping tagged with another
+// one, that is, a ping written to another connection.
+func stalePong(m Message) bool {
+	if !m.Type().IsPong() {
+		return false
+	}
+
+	read := GenerationOf(m)
+	tagged, ok := pingGeneration(string(m.Data()))
+	return ok && read != 0 && tagged != read
+}
@@ -0,0 +1,78 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseConnectionHandler_TagsGeneration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cli := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	factory := NewBaseConnectionHandlerFactory(newTestLogger(io.Discard), func(ctx context.Context, recv chan<- Message) Connection {
+		return NewSimulatedConnectionFactory(NewSliceMessageSource(testRecording(3, 0)), nil)(ctx, recv)
+	})
+
+	var (
+		mu          sync.Mutex
+		generations []uint64
+	)
+	record := func(_ Client, m Message) {
+		mu.Lock()
+		generations = append(generations, GenerationOf(m))
+		mu.Unlock()
+	}
+
+	for i := 0; i < 2; i++ {
+		h := factory(cli, record, nil)
+		require.NoError(t, h.Connect(ctx))
+		defer h.Close()
+
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(generations) == 3*(i+1)
+		}, time.Second, time.Millisecond)
+	}
+
+	require.Equal(t, []uint64{1, 1, 1, 2, 2, 2}, generations)
+}
+
+func TestDropStale(t *testing.T) {
+	var (
+		factory  stubConnectionHandlerFactory
+		received []string
+	)
+	cli := newBasicClient(factory.Factory, DropStale(func(_ Client, m Message) {
+		received = append(received, string(m.Data()))
+	}), func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	deliver := factory.Last().handler
+	deliver(cli, withGeneration(NewDataMessage([]byte("a")), 1))
+	// The client reconnected while messages read on the first connection were still buffered.
+	deliver(cli, withGeneration(NewDataMessage([]byte("b")), 2))
+	deliver(cli, withGeneration(NewDataMessage([]byte("stale")), 1))
+	deliver(cli, withGeneration(NewDataMessage([]byte("c")), 2))
+	deliver(cli, NewDataMessage([]byte("untagged")))
+
+	require.Equal(t, []string{"a", "b", "c", "untagged"}, received)
+	require.EqualValues(t, 2, cli.Generation())
+	require.EqualValues(t, 1, cli.StaleDropped())
+}
+
+func TestWithGeneration_KeepsMessageKind(t *testing.T) {
+	m := withGeneration(NewCloseMessage(4001, []byte("bye")), 3)
+
+	_, isError := m.(ErrorMessage)
+	require.True(t, isError)
+	require.EqualValues(t, 3, GenerationOf(m))
+	require.Zero(t, GenerationOf(NewDataMessage(nil)))
+}
@@ -0,0 +1,144 @@
+package libws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultDrainTimeout is how long Close waits for the MessageHandlerCtx invocations in flight, see WithDrainTimeout.
+const defaultDrainTimeout = 5 * time.Second
+
+// WithDrainTimeout sets how long Close waits for the MessageHandlerCtx invocations in flight to return once their
+// context is cancelled, 5 seconds by default. It has no effect on other message handlers.
+func WithDrainTimeout(d time.Duration) ClientOption {
+	return func(b *basicClient) {
+		b.drainTimeout = d
+	}
+}
+
+// inFlightHandlers counts the message handler invocations in flight, so that Close can wait for them.
+type inFlightHandlers struct {
+	mu     sync.Mutex
+	n      int
+	closed bool
+	idle   chan struct{} // idle is closed once closed and no invocation is in flight
+}
+
+func newInFlightHandlers() *inFlightHandlers {
+	return &inFlightHandlers{idle: make(chan struct{})}
+}
+
+// enter accounts for an invocation starting, telling false once draining started, when it must not run.
+func (f *inFlightHandlers) enter() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+	f.n++
+	return true
+}
+
+func (f *inFlightHandlers) exit() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.n--
+	if f.closed && f.n == 0 {
+		close(f.idle)
+	}
+}
+
+// drain refuses further invocations and waits for those in flight to return, for timeout at most. It tells whether
+// they all did.
+func (f *inFlightHandlers) drain(timeout time.Duration) bool {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		if f.n == 0 {
+			close(f.idle)
+		}
+	}
+	f.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// adaptMessageHandlerCtx turns h into a MessageHandler passing it a context cancelled once the connection the message
+// came from or the client closes.
+func (b *basicClient) adaptMessageHandlerCtx(h MessageHandlerCtx) MessageHandler {
+	b.handlersCtx, b.cancelHandlers = context.WithCancel(context.Background())
+	b.inFlight = newInFlightHandlers()
+
+	return func(cli Client, m Message) {
+		if !b.inFlight.enter() {
+			b.logger.Debugf("client closing, message not handled: %s", m)
+			discard(m)
+			return
+		}
+		defer b.inFlight.exit()
+
+		ctx, cancel := b.handlerContext()
+		defer cancel()
+
+		h(ctx, cli, m)
+	}
+}
+
+// handlerContext returns the context passed to a MessageHandlerCtx: derived from the connection-scoped context, when
+// the layers expose one, and cancelled on Close.
+func (b *basicClient) handlerContext() (context.Context, context.CancelFunc) {
+	connCtx := b.ConnContext()
+	if connCtx == nil {
+		return context.WithCancel(b.handlersCtx)
+	}
+
+	ctx, cancel := context.WithCancel(connCtx)
+	stop := context.AfterFunc(b.handlersCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// drainHandlers cancels the context of the MessageHandlerCtx invocations in flight and waits for them to return, for
+// the drain timeout at most.
+func (b *basicClient) drainHandlers() {
+	if b.inFlight == nil {
+		return
+	}
+
+	b.cancelHandlers()
+	if !b.inFlight.drain(b.drainTimeout) {
+		b.logger.Warnf("message handlers still running after the drain timeout of %s, closing anyway", b.drainTimeout)
+	}
+}
+
+// NewBasicClientFactoryCtx is like NewBasicClientFactory but takes a MessageHandlerCtx. Its context is cancelled once
+// the connection the message came from closes, or the client does: Close cancels it, then waits for the invocations
+// in flight to return, up to the timeout set with WithDrainTimeout.
+func NewBasicClientFactoryCtx(
+	connHandlerFactory ConnectionHandlerFactory,
+	messageHandler MessageHandlerCtx,
+	eventHandler EventHandler,
+	opts ...ClientOption,
+) ClientFactory {
+	adapt := func(b *basicClient) {
+		if messageHandler != nil {
+			b.messageHandler = b.adaptMessageHandlerCtx(messageHandler)
+		}
+	}
+	opts = append(opts[:len(opts):len(opts)], adapt)
+
+	return NewBasicClientFactory(connHandlerFactory, nil, eventHandler, opts...)
+}
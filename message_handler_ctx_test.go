@@ -0,0 +1,97 @@
+package libws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageHandlerCtx_CancelledOnClose(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+
+	cli := NewBasicClientFactoryCtx(
+		stubs.Factory,
+		func(ctx context.Context, _ Client, _ Message) {
+			close(started)
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+		},
+		func(Client, EventType) {},
+		WithDrainTimeout(time.Second),
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+
+	go stubs.Last().Deliver(NewDataMessage([]byte("slow")))
+	<-started
+
+	start := time.Now()
+	cli.Close()
+	require.Less(t, time.Since(start), time.Second)
+	require.ErrorIs(t, <-cancelled, context.Canceled, "the handler returned before Close did")
+}
+
+func TestMessageHandlerCtx_DrainTimeout(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	cli := NewBasicClientFactoryCtx(
+		stubs.Factory,
+		func(context.Context, Client, Message) {
+			close(started)
+			<-release
+		},
+		func(Client, EventType) {},
+		WithDrainTimeout(50*time.Millisecond),
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+
+	go stubs.Last().Deliver(NewDataMessage([]byte("stuck")))
+	<-started
+
+	start := time.Now()
+	cli.Close()
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, 50*time.Millisecond)
+	require.Less(t, elapsed, time.Second)
+}
+
+func TestMessageHandlerCtx_CancelledWithConnection(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	var handled []error
+
+	cli := NewBasicClientFactoryCtx(
+		stubs.Factory,
+		func(ctx context.Context, _ Client, _ Message) { handled = append(handled, ctx.Err()) },
+		func(Client, EventType) {},
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	stubs.Last().Deliver(NewDataMessage([]byte("live")))
+	stubs.Last().Kill(ErrConnectionClosed)
+	stubs.Last().Deliver(NewDataMessage([]byte("late")))
+
+	require.Equal(t, []error{nil, context.Canceled}, handled)
+}
+
+func TestMessageHandlerCtx_NotCalledOnceClosed(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	var calls int
+
+	cli := NewBasicClientFactoryCtx(
+		stubs.Factory,
+		func(context.Context, Client, Message) { calls++ },
+		func(Client, EventType) {},
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+	cli.Close()
+
+	stubs.Last().Deliver(NewDataMessage([]byte("after close")))
+	require.Zero(t, calls)
+}
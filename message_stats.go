@@ -0,0 +1,86 @@
+package libws
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxMessageClasses bounds the number of classes tracked unless WithMaxMessageClasses says otherwise.
+	defaultMaxMessageClasses = 64
+	// MessageClassOverflow is the class accounting for messages of classes beyond the tracked maximum.
+	MessageClassOverflow = "_overflow"
+)
+
+type (
+	// MessageClassifier names the class of an inbound message, e.g. "trade", "heartbeat" or "order_update". It runs
+	// on the read path for every message, so it must be cheap: avoid decoding payloads and allocating. Reading the
+	// payload of a StreamMessage reads it into memory, defeating streaming.
+	MessageClassifier func(Message) string
+
+	// MessageClassStats holds the statistics of a class of inbound messages.
+	MessageClassStats struct {
+		Count uint64
+		// Bytes is the size of the payloads of the messages counted, StreamMessages excluded: theirs is not known
+		// until consumed, see WithStreaming.
+		Bytes    uint64
+		LastSeen time.Time
+	}
+
+	// messageStats accumulates MessageClassStats per class, bounded to max classes.
+	messageStats struct {
+		mu       sync.Mutex
+		classify MessageClassifier
+		max      int
+		clock    clock
+		classes  map[string]*MessageClassStats
+	}
+)
+
+func newMessageStats(classify MessageClassifier, maxClasses int, clk clock) *messageStats {
+	return &messageStats{
+		classify: classify,
+		max:      maxClasses,
+		clock:    clk,
+		classes:  make(map[string]*MessageClassStats),
+	}
+}
+
+// observe accounts for m under its class.
+func (s *messageStats) observe(m Message) {
+	class := s.classify(m)
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.classes[class]
+	if !ok {
+		if len(s.classes) >= s.max {
+			class = MessageClassOverflow
+			stats, ok = s.classes[class]
+		}
+		if !ok {
+			stats = &MessageClassStats{}
+			s.classes[class] = stats
+		}
+	}
+
+	stats.Count++
+	if _, streamed := m.(*StreamMessage); !streamed {
+		stats.Bytes += uint64(len(m.Data()))
+	}
+	stats.LastSeen = now
+}
+
+// snapshot returns a copy of the statistics of every class.
+func (s *messageStats) snapshot() map[string]MessageClassStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make(map[string]MessageClassStats, len(s.classes))
+	for class, stats := range s.classes {
+		res[class] = *stats
+	}
+	return res
+}
@@ -0,0 +1,88 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var tradePrefix = []byte(`{"e":"trade"`)
+
+func classifyTestMessage(m Message) string {
+	switch {
+	case m.Type().IsPong():
+		return "heartbeat"
+	case bytes.HasPrefix(m.Data(), tradePrefix):
+		return "trade"
+	default:
+		return "order_update"
+	}
+}
+
+func TestClient_MessageStats(t *testing.T) {
+	clk := newFakeClock()
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {},
+		WithMessageClassifier(classifyTestMessage), withClock(clk))
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	conn := stubs.Last()
+	start := clk.Now()
+
+	conn.Deliver(NewDataMessage([]byte(`{"e":"trade","p":"1"}`)))
+	conn.Deliver(NewPongMessage(nil))
+	clk.Advance(time.Second)
+	conn.Deliver(NewDataMessage([]byte(`{"e":"trade","p":"2"}`)))
+	clk.Advance(time.Second)
+	conn.Deliver(NewDataMessage([]byte(`{"e":"executionReport"}`)))
+
+	require.Equal(t, map[string]MessageClassStats{
+		"trade":        {Count: 2, Bytes: 42, LastSeen: start.Add(time.Second)},
+		"heartbeat":    {Count: 1, Bytes: 0, LastSeen: start},
+		"order_update": {Count: 1, Bytes: 23, LastSeen: start.Add(2 * time.Second)},
+	}, cli.MessageStats())
+
+	clk.Advance(time.Second)
+	conn.Deliver(NewPongMessage(nil))
+	require.Equal(t, start.Add(3*time.Second), cli.MessageStats()["heartbeat"].LastSeen)
+}
+
+func TestClient_MessageStatsOverflow(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {},
+		WithMessageClassifier(func(m Message) string { return string(m.Data()) }), WithMaxMessageClasses(2))
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	for _, class := range []string{"a", "b", "c", "d", "a"} {
+		stubs.Last().Deliver(NewDataMessage([]byte(class)))
+	}
+
+	stats := cli.MessageStats()
+	require.Len(t, stats, 3)
+	require.EqualValues(t, 2, stats["a"].Count)
+	require.EqualValues(t, 1, stats["b"].Count)
+	require.EqualValues(t, 2, stats[MessageClassOverflow].Count)
+}
+
+func TestMessageStats_ObserveDoesNotAllocate(t *testing.T) {
+	stats := newMessageStats(classifyTestMessage, defaultMaxMessageClasses, realClock{})
+	m := NewDataMessage([]byte(`{"e":"trade","p":"1"}`))
+	stats.observe(m)
+
+	require.Zero(t, testing.AllocsPerRun(100, func() { stats.observe(m) }))
+}
+
+func BenchmarkMessageStats_Observe(b *testing.B) {
+	stats := newMessageStats(classifyTestMessage, defaultMaxMessageClasses, realClock{})
+	m := NewDataMessage([]byte(`{"e":"trade","p":"1"}`))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stats.observe(m)
+	}
+}
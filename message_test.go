@@ -0,0 +1,120 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGapDetected      = SyntheticMessageTypeMin
+	testSnapshotComplete = SyntheticMessageTypeMin + 1
+)
+
+func TestMessageType_Predicates(t *testing.T) {
+	for _, mt := range []MessageType{TextMessage, BinaryMessage, PingMessage, PongMessage, CloseError} {
+		require.False(t, mt.IsSynthetic(), "wire type %d", mt)
+	}
+	require.True(t, PingMessage.IsControl())
+	require.False(t, TextMessage.IsControl())
+
+	require.True(t, TextMessage.IsData() && TextMessage.IsText())
+	require.True(t, BinaryMessage.IsData() && BinaryMessage.IsBinary())
+	require.False(t, BinaryMessage.IsText())
+	require.False(t, PingMessage.IsData())
+	require.Equal(t, TextMessage, NewDataMessage(nil).Type(), "data messages are text")
+
+	for _, mt := range []MessageType{testGapDetected, testSnapshotComplete, 255} {
+		require.True(t, mt.IsSynthetic(), "type %d", mt)
+		require.False(t, mt.IsControl(), "type %d", mt)
+		require.False(t, mt.IsData(), "type %d", mt)
+	}
+}
+
+func TestClient_SyntheticMessages(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+
+	var (
+		handled  []Message
+		rejected []error
+	)
+	cli := newBasicClient(
+		stubs.Factory,
+		func(_ Client, m Message) { handled = append(handled, m) },
+		func(Client, EventType) {},
+		WithOnSendError(func(_ Client, _ Message, err error) { rejected = append(rejected, err) }),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+
+	gap := NewMessage(testGapDetected, []byte("seq 41..43"))
+	snapshot := NewMessage(testSnapshotComplete, nil)
+	ping := NewPingMessage(nil)
+	for _, m := range []Message{gap, ping, snapshot} {
+		cli.InjectRecv(m)
+	}
+
+	require.Equal(t, []Message{gap, snapshot}, handled, "synthetic messages are routed as data")
+	require.Equal(t, []Message{ping}, stubs.Last().Received(), "control frames still reach the connection layers")
+
+	cli.Send(gap)
+	require.Empty(t, stubs.Last().Sent())
+	require.Len(t, rejected, 1)
+	require.ErrorIs(t, rejected[0], ErrSyntheticMessage)
+}
+
+func TestWsConnection_WriteRejectsSynthetic(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+	conn, _ := newTestWsConnection(t, srv)
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	require.ErrorIs(t, conn.Write(NewMessage(testGapDetected, nil)), ErrSyntheticMessage)
+	require.NoError(t, conn.Write(NewDataMessage([]byte("still open"))))
+}
+
+func TestClient_ReadSeqAndReceivedAt(t *testing.T) {
+	const burst = 200
+
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		for i := 0; i < burst; i++ {
+			if i == burst/2 {
+				// Control frames are not numbered.
+				_ = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+			}
+			_ = conn.WriteMessage(websocket.BinaryMessage, []byte{byte(i)})
+		}
+		serveUntilClosed(conn)
+	})
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	factory := NewBaseConnectionHandlerFactory(
+		logger,
+		NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}),
+	)
+
+	handled := make(chan Message, burst)
+	cli := newBasicClient(factory, func(_ Client, m Message) { handled <- m }, func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	var last time.Time
+	for i := 1; i <= burst; i++ {
+		select {
+		case m := <-handled:
+			require.EqualValues(t, i, ReadSeqOf(m), "no gap")
+			require.False(t, ReceivedAtOf(m).Before(last), "monotonic timestamps")
+			last = ReceivedAtOf(m)
+		case <-time.After(time.Second):
+			t.Fatalf("message #%d not handled", i)
+		}
+	}
+
+	require.Zero(t, ReadSeqOf(NewTextMessage(nil)))
+}
@@ -0,0 +1,55 @@
+package libws
+
+import "github.com/fasthttp/websocket"
+
+// defaultIOBufferSize is the size of the read and write buffers of the websocket library when the dialer leaves them
+// unset.
+const defaultIOBufferSize = 4096
+
+// WithReadBufferSize sizes the buffer reading from the socket to n bytes, overriding the one of the dialer, 4 KB by
+// default. Feeds pushing large or many messages are read with fewer syscalls from a larger buffer, while hundreds of
+// connections to venues sending small messages are cheaper with a smaller one. See ConnectionInfo.ReadBufferSize.
+func WithReadBufferSize(n int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.readBufferSize = max(n, 0)
+	}
+}
+
+// WithWriteBufferSize sizes the buffer writing to the socket to n bytes, overriding the one of the dialer, 4 KB by
+// default. A message larger than the buffer is written in as many frames. See ConnectionInfo.WriteBufferSize.
+func WithWriteBufferSize(n int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.writeBufferSize = max(n, 0)
+	}
+}
+
+// applyBufferSizes sets the buffer sizes configured with WithReadBufferSize and WithWriteBufferSize on dialer, a copy
+// of the connection dialer.
+func (w *WsConnection) applyBufferSizes(dialer *websocket.Dialer) {
+	if w.readBufferSize > 0 {
+		dialer.ReadBufferSize = w.readBufferSize
+	}
+	if w.writeBufferSize > 0 {
+		dialer.WriteBufferSize = w.writeBufferSize
+	}
+}
+
+// bufferSizes returns the sizes of the read and write buffers of the connections dialed.
+func (w *WsConnection) bufferSizes() (read, write int) {
+	read, write = w.dialer.ReadBufferSize, w.dialer.WriteBufferSize
+	if w.readBufferSize > 0 {
+		read = w.readBufferSize
+	}
+	if w.writeBufferSize > 0 {
+		write = w.writeBufferSize
+	}
+	return orDefaultBufferSize(read), orDefaultBufferSize(write)
+}
+
+// orDefaultBufferSize returns the size of a buffer the websocket library sizes to n, when set.
+func orDefaultBufferSize(n int) int {
+	if n > 0 {
+		return n
+	}
+	return defaultIOBufferSize
+}
@@ -0,0 +1,72 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWsConnection_BufferSizes(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+	p := OpenConnectionParams{URL: testWsURL(t, srv)}
+
+	t.Run("configured", func(t *testing.T) {
+		conn, _ := newTestWsConnection(t, srv, WithReadBufferSize(64<<10), WithWriteBufferSize(512))
+
+		dialer := conn.dialerFor(p)
+		require.NotSame(t, websocket.DefaultDialer, dialer, "the connection dialer is left untouched")
+		require.Equal(t, 64<<10, dialer.ReadBufferSize)
+		require.Equal(t, 512, dialer.WriteBufferSize)
+		require.Zero(t, websocket.DefaultDialer.ReadBufferSize)
+
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+		require.Equal(t, 64<<10, conn.Info().ReadBufferSize)
+		require.Equal(t, 512, conn.Info().WriteBufferSize)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		conn, _ := newTestWsConnection(t, srv)
+		require.Same(t, websocket.DefaultDialer, conn.dialerFor(p))
+
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+		require.Equal(t, defaultIOBufferSize, conn.Info().ReadBufferSize)
+		require.Equal(t, defaultIOBufferSize, conn.Info().WriteBufferSize)
+	})
+}
+
+// BenchmarkWsConnection_ReadBufferSize reads messages of a few sizes streamed by a loopback server through read
+// buffers of a few sizes, telling which buffer suits a feed.
+func BenchmarkWsConnection_ReadBufferSize(b *testing.B) {
+	for _, msgSize := range []int{64, 16 << 10} {
+		payload := make([]byte, msgSize)
+		srv := newTestWsServer(b, func(conn *websocket.Conn) {
+			pm, err := websocket.NewPreparedMessage(websocket.BinaryMessage, payload)
+			if err != nil {
+				return
+			}
+			for conn.WritePreparedMessage(pm) == nil {
+			}
+		})
+
+		for _, bufSize := range []int{1 << 10, 4 << 10, 64 << 10} {
+			b.Run(fmt.Sprintf("msg=%d/buf=%d", msgSize, bufSize), func(b *testing.B) {
+				conn, recv := newTestWsConnection(b, srv, WithReadBufferSize(bufSize))
+				if err := conn.Open(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+				defer conn.Close()
+
+				b.SetBytes(int64(msgSize))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					<-recv
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package libws
+
+import (
+	"net/http"
+	"strings"
+)
+
+// defaultCompressionThreshold is the payload size over which outbound messages are compressed, unless decided
+// otherwise with WithCompressionDecider.
+const defaultCompressionThreshold = 512
+
+// WithCompression makes the connection negotiate per-message compression (permessage-deflate) with the peer, writing
+// compressed frames at the given flate level, from -2 (huffman only) to 9 (best compression), -1 being the default
+// level. Peers not supporting it are connected to without compression, see ConnectionInfo.Compressed and
+// ConnectionControl.LatestInfo. Write compression can be turned off and on again at any time with
+// ConnectionControl.SetWriteCompression, inbound frames being decompressed regardless.
+func WithCompression(level int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.compression = true
+		w.compressionLevel = level
+	}
+}
+
+// SetWriteCompression enables or disables the compression of outbound messages on connections which negotiated it,
+// e.g. to spare CPU on small messages. It is enabled by default and takes effect from the next message written.
+func (c *ConnectionControl) SetWriteCompression(enabled bool) {
+	c.writeUncompressed.Store(!enabled)
+}
+
+// WriteCompression tells whether outbound messages are compressed on connections which negotiated it.
+func (c *ConnectionControl) WriteCompression() bool {
+	return !c.writeUncompressed.Load()
+}
+
+// WithCompressionDecider makes the connection compress only the data messages decide accepts, e.g. to write already
+// compressed payloads as they are, on connections which negotiated compression. By default, messages with a payload
+// over 512 bytes are compressed, tiny ones costing more CPU than they save bytes. It has no effect while write
+// compression is disabled, see ConnectionControl.SetWriteCompression. See ConnectionStats.CompressedFrames.
+func WithCompressionDecider(decide func(m Message) bool) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.compressionDecider = decide
+	}
+}
+
+// compressLarger is the default compression decider, see WithCompressionDecider.
+func compressLarger(m Message) bool {
+	return len(m.Data()) > defaultCompressionThreshold
+}
+
+// negotiatedCompression tells whether the handshake response accepted per-message compression.
+func negotiatedCompression(resp *http.Response) bool {
+	if resp == nil {
+		return false
+	}
+
+	for _, ext := range resp.Header.Values("Sec-Websocket-Extensions") {
+		if strings.Contains(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCompressionLevel sets the compression level of a connection which negotiated compression.
+func (w *WsConnection) applyCompressionLevel() {
+	if !w.compression || !w.Info().Compressed {
+		return
+	}
+
+	if err := w.conn.SetCompressionLevel(w.compressionLevel); err != nil {
+		w.logger.Warnf("cannot set compression level %d, keeping the default one: %s", w.compressionLevel, err)
+	}
+}
+
+// syncWriteCompression applies the write compression setting of the control and the compression decider before m, a
+// data message, is written, telling whether it is compressed.
+func (w *WsConnection) syncWriteCompression(m Message) bool {
+	if info := w.info.Load(); info == nil || !info.Compressed {
+		return false
+	}
+
+	compress := w.control.WriteCompression() && w.compressionDecider(m)
+	w.conn.EnableWriteCompression(compress)
+	return compress
+}
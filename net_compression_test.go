@@ -0,0 +1,230 @@
+package libws
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+type countingListener struct {
+	net.Listener
+	read *atomic.Int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: c, read: l.read}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// newCompressingEchoServer returns a server echoing every message, negotiating compression if enabled, along with
+// the count of bytes it read off the wire.
+func newCompressingEchoServer(t testing.TB, enabled bool) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{EnableCompression: enabled}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			mt, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+
+	read := &atomic.Int64{}
+	srv.Listener = countingListener{Listener: srv.Listener, read: read}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return srv, read
+}
+
+// echoWireSize sends payload through conn, waits for its echo and returns the bytes the server read meanwhile.
+func echoWireSize(t *testing.T, conn *WsConnection, recv chan Message, read *atomic.Int64, payload string) int64 {
+	t.Helper()
+
+	before := read.Load()
+	require.NoError(t, conn.Write(NewDataMessage([]byte(payload))))
+	select {
+	case m := <-recv:
+		require.Equal(t, payload, string(m.Data()))
+	case <-time.After(time.Second):
+		t.Fatal("no echo received")
+	}
+	return read.Load() - before
+}
+
+func TestWsConnection_Compression(t *testing.T) {
+	payload := strings.Repeat("compressible order book update ", 2048)
+
+	t.Run("negotiated", func(t *testing.T) {
+		srv, read := newCompressingEchoServer(t, true)
+		conn, recv := newTestWsConnection(t, srv, WithCompression(1))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		require.True(t, conn.Info().Compressed)
+		info, ok := conn.control.LatestInfo()
+		require.True(t, ok)
+		require.True(t, info.Compressed)
+		require.Less(t, echoWireSize(t, conn, recv, read, payload), int64(len(payload)/10))
+
+		conn.control.SetWriteCompression(false)
+		require.Greater(t, echoWireSize(t, conn, recv, read, payload), int64(len(payload)))
+
+		conn.control.SetWriteCompression(true)
+		require.Less(t, echoWireSize(t, conn, recv, read, payload), int64(len(payload)/10))
+	})
+
+	t.Run("refused by the server", func(t *testing.T) {
+		srv, read := newCompressingEchoServer(t, false)
+		conn, recv := newTestWsConnection(t, srv, WithCompression(1))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		require.False(t, conn.Info().Compressed)
+		require.Greater(t, echoWireSize(t, conn, recv, read, payload), int64(len(payload)))
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		srv, read := newCompressingEchoServer(t, true)
+		conn, recv := newTestWsConnection(t, srv)
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		require.False(t, conn.Info().Compressed)
+		require.Greater(t, echoWireSize(t, conn, recv, read, payload), int64(len(payload)))
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		srv, read := newCompressingEchoServer(t, true)
+		conn, recv := newTestWsConnection(t, srv, WithCompression(42))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		require.True(t, conn.Info().Compressed)
+		require.Less(t, echoWireSize(t, conn, recv, read, payload), int64(len(payload)/10))
+	})
+}
+
+func TestWsConnection_CompressionDecider(t *testing.T) {
+	compressible := strings.Repeat("compressible order book update ", 2048)
+
+	t.Run("per message", func(t *testing.T) {
+		srv, read := newCompressingEchoServer(t, true)
+		conn, recv := newTestWsConnection(t, srv, WithCompression(1), WithCompressionDecider(func(m Message) bool {
+			return !strings.HasPrefix(string(m.Data()), "raw")
+		}))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		require.Less(t, echoWireSize(t, conn, recv, read, compressible), int64(len(compressible)/10))
+		require.Greater(t, echoWireSize(t, conn, recv, read, "raw"+compressible), int64(len(compressible)))
+		require.Less(t, echoWireSize(t, conn, recv, read, compressible), int64(len(compressible)/10))
+
+		// Write compression disabled takes precedence.
+		conn.control.SetWriteCompression(false)
+		require.Greater(t, echoWireSize(t, conn, recv, read, compressible), int64(len(compressible)))
+
+		stats := conn.Stats()
+		require.EqualValues(t, 2, stats.CompressedFrames)
+		require.EqualValues(t, 2, stats.UncompressedFrames)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		srv, read := newCompressingEchoServer(t, true)
+		conn, recv := newTestWsConnection(t, srv, WithCompression(1))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		tiny := compressible[:defaultCompressionThreshold]
+		require.Greater(t, echoWireSize(t, conn, recv, read, tiny), int64(len(tiny)))
+		require.Less(t, echoWireSize(t, conn, recv, read, tiny+"x"), int64(len(tiny)))
+
+		stats := conn.Stats()
+		require.EqualValues(t, 1, stats.CompressedFrames)
+		require.EqualValues(t, 1, stats.UncompressedFrames)
+	})
+
+	t.Run("not negotiated", func(t *testing.T) {
+		srv, read := newCompressingEchoServer(t, false)
+		conn, recv := newTestWsConnection(t, srv, WithCompression(1), WithCompressionDecider(func(Message) bool { return true }))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		require.Greater(t, echoWireSize(t, conn, recv, read, compressible), int64(len(compressible)))
+		require.Zero(t, conn.Stats().CompressedFrames)
+	})
+}
+
+// BenchmarkWsConnection_CompressionDecider writes a mix of tiny, compressible and already compressed payloads,
+// compressing all of them or as decided by default.
+func BenchmarkWsConnection_CompressionDecider(b *testing.B) {
+	random := make([]byte, 16<<10)
+	_, _ = rand.Read(random)
+	workload := []Message{
+		NewTextMessage([]byte(`{"op":"ping"}`)),
+		NewTextMessage([]byte(strings.Repeat("compressible order book update ", 512))),
+		NewBinaryMessage(random),
+		NewTextMessage([]byte(`{"op":"subscribe","channel":"trades"}`)),
+	}
+
+	deciders := []struct {
+		name   string
+		decide func(Message) bool
+	}{
+		{name: "all", decide: func(Message) bool { return true }},
+		{name: "default", decide: compressLarger},
+	}
+
+	for _, d := range deciders {
+		b.Run(d.name, func(b *testing.B) {
+			srv, _ := newCompressingEchoServer(b, true)
+			conn, recv := newTestWsConnection(b, srv, WithCompression(-1), WithCompressionDecider(d.decide))
+			if err := conn.Open(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := conn.Write(workload[i%len(workload)]); err != nil {
+					b.Fatal(err)
+				}
+				<-recv
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package libws
+
+import (
+	"encoding/binary"
+
+	"github.com/fasthttp/websocket"
+)
+
+// maxControlFramePayload is the largest payload of a control frame, as RFC 6455 tells.
+const maxControlFramePayload = 125
+
+// FromWireFrame returns the message of a frame of type frameType, a websocket opcode such as websocket.TextMessage,
+// carrying data. Close frames carry their status code, see CloseCodeOf, and their reason as data. It returns nil for
+// frames which cannot be: opcodes which are not frame types, continuation frames included, and control frames over
+// 125 bytes.
+func FromWireFrame(frameType int, data []byte) Message {
+	switch frameType {
+	case websocket.TextMessage:
+		return NewTextMessage(data)
+	case websocket.BinaryMessage:
+		return NewBinaryMessage(data)
+	}
+
+	if len(data) > maxControlFramePayload {
+		return nil
+	}
+
+	switch frameType {
+	case websocket.PingMessage:
+		return NewPingMessage(data)
+	case websocket.PongMessage:
+		return NewPongMessage(data)
+	case websocket.CloseMessage:
+		code, reason := parseCloseFramePayload(data)
+		return NewCloseMessage(code, []byte(reason))
+	default:
+		return nil
+	}
+}
+
+// ToWireFrame returns the opcode and payload of the frame m is written as, the payload of close messages starting
+// with their status code. It returns false for messages which cannot be written as a frame: synthetic ones, see
+// SyntheticMessageTypeMin, unknown types and control messages whose payload would be over 125 bytes.
+func ToWireFrame(m Message) (int, []byte, bool) {
+	var (
+		frameType int
+		payload   = m.Data()
+	)
+
+	switch m.Type() {
+	case TextMessage:
+		return websocket.TextMessage, payload, true
+	case BinaryMessage:
+		return websocket.BinaryMessage, payload, true
+	case PingMessage:
+		frameType = websocket.PingMessage
+	case PongMessage:
+		frameType = websocket.PongMessage
+	case CloseError:
+		frameType, payload = websocket.CloseMessage, closeFramePayload(m)
+	default:
+		return 0, nil, false
+	}
+
+	if len(payload) > maxControlFramePayload {
+		return 0, nil, false
+	}
+
+	return frameType, payload, true
+}
+
+// closeFramePayload returns the payload of the close frame m, built with NewCloseMessage. Messages without a status
+// code close normally.
+func closeFramePayload(m Message) []byte {
+	code := websocket.CloseNormalClosure
+	if cm, ok := m.(closeMessage); ok {
+		code = cm.Code
+	}
+
+	return websocket.FormatCloseMessage(code, string(m.Data()))
+}
+
+// parseCloseFramePayload returns the status code and reason of a close frame from its payload, the code being
+// CloseNoStatusReceived when there is none, as RFC 6455 tells.
+func parseCloseFramePayload(payload []byte) (int, string) {
+	switch len(payload) {
+	case 0:
+		return websocket.CloseNoStatusReceived, ""
+	case 1:
+		return websocket.CloseProtocolError, ""
+	default:
+		return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+	}
+}
@@ -0,0 +1,140 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromWireFrame(t *testing.T) {
+	control := bytes.Repeat([]byte("x"), maxControlFramePayload)
+
+	tests := []struct {
+		name      string
+		frameType int
+		data      []byte
+		want      Message
+		// lossy frames are not written back as read
+		lossy bool
+	}{
+		{name: "text", frameType: websocket.TextMessage, data: []byte("hi"), want: NewTextMessage([]byte("hi"))},
+		{name: "empty text", frameType: websocket.TextMessage, want: NewTextMessage(nil)},
+		{name: "binary", frameType: websocket.BinaryMessage, data: []byte{0, 1}, want: NewBinaryMessage([]byte{0, 1})},
+		{name: "large binary", frameType: websocket.BinaryMessage, data: append(control, 'x'), want: NewBinaryMessage(append(control, 'x'))},
+		{name: "ping", frameType: websocket.PingMessage, data: []byte("p"), want: NewPingMessage([]byte("p"))},
+		{name: "ping at the control limit", frameType: websocket.PingMessage, data: control, want: NewPingMessage(control)},
+		{name: "ping over the control limit", frameType: websocket.PingMessage, data: append(control, 'x')},
+		{name: "pong", frameType: websocket.PongMessage, data: []byte("p"), want: NewPongMessage([]byte("p"))},
+		{name: "empty pong", frameType: websocket.PongMessage, want: NewPongMessage(nil)},
+		{
+			name:      "close",
+			frameType: websocket.CloseMessage,
+			data:      websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "busy"),
+			want:      NewCloseMessage(websocket.CloseTryAgainLater, []byte("busy")),
+		},
+		{
+			name:      "close without status",
+			frameType: websocket.CloseMessage,
+			want:      NewCloseMessage(websocket.CloseNoStatusReceived, []byte("")),
+		},
+		{
+			name:      "truncated close",
+			frameType: websocket.CloseMessage,
+			data:      []byte{0x03},
+			want:      NewCloseMessage(websocket.CloseProtocolError, []byte("")),
+			lossy:     true,
+		},
+		{name: "close over the control limit", frameType: websocket.CloseMessage, data: append(control, 'x')},
+		{name: "continuation", frameType: 0, data: []byte("hi")},
+		{name: "reserved opcode", frameType: 3, data: []byte("hi")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := FromWireFrame(tt.frameType, tt.data)
+			require.Equal(t, tt.want, m)
+			if m == nil {
+				return
+			}
+
+			// What is read can be written back as is.
+			frameType, data, ok := ToWireFrame(m)
+			require.True(t, ok)
+			require.Equal(t, tt.frameType, frameType)
+			if !tt.lossy {
+				require.Equal(t, string(tt.data), string(data))
+			}
+		})
+	}
+}
+
+func TestToWireFrame(t *testing.T) {
+	control := bytes.Repeat([]byte("x"), maxControlFramePayload)
+	reason := control[:maxControlFramePayload-2]
+
+	tests := []struct {
+		name          string
+		m             Message
+		wantFrameType int
+		wantData      []byte
+		wantFail      bool
+	}{
+		{name: "text", m: NewTextMessage([]byte("hi")), wantFrameType: websocket.TextMessage, wantData: []byte("hi")},
+		{name: "binary", m: NewBinaryMessage([]byte{0}), wantFrameType: websocket.BinaryMessage, wantData: []byte{0}},
+		{name: "large text", m: NewTextMessage(append(control, 'x')), wantFrameType: websocket.TextMessage, wantData: append(control, 'x')},
+		{name: "ping", m: NewPingMessage(control), wantFrameType: websocket.PingMessage, wantData: control},
+		{name: "ping over the control limit", m: NewPingMessage(append(control, 'x')), wantFail: true},
+		{name: "pong", m: NewPongMessage(nil), wantFrameType: websocket.PongMessage},
+		{name: "pong over the control limit", m: NewPongMessage(append(control, 'x')), wantFail: true},
+		{
+			name:          "close",
+			m:             NewCloseMessage(4000, []byte("kicked")),
+			wantFrameType: websocket.CloseMessage,
+			wantData:      websocket.FormatCloseMessage(4000, "kicked"),
+		},
+		{
+			name:          "close at the control limit",
+			m:             NewCloseMessage(websocket.CloseGoingAway, reason),
+			wantFrameType: websocket.CloseMessage,
+			wantData:      websocket.FormatCloseMessage(websocket.CloseGoingAway, string(reason)),
+		},
+		{name: "close over the control limit", m: NewCloseMessage(websocket.CloseGoingAway, append(reason, 'x')), wantFail: true},
+		{
+			name:          "close without status",
+			m:             NewCloseMessage(websocket.CloseNoStatusReceived, nil),
+			wantFrameType: websocket.CloseMessage,
+			wantData:      []byte{},
+		},
+		{
+			name:          "close without code",
+			m:             NewMessage(CloseError, []byte("bye")),
+			wantFrameType: websocket.CloseMessage,
+			wantData:      websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"),
+		},
+		{name: "synthetic", m: NewMessage(SyntheticMessageTypeMin, []byte("gap")), wantFail: true},
+		{name: "unknown", m: NewMessage(3, []byte("hi")), wantFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frameType, data, ok := ToWireFrame(tt.m)
+			require.Equal(t, !tt.wantFail, ok)
+			require.Equal(t, tt.wantFrameType, frameType)
+			require.Equal(t, tt.wantData, data)
+		})
+	}
+}
+
+func TestWsConnection_WriteControlOverLimit(t *testing.T) {
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, serveUntilClosed))
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	over := bytes.Repeat([]byte("x"), maxControlFramePayload+1)
+	require.ErrorIs(t, conn.Write(NewPingMessage(over)), ErrInvalidOutbound)
+	require.ErrorIs(t, conn.CloseWithReason(websocket.CloseNormalClosure, string(over)), ErrInvalidOutbound)
+	require.False(t, isClosed(conn.CloseChan()))
+}
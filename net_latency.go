@@ -0,0 +1,228 @@
+package libws
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWindowSize is the number of round trips LatencyStats are computed over.
+	latencyWindowSize = 128
+	// maxPendingPings bounds the pings awaiting their pong, the oldest being forgotten first.
+	maxPendingPings = 32
+	// pendingPingTimeout is how long a ping awaits its pong before being forgotten as expired.
+	pendingPingTimeout = 30 * time.Second
+)
+
+const (
+	// PongByPayload matches a pong with the oldest pending ping carrying the same payload, for peers echoing ping
+	// payloads verbatim as RFC 6455 requires. Pings carrying unique payloads, see PingTokens, are timed right even
+	// when pongs come back out of order or get lost, which pings carrying the same payload are not.
+	PongByPayload PongCorrelation = iota
+	// PongByLastSent matches any pong with the latest ping written, forgetting the ones pending before it as expired,
+	// for peers not echoing ping payloads. Round trips are understated whenever the pong answers an earlier ping,
+	// e.g. when pings are written faster than pongs come back, and unsolicited pongs are taken as answers.
+	PongByLastSent
+)
+
+type (
+	// LatencyStats describes the round trips of the latest pings of a connection, timed from writing the ping to
+	// reading its pong, over a window of the latest 128. See WithPongCorrelation for how pongs are matched with pings.
+	LatencyStats struct {
+		// Samples is the number of round trips measured over the life of the connection.
+		Samples uint64
+		Last    time.Duration
+		// Min, Avg and P99 are computed over the window.
+		Min time.Duration
+		Avg time.Duration
+		P99 time.Duration
+		// Pending is the number of pings awaiting their pong, at most 32.
+		Pending int
+		// Expired counts the pings forgotten without a pong, pending for 30s or pushed out by newer ones.
+		Expired uint64
+		// Orphans counts the pongs matching no pending ping, e.g. unsolicited ones or late ones of expired pings.
+		Orphans uint64
+		// Stale counts the pongs answering pings written to another connection, told by their generation tag, see
+		// WithGenerationTaggedPings. They are neither timed nor counted as orphans.
+		Stale uint64
+	}
+
+	// PongCorrelation tells how a connection matches the pongs it reads with the pings it wrote, see
+	// WithPongCorrelation.
+	PongCorrelation int
+
+	// LatencyProvider is an optional interface implemented by connections, connection handlers and clients which
+	// measure the round trip of their current connection, see LatencyStats. Reconnecting layers report the one in
+	// use, starting over with every new connection.
+	LatencyProvider interface {
+		Latency() LatencyStats
+	}
+
+	// latencyTracker correlates pongs with the pings written, keeping a window of round trips.
+	latencyTracker struct {
+		correlation PongCorrelation
+		// generation is the generation of the connection, pongs tagged with another one are stale
+		generation uint64
+
+		mu sync.Mutex
+		// pending are the pings awaiting their pong, oldest first
+		pending []pendingPing
+		expired uint64
+		orphans uint64
+		stale   uint64
+		window  []time.Duration
+		// next is where the next sample goes once the window is full
+		next    int
+		samples uint64
+		last    time.Duration
+	}
+
+	pendingPing struct {
+		payload string
+		at      time.Time
+	}
+)
+
+// WithPongCorrelation overrides how pongs are matched with pings to time round trips, PongByPayload by default, see
+// LatencyStats.
+func WithPongCorrelation(c PongCorrelation) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.latency.correlation = c
+	}
+}
+
+// sent records a ping carrying payload written at.
+func (t *latencyTracker) sent(payload string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(at)
+	if len(t.pending) == maxPendingPings {
+		t.pending = slices.Delete(t.pending, 0, 1)
+		t.expired++
+	}
+	t.pending = append(t.pending, pendingPing{payload: payload, at: at})
+}
+
+// received matches a pong carrying payload read at with a pending ping as told by the correlation, returning the round
+// trip. Pongs matching no ping are counted as orphans.
+func (t *latencyTracker) received(payload string, at time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(at)
+
+	if tagged, ok := pingGeneration(payload); ok && t.generation != 0 && tagged != t.generation {
+		t.stale++
+		return 0, false
+	}
+
+	i := len(t.pending) - 1
+	if t.correlation == PongByPayload {
+		i = slices.IndexFunc(t.pending, func(p pendingPing) bool { return p.payload == payload })
+	}
+	if i < 0 {
+		t.orphans++
+		return 0, false
+	}
+
+	rtt := max(at.Sub(t.pending[i].at), 0)
+	if t.correlation == PongByLastSent {
+		t.expired += uint64(i)
+		t.pending = t.pending[:0]
+	} else {
+		t.pending = slices.Delete(t.pending, i, i+1)
+	}
+
+	if len(t.window) < latencyWindowSize {
+		t.window = append(t.window, rtt)
+	} else {
+		t.window[t.next] = rtt
+		t.next = (t.next + 1) % latencyWindowSize
+	}
+	t.samples++
+	t.last = rtt
+
+	return rtt, true
+}
+
+// expire forgets the pings pending for longer than pendingPingTimeout at now.
+func (t *latencyTracker) expire(now time.Time) {
+	n := 0
+	for n < len(t.pending) && now.Sub(t.pending[n].at) > pendingPingTimeout {
+		n++
+	}
+	if n > 0 {
+		t.pending = slices.Delete(t.pending, 0, n)
+		t.expired += uint64(n)
+	}
+}
+
+func (t *latencyTracker) snapshot() LatencyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := LatencyStats{Pending: len(t.pending), Expired: t.expired, Orphans: t.orphans, Stale: t.stale}
+	if len(t.window) == 0 {
+		return stats
+	}
+
+	sorted := slices.Clone(t.window)
+	slices.Sort(sorted)
+
+	var sum time.Duration
+	for _, rtt := range sorted {
+		sum += rtt
+	}
+
+	stats.Samples = t.samples
+	stats.Last = t.last
+	stats.Min = sorted[0]
+	stats.Avg = sum / time.Duration(len(sorted))
+	stats.P99 = sorted[(len(sorted)*99-1)/100]
+
+	return stats
+}
+
+// latencyOf returns the LatencyStats of the connection h currently wraps, walking decorators down, if any.
+func latencyOf(h ConnectionHandler) (LatencyStats, bool) {
+	for h != nil {
+		if p, ok := h.(LatencyProvider); ok {
+			return p.Latency(), true
+		}
+
+		u, ok := h.(handlerUnwrapper)
+		if !ok {
+			break
+		}
+		h = u.unwrapHandler()
+	}
+
+	return LatencyStats{}, false
+}
+
+// Latency returns the round trips of the pings written so far, see LatencyStats.
+func (w *WsConnection) Latency() LatencyStats {
+	return w.latency.snapshot()
+}
+
+// Latency returns the round trips of the connection, when it measures them, see LatencyProvider.
+func (h *baseConnectionHandler) Latency() LatencyStats {
+	if p, ok := h.conn.(LatencyProvider); ok {
+		return p.Latency()
+	}
+
+	return LatencyStats{}
+}
+
+// Latency returns the round trips of the current connection, when its layers measure them, see LatencyProvider. It is
+// zero before Open.
+func (b *basicClient) Latency() LatencyStats {
+	if b.connectionHandler == nil {
+		return LatencyStats{}
+	}
+
+	latency, _ := latencyOf(b.connectionHandler)
+	return latency
+}
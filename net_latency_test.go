@@ -0,0 +1,283 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// delayedPongServer answers pings delay late, after sending a pong of its own no ping asked for.
+func delayedPongServer(delay time.Duration) func(*websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		_ = conn.WriteControl(websocket.PongMessage, []byte("unsolicited"), time.Now().Add(time.Second))
+		conn.SetPingHandler(func(data string) error {
+			time.Sleep(delay)
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		serveUntilClosed(conn)
+	}
+}
+
+func TestWsConnection_Latency(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, delayedPongServer(delay)))
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+	require.Zero(t, conn.Latency().Samples)
+
+	for _, payload := range []string{"1", "2", "2"} {
+		require.NoError(t, conn.Write(NewPingMessage([]byte(payload))))
+	}
+	require.Eventually(t, func() bool { return conn.Latency().Samples == 3 }, 2*time.Second, time.Millisecond)
+
+	latency := conn.Latency()
+	require.GreaterOrEqual(t, latency.Min, delay)
+	require.GreaterOrEqual(t, latency.Avg, latency.Min)
+	require.GreaterOrEqual(t, latency.P99, latency.Avg)
+	require.GreaterOrEqual(t, latency.Last, 3*delay, "pongs are answered one after the other")
+	require.Less(t, latency.P99, time.Second)
+	require.Zero(t, latency.Pending)
+	require.EqualValues(t, 1, latency.Orphans, "the unsolicited pong is not told apart")
+}
+
+func TestLatencyTracker(t *testing.T) {
+	var tracker latencyTracker
+	start := time.Now()
+
+	_, ok := tracker.received("foreign", start)
+	require.False(t, ok)
+
+	for i := range latencyWindowSize + 10 {
+		at := start.Add(time.Duration(i) * time.Second)
+		tracker.sent("", at)
+		rtt, ok := tracker.received("", at.Add(time.Duration(i+1)*time.Millisecond))
+		require.True(t, ok)
+		require.Equal(t, time.Duration(i+1)*time.Millisecond, rtt)
+	}
+
+	_, ok = tracker.received("", start)
+	require.False(t, ok, "a pong matched a ping twice")
+
+	require.Equal(t, LatencyStats{
+		Samples: latencyWindowSize + 10,
+		Last:    (latencyWindowSize + 10) * time.Millisecond,
+		Min:     11 * time.Millisecond,
+		Avg:     (11 + latencyWindowSize + 10) * time.Millisecond / 2,
+		P99:     (latencyWindowSize + 9) * time.Millisecond,
+		Orphans: 2,
+	}, tracker.snapshot())
+}
+
+func TestLatencyTracker_PendingBounded(t *testing.T) {
+	var tracker latencyTracker
+	start := time.Now()
+
+	for i := range maxPendingPings + 1 {
+		tracker.sent(string(rune('a'+i)), start)
+	}
+
+	_, ok := tracker.received("a", start)
+	require.False(t, ok, "the oldest ping was not forgotten")
+	_, ok = tracker.received("b", start)
+	require.True(t, ok)
+
+	stats := tracker.snapshot()
+	require.Equal(t, maxPendingPings-1, stats.Pending)
+	require.EqualValues(t, 1, stats.Expired)
+	require.EqualValues(t, 1, stats.Orphans)
+}
+
+func TestLatencyTracker_Correlation(t *testing.T) {
+	type step struct {
+		// pong tells a pong was read rather than a ping written
+		pong    bool
+		payload string
+		at      time.Duration
+		// rtt is the round trip the pong is timed with, if matched
+		rtt time.Duration
+	}
+	ping := func(payload string, at time.Duration) step { return step{payload: payload, at: at} }
+	pong := func(payload string, at, rtt time.Duration) step {
+		return step{pong: true, payload: payload, at: at, rtt: rtt}
+	}
+	const ms = time.Millisecond
+
+	tests := []struct {
+		name        string
+		correlation PongCorrelation
+		steps       []step
+		want        LatencyStats
+	}{
+		{
+			name:  "in order",
+			steps: []step{ping("1", 0), ping("2", 10*ms), ping("3", 20*ms), pong("1", 50*ms, 50*ms), pong("2", 60*ms, 50*ms), pong("3", 70*ms, 50*ms)},
+			want:  LatencyStats{Samples: 3, Last: 50 * ms, Min: 50 * ms, Avg: 50 * ms, P99: 50 * ms},
+		},
+		{
+			name:  "out of order",
+			steps: []step{ping("1", 0), ping("2", 10*ms), ping("3", 20*ms), pong("3", 50*ms, 30*ms), pong("1", 60*ms, 60*ms), pong("2", 70*ms, 60*ms)},
+			want:  LatencyStats{Samples: 3, Last: 60 * ms, Min: 30 * ms, Avg: 50 * ms, P99: 60 * ms},
+		},
+		{
+			name: "lost",
+			steps: []step{
+				ping("1", 0), ping("2", 10*ms), ping("3", 20*ms), pong("1", 50*ms, 50*ms), pong("3", 70*ms, 50*ms),
+				// The pong of the second ping comes back once it expired.
+				ping("4", pendingPingTimeout+20*ms), pong("2", pendingPingTimeout+30*ms, 0),
+			},
+			want: LatencyStats{Samples: 2, Last: 50 * ms, Min: 50 * ms, Avg: 50 * ms, P99: 50 * ms, Pending: 1, Expired: 1, Orphans: 1},
+		},
+		{
+			name:        "not echoed",
+			correlation: PongByLastSent,
+			steps:       []step{ping("1", 0), ping("2", 10*ms), ping("3", 20*ms), pong("", 50*ms, 30*ms), pong("", 60*ms, 0)},
+			want:        LatencyStats{Samples: 1, Last: 30 * ms, Min: 30 * ms, Avg: 30 * ms, P99: 30 * ms, Expired: 2, Orphans: 1},
+		},
+		{
+			name:  "not echoed matched by payload",
+			steps: []step{ping("1", 0), pong("", 50*ms, 0)},
+			want:  LatencyStats{Pending: 1, Orphans: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := latencyTracker{correlation: tt.correlation}
+			start := time.Now()
+
+			for _, s := range tt.steps {
+				if !s.pong {
+					tracker.sent(s.payload, start.Add(s.at))
+					continue
+				}
+
+				rtt, ok := tracker.received(s.payload, start.Add(s.at))
+				require.Equal(t, s.rtt != 0, ok, "pong %q at %s", s.payload, s.at)
+				require.Equal(t, s.rtt, rtt, "pong %q at %s", s.payload, s.at)
+			}
+
+			require.Equal(t, tt.want, tracker.snapshot())
+		})
+	}
+}
+
+func TestWsConnection_PongCorrelation(t *testing.T) {
+	// The server answers every ping with an empty pong.
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		conn.SetPingHandler(func(string) error {
+			return conn.WriteControl(websocket.PongMessage, nil, time.Now().Add(time.Second))
+		})
+		serveUntilClosed(conn)
+	})
+
+	for _, tt := range []struct {
+		name        string
+		correlation PongCorrelation
+		wantSamples uint64
+	}{
+		{name: "by payload", correlation: PongByPayload},
+		{name: "by last sent", correlation: PongByLastSent, wantSamples: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, _ := newTestWsConnection(t, srv, WithPongCorrelation(tt.correlation))
+			require.NoError(t, conn.Open(context.Background()))
+			defer conn.Close()
+
+			tokens := PingTokens()
+			require.NoError(t, conn.Write(NewPingMessage(tokens())))
+			require.Eventually(t, func() bool {
+				latency := conn.Latency()
+				return latency.Samples+latency.Orphans == 1
+			}, 2*time.Second, time.Millisecond)
+			require.Equal(t, tt.wantSamples, conn.Latency().Samples)
+		})
+	}
+}
+
+func TestBasicClient_Latency(t *testing.T) {
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, newTestWsServer(t, delayedPongServer(time.Millisecond)))
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	var samples atomic.Int32
+	cli := newBasicClient(
+		NewBackoffConnectionHandlerFactory(nil,
+			NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})),
+			func(int) time.Duration { return 0 }, time.Minute),
+		func(Client, Message) {},
+		func(Client, EventType) {},
+	)
+	require.Zero(t, cli.Latency())
+	listenEvent(cli, EventLatencySample, listenInline, func(EventType) { samples.Add(1) })
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	cli.Send(NewPingMessage([]byte("ping")))
+	require.Eventually(t, func() bool { return samples.Load() == 1 }, 2*time.Second, time.Millisecond)
+
+	latency := cli.Latency()
+	require.EqualValues(t, 1, latency.Samples)
+	require.GreaterOrEqual(t, latency.Last, time.Millisecond)
+	require.Equal(t, latency.Last, latency.P99)
+}
+
+func TestBasicClient_StalePongs(t *testing.T) {
+	// The first connection drops a ping unanswered, the second one answers it, as if the pong crossed connections.
+	var conns atomic.Int32
+	pinged := make(chan string, 1)
+	u := testWsURL(t, newTestWsServer(t, func(conn *websocket.Conn) {
+		if conns.Add(1) == 1 {
+			conn.SetPingHandler(func(data string) error {
+				pinged <- data
+				return errors.New("dropped")
+			})
+			serveUntilClosed(conn)
+			return
+		}
+
+		_ = conn.WriteControl(websocket.PongMessage, []byte(<-pinged), time.Now().Add(time.Second))
+		_ = conn.WriteControl(websocket.PongMessage, []byte("unsolicited"), time.Now().Add(time.Second))
+		serveUntilClosed(conn)
+	}))
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	var connected []uint64
+	var cli *basicClient
+	cli = newBasicClient(
+		NewBackoffConnectionHandlerFactory(nil,
+			NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{},
+				WithPongCorrelation(PongByLastSent))),
+			func(int) time.Duration { return 0 }, time.Minute),
+		func(Client, Message) {},
+		func(_ Client, eventType EventType) {
+			if eventType != EventConnect {
+				return
+			}
+			control, _ := Handle[*ConnectionControl](cli)
+			info, _ := control.LatestInfo()
+			connected = append(connected, info.Generation)
+		},
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	cli.Send(NewPingMessage(tagPing(1, []byte("1"))))
+	require.Eventually(t, func() bool { return cli.Latency().Orphans == 1 }, 2*time.Second, time.Millisecond)
+
+	stats := cli.Latency()
+	require.EqualValues(t, 1, stats.Stale)
+	require.Zero(t, stats.Samples, "a pong was matched with the ping of another connection")
+	require.Equal(t, []uint64{1, 2}, connected)
+}
@@ -0,0 +1,128 @@
+package libws
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// netDialContext is the signature of the NetDialContext of a dialer.
+type netDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialContext makes d a proxy.ContextDialer, for proxies to dial through it.
+func (d netDialContext) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// Dial makes d a proxy.Dialer.
+func (d netDialContext) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+// proxyDial returns a NetDialContext tunneling connections through the proxy at proxyURL, which is dialed with
+// forward. http:// and https:// proxies are asked to CONNECT, socks5:// ones to connect, credentials being taken
+// from the user info of the URL. Failures to establish the tunnel are wrapped with ErrProxy.
+func proxyDial(proxyURL *url.URL, forward netDialContext) netDialContext {
+	switch proxyURL.Scheme {
+	case "http", "https":
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := connectThroughProxy(ctx, proxyURL, forward, network, addr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrProxy, RedactURL(*proxyURL), err)
+			}
+			return conn, nil
+		}
+	case "socks5", "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, forward)
+		return func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrProxy, err)
+			}
+
+			conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrProxy, RedactURL(*proxyURL), err)
+			}
+			return conn, nil
+		}
+	default:
+		return func(context.Context, string, string) (net.Conn, error) {
+			return nil, fmt.Errorf("%w: unsupported proxy scheme %q", ErrProxy, proxyURL.Scheme)
+		}
+	}
+}
+
+// connectThroughProxy dials the HTTP proxy at proxyURL, over TLS for https:// ones, and asks it to CONNECT to addr.
+func connectThroughProxy(
+	ctx context.Context,
+	proxyURL *url.URL,
+	forward netDialContext,
+	network, addr string,
+) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := forward(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	// The proxy does not speak past its response until the tunnel is used, nothing is lost buffering it.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("CONNECT refused: %s", resp.Status)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,267 @@
+package libws
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// socks5TestProxy is a SOCKS5 proxy serving CONNECT requests, requiring username and password authentication when
+// user is set.
+type socks5TestProxy struct {
+	ln         net.Listener
+	user, pass string
+	tunnels    atomic.Int32
+}
+
+func newSocks5TestProxy(t *testing.T, user, pass string) *socks5TestProxy {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	p := &socks5TestProxy{ln: ln, user: user, pass: pass}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+
+	return p
+}
+
+func (p *socks5TestProxy) url(user *url.Userinfo) *url.URL {
+	return &url.URL{Scheme: "socks5", Host: p.ln.Addr().String(), User: user}
+}
+
+func (p *socks5TestProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version, methods.
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	if p.user == "" {
+		_, _ = conn.Write([]byte{5, 0})
+	} else {
+		_, _ = conn.Write([]byte{5, 2})
+
+		// Username and password: version, user, password.
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, user); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return
+		}
+		pass := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, pass); err != nil {
+			return
+		}
+		if string(user) != p.user || string(pass) != p.pass {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+
+	// Request: version, command, reserved, address type, address, port.
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	p.tunnels.Add(1)
+	pipe(conn, target)
+}
+
+// pipe copies a to b and b to a until either is closed.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { _, _ = io.Copy(a, b); done <- struct{}{} }()
+	go func() { _, _ = io.Copy(b, a); done <- struct{}{} }()
+	<-done
+}
+
+// newConnectTestProxy returns an HTTP proxy serving CONNECT requests authorized with credentials, if any, along with
+// the count of tunnels it established.
+func newConnectTestProxy(t *testing.T, credentials string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var tunnels atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if credentials != "" && r.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return
+		}
+
+		tunnels.Add(1)
+		pipe(conn, target)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &tunnels
+}
+
+// newProxiedTestConnection returns a WsConnection dialing u through the proxy at proxyURL.
+func newProxiedTestConnection(t *testing.T, u url.URL, proxyURL *url.URL) (*WsConnection, chan Message) {
+	t.Helper()
+
+	repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u, ProxyURL: proxyURL}, nil
+	})
+	recv := make(chan Message, 8)
+	conn := NewWebsocketConnection(websocket.DefaultDialer, repo, newTestLogger(io.Discard), recv, ErrorAdapters{})
+	t.Cleanup(conn.Close)
+
+	return conn, recv
+}
+
+func TestWsConnection_Proxy(t *testing.T) {
+	u := testWsURL(t, newTestWsServer(t, serveEcho))
+
+	requireEcho := func(t *testing.T, conn *WsConnection, recv chan Message) {
+		t.Helper()
+
+		require.NoError(t, conn.Open(context.Background()))
+		require.NoError(t, conn.Write(NewTextMessage([]byte("through the proxy"))))
+		select {
+		case m := <-recv:
+			require.Equal(t, "through the proxy", string(m.Data()))
+		case <-time.After(time.Second):
+			t.Fatal("no echo received")
+		}
+	}
+
+	t.Run("socks5", func(t *testing.T) {
+		proxy := newSocks5TestProxy(t, "", "")
+		conn, recv := newProxiedTestConnection(t, u, proxy.url(nil))
+		requireEcho(t, conn, recv)
+		require.EqualValues(t, 1, proxy.tunnels.Load())
+	})
+
+	t.Run("socks5 with credentials", func(t *testing.T) {
+		proxy := newSocks5TestProxy(t, "collector", "secret")
+		conn, recv := newProxiedTestConnection(t, u, proxy.url(url.UserPassword("collector", "secret")))
+		requireEcho(t, conn, recv)
+
+		conn, _ = newProxiedTestConnection(t, u, proxy.url(url.UserPassword("collector", "wrong")))
+		err := conn.Open(context.Background())
+		require.ErrorIs(t, err, ErrProxy)
+		require.NotErrorIs(t, err, ErrCannotConnect, "proxy failures are told apart from the endpoint being down")
+		require.NotContains(t, err.Error(), "wrong")
+		require.EqualValues(t, 1, proxy.tunnels.Load())
+	})
+
+	t.Run("http connect", func(t *testing.T) {
+		srv, tunnels := newConnectTestProxy(t, "")
+		proxyURL, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+
+		conn, recv := newProxiedTestConnection(t, u, proxyURL)
+		requireEcho(t, conn, recv)
+		require.EqualValues(t, 1, tunnels.Load())
+	})
+
+	t.Run("http connect denied", func(t *testing.T) {
+		srv, tunnels := newConnectTestProxy(t, "Y29sbGVjdG9yOnNlY3JldA==") // collector:secret
+		proxyURL, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+
+		conn, _ := newProxiedTestConnection(t, u, proxyURL)
+		err = conn.Open(context.Background())
+		require.ErrorIs(t, err, ErrProxy)
+		require.NotErrorIs(t, err, ErrCannotConnect)
+		require.Contains(t, err.Error(), "407")
+
+		proxyURL.User = url.UserPassword("collector", "secret")
+		conn, recv := newProxiedTestConnection(t, u, proxyURL)
+		requireEcho(t, conn, recv)
+		require.EqualValues(t, 1, tunnels.Load())
+	})
+
+	t.Run("unsupported scheme", func(t *testing.T) {
+		conn, _ := newProxiedTestConnection(t, u, &url.URL{Scheme: "ftp", Host: "127.0.0.1:21"})
+		require.ErrorIs(t, conn.Open(context.Background()), ErrProxy)
+	})
+}
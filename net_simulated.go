@@ -0,0 +1,215 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// TimedMessage is a message along with the time it was originally received.
+	TimedMessage struct {
+		At      time.Time
+		Message Message
+	}
+
+	// MessageSource yields the inbound messages of a simulated connection. Next returns io.EOF once exhausted.
+	// A source is shared by every connection built by the same factory, so reconnections resume where the
+	// previous connection stopped.
+	MessageSource interface {
+		Next() (TimedMessage, error)
+	}
+
+	// MessageSink captures the outbound messages of a simulated connection.
+	MessageSink interface {
+		Capture(m Message) error
+	}
+
+	// SimulationOption customizes simulated connections.
+	SimulationOption func(*simulatedConnection)
+
+	// simulatedConnection replays messages from a MessageSource and captures writes into a MessageSink, without
+	// touching the network. It stays open once the source is exhausted, like an idle server would.
+	simulatedConnection struct {
+		noopConnection
+
+		source MessageSource
+		sink   MessageSink
+		recv   chan<- Message
+		paced  bool
+		clock  clock
+
+		closeC    CloseChan
+		closeOnce sync.Once
+		closeErr  error
+	}
+
+	// sliceMessageSource is a MessageSource backed by a slice.
+	sliceMessageSource struct {
+		mu       sync.Mutex
+		messages []TimedMessage
+	}
+
+	// MessageRecorder is a MessageSink keeping every captured message in memory.
+	MessageRecorder struct {
+		mu       sync.Mutex
+		messages []Message
+	}
+)
+
+// NewSimulatedConnectionFactory returns a factory of connections replaying source and capturing writes into sink.
+// Messages are delivered as fast as possible unless WithOriginalPacing is given. Compose it with
+// NewBaseConnectionHandlerFactory to run a production client composition offline.
+func NewSimulatedConnectionFactory(source MessageSource, sink MessageSink, opts ...SimulationOption) ConnectionFactory {
+	return func(_ context.Context, recvChan chan<- Message) Connection {
+		c := &simulatedConnection{
+			source: source,
+			sink:   sink,
+			recv:   recvChan,
+			clock:  realClock{},
+			closeC: make(CloseChan),
+		}
+
+		for _, opt := range opts {
+			opt(c)
+		}
+
+		return c
+	}
+}
+
+// WithOriginalPacing makes simulated connections wait between messages as long as originally elapsed between them.
+func WithOriginalPacing() SimulationOption {
+	return func(c *simulatedConnection) {
+		c.paced = true
+	}
+}
+
+// withSimulationClock sets the clock used to pace messages.
+func withSimulationClock(clk clock) SimulationOption {
+	return func(c *simulatedConnection) {
+		c.clock = clk
+	}
+}
+
+func (c *simulatedConnection) Open(ctx context.Context) error {
+	go c.replay(ctx)
+	return nil
+}
+
+func (c *simulatedConnection) Write(m Message) error {
+	if err := checkWritable(m); err != nil {
+		return err
+	}
+
+	return c.sink.Capture(m)
+}
+
+func (c *simulatedConnection) Close() {
+	c.close(ErrTerminated)
+}
+
+func (c *simulatedConnection) CloseChan() CloseChan {
+	return c.closeC
+}
+
+func (c *simulatedConnection) CloseErr() error {
+	select {
+	case <-c.closeC:
+		return c.closeErr
+	default:
+		return nil
+	}
+}
+
+func (c *simulatedConnection) close(err error) {
+	c.closeOnce.Do(func() {
+		c.closeErr = err
+		close(c.closeC)
+	})
+}
+
+func (c *simulatedConnection) replay(ctx context.Context) {
+	var last time.Time
+
+	for {
+		tm, err := c.source.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			c.close(fmt.Errorf("%w: simulation source failed: %w", ErrConnectionClosed, err))
+			return
+		}
+
+		if c.paced && !last.IsZero() && tm.At.After(last) {
+			if !c.wait(ctx, tm.At.Sub(last)) {
+				return
+			}
+		}
+		last = tm.At
+
+		select {
+		case c.recv <- tm.Message:
+		case <-c.closeC:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// wait blocks for d as measured by the connection clock, returning false if the connection closes meanwhile.
+func (c *simulatedConnection) wait(ctx context.Context, d time.Duration) bool {
+	elapsed := make(chan struct{})
+	timer := c.clock.AfterFunc(d, func() { close(elapsed) })
+	defer timer.Stop()
+
+	select {
+	case <-elapsed:
+		return true
+	case <-c.closeC:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// NewSliceMessageSource returns a MessageSource yielding messages in order.
+func NewSliceMessageSource(messages []TimedMessage) MessageSource {
+	return &sliceMessageSource{messages: messages}
+}
+
+func (s *sliceMessageSource) Next() (TimedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.messages) == 0 {
+		return TimedMessage{}, io.EOF
+	}
+
+	tm := s.messages[0]
+	s.messages = s.messages[1:]
+	return tm, nil
+}
+
+// Capture records m.
+func (r *MessageRecorder) Capture(m Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// Messages returns a copy of the captured messages.
+func (r *MessageRecorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
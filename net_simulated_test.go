@@ -0,0 +1,122 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testRecording(n int, gap time.Duration) []TimedMessage {
+	start := time.Unix(1700000000, 0)
+
+	recording := make([]TimedMessage, n)
+	for i := range recording {
+		recording[i] = TimedMessage{
+			At:      start.Add(time.Duration(i) * gap),
+			Message: NewDataMessage([]byte(`{"seq":` + strconv.Itoa(i) + `}`)),
+		}
+	}
+	return recording
+}
+
+func TestSimulatedConnection_ComposedClient(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recording := testRecording(50, time.Millisecond)
+	sink := &MessageRecorder{}
+	logger := newTestLogger(io.Discard)
+
+	factory := NewActiveKeepAliveConnectionHandlerFactory(
+		logger,
+		NewBackoffConnectionHandlerFactory(
+			logger,
+			NewBaseConnectionHandlerFactory(logger, NewSimulatedConnectionFactory(NewSliceMessageSource(recording), sink)),
+			ExponentialBackoffSeconds,
+			time.Minute,
+		),
+		10*time.Millisecond,
+		NewKeepAliveMessageFactory(PingMessage, func() []byte { return nil }),
+	)
+
+	var (
+		mu       sync.Mutex
+		received []Message
+	)
+	cli := NewBasicClientFactory(factory, func(_ Client, m Message) {
+		mu.Lock()
+		received = append(received, m)
+		mu.Unlock()
+	}, func(Client, EventType) {})()
+
+	require.NoError(t, cli.Open(ctx))
+	defer cli.Close()
+
+	cli.Send(NewDataMessage([]byte(`{"op":"subscribe"}`)))
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == len(recording)
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	for i, m := range received {
+		require.Equal(t, withGeneration(recording[i].Message, 1), m)
+	}
+	mu.Unlock()
+
+	// Outbound messages, keep-alive pings included, are captured.
+	require.Eventually(t, func() bool {
+		var pinged bool
+		for _, m := range sink.Messages() {
+			pinged = pinged || m.Type() == PingMessage
+		}
+		return pinged
+	}, time.Second, time.Millisecond)
+	require.Contains(t, sink.Messages(), NewDataMessage([]byte(`{"op":"subscribe"}`)))
+}
+
+func TestSimulatedConnection_OriginalPacing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const gap = 50 * time.Millisecond
+
+	clk := newFakeClock()
+	recv := make(chan Message, 8)
+	conn := NewSimulatedConnectionFactory(
+		NewSliceMessageSource(testRecording(3, gap)),
+		&MessageRecorder{},
+		WithOriginalPacing(),
+		withSimulationClock(clk),
+	)(ctx, recv)
+	require.NoError(t, conn.Open(ctx))
+	defer conn.Close()
+
+	var deliveredAt []time.Time
+	for len(deliveredAt) < 3 {
+		select {
+		case <-recv:
+			deliveredAt = append(deliveredAt, clk.Now())
+		case <-time.After(time.Millisecond):
+			clk.Advance(time.Millisecond)
+		}
+	}
+
+	for i := 1; i < len(deliveredAt); i++ {
+		require.GreaterOrEqual(t, deliveredAt[i].Sub(deliveredAt[i-1]), gap)
+	}
+
+	// The connection outlives the recording.
+	select {
+	case <-conn.CloseChan():
+		t.Fatal("connection closed after replay")
+	default:
+	}
+}
@@ -0,0 +1,144 @@
+package libws
+
+import "sync"
+
+// Policies of a WsConnection whose inbound messages are not consumed as fast as they are read, see
+// WithSlowConsumerPolicy.
+const (
+	// SlowConsumerBlock stops reading until the message is taken, the default. Left to the network, the backlog
+	// eventually makes the peer drop the connection, e.g. for not answering pings.
+	SlowConsumerBlock SlowConsumerPolicy = iota
+	// SlowConsumerDropOldest keeps reading, queuing up to 256 messages and dropping the oldest queued one to make room.
+	SlowConsumerDropOldest
+	// SlowConsumerDropNewest keeps reading, dropping the messages read while the consumer has no room for them.
+	SlowConsumerDropNewest
+	// SlowConsumerClose closes the connection with ErrSlowConsumer as soon as the consumer has no room for a message.
+	SlowConsumerClose
+)
+
+// slowConsumerQueueSize bounds the messages queued under SlowConsumerDropOldest.
+const slowConsumerQueueSize = 256
+
+type (
+	// SlowConsumerPolicy tells what a WsConnection does with an inbound message the consumer has no room for.
+	SlowConsumerPolicy int
+
+	// inboundQueue holds the inbound messages waiting for the consumer under SlowConsumerDropOldest.
+	inboundQueue struct {
+		mu       sync.Mutex
+		messages []Message
+		// ready signals that messages were queued
+		ready chan struct{}
+	}
+)
+
+// WithSlowConsumerPolicy sets what the connection does when the consumer of its inbound messages falls behind, see
+// SlowConsumerPolicy. Dropped messages are counted, see InboundDropped, and emitted as EventInboundDropped. Control
+// frames are never dropped.
+func WithSlowConsumerPolicy(p SlowConsumerPolicy) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.slowConsumer = p
+	}
+}
+
+// InboundDropped returns how many inbound messages were dropped as the consumer fell behind, see
+// WithSlowConsumerPolicy.
+func (w *WsConnection) InboundDropped() int {
+	return int(w.inboundDropped.Load())
+}
+
+// deliver passes the data message m read from the wire to the consumer as the slow consumer policy says. It returns
+// false when the connection is to be closed.
+func (w *WsConnection) deliver(m Message) bool {
+	switch w.slowConsumer {
+	case SlowConsumerDropOldest:
+		if w.inbound.push(m) {
+			w.dropInbound()
+		}
+	case SlowConsumerDropNewest:
+		select {
+		case w.recv <- m:
+		default:
+			w.dropInbound()
+		}
+	case SlowConsumerClose:
+		select {
+		case w.recv <- m:
+		default:
+			w.logger.Errorln("closing connection, inbound messages are not consumed fast enough")
+			w.setCloseReason(ErrSlowConsumer)
+			return false
+		}
+	default:
+		w.recv <- m
+	}
+
+	return true
+}
+
+func (w *WsConnection) dropInbound() {
+	w.inboundDropped.Add(1)
+	if w.emitter != nil {
+		w.emitter.Emit(EventInboundDropped, EventInboundDropped)
+	}
+}
+
+// forwardInbound passes the queued inbound messages to the consumer, in order, until the connection closes.
+func (w *WsConnection) forwardInbound() {
+	defer w.loops.Done()
+
+	for {
+		m, ok := w.inbound.pop()
+		if !ok {
+			select {
+			case <-w.stopC:
+				return
+			case <-w.inbound.ready:
+			}
+			continue
+		}
+
+		select {
+		case <-w.stopC:
+			return
+		case w.recv <- m:
+		}
+	}
+}
+
+func newInboundQueue() *inboundQueue {
+	return &inboundQueue{ready: make(chan struct{}, 1)}
+}
+
+// push queues m, dropping the oldest queued message to make room when full, in which case it returns true.
+func (q *inboundQueue) push(m Message) bool {
+	q.mu.Lock()
+	dropped := len(q.messages) >= slowConsumerQueueSize
+	if dropped {
+		q.messages[0] = nil
+		q.messages = q.messages[1:]
+	}
+	q.messages = append(q.messages, m)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	return dropped
+}
+
+func (q *inboundQueue) pop() (Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.messages) == 0 {
+		return nil, false
+	}
+
+	m := q.messages[0]
+	q.messages[0] = nil
+	q.messages = q.messages[1:]
+	return m, true
+}
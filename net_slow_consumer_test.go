@@ -0,0 +1,95 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// burstServer sends n numbered messages, then a ping, then keeps the connection open.
+func burstServer(n int) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		for i := range n {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprint(i)))
+		}
+		_ = conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second))
+		serveUntilClosed(conn)
+	}
+}
+
+// newStalledConsumerConnection opens a connection to a burstServer of n messages whose consumer has room for a single
+// message and does not read it. Control frames are delivered apart, to control.
+func newStalledConsumerConnection(t *testing.T, n int, p SlowConsumerPolicy) (*WsConnection, chan Message, chan Message) {
+	t.Helper()
+
+	u := testWsURL(t, newTestWsServer(t, burstServer(n)))
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	recv, control := make(chan Message, 1), make(chan Message, 8)
+	conn := NewWebsocketConnection(websocket.DefaultDialer, repo, logger, recv, ErrorAdapters{}, WithSlowConsumerPolicy(p))
+	conn.bindControlRecv(control)
+	require.NoError(t, conn.Open(context.Background()))
+	t.Cleanup(conn.Close)
+
+	return conn, recv, control
+}
+
+func requirePing(t *testing.T, control <-chan Message) {
+	t.Helper()
+
+	select {
+	case m := <-control:
+		require.Equal(t, PingMessage, m.Type())
+	case <-time.After(2 * time.Second):
+		t.Fatal("ping not delivered")
+	}
+}
+
+func TestWsConnection_SlowConsumerDropNewest(t *testing.T) {
+	conn, recv, control := newStalledConsumerConnection(t, 10, SlowConsumerDropNewest)
+
+	requirePing(t, control)
+	require.Equal(t, 9, conn.InboundDropped())
+	require.Equal(t, "0", string((<-recv).Data()))
+}
+
+func TestWsConnection_SlowConsumerDropOldest(t *testing.T) {
+	const n = slowConsumerQueueSize + 50
+	conn, recv, control := newStalledConsumerConnection(t, n, SlowConsumerDropOldest)
+
+	requirePing(t, control)
+	dropped := conn.InboundDropped()
+	require.Positive(t, dropped)
+
+	// The newest messages are kept, in order.
+	var received []string
+	for len(received)+dropped < n {
+		select {
+		case m := <-recv:
+			received = append(received, string(m.Data()))
+		case <-time.After(time.Second):
+			t.Fatalf("received %d messages and dropped %d out of %d", len(received), dropped, n)
+		}
+	}
+	require.Equal(t, fmt.Sprint(n-1), received[len(received)-1])
+	require.Equal(t, fmt.Sprint(n-slowConsumerQueueSize), received[len(received)-slowConsumerQueueSize])
+}
+
+func TestWsConnection_SlowConsumerClose(t *testing.T) {
+	conn, _, _ := newStalledConsumerConnection(t, 10, SlowConsumerClose)
+
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	require.ErrorIs(t, conn.CloseErr(), ErrSlowConsumer)
+}
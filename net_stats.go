@@ -0,0 +1,134 @@
+package libws
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// ConnectionStats is a snapshot of the traffic of a connection, telling whether it is actually moving data. Frames
+	// of every kind are counted, control ones included, along with the size of their payload.
+	ConnectionStats struct {
+		MessagesRead    uint64
+		MessagesWritten uint64
+		BytesRead       uint64
+		BytesWritten    uint64
+		// LastReadAt and LastWriteAt are when the latest frame was read and written, the zero time until one was.
+		LastReadAt  time.Time
+		LastWriteAt time.Time
+		// ConnectedAt is when the connection was established, the zero time until it was.
+		ConnectedAt time.Time
+		// CompressedFrames and UncompressedFrames count the data frames written with and without compression, see
+		// WithCompressionDecider.
+		CompressedFrames   uint64
+		UncompressedFrames uint64
+	}
+
+	// StatsProvider is an optional interface implemented by connections, connection handlers and clients which expose
+	// the ConnectionStats of their current connection. Reconnecting layers report the one in use, starting over with
+	// every new connection.
+	StatsProvider interface {
+		Stats() ConnectionStats
+	}
+
+	// connStats accumulates the ConnectionStats of a connection, updated by its read and write loops without locking.
+	connStats struct {
+		messagesRead    atomic.Uint64
+		messagesWritten atomic.Uint64
+		bytesRead       atomic.Uint64
+		bytesWritten    atomic.Uint64
+		lastReadAt      atomic.Int64
+		lastWriteAt     atomic.Int64
+		connectedAt     atomic.Int64
+		compressed      atomic.Uint64
+		uncompressed    atomic.Uint64
+	}
+)
+
+// read accounts for a frame of n bytes read at.
+func (s *connStats) read(n int, at time.Time) {
+	s.messagesRead.Add(1)
+	s.bytesRead.Add(uint64(n))
+	s.lastReadAt.Store(at.UnixNano())
+}
+
+// wrote accounts for a frame of n bytes written at.
+func (s *connStats) wrote(n int, at time.Time) {
+	s.messagesWritten.Add(1)
+	s.bytesWritten.Add(uint64(n))
+	s.lastWriteAt.Store(at.UnixNano())
+}
+
+// wroteData accounts for a data frame written, compressed or not.
+func (s *connStats) wroteData(compressed bool) {
+	if compressed {
+		s.compressed.Add(1)
+	} else {
+		s.uncompressed.Add(1)
+	}
+}
+
+func (s *connStats) snapshot() ConnectionStats {
+	return ConnectionStats{
+		MessagesRead:       s.messagesRead.Load(),
+		MessagesWritten:    s.messagesWritten.Load(),
+		BytesRead:          s.bytesRead.Load(),
+		BytesWritten:       s.bytesWritten.Load(),
+		LastReadAt:         unixNanoTime(s.lastReadAt.Load()),
+		LastWriteAt:        unixNanoTime(s.lastWriteAt.Load()),
+		ConnectedAt:        unixNanoTime(s.connectedAt.Load()),
+		CompressedFrames:   s.compressed.Load(),
+		UncompressedFrames: s.uncompressed.Load(),
+	}
+}
+
+// unixNanoTime returns the time of n nanoseconds since the Unix epoch, the zero time when n is zero.
+func unixNanoTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+// statsOf returns the stats of the current connection of the handler chain starting at h, from the first layer
+// exposing them, if any.
+func statsOf(h ConnectionHandler) (ConnectionStats, bool) {
+	for h != nil {
+		if p, ok := h.(StatsProvider); ok {
+			return p.Stats(), true
+		}
+
+		u, ok := h.(handlerUnwrapper)
+		if !ok {
+			break
+		}
+		h = u.unwrapHandler()
+	}
+
+	return ConnectionStats{}, false
+}
+
+// Stats returns the traffic of the connection so far.
+func (w *WsConnection) Stats() ConnectionStats {
+	return w.stats.snapshot()
+}
+
+// Stats returns the traffic of the connection, when it exposes it, see StatsProvider.
+func (h *baseConnectionHandler) Stats() ConnectionStats {
+	if p, ok := h.conn.(StatsProvider); ok {
+		return p.Stats()
+	}
+
+	return ConnectionStats{}
+}
+
+// Stats returns the traffic of the current connection, when its layers expose it, see StatsProvider. It is zero
+// before Open.
+func (b *basicClient) Stats() ConnectionStats {
+	if b.connectionHandler == nil {
+		return ConnectionStats{}
+	}
+
+	stats, _ := statsOf(b.connectionHandler)
+	return stats
+}
@@ -0,0 +1,88 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// serveEcho echoes every message read from conn, ping frames being answered with pongs.
+func serveEcho(conn *websocket.Conn) {
+	for {
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(mt, data); err != nil {
+			return
+		}
+	}
+}
+
+func TestWsConnection_Stats(t *testing.T) {
+	conn, recv := newTestWsConnection(t, newTestWsServer(t, serveEcho))
+	require.Zero(t, conn.Stats())
+
+	before := time.Now()
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	require.NoError(t, conn.Write(NewTextMessage([]byte("abc"))))
+	require.NoError(t, conn.Write(NewBinaryMessage([]byte("defgh"))))
+	require.NoError(t, conn.Write(NewPingMessage([]byte("p"))))
+	for i := 0; i < 3; i++ {
+		select {
+		case <-recv:
+		case <-time.After(time.Second):
+			t.Fatal("echo not received")
+		}
+	}
+
+	stats := conn.Stats()
+	require.EqualValues(t, 3, stats.MessagesWritten)
+	require.EqualValues(t, 9, stats.BytesWritten)
+	require.EqualValues(t, 3, stats.MessagesRead, "the pong is counted")
+	require.EqualValues(t, 9, stats.BytesRead)
+	require.False(t, stats.ConnectedAt.Before(before))
+	require.False(t, stats.LastWriteAt.Before(stats.ConnectedAt))
+	require.False(t, stats.LastReadAt.Before(stats.ConnectedAt))
+	require.False(t, stats.LastReadAt.After(time.Now()))
+}
+
+func TestClient_Stats(t *testing.T) {
+	srv := newTestWsServer(t, serveEcho)
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	factory := NewReopenIntervalConnFactory(
+		logger,
+		time.Hour,
+		NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})),
+	)
+
+	handled := make(chan Message, 1)
+	cli := newBasicClient(factory, func(_ Client, m Message) { handled <- m }, func(Client, EventType) {})
+	var _ StatsProvider = cli
+	require.Zero(t, cli.Stats(), "before Open")
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	cli.Send(NewTextMessage([]byte("hello")))
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("echo not handled")
+	}
+
+	stats := cli.Stats()
+	require.EqualValues(t, 1, stats.MessagesWritten)
+	require.EqualValues(t, 5, stats.BytesRead)
+	require.False(t, stats.ConnectedAt.IsZero())
+	require.False(t, stats.LastReadAt.IsZero())
+}
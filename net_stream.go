@@ -0,0 +1,334 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// streamCopyBufferSize is the size of the chunks in which WriteReader copies a payload, the write deadline being
+// pushed back before each of them.
+const streamCopyBufferSize = 32 << 10
+
+type (
+	// StreamMessage is a data message whose payload is read from the connection as it is consumed rather than held in
+	// memory, see WithStreaming. It must be read to the end or closed for the next frame to be read: until then, the
+	// connection reads nothing else, control frames included. It is not safe for concurrent use.
+	StreamMessage struct {
+		mt MessageType
+		// r is what is left of the payload, the frame reader until Data was called
+		r    io.Reader
+		data []byte
+		// read is the size of the payload read from the frame so far
+		read int
+		// onRead is called on every read, pushing the read deadline back
+		onRead func()
+		done   chan struct{}
+
+		generation uint64
+		receivedAt time.Time
+		readSeq    uint64
+	}
+
+	// StreamWriter is an optional interface implemented by connections, connection handlers and clients which write
+	// a payload copied from a reader as a single frame, see WsConnection.WriteReader.
+	StreamWriter interface {
+		WriteReader(mt MessageType, r io.Reader) error
+	}
+
+	// streamWrite is an outbound data message whose payload is copied from r by the write loop, see WriteReader.
+	streamWrite struct {
+		mt   MessageType
+		r    io.Reader
+		errC chan error
+	}
+)
+
+// WithStreaming makes the connection deliver the data messages it reads as StreamMessages, whose payload is read as
+// it is consumed, e.g. to decode order book snapshots of tens of megabytes without a copy of them in memory. As no
+// frame is read until the previous one was consumed, the message handler must read every StreamMessage to the end or
+// close it, and slow consumer policies do not apply. Messages the layers of the library drop are closed, the ones they
+// buffer read into memory. It is disabled by default.
+func WithStreaming() WsConnectionOption {
+	return func(w *WsConnection) {
+		w.streaming = true
+	}
+}
+
+func newStreamMessage(mt MessageType, r io.Reader, onRead func()) *StreamMessage {
+	return &StreamMessage{mt: mt, r: r, onRead: onRead, done: make(chan struct{})}
+}
+
+func (m *StreamMessage) Type() MessageType {
+	return m.mt
+}
+
+// Data reads what is left of the payload into memory, defeating streaming, and returns it. Reads go on from it.
+func (m *StreamMessage) Data() []byte {
+	if m.data == nil {
+		m.data, _ = io.ReadAll(m.r)
+		if m.data == nil {
+			m.data = []byte{}
+		}
+		m.r = &sliceReader{bts: m.data}
+	}
+
+	return m.data
+}
+
+func (m *StreamMessage) String() string {
+	return fmt.Sprintf("StreamMessage{type=%d}", m.mt)
+}
+
+// Read reads the payload. The frame is consumed once it returns io.EOF, or another error.
+func (m *StreamMessage) Read(p []byte) (int, error) {
+	return m.r.Read(p)
+}
+
+// Close discards what is left of the payload, reading it from the connection, letting the next frame be read. It
+// returns the error of the connection, if any.
+func (m *StreamMessage) Close() error {
+	_, err := io.Copy(io.Discard, m.r)
+	return err
+}
+
+func (m *StreamMessage) Generation() uint64 {
+	return m.generation
+}
+
+func (m *StreamMessage) ReceivedAt() time.Time {
+	return m.receivedAt
+}
+
+func (m *StreamMessage) ReadSeq() uint64 {
+	return m.readSeq
+}
+
+// discard drops m, closing it when it is streamed for the connection to read the next frame, see StreamMessage.
+// Layers dropping messages must discard them.
+func discard(m Message) {
+	if c, ok := m.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// hold reads the payload of m into memory when it is streamed, for it to be held past its delivery without the
+// connection stalling, see StreamMessage. Layers buffering messages must hold them.
+func hold(m Message) Message {
+	if sm, ok := m.(*StreamMessage); ok {
+		sm.Data()
+	}
+	return m
+}
+
+// frameReader reads the payload of the frame of m from r, telling the read loop once it was consumed.
+type frameReader struct {
+	m *StreamMessage
+	r io.Reader
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	if isClosed(f.m.done) {
+		return 0, io.EOF
+	}
+
+	f.m.onRead()
+	n, err := f.r.Read(p)
+	f.m.read += n
+	if err != nil {
+		close(f.m.done)
+	}
+	return n, err
+}
+
+// sliceReader reads bts, the payload of a StreamMessage read into memory by Data.
+type sliceReader struct {
+	bts []byte
+	off int
+}
+
+func (s *sliceReader) Read(p []byte) (int, error) {
+	if s.off >= len(s.bts) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, s.bts[s.off:])
+	s.off += n
+	return n, nil
+}
+
+// readStream reads the next data frame, delivering it as a StreamMessage, then waits for it to be consumed. It
+// returns false, along with the error of the read if any, once the read loop must stop.
+func (w *WsConnection) readStream() (bool, error) {
+	messageType, r, err := w.conn.NextReader()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	// frames from NextReader are either binary or text
+	mt := FromWireFrame(messageType, nil).Type()
+	if logEnabled(w.logger, LevelDebug) {
+		w.logger.Debugf("<= [STREAM] type %d", mt)
+	}
+
+	m := newStreamMessage(mt, nil, w.extendReadDeadline)
+	m.r = &frameReader{m: m, r: r}
+
+	select {
+	case w.recv <- w.stamp(m, now):
+	case <-w.stopC:
+		return false, nil
+	}
+
+	select {
+	case <-m.done:
+	case <-w.stopC:
+		return false, nil
+	}
+
+	w.stats.read(m.read, now)
+	return true, nil
+}
+
+// WriteReader writes the payload copied from r as a single frame of type mt, text or binary, without holding it in
+// memory, ordered with the messages written with Write. It returns once the frame was written, with the error of the
+// write, which closes the connection, that of r included. WithMaxWriteSize does not apply.
+func (w *WsConnection) WriteReader(mt MessageType, r io.Reader) error {
+	if !mt.IsData() {
+		return fmt.Errorf("%w: cannot stream messages of type %d", ErrInvalidOutbound, mt)
+	}
+
+	sw := &streamWrite{mt: mt, r: r, errC: make(chan error, 1)}
+	if err := w.sendContext(context.Background(), sw); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-sw.errC:
+		return err
+	case <-w.closeChan:
+		// The write loop exited, either with the frame written or without taking it.
+		select {
+		case err := <-sw.errC:
+			return err
+		default:
+			return ErrConnectionClosed
+		}
+	}
+}
+
+func (s *streamWrite) Type() MessageType {
+	return s.mt
+}
+
+// Data returns nil, the payload being copied from the reader as it is written.
+func (s *streamWrite) Data() []byte {
+	return nil
+}
+
+func (s *streamWrite) String() string {
+	return fmt.Sprintf("Message{type=%d,data=<stream>}", s.mt)
+}
+
+// writeData writes the data message m as a frame of type messageType, copying its payload when it is streamed, see
+// WriteReader. It returns the size of the payload written.
+func (w *WsConnection) writeData(messageType int, m Message) (int, error) {
+	sw, ok := m.(*streamWrite)
+	if !ok {
+		return len(m.Data()), w.conn.WriteMessage(messageType, m.Data())
+	}
+
+	n, err := w.copyFrame(messageType, sw.r)
+	sw.errC <- err
+	return n, err
+}
+
+// copyFrame writes the payload copied from r as a single frame of type messageType, pushing the write deadline back
+// before every chunk.
+func (w *WsConnection) copyFrame(messageType int, r io.Reader) (int, error) {
+	fw, err := w.conn.NextWriter(messageType)
+	if err != nil {
+		return 0, err
+	}
+
+	// Neither side is given the chance to bypass the chunks, with ReadFrom or WriteTo.
+	dw := &deadlineWriter{w: fw, conn: w.conn, timeout: w.writeTimeout, watch: &w.writeWatch}
+	n, err := io.CopyBuffer(dw, struct{ io.Reader }{r}, make([]byte, streamCopyBufferSize))
+	if err != nil {
+		// Closing the writer would end the frame, the peer taking the partial payload for the whole of it.
+		return int(n), fmt.Errorf("cannot stream payload: %w", err)
+	}
+
+	dw.extend()
+	return int(n), fw.Close()
+}
+
+// deadlineWriter writes to w, pushing the write deadline of conn back by timeout before every write, if any. Every
+// write counts as progress for watch.
+type deadlineWriter struct {
+	w       io.Writer
+	conn    *websocket.Conn
+	timeout time.Duration
+	watch   *writeWatch
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.extend()
+	return d.w.Write(p)
+}
+
+func (d *deadlineWriter) extend() {
+	d.watch.progress()
+	if d.timeout > 0 {
+		_ = d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+	}
+}
+
+// streamWriterOf returns the StreamWriter of the connection h currently wraps, walking decorators down, if any.
+func streamWriterOf(h ConnectionHandler) (StreamWriter, bool) {
+	for h != nil {
+		if w, ok := h.(StreamWriter); ok {
+			return w, true
+		}
+
+		u, ok := h.(handlerUnwrapper)
+		if !ok {
+			break
+		}
+		h = u.unwrapHandler()
+	}
+
+	return nil, false
+}
+
+// WriteReader writes the payload copied from r as a single frame through the connection, when it supports it, see
+// WsConnection.WriteReader.
+func (h *baseConnectionHandler) WriteReader(mt MessageType, r io.Reader) error {
+	if h.conn == nil {
+		return ErrConnectionClosed
+	}
+
+	if w, ok := h.conn.(StreamWriter); ok {
+		return w.WriteReader(mt, r)
+	}
+
+	return fmt.Errorf("%w: connection cannot stream messages", ErrInvalidOutbound)
+}
+
+// WriteReader writes the payload copied from r as a single frame through the current connection, when its layers
+// support it, see WsConnection.WriteReader. Outbound middlewares do not apply.
+func (b *basicClient) WriteReader(mt MessageType, r io.Reader) error {
+	if b.connectionHandler == nil {
+		return ErrConnectionClosed
+	}
+
+	if w, ok := streamWriterOf(b.connectionHandler); ok {
+		return w.WriteReader(mt, r)
+	}
+
+	return fmt.Errorf("%w: connection cannot stream messages", ErrInvalidOutbound)
+}
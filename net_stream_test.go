@@ -0,0 +1,292 @@
+package libws
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+// patternReader reads n bytes of a pattern, without holding them.
+type patternReader struct {
+	n, off int
+	// err, when set, is returned once half of the bytes were read
+	err error
+}
+
+func (p *patternReader) Read(b []byte) (int, error) {
+	if p.err != nil && p.off >= p.n/2 {
+		return 0, p.err
+	}
+	if p.off >= p.n {
+		return 0, io.EOF
+	}
+
+	k := min(len(b), p.n-p.off)
+	for i := range k {
+		b[i] = byte((p.off + i) % 251)
+	}
+	p.off += k
+	return k, nil
+}
+
+// serveStreamEcho echoes every frame, streaming its payload.
+func serveStreamEcho(conn *websocket.Conn) {
+	for {
+		mt, r, err := conn.NextReader()
+		if err != nil {
+			return
+		}
+		w, err := conn.NextWriter(mt)
+		if err != nil {
+			return
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return
+		}
+		if err := w.Close(); err != nil {
+			return
+		}
+	}
+}
+
+// nextStream returns the next message of recv, a StreamMessage.
+func nextStream(t *testing.T, recv <-chan Message) *StreamMessage {
+	t.Helper()
+
+	select {
+	case m := <-recv:
+		sm, ok := m.(*StreamMessage)
+		require.True(t, ok, "got %T", m)
+		return sm
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestWsConnection_StreamRoundTrip(t *testing.T) {
+	const size = 50 << 20
+
+	conn, recv := newTestWsConnection(t, newTestWsServer(t, serveStreamEcho), WithStreaming())
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	expected := sha256.New()
+	_, err := io.Copy(expected, &patternReader{n: size})
+	require.NoError(t, err)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// The echo is read while written, as neither side buffers it.
+	written := make(chan error, 1)
+	go func() {
+		if err := conn.WriteReader(BinaryMessage, &patternReader{n: size}); err != nil {
+			written <- err
+			return
+		}
+		written <- conn.Write(NewTextMessage([]byte("after")))
+	}()
+
+	m := nextStream(t, recv)
+	require.Equal(t, BinaryMessage, m.Type())
+	require.EqualValues(t, 1, ReadSeqOf(m))
+	actual := sha256.New()
+	n, err := io.Copy(actual, m)
+	require.NoError(t, err)
+	require.EqualValues(t, size, n)
+	require.Equal(t, expected.Sum(nil), actual.Sum(nil))
+	require.NoError(t, <-written)
+
+	runtime.ReadMemStats(&after)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(size/4), "the payload was held in memory")
+
+	next := nextStream(t, recv)
+	require.Equal(t, TextMessage, next.Type())
+	require.EqualValues(t, 2, ReadSeqOf(next))
+	require.Equal(t, []byte("after"), next.Data())
+	require.NoError(t, next.Close())
+
+	require.Eventually(t, func() bool {
+		stats := conn.Stats()
+		return stats.MessagesRead == 2 && stats.BytesRead == size+5
+	}, time.Second, time.Millisecond)
+	stats := conn.Stats()
+	require.EqualValues(t, 2, stats.MessagesWritten)
+	require.EqualValues(t, size+5, stats.BytesWritten)
+}
+
+func TestWsConnection_StreamMustBeConsumed(t *testing.T) {
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("first"))
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte("second"))
+		serveUntilClosed(conn)
+	})
+	conn, recv := newTestWsConnection(t, srv, WithStreaming())
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	first := nextStream(t, recv)
+	buf := make([]byte, 2)
+	_, err := io.ReadFull(first, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("fi"), buf)
+
+	select {
+	case m := <-recv:
+		t.Fatalf("read %s before the previous frame was consumed", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, first.Close())
+	second := nextStream(t, recv)
+	require.Equal(t, BinaryMessage, second.Type())
+	bts, err := io.ReadAll(second)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second"), bts)
+}
+
+func TestWsConnection_WriteReaderFailure(t *testing.T) {
+	var received atomic.Int32
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			received.Add(1)
+		}
+	})
+	conn, _ := newTestWsConnection(t, srv)
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	errSource := errors.New("source failed")
+	err := conn.WriteReader(TextMessage, &patternReader{n: 1 << 20, err: errSource})
+	require.ErrorIs(t, err, errSource)
+
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	require.ErrorIs(t, conn.CloseErr(), errSource)
+	require.Zero(t, received.Load(), "the partial payload was taken for a message")
+
+	require.ErrorIs(t, conn.WriteReader(PingMessage, &patternReader{}), ErrInvalidOutbound)
+	require.ErrorIs(t, conn.WriteReader(TextMessage, &patternReader{}), ErrConnectionClosed)
+}
+
+// newTestStreamMessage returns a StreamMessage of payload as read by a connection, along with a function telling
+// whether the connection would read the next frame.
+func newTestStreamMessage(payload string) (*StreamMessage, func() bool) {
+	m := newStreamMessage(BinaryMessage, nil, func() {})
+	m.r = &frameReader{m: m, r: strings.NewReader(payload)}
+
+	return m, func() bool { return isClosed(m.done) }
+}
+
+func TestStreamMessage_ConsumedByLayers(t *testing.T) {
+	cli := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	defer cli.Close()
+
+	tests := []struct {
+		name string
+		// handle passes m through the layer, which does not deliver it
+		handle func(t *testing.T, m Message)
+	}{
+		{
+			name: "shed",
+			handle: func(t *testing.T, m Message) {
+				s := NewOverloadShedder(func(Message) Class { return "ticker" }, map[Class]rate.Limit{"ticker": 1}, nil)
+				handle := s.Wrap(func(Client, Message) {})
+				handle(cli, NewBinaryMessage(nil))
+				handle(cli, m)
+				require.EqualValues(t, 1, s.Shed()["ticker"])
+			},
+		},
+		{
+			name: "stale",
+			handle: func(t *testing.T, m Message) {
+				g := NewFreshnessGuard(func(Message) (time.Time, bool) { return time.Unix(0, 0), true }, time.Minute, nil)
+				g.Wrap(func(Client, Message) { t.Fatal("stale message delivered") })(cli, m)
+			},
+		},
+		{
+			name: "not primary",
+			handle: func(t *testing.T, m Message) {
+				stubs := &stubConnectionHandlerFactory{}
+				h := &hotStandbyConnectionHandler{
+					connHandlerFactory: stubs.Factory,
+					handler:            func(Client, Message) { t.Fatal("standby message delivered") },
+				}
+				h.newSlot()
+				stubs.Last().Deliver(m)
+			},
+		},
+		{
+			name: "replaced connection",
+			handle: func(t *testing.T, m Message) {
+				s := NewSeamlessRotation(func(Message) (uint64, bool) { return 0, false })
+				s.bind(func(Client, Message) { t.Fatal("message of a replaced connection delivered") })
+				replaced := s.direct()
+				s.direct()
+				replaced(cli, m)
+			},
+		},
+		{
+			name: "buffered while rotating",
+			handle: func(t *testing.T, m Message) {
+				// The message of the new connection is a few sequences ahead of the old one.
+				s := NewSeamlessRotation(func(got Message) (uint64, bool) {
+					if got == m {
+						return 12, true
+					}
+					return 10, true
+				})
+				s.bind(func(Client, Message) {})
+				s.direct()(cli, NewBinaryMessage(nil))
+				pending, _ := s.overlap()
+				pending(cli, m)
+				require.Len(t, s.buffer, 1)
+				require.Equal(t, "stream", string(s.buffer[0].m.Data()))
+			},
+		},
+		{
+			name: "closed decode pipeline",
+			handle: func(t *testing.T, m Message) {
+				p := NewOrderedDecodePipeline(func([]byte) (int, error) { return 0, nil }, 1, func(int) {})
+				p.Close()
+				p.Handle(cli, m)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, consumed := newTestStreamMessage("stream")
+			tt.handle(t, m)
+			require.True(t, consumed(), "the connection stalls on the message")
+		})
+	}
+}
+
+func TestMessageStats_StreamMessage(t *testing.T) {
+	stats := newMessageStats(func(Message) string { return "snapshot" }, defaultMaxMessageClasses, newFakeClock())
+	m, consumed := newTestStreamMessage("stream")
+
+	stats.observe(m)
+	require.False(t, consumed(), "the payload was read into memory")
+	require.Equal(t, uint64(1), stats.snapshot()["snapshot"].Count)
+	require.Zero(t, stats.snapshot()["snapshot"].Bytes)
+}
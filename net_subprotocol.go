@@ -0,0 +1,52 @@
+package libws
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/fasthttp/websocket"
+)
+
+// WithSubprotocols makes the connection offer the given subprotocols in the handshake, in order of preference, e.g.
+// graphql-transport-ws or mqtt, unless the connection params set their own, see OpenConnectionParams.Subprotocols.
+// The server picking none of them fails the dial with ErrSubprotocolNotNegotiated. The one picked is told by
+// WsConnection.Subprotocol and ConnectionInfo.Subprotocol.
+func WithSubprotocols(protocols ...string) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.subprotocols = protocols
+	}
+}
+
+// Subprotocol returns the subprotocol the server picked in the handshake, empty if none was offered or the
+// connection has not been opened.
+func (w *WsConnection) Subprotocol() string {
+	return w.Info().Subprotocol
+}
+
+// subprotocolsFor returns the subprotocols to offer when dialing with p, nil if none.
+func (w *WsConnection) subprotocolsFor(p OpenConnectionParams) []string {
+	if len(p.Subprotocols) > 0 {
+		return p.Subprotocols
+	}
+	if len(w.subprotocols) > 0 {
+		return w.subprotocols
+	}
+	return nil
+}
+
+// checkSubprotocol fails with ErrSubprotocolNotNegotiated unless conn was accepted with one of the subprotocols
+// offered, if any.
+func checkSubprotocol(conn *websocket.Conn, offered []string) error {
+	if len(offered) == 0 {
+		return nil
+	}
+
+	switch picked := conn.Subprotocol(); {
+	case picked == "":
+		return fmt.Errorf("%w: none of %q accepted", ErrSubprotocolNotNegotiated, offered)
+	case !slices.Contains(offered, picked):
+		return fmt.Errorf("%w: %q picked, %q offered", ErrSubprotocolNotNegotiated, picked, offered)
+	default:
+		return nil
+	}
+}
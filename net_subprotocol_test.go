@@ -0,0 +1,114 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newSubprotocolTestServer returns a server accepting the given subprotocols, in order of preference of the client, or
+// picking picked regardless of the ones offered when set.
+func newSubprotocolTestServer(t *testing.T, picked string, accepted ...string) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{Subprotocols: accepted}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var header http.Header
+		if picked != "" {
+			header = http.Header{"Sec-Websocket-Protocol": {picked}}
+		}
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serveEcho(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestWsConnection_Subprotocols(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted []string
+		picked   string
+		// option is offered with WithSubprotocols, params in the connection params
+		option, params []string
+		want           string
+		wantErr        error
+	}{
+		{
+			name:     "negotiated",
+			accepted: []string{"mqtt", "graphql-transport-ws"},
+			option:   []string{"graphql-transport-ws", "mqtt"},
+			want:     "graphql-transport-ws",
+		},
+		{
+			name:     "params override the option",
+			accepted: []string{"mqtt", "graphql-transport-ws"},
+			option:   []string{"graphql-transport-ws"},
+			params:   []string{"mqtt"},
+			want:     "mqtt",
+		},
+		{
+			name:     "none offered",
+			accepted: []string{"mqtt"},
+		},
+		{
+			name:     "refused",
+			accepted: []string{"mqtt"},
+			option:   []string{"graphql-transport-ws"},
+			wantErr:  ErrSubprotocolNotNegotiated,
+		},
+		{
+			name:    "not offered picked",
+			picked:  "mqtt",
+			params:  []string{"graphql-transport-ws"},
+			wantErr: ErrSubprotocolNotNegotiated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newSubprotocolTestServer(t, tt.picked, tt.accepted...)
+			u := testWsURL(t, srv)
+			repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+				return OpenConnectionParams{URL: u, Subprotocols: tt.params}, nil
+			})
+
+			var opts []WsConnectionOption
+			if tt.option != nil {
+				opts = append(opts, WithSubprotocols(tt.option...))
+			}
+			recv := make(chan Message, 8)
+			conn := NewWebsocketConnection(websocket.DefaultDialer, repo, newTestLogger(io.Discard), recv, ErrorAdapters{}, opts...)
+			defer conn.Close()
+
+			err := conn.Open(context.Background())
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				require.NotErrorIs(t, err, ErrCannotConnect)
+				_, ok := conn.Control().LatestInfo()
+				require.False(t, ok)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, tt.want, conn.Subprotocol())
+			info, ok := conn.Control().LatestInfo()
+			require.True(t, ok)
+			require.Equal(t, tt.want, info.Subprotocol)
+
+			require.NoError(t, conn.Write(NewTextMessage([]byte("subscribe"))))
+			require.Equal(t, "subscribe", string((<-recv).Data()))
+		})
+	}
+}
@@ -1,18 +1,23 @@
 package libws
 
 import (
+	"crypto/tls"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"context"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/pkg/errors"
 
 	"github.com/fasthttp/websocket"
+	"golang.org/x/time/rate"
 )
 
 type (
@@ -23,28 +28,153 @@ type (
 	OpenConnectionParams struct {
 		URL    url.URL
 		Header http.Header
+		// NetDial, when set, establishes the underlying connection instead of dialing the URL host, e.g. to reach a
+		// local sidecar over a Unix domain socket with UnixSocketDial. The handshake still uses the URL, Host
+		// header included.
+		NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+		// TLSConfig, when set, overrides the TLS config of the dialer. Params being fetched on every dial, it is the
+		// way to handshake with a rotated client certificate, see WithCertExpiryRotation.
+		TLSConfig *tls.Config
+		// HandshakeTimeout, when positive, overrides the handshake timeout of the dialer.
+		HandshakeTimeout time.Duration
+		// Proxy, when set, overrides the proxy of the dialer, which NetDial then dials, if set.
+		Proxy func(*http.Request) (*url.URL, error)
+		// ProxyURL, when set, tunnels the connection through the proxy at that URL, http://, https:// or socks5://,
+		// authenticating with its user info, if any. It takes precedence over Proxy. Failures to establish the
+		// tunnel are wrapped with ErrProxy rather than ErrCannotConnect, the backoff layer waiting as computed then
+		// instead of reconnecting right away.
+		ProxyURL *url.URL
+		// Subprotocols, when set, are the subprotocols offered in the handshake, in order of preference, in place of
+		// the ones set with WithSubprotocols. The server picking none of them fails the dial with
+		// ErrSubprotocolNotNegotiated.
+		Subprotocols []string
 	}
 
 	ErrAdapter func(*websocket.Conn, *http.Response, error) error
 
+	// ErrorAdapters translate connection errors, e.g. into the errors of a venue. Unset adapters leave errors as is.
 	ErrorAdapters struct {
 		OnDial ErrAdapter
+		// OnRead translates the close reason of a connection whose read failed, before it is recorded, see CloseErr.
+		// The peer closing it is told by a *CloseCodeError. Returning an ErrUnrecoverableConnection stops reconnecting.
+		OnRead func(error) error
+		// OnWrite translates the close reason of a connection which failed writing the message, before it is
+		// recorded, see CloseErr.
+		OnWrite func(Message, error) error
+	}
+
+	// ConnectionControl is the runtime-control handle of the websocket connections built by a factory. Retrieve it
+	// with Handle[*ConnectionControl](client) once the client was opened with NewBaseConnectionHandlerFactory, or
+	// from WsConnection.Control. Changes take effect on subsequent dials and errors, reconnections included.
+	ConnectionControl struct {
+		errAdapters atomic.Pointer[ErrorAdapters]
+		// info describes the latest connection established, see LatestInfo
+		info atomic.Pointer[ConnectionInfo]
+		// writeUncompressed disables write compression where negotiated, see SetWriteCompression
+		writeUncompressed atomic.Bool
+
+		dialMu sync.Mutex
+		// failedDials counts the consecutive failed dials since the last successful one
+		failedDials int
+		lastDial    dialRecord
+	}
+
+	// dialRecord describes the latest dial of a connection factory.
+	dialRecord struct {
+		url     string // redacted
+		attempt int
+	}
+
+	// WsConnectionOption customizes a WsConnection.
+	WsConnectionOption func(*WsConnection)
+
+	// RedirectSigner updates the params of a redirected handshake before it is dialed, e.g. to sign the new path.
+	RedirectSigner func(OpenConnectionParams) (OpenConnectionParams, error)
+
+	// ConnectionInfo describes an established connection.
+	ConnectionInfo struct {
+		// URL is the URL finally dialed, after following redirects.
+		URL url.URL
+		// Redirects is the number of redirects followed to reach URL.
+		Redirects int
+		// RemoteAddr is the address the connection was established to, once resolved or pinned.
+		RemoteAddr string
+		// Compressed tells whether per-message compression was negotiated, see WithCompression.
+		Compressed bool
+		// ReadBufferSize and WriteBufferSize are the sizes of the buffers of the connection, see WithReadBufferSize and
+		// WithWriteBufferSize.
+		ReadBufferSize  int
+		WriteBufferSize int
+		// Handshake is the response to the opening handshake, e.g. to read the headers or cookies the server set, see
+		// WsConnection.HandshakeResponse.
+		Handshake *http.Response
+		// Subprotocol is the subprotocol the server picked, empty if none was offered, see WithSubprotocols.
+		Subprotocol string
+		// Generation is the generation of the connection, the one the messages read on it carry, see MetaMessage. It
+		// is zero when the client does not number its connections.
+		Generation uint64
 	}
 
 	// WsConnection represents a WebSocket connection.
 	// It implements the Connection interface.
 	WsConnection struct {
-		errAdapters              ErrorAdapters
+		control                  *ConnectionControl
 		openConnectionParamsRepo openConnectionParamsRepo
 		logger                   logger
 		dialer                   *websocket.Dialer
 		conn                     *websocket.Conn
+		connMu                   sync.Mutex // connMu orders the assignment of conn with a concurrent close
 		closeChan                CloseChan
 		closeOnce                sync.Once
+		stopC                    chan struct{} // stopC stops the read and write loops, closeChan is closed once both exited
+		loops                    sync.WaitGroup
 		closeReason              error
-		closeReasonOnce          sync.Once
+		closeReasonAt            time.Time
+		closeReasonMu            sync.Mutex
+		connCtx                  context.Context
+		connCancel               context.CancelFunc
+		closeCodes               CloseCodeTable
+		maxRedirects             int
+		redirectSigner           RedirectSigner
+		resolver                 *net.Resolver
+		pinnedAddrs              map[string]string
+		closeEcho                bool
+		writeTimeout             time.Duration
+		maxOutboundSize          int
+		maxMessageSize           int64
+		maxOversizeSkips         int
+		oversizeSkips            atomic.Int64
+		stats                    connStats
+		latency                  latencyTracker
+		readSeq                  uint64 // readSeq numbers the data messages read, see SequencedMessage
+		streaming                bool   // streaming delivers data messages as StreamMessages, see WithStreaming
+		sendBufferSize           int
+		slowConsumer             SlowConsumerPolicy
+		idleReadTimeout          time.Duration
+		compression              bool // compression negotiates per-message compression, see WithCompression
+		compressionLevel         int
+		compressionDecider       func(Message) bool
+		readBufferSize           int
+		writeBufferSize          int
+		subprotocols             []string      // subprotocols are offered in the handshake, see WithSubprotocols
+		inbound                  *inboundQueue // inbound queues the messages for the consumer under SlowConsumerDropOldest
+		inboundDropped           atomic.Int64
+		sendDropped              atomic.Int64
+		emitter                  emitter[EventType, EventType]
+		closeSent                atomic.Bool // closeSent tells whether a close frame was written, echoed or ours
+		closing                  atomic.Bool // closing refuses writes once a graceful close started
+		info                     atomic.Pointer[ConnectionInfo]
 		recv                     chan<- Message // recv messages to be received over the wire
+		controlRecv              chan<- Message // controlRecv, when bound, receives the control frames in place of recv
+		generate                 func() uint64  // generate, when bound, allocates the generation of the connection once open
 		send                     chan Message   // send messages to be sent over the wire
+		sendControl              chan Message   // sendControl, under WithWriteRateLimit, pings and pongs overtaking paced writes
+		writeLimiter             *rate.Limiter  // writeLimiter paces data messages, see WithWriteRateLimit
+		writeDelayThreshold      time.Duration
+		writeClock               clock
+		delayedWrites            atomic.Int64
+		writeStallTimeout        time.Duration // writeStallTimeout, when positive, bounds a write without progress
+		writeWatch               writeWatch
 	}
 )
 
@@ -52,21 +182,159 @@ var (
 	NoopOpenConnectionParams = OpenConnectionParams{}
 )
 
+// defaultWriteTimeout bounds the write of every frame, see WithWriteTimeout.
+const defaultWriteTimeout = time.Second
+
+// closeEchoTimeout bounds the write of the close frame echoing a peer close.
+const closeEchoTimeout = time.Second
+
+// closeReplyTimeout bounds the wait for the peer to reply to a close frame of ours, see WsConnection.CloseWithReason.
+const closeReplyTimeout = time.Second
+
+// maxDialErrorBody bounds the part of a rejected handshake response body kept by DialHTTPError.
+const maxDialErrorBody = 4 << 10
+
+// closeReasonWindow is the time during which close reasons racing the first one found are considered.
+const closeReasonWindow = 100 * time.Millisecond
+
 func NewWebsocketConnection(
 	dialer *websocket.Dialer,
 	openParamsRepo OpenConnectionParamsRepo,
 	logger logger,
 	recvChan chan<- Message,
 	errorHandlers ErrorAdapters,
+	opts ...WsConnectionOption,
 ) *WsConnection {
-	return &WsConnection{
-		errAdapters:              errorHandlers,
+	w := &WsConnection{
+		control:                  newConnectionControl(errorHandlers),
 		dialer:                   dialer,
 		openConnectionParamsRepo: openParamsRepo,
 		recv:                     recvChan,
-		send:                     make(chan Message),
 		closeChan:                make(CloseChan),
-		logger:                   logger.WithField("net", "ws_connection"),
+		stopC:                    make(chan struct{}),
+		closeEcho:                true,
+		writeTimeout:             defaultWriteTimeout,
+		writeDelayThreshold:      defaultWriteDelayThreshold,
+		writeClock:               realClock{},
+		logger:                   orNop(logger).WithField("net", "ws_connection"),
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.send = make(chan Message, w.sendBufferSize)
+	if w.writeLimiter != nil {
+		w.sendControl = make(chan Message, w.sendBufferSize)
+	}
+	if w.writeStallTimeout == 0 {
+		w.writeStallTimeout = writeStallFactor * w.writeTimeout
+	}
+	if w.compressionDecider == nil {
+		w.compressionDecider = compressLarger
+	}
+
+	return w
+}
+
+// WithIdleReadTimeout closes the connection with ErrReadIdleTimeout once no frame at all, control frames included,
+// was read for d, as some peers stop sending without closing. The backoff layer reconnects right away then. It is
+// disabled by default.
+func WithIdleReadTimeout(d time.Duration) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.idleReadTimeout = d
+	}
+}
+
+// WithSendBufferSize buffers up to n messages between Write and the write loop, so that bursts of writes, e.g.
+// subscriptions replayed after a reconnection, do not wait for each other to be written. Writes are unbuffered by
+// default. Messages still buffered when the connection closes are never sent: they are counted, see SendDropped.
+func WithSendBufferSize(n int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.sendBufferSize = max(n, 0)
+	}
+}
+
+// WithFollowRedirects makes the handshake follow up to maxHops 3xx responses carrying a Location header, keeping the
+// original headers. Dialing fails with ErrRedirectLoop when a URL is visited twice, and with ErrTooManyRedirects past
+// maxHops. Redirects are not followed by default.
+func WithFollowRedirects(maxHops int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.maxRedirects = maxHops
+	}
+}
+
+// WithRedirectSigner sets a callback updating the params of every redirected handshake, for signatures covering the
+// URL.
+func WithRedirectSigner(signer RedirectSigner) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.redirectSigner = signer
+	}
+}
+
+// WithResolver makes the connection resolve the host it dials with r rather than the default resolver, e.g. one
+// bypassing a caching resolver. Hosts pinned with WithPinnedAddrs are not resolved.
+func WithResolver(r *net.Resolver) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.resolver = r
+	}
+}
+
+// WithPinnedAddrs pins hosts to known addresses, bypassing resolution. Keys are either hosts or host:port pairs, the
+// latter taking precedence, and values are ip:port addresses, or bare IPs keeping the dialed port.
+func WithPinnedAddrs(addrs map[string]string) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.pinnedAddrs = addrs
+	}
+}
+
+// WithMaxWriteSize makes Write refuse messages whose payload exceeds n bytes with an error wrapping
+// ErrMessageTooLarge, so that they are never written. Control frames are not limited. See also WithMaxOutboundSize,
+// enforcing the limit in the client.
+func WithMaxWriteSize(n int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.maxOutboundSize = n
+	}
+}
+
+// WithMaxMessageSize limits the size of inbound messages to n bytes, which are not limited by default. A larger message
+// closes the connection with an error wrapping both ErrMessageTooLarge and ErrConnectionClosed, so that it is
+// reconnected as any closed connection, unless recovering, see WithOversizeRecovery.
+func WithMaxMessageSize(n int64) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.maxMessageSize = n
+	}
+}
+
+// WithOversizeRecovery makes the connection skip inbound messages over the WithMaxMessageSize limit, draining them
+// from the wire, rather than closing, up to maxSkips times per connection. Every skip is counted, see OversizeSkips,
+// and emitted as EventOversizeSkipped.
+func WithOversizeRecovery(maxSkips int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.maxOversizeSkips = maxSkips
+	}
+}
+
+// WithWriteTimeout bounds the write of every frame, data and control ones alike, to d, one second by default. A write
+// timing out closes the connection. Zero disables the deadline.
+func WithWriteTimeout(d time.Duration) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.writeTimeout = d
+	}
+}
+
+// WithoutCloseEcho disables echoing the close frame of the peer. By default, as RFC 6455 requires, a close frame
+// received from the peer is answered with one carrying the same code before the socket is closed.
+func WithoutCloseEcho() WsConnectionOption {
+	return func(w *WsConnection) {
+		w.closeEcho = false
+	}
+}
+
+// WithCloseCodeTable makes the connection classify close frames carrying codes found in table, falling back to the
+// RFC 6455 codes otherwise. The resulting CloseErr is a *CloseCodeError.
+func WithCloseCodeTable(table CloseCodeTable) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.closeCodes = table
 	}
 }
 
@@ -75,7 +343,11 @@ func NewWebsocketFactory(
 	dialer *websocket.Dialer,
 	openConnectionParamsRepo OpenConnectionParamsRepo,
 	errorHandlers ErrorAdapters,
+	opts ...WsConnectionOption,
 ) ConnectionFactory {
+	control := newConnectionControl(errorHandlers)
+	opts = append(opts[:len(opts):len(opts)], withConnectionControl(control))
+
 	return func(ctx context.Context, recvChan chan<- Message) Connection {
 		return NewWebsocketConnection(
 			dialer,
@@ -83,14 +355,183 @@ func NewWebsocketFactory(
 			logger,
 			recvChan,
 			errorHandlers,
+			opts...,
 		)
 	}
 }
 
-// Write sends a message over the WebSocket connection.
+// withConnectionControl makes the connection share control, so that changes outlive the connection.
+func withConnectionControl(control *ConnectionControl) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.control = control
+	}
+}
+
+func newConnectionControl(adapters ErrorAdapters) *ConnectionControl {
+	c := &ConnectionControl{}
+	c.SetErrorAdapters(adapters)
+	return c
+}
+
+// beginDial returns the attempt number of a dial about to start.
+func (c *ConnectionControl) beginDial() int {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	return c.failedDials + 1
+}
+
+// endDial records the outcome of the dial of u started as attempt.
+func (c *ConnectionControl) endDial(u url.URL, attempt int, err error) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	c.lastDial = dialRecord{url: RedactURL(u), attempt: attempt}
+	if err != nil {
+		c.failedDials++
+		return
+	}
+	c.failedDials = 0
+}
+
+// latestDial returns the latest dial recorded, if any.
+func (c *ConnectionControl) latestDial() (dialRecord, bool) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	return c.lastDial, c.lastDial.attempt > 0
+}
+
+// LatestInfo describes the latest connection established by the connection factory, if any, e.g. to tell whether it
+// negotiated compression.
+func (c *ConnectionControl) LatestInfo() (ConnectionInfo, bool) {
+	if info := c.info.Load(); info != nil {
+		return *info, true
+	}
+	return ConnectionInfo{}, false
+}
+
+// SetErrorAdapters replaces the adapters classifying connection errors.
+func (c *ConnectionControl) SetErrorAdapters(adapters ErrorAdapters) {
+	c.errAdapters.Store(&adapters)
+}
+
+// ErrorAdapters returns the adapters currently classifying connection errors.
+func (c *ConnectionControl) ErrorAdapters() ErrorAdapters {
+	return *c.errAdapters.Load()
+}
+
+// Write sends a message over the WebSocket connection, text messages as text frames and binary ones as binary frames.
+// Synthetic messages are refused with an error wrapping ErrSyntheticMessage, other types that cannot be written with
+// one wrapping ErrInvalidOutbound, and ErrConnectionClosed is returned rather than blocking once the connection is
+// closing.
 func (w *WsConnection) Write(m Message) error {
-	w.send <- m
-	return nil
+	return w.sendContext(context.Background(), m)
+}
+
+// TrySend writes m as Write does, unless the write loop cannot take it right away, see WithSendBufferSize, in which
+// case it returns false.
+func (w *WsConnection) TrySend(m Message) bool {
+	return w.sendContext(doneContext(), m) == nil
+}
+
+// SendTimeout writes m as Write does, returning ErrBackpressure when the write loop did not take it within d.
+func (w *WsConnection) SendTimeout(m Message, d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	return w.sendContext(ctx, m)
+}
+
+// sendContext writes m, giving up with ErrBackpressure once ctx is done, see contextSender.
+func (w *WsConnection) sendContext(ctx context.Context, m Message) error {
+	if err := checkWritable(m); err != nil {
+		return err
+	}
+
+	if err := checkOutboundSize(m, w.maxOutboundSize); err != nil {
+		return err
+	}
+
+	// With a buffer, the send could be picked even though the connection is closing.
+	if isClosed(w.stopC) || w.closing.Load() {
+		return ErrConnectionClosed
+	}
+
+	send := w.send
+	if w.sendControl != nil && (m.Type().IsPing() || m.Type().IsPong()) {
+		send = w.sendControl
+	}
+
+	// Done contexts get a chance to send, rather than racing the send.
+	select {
+	case send <- m:
+		return nil
+	default:
+	}
+
+	select {
+	case send <- m:
+		return nil
+	case <-w.stopC:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ErrBackpressure
+	}
+}
+
+// SendDropped returns how many messages accepted by Write were dropped unsent as the connection closed, which only
+// happens with WithSendBufferSize.
+func (w *WsConnection) SendDropped() int {
+	return int(w.sendDropped.Load())
+}
+
+// OversizeSkips returns how many inbound messages over the size limit were skipped, see WithOversizeRecovery.
+func (w *WsConnection) OversizeSkips() int {
+	return int(w.oversizeSkips.Load())
+}
+
+// bindControlRecv makes the connection deliver the control frames it reads to c rather than along with data messages,
+// so that they can be handled ahead of queued data.
+func (w *WsConnection) bindControlRecv(c chan<- Message) {
+	w.controlRecv = c
+}
+
+// deliverControl delivers the control frame m read from the wire without stalling the read loop: when there is no
+// room for it, it is handed over by a goroutine of its own, as soon as there is, unless the connection closes first.
+func (w *WsConnection) deliverControl(m Message) {
+	recv := w.recv
+	if w.controlRecv != nil {
+		recv = w.controlRecv
+	}
+
+	select {
+	case recv <- m:
+	default:
+		go func() {
+			select {
+			case recv <- m:
+			case <-w.stopC:
+			}
+		}()
+	}
+}
+
+// bindEmitter makes the connection emit its events through e.
+func (w *WsConnection) bindEmitter(e emitter[EventType, EventType]) {
+	w.emitter = e
+}
+
+// bindGeneration makes the connection allocate its generation with generate once open, before EventConnect, see
+// ConnectionInfo.Generation.
+func (w *WsConnection) bindGeneration(generate func() uint64) {
+	w.generate = generate
+}
+
+// Control returns the runtime-control handle of the connection, shared with every connection built by the same
+// factory.
+func (w *WsConnection) Control() *ConnectionControl {
+	return w.control
 }
 
 // Close terminates the WebSocket connection.
@@ -99,6 +540,66 @@ func (w *WsConnection) Close() {
 	w.safeClose()
 }
 
+// CloseWithErr closes the connection as Close does, with err as the close reason rather than ErrTerminated, unless
+// it was closed already.
+func (w *WsConnection) CloseWithErr(err error) {
+	w.closeOnce.Do(func() {
+		w.setCloseReason(err)
+		w.close()
+	})
+}
+
+// CloseWithReason closes the connection gracefully: a close frame carrying code and reason is written once the
+// messages being sent were, then the reply of the peer is awaited, for a second at most, before the connection is torn
+// down. It returns once the connection is closed, or ErrConnectionClosed right away when it already was. Reasons over
+// 123 bytes, which do not fit a close frame, are refused with an error wrapping ErrInvalidOutbound.
+func (w *WsConnection) CloseWithReason(code int, reason string) error {
+	m := NewCloseMessage(code, []byte(reason))
+	if _, _, ok := ToWireFrame(m); !ok {
+		return fmt.Errorf("%w: close reason of %d bytes does not fit a close frame", ErrInvalidOutbound, len(reason))
+	}
+
+	return w.closeGracefully(context.Background(), m)
+}
+
+// CloseGraceful closes the connection as CloseWithReason does with a normal closure, once the messages queued were
+// written: writes are refused with ErrConnectionClosed meanwhile. Should ctx be done first, e.g. as the queue drains
+// slowly under WithWriteRateLimit, the connection is torn down right away and the error of ctx returned. Close, by
+// contrast, discards the messages queued.
+func (w *WsConnection) CloseGraceful(ctx context.Context) error {
+	return w.closeGracefully(ctx, NewCloseMessage(websocket.CloseNormalClosure, nil))
+}
+
+// closeGracefully queues the close frame m behind the messages being sent, refusing new ones, then waits for the
+// connection to close, tearing it down once ctx is done.
+func (w *WsConnection) closeGracefully(ctx context.Context, m Message) error {
+	if w.openedConn() == nil {
+		w.safeClose()
+		return ErrConnectionClosed
+	}
+
+	w.closing.Store(true)
+
+	select {
+	case w.send <- m:
+	case <-w.stopC:
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		w.safeClose()
+		<-w.closeChan
+		return ctx.Err()
+	}
+
+	select {
+	case <-w.closeChan:
+		return nil
+	case <-ctx.Done():
+		w.safeClose()
+		<-w.closeChan
+		return ctx.Err()
+	}
+}
+
 // Open initiates the WebSocket connection.
 // This method is blocking and returns when the connection is successfully established or an error occurs.
 func (w *WsConnection) Open(ctx context.Context) error {
@@ -111,146 +612,516 @@ func (w *WsConnection) CloseChan() CloseChan {
 	return w.closeChan
 }
 
-// CloseErr returns an error that explains why the WebSocket connection was closed.
-// If the connection closed normally, CloseErr should return nil.
+// CloseErr returns an error that explains why the WebSocket connection was closed, nil while open. It is safe to call
+// concurrently with the connection tearing down, but only final once CloseChan is closed: a close reason racing the
+// first one found may still replace it until then.
 func (w *WsConnection) CloseErr() error {
+	w.closeReasonMu.Lock()
+	defer w.closeReasonMu.Unlock()
+
 	return w.closeReason
 }
 
+// Info describes the connection once open.
+func (w *WsConnection) Info() ConnectionInfo {
+	if info := w.info.Load(); info != nil {
+		return *info
+	}
+	return ConnectionInfo{}
+}
+
+// HandshakeResponse returns the response to the opening handshake, headers, status and cookies included, without its
+// body. It returns nil if the connection has not been opened.
+func (w *WsConnection) HandshakeResponse() *http.Response {
+	return w.Info().Handshake
+}
+
+// handshakeResponse copies resp, leaving the body and the request it answered out.
+func handshakeResponse(resp *http.Response) *http.Response {
+	if resp == nil {
+		return nil
+	}
+
+	return &http.Response{
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		ProtoMajor: resp.ProtoMajor,
+		ProtoMinor: resp.ProtoMinor,
+		Header:     resp.Header.Clone(),
+		Body:       http.NoBody,
+		TLS:        resp.TLS,
+	}
+}
+
+// ConnContext returns a context created when the connection was dialed and cancelled when it closes.
+// It returns nil if the connection has not been opened.
+func (w *WsConnection) ConnContext() context.Context {
+	return w.connCtx
+}
+
 func (w *WsConnection) start(ctx context.Context) error {
 	p, err := w.openConnectionParamsRepo.Get(ctx)
 
 	if err != nil {
 		w.logger.Errorf("cannot get connection params due to %s: ", err)
-		return err
+		return fmt.Errorf("%w: %w", ErrParamsUnavailable, err)
 	}
+	ReportOpenProgress(ctx, OpenParamsFetched, RedactURL(p.URL))
 
-	conn, resp, err := w.dialer.Dial(p.URL.String(), p.Header)
-
-	if err = w.handleDialError(conn, resp, err); err != nil {
-		w.logger.Errorf("connection err to %s: %s, %+v", p.URL.String(), err, resp)
+	attempt := w.control.beginDial()
+	ReportOpenProgress(ctx, OpenDialing, fmt.Sprintf("attempt %d", attempt))
+	conn, resp, p, redirects, err := w.dial(ctx, p)
+	w.control.endDial(p.URL, attempt, err)
+	if r, ok := w.openConnectionParamsRepo.(dialReporter); ok {
+		r.reportDial(err)
+	}
+	if err != nil {
+		if cause := dialAborted(ctx); cause != nil {
+			err = fmt.Errorf("%w: dial aborted: %w", ErrTerminated, cause)
+		}
+	}
+	if err != nil {
+		err = &DialError{URL: RedactURL(p.URL), Attempt: attempt, Err: err}
+		w.logger.Errorf("connection err: %s", err)
 		return err
 	}
 
-	w.logger.Debugf("success opening connection to %s", p.URL.String())
-
+	w.connMu.Lock()
+	if isClosed(w.stopC) {
+		// Closed while dialing: nothing would ever close the connection.
+		w.connMu.Unlock()
+		_ = conn.Close()
+		return errors.Wrap(ErrConnectionClosed, "closed while opening")
+	}
+	var generation uint64
+	if w.generate != nil {
+		generation = w.generate()
+	}
 	w.conn = conn
+	w.connCtx, w.connCancel = context.WithCancel(withConnGeneration(ctx, generation))
+	// The loops are counted along with conn being set: a concurrent close waits for them from then on, even the ones
+	// not started yet, which exit right away once started.
+	w.loops.Add(w.loopCount())
+	w.connMu.Unlock()
+	w.stats.connectedAt.Store(time.Now().UnixNano())
+	ReportOpenProgress(ctx, OpenConnected, conn.RemoteAddr().String())
+
+	w.logger.Debugf("success opening connection to %s (attempt %d)", RedactURL(p.URL), attempt)
+
+	info := &ConnectionInfo{
+		URL:         p.URL,
+		Redirects:   redirects,
+		RemoteAddr:  conn.RemoteAddr().String(),
+		Compressed:  negotiatedCompression(resp),
+		Handshake:   handshakeResponse(resp),
+		Subprotocol: conn.Subprotocol(),
+		Generation:  generation,
+	}
+	w.latency.generation = generation
+	info.ReadBufferSize, info.WriteBufferSize = w.bufferSizes()
+	w.info.Store(info)
+	w.control.info.Store(info)
+
+	// Recovering from oversized messages requires enforcing the limit ourselves: the websocket library fails the
+	// connection for good once its own limit is exceeded.
+	if w.maxMessageSize > 0 && w.maxOversizeSkips == 0 {
+		conn.SetReadLimit(w.maxMessageSize)
+	}
+	w.applyCompressionLevel()
 
 	// Override control message handlers to gain full control over 'control' frames, as
 	// some exchange rate-limit its reception as well.
 	conn.SetPingHandler(func(appData string) error {
-		w.logger.Debugln("<= [PING]")
-		w.recv <- NewPingMessage([]byte(appData))
+		w.stats.read(len(appData), time.Now())
+		w.extendReadDeadline()
+		w.logInbound(PingMessage, nil)
+		w.deliverControl(NewPingMessage([]byte(appData)))
 		return nil
 	})
 
 	conn.SetPongHandler(func(appData string) error {
-		w.logger.Debugln("<= [PONG]")
-		w.recv <- NewPongMessage([]byte(appData))
+		now := time.Now()
+		w.stats.read(len(appData), now)
+		if _, ok := w.latency.received(appData, now); ok && w.emitter != nil {
+			w.emitter.Emit(EventLatencySample, EventLatencySample)
+		}
+		w.extendReadDeadline()
+		w.logInbound(PongMessage, nil)
+		w.deliverControl(NewPongMessage([]byte(appData)))
 		return nil
 	})
 
 	conn.SetCloseHandler(func(code int, text string) error {
-		w.logger.Debugln("<= [CLOSE]")
-		w.recv <- NewCloseMessage(code, []byte(text))
+		w.stats.read(len(text)+2, time.Now())
+		w.logInbound(CloseError, nil)
+		w.echoClose(code)
+		w.deliverControl(NewCloseMessage(code, []byte(text)))
 		return nil
 	})
 
+	if w.slowConsumer == SlowConsumerDropOldest {
+		w.inbound = newInboundQueue()
+		go w.forwardInbound()
+	}
+
+	if w.writeStallTimeout > 0 {
+		go w.watchWrites()
+	}
+
 	go w.read(ctx)
 	go w.write(ctx)
 
+	if w.emitter != nil {
+		w.emitter.Emit(EventConnect, EventConnect)
+	}
+
 	return nil
 }
 
+// loopCount returns the number of loops start runs: the read and write ones, and the ones of the options needing one.
+func (w *WsConnection) loopCount() int {
+	n := 2
+	if w.slowConsumer == SlowConsumerDropOldest {
+		n++
+	}
+	if w.writeStallTimeout > 0 {
+		n++
+	}
+	return n
+}
+
 func (w *WsConnection) read(ctx context.Context) {
+	defer w.loops.Done()
 	defer w.safeClose()
+	defer recoverPanic(ctx, w.logger, w.setCloseReason)
 
 	for {
 		select {
-		case <-w.closeChan:
+		case <-w.stopC:
 			w.setCloseReason(ErrTerminated)
 			return
 		case <-ctx.Done():
 			w.setCloseReason(ErrTerminated)
 			return
 		default:
-			messageType, bts, err := w.conn.ReadMessage()
-			if err != nil {
-				w.logger.Errorf("error occurred on websocket read: %s", err)
+			w.extendReadDeadline()
+			if w.streaming {
+				ok, err := w.readStream()
+				if err != nil {
+					w.readFailed(err)
+				}
+				if !ok {
+					return
+				}
+				continue
+			}
 
-				w.setCloseReason(errors.Wrap(
-					ErrConnectionClosed,
-					"error occurred on websocket read: "+err.Error(),
-				))
+			messageType, bts, err := w.readMessage()
+			if err != nil {
+				w.readFailed(err)
 				return
 			}
+			now := time.Now()
+			w.stats.read(len(bts), now)
 			// message types from ReadMessage are either binary or text
-			switch messageType {
-			case websocket.BinaryMessage:
-				w.logger.Debugln("<= [BIN]")
-				w.recv <- NewBinaryMessage(bts)
-			case websocket.CloseMessage:
-				w.logger.Debugln("<= [CLOSE]")
-				w.recv <- NewCloseMessage(messageType, bts)
+			m := FromWireFrame(messageType, bts)
+			switch {
+			case m == nil:
+				w.logger.Warnf("skipping frame of unsupported type %d", messageType)
+			case m.Type().IsClose():
+				w.logInbound(CloseError, bts)
+				w.recv <- withReceivedAt(m, now)
 			default:
-				w.logger.Debugf("<= [DATA] %s", string(bts))
-				w.recv <- NewDataMessage(bts)
+				w.logInbound(m.Type(), bts)
+				if !w.deliver(w.stamp(m, now)) {
+					return
+				}
 			}
 		}
 	}
 }
 
+// readFailed records why reading failed with err as the close reason.
+func (w *WsConnection) readFailed(err error) {
+	if errors.Is(err, net.ErrClosed) {
+		// We closed the connection, whoever did it already told why.
+		return
+	}
+
+	var (
+		netErr   net.Error
+		closeErr *websocket.CloseError
+		reason   error
+	)
+	switch {
+	case w.idleReadTimeout > 0 && errors.As(err, &netErr) && netErr.Timeout():
+		w.logger.Errorf("nothing read for %s, closing connection", w.idleReadTimeout)
+		reason = errors.Wrapf(ErrReadIdleTimeout, "nothing read for %s", w.idleReadTimeout)
+	case errors.Is(err, websocket.ErrReadLimit) || errors.Is(err, ErrMessageTooLarge):
+		w.logger.Errorf("error occurred on websocket read: %s", err)
+		reason = fmt.Errorf("%w: %w: inbound message over %d bytes", ErrConnectionClosed, ErrMessageTooLarge, w.maxMessageSize)
+	case errors.As(err, &closeErr):
+		w.logger.Errorf("error occurred on websocket read: %s", err)
+		w.echoClose(closeErr.Code)
+		reason = w.closeCodes.classify(closeErr.Code, closeErr.Text)
+	default:
+		w.logger.Errorf("error occurred on websocket read: %s", err)
+		reason = fmt.Errorf("%w: error occurred on websocket read: %w", ErrConnectionClosed, err)
+	}
+
+	if adapters := w.control.ErrorAdapters(); adapters.OnRead != nil {
+		reason = adapters.OnRead(reason)
+	}
+	w.setCloseReason(reason)
+}
+
+// stamp tags m, a data message just read, with when it was read and its position among those read on the
+// connection, see SequencedMessage. It is only called from the read loop.
+func (w *WsConnection) stamp(m Message, at time.Time) Message {
+	w.readSeq++
+	return withReadSeq(withReceivedAt(m, at), w.readSeq)
+}
+
+// extendReadDeadline pushes the read deadline back by the idle read timeout, if any, as a frame was read.
+func (w *WsConnection) extendReadDeadline() {
+	if w.idleReadTimeout > 0 {
+		_ = w.conn.SetReadDeadline(time.Now().Add(w.idleReadTimeout))
+	}
+}
+
+// readMessage reads the next message. Under WithOversizeRecovery, messages over the size limit are drained and
+// skipped, until too many were.
+func (w *WsConnection) readMessage() (int, []byte, error) {
+	if w.maxMessageSize <= 0 || w.maxOversizeSkips == 0 {
+		return w.conn.ReadMessage()
+	}
+
+	for {
+		messageType, r, err := w.conn.NextReader()
+		if err != nil {
+			return messageType, nil, err
+		}
+
+		bts, err := io.ReadAll(io.LimitReader(r, w.maxMessageSize+1))
+		if err != nil {
+			return messageType, nil, err
+		}
+		if int64(len(bts)) <= w.maxMessageSize {
+			return messageType, bts, nil
+		}
+
+		drained, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return messageType, nil, err
+		}
+
+		skips := w.oversizeSkips.Add(1)
+		if skips > int64(w.maxOversizeSkips) {
+			return messageType, nil, ErrMessageTooLarge
+		}
+
+		w.logger.Warnf("skipped inbound message of %d bytes, over the limit of %d (%d/%d skips)",
+			int64(len(bts))+drained, w.maxMessageSize, skips, w.maxOversizeSkips)
+		if w.emitter != nil {
+			w.emitter.Emit(EventOversizeSkipped, EventOversizeSkipped)
+		}
+	}
+}
+
 func (w *WsConnection) write(ctx context.Context) {
+	defer w.loops.Done()
 	defer w.safeClose()
+	defer recoverPanic(ctx, w.logger, w.setCloseReason)
 
 	for {
 		select {
-		case <-w.closeChan:
+		case <-w.stopC:
 			w.setCloseReason(ErrTerminated)
 			return
 		case <-ctx.Done():
 			w.setCloseReason(ErrTerminated)
 			return
+		case msg := <-w.sendControl:
+			if !w.writeFrame(ctx, msg) {
+				return
+			}
 		case msg, ok := <-w.send:
 			if !ok {
 				w.logger.Infoln("closing connection from our side")
+				w.closeSent.Store(true)
+				w.writeWatch.progress()
 				_ = w.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				w.writeWatch.done()
 				w.setCloseReason(ErrTerminated)
 				return
 			}
 
-			deadline := time.Now().Add(time.Second)
-			_ = w.conn.SetWriteDeadline(deadline)
+			if msg.Type().IsData() && w.writeLimiter != nil && !w.awaitWriteToken(ctx) {
+				return
+			}
+			if !w.writeFrame(ctx, msg) {
+				return
+			}
+		}
+	}
+}
 
-			var err error
+// writeFrame writes msg, returning false once the write loop must stop, the close reason being recorded.
+func (w *WsConnection) writeFrame(ctx context.Context, msg Message) bool {
+	var deadline time.Time
+	if w.writeTimeout > 0 {
+		deadline = time.Now().Add(w.writeTimeout)
+	}
+	_ = w.conn.SetWriteDeadline(deadline)
 
-			switch msg.Type() {
-			case PingMessage:
-				w.logger.Debugln("=> [PING]")
-				err = w.conn.WriteControl(websocket.PingMessage, msg.Data(), deadline)
-				if e, ok := err.(net.Error); ok && e.Temporary() {
-					err = nil
-				}
-			case PongMessage:
-				w.logger.Debugln("=> [PONG]")
-				err = w.conn.WriteControl(websocket.PongMessage, msg.Data(), deadline)
-			case DataMessage:
-				w.logger.Infof("=> [DATA] %s", msg.Data())
-				err = w.conn.WriteMessage(websocket.TextMessage, msg.Data())
-			}
+	frameType, payload, ok := ToWireFrame(msg)
+	if !ok {
+		// Write refuses such messages, this is a bug.
+		w.logger.Warnf("not writing message of type %d, which cannot be written as a frame", msg.Type())
+		return true
+	}
 
-			if err != nil {
-				if websocket.IsCloseError(err,
-					websocket.CloseGoingAway,
-					websocket.CloseAbnormalClosure,
-				) {
-					w.setCloseReason(ErrConnectionClosed)
-				} else {
-					w.setCloseReason(errors.Wrap(ErrConnectionClosed, err.Error()))
-				}
-			}
+	var err error
+	written := len(payload)
+
+	w.logOutbound(msg)
+
+	w.writeWatch.progress()
+	defer w.writeWatch.done()
+
+	switch msg.Type() {
+	case PingMessage:
+		sentAt := time.Now()
+		err = w.conn.WriteControl(frameType, payload, deadline)
+		if e, ok := err.(net.Error); ok && e.Temporary() {
+			err = nil
+		}
+		if err == nil {
+			w.latency.sent(string(payload), sentAt)
+		}
+	case PongMessage:
+		err = w.conn.WriteControl(frameType, payload, deadline)
+	case CloseError:
+		// Either the peer closed first and was answered already, or the close frame was written: in both
+		// cases the connection is done once the peer's close frame is read.
+		if !w.closeSent.Swap(true) {
+			err = w.conn.WriteControl(frameType, payload, deadline)
+		}
+		if err == nil {
+			w.stats.wrote(written, time.Now())
+			w.setCloseReason(ErrTerminated)
+			w.writeWatch.done()
+			w.awaitCloseReply(ctx)
+			return false
+		}
+	default:
+		compressed := w.syncWriteCompression(msg)
+		written, err = w.writeData(frameType, msg)
+		if err == nil {
+			w.stats.wroteData(compressed)
+		}
+	}
+
+	if err != nil {
+		reason := ErrConnectionClosed
+		if !websocket.IsCloseError(err,
+			websocket.CloseGoingAway,
+			websocket.CloseAbnormalClosure,
+		) {
+			reason = fmt.Errorf("%w: %w", ErrConnectionClosed, err)
 		}
+		if adapters := w.control.ErrorAdapters(); adapters.OnWrite != nil {
+			reason = adapters.OnWrite(msg, reason)
+		}
+		w.setCloseReason(reason)
+		// A failed write leaves the connection unusable, timeouts included.
+		return false
 	}
+	w.stats.wrote(written, time.Now())
+
+	return true
+}
+
+// logInbound logs a message read from the wire. Entries are only built when debug is enabled, keeping the read loop
+// free of allocations otherwise.
+func (w *WsConnection) logInbound(mt MessageType, bts []byte) {
+	if !logEnabled(w.logger, LevelDebug) {
+		return
+	}
+
+	switch mt {
+	case PingMessage:
+		w.logger.Debugln("<= [PING]")
+	case PongMessage:
+		w.logger.Debugln("<= [PONG]")
+	case CloseError:
+		w.logger.Debugln("<= [CLOSE]")
+	case BinaryMessage:
+		w.logger.Debugln("<= [BIN]")
+	default:
+		w.logger.Debugf("<= [DATA] %s", bts)
+	}
+}
+
+// logOutbound logs a message about to be written to the wire, under the same rules as logInbound.
+func (w *WsConnection) logOutbound(m Message) {
+	if !logEnabled(w.logger, LevelDebug) {
+		return
+	}
+
+	switch m.Type() {
+	case PingMessage:
+		w.logger.Debugln("=> [PING]")
+	case PongMessage:
+		w.logger.Debugln("=> [PONG]")
+	case BinaryMessage:
+		w.logger.Debugln("=> [BIN]")
+	case CloseError:
+		w.logger.Debugln("=> [CLOSE]")
+	case TextMessage:
+		w.logger.Debugf("=> [DATA] %s", m.Data())
+	}
+}
+
+// echoClose answers a close frame of the peer with one carrying the same code, unless disabled or a close frame was
+// already written.
+func (w *WsConnection) echoClose(code int) {
+	if !w.closeEcho || w.closeSent.Swap(true) {
+		return
+	}
+
+	// No status and abnormal closure codes must not be sent over the wire, answer them with an empty close frame.
+	var payload []byte
+	if code != websocket.CloseNoStatusReceived && code != websocket.CloseAbnormalClosure {
+		payload = websocket.FormatCloseMessage(code, "")
+	}
+
+	if err := w.conn.WriteControl(websocket.CloseMessage, payload, time.Now().Add(closeEchoTimeout)); err != nil {
+		w.logger.Warnf("cannot echo close frame: %s", err)
+	}
+}
+
+// awaitCloseReply waits, for closeReplyTimeout at most, for the read loop to stop on the close frame the peer replies
+// with.
+func (w *WsConnection) awaitCloseReply(ctx context.Context) {
+	timer := time.NewTimer(closeReplyTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.stopC:
+	case <-ctx.Done():
+	case <-timer.C:
+		w.logger.Warnln("peer did not reply to our close frame")
+	}
+}
+
+// openedConn returns the connection dialed by Open, nil until it succeeded.
+func (w *WsConnection) openedConn() *websocket.Conn {
+	w.connMu.Lock()
+	defer w.connMu.Unlock()
+
+	return w.conn
 }
 
 func (w *WsConnection) safeClose() {
@@ -258,39 +1129,348 @@ func (w *WsConnection) safeClose() {
 }
 
 func (w *WsConnection) close() {
-	_ = w.conn.Close()
-	close(w.closeChan)
+	w.connMu.Lock()
+	close(w.stopC)
+	conn, connCancel := w.conn, w.connCancel
+	w.connMu.Unlock()
+
+	if conn == nil {
+		// Never opened: no loop is there to tell why the connection closed.
+		w.setCloseReason(ErrTerminated)
+	} else {
+		_ = conn.Close()
+		connCancel()
+	}
+
+	// Expose the close once both loops exited, so that every close reason they found has been considered.
+	go func() {
+		w.loops.Wait()
+		w.dropUnsent()
+		close(w.closeChan)
+	}()
+}
+
+// dropUnsent counts and forgets the messages left in the send buffer once the write loop exited.
+func (w *WsConnection) dropUnsent() {
+	var dropped int64
+	for len(w.send) > 0 {
+		<-w.send
+		dropped++
+	}
+	for len(w.sendControl) > 0 {
+		<-w.sendControl
+		dropped++
+	}
+
+	if dropped > 0 {
+		w.sendDropped.Add(dropped)
+		w.logger.Warnf("dropped %d buffered messages never sent", dropped)
+	}
 }
 
+// setCloseReason records err as a candidate close reason. Reasons found while shutting down race each other, e.g. a
+// peer close and our own termination, so rather than keeping the first one, the most severe candidate found within
+// closeReasonWindow of the first one is kept.
 func (w *WsConnection) setCloseReason(err error) {
-	w.closeReasonOnce.Do(func() {
+	w.closeReasonMu.Lock()
+	defer w.closeReasonMu.Unlock()
+
+	now := time.Now()
+	if w.closeReason == nil {
 		w.closeReason = err
-	})
+		w.closeReasonAt = now
+		return
+	}
+
+	if now.Sub(w.closeReasonAt) <= closeReasonWindow && closeReasonSeverity(err) > closeReasonSeverity(w.closeReason) {
+		w.closeReason = err
+	}
 }
 
-func (w *WsConnection) handleDialError(conn *websocket.Conn, resp *http.Response, err error) error {
-	if w.errAdapters.OnDial != nil {
-		return w.errAdapters.OnDial(conn, resp, err)
+// closeReasonSeverity ranks close reasons: errors coming from the peer or the network explain a close better than
+// our own termination.
+func closeReasonSeverity(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrTerminated):
+		return 1
+	default:
+		return 2
 	}
+}
 
-	// 1. Check HTTP errors first
-	var msg string
+// dialAborted returns why ctx aborted a dial, if it did. A dial fails on the deadline of ctx as soon as it passes,
+// possibly a moment before ctx reports it.
+func dialAborted(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// dial performs the handshake, following redirects when enabled. It returns the handshake response along with the
+// params finally dialed and the number of redirects followed. Cancelling ctx aborts the handshake.
+func (w *WsConnection) dial(ctx context.Context, p OpenConnectionParams) (*websocket.Conn, *http.Response, OpenConnectionParams, int, error) {
+	visited := map[string]struct{}{p.URL.String(): {}}
+
+	for redirects := 0; ; redirects++ {
+		conn, resp, err := w.dialerFor(p).DialContext(ctx, p.URL.String(), p.Header)
+
+		next, ok := w.redirectTarget(p.URL, resp)
+		if !ok {
+			if err = w.handleDialError(conn, resp, err); err != nil {
+				if resp != nil {
+					w.logger.Debugf("handshake response to %s: %s", RedactURL(p.URL), resp.Status)
+				}
+				return nil, nil, p, redirects, err
+			}
+			if err = checkSubprotocol(conn, w.subprotocolsFor(p)); err != nil {
+				_ = conn.Close()
+				return nil, nil, p, redirects, err
+			}
+
+			return conn, resp, p, redirects, nil
+		}
 
-	if resp != nil {
 		if resp.Body != nil {
-			bts, err := io.ReadAll(resp.Body)
-			if err == nil {
-				msg = string(bts)
+			_ = resp.Body.Close()
+		}
+
+		if redirects >= w.maxRedirects {
+			return nil, nil, p, redirects, errors.Wrapf(ErrTooManyRedirects, "more than %d redirects", w.maxRedirects)
+		}
+		if _, seen := visited[next.String()]; seen {
+			return nil, nil, p, redirects, errors.Wrap(ErrRedirectLoop, next.String())
+		}
+		visited[next.String()] = struct{}{}
+
+		w.logger.Infof("handshake redirected from %s to %s", RedactURL(p.URL), RedactURL(next))
+
+		p.URL = next
+		if w.redirectSigner != nil {
+			signed, err := w.redirectSigner(p)
+			if err != nil {
+				return nil, nil, p, redirects, fmt.Errorf("%w: cannot sign redirect: %w", ErrCannotConnect, err)
 			}
+			p = signed
 		}
+	}
+}
+
+// dialerFor returns the dialer to use for p: a copy of the connection dialer with the overrides of p, if any, or
+// resolving hosts as configured with WithResolver and WithPinnedAddrs, negotiating compression if enabled, sizing
+// buffers as configured with WithReadBufferSize and WithWriteBufferSize, and offering subprotocols if any.
+func (w *WsConnection) dialerFor(p OpenConnectionParams) *websocket.Dialer {
+	resolving := w.resolver != nil || w.pinnedAddrs != nil
+	sized := w.readBufferSize > 0 || w.writeBufferSize > 0
+	subprotocols := w.subprotocolsFor(p)
+	if !p.overridesDialer() && !resolving && !w.compression && !sized && subprotocols == nil {
+		return w.dialer
+	}
+
+	dialer := *w.dialer
+	if w.compression {
+		dialer.EnableCompression = true
+	}
+	w.applyBufferSizes(&dialer)
+	if subprotocols != nil {
+		dialer.Subprotocols = subprotocols
+	}
+	if p.TLSConfig != nil {
+		dialer.TLSClientConfig = p.TLSConfig
+	}
+	if p.HandshakeTimeout > 0 {
+		dialer.HandshakeTimeout = p.HandshakeTimeout
+	}
+
+	switch {
+	case p.NetDial != nil:
+		dialer.NetDial = nil
+		dialer.NetDialContext = p.NetDial
+		dialer.Proxy = nil
+	case resolving:
+		dialer.NetDial = nil
+		dialer.NetDialContext = w.resolvingDial(w.dialer)
+	}
+	if p.Proxy != nil {
+		dialer.Proxy = p.Proxy
+	}
+	if p.ProxyURL != nil {
+		dialer.NetDialContext = proxyDial(p.ProxyURL, netDialOf(&dialer))
+		dialer.NetDial = nil
+		dialer.Proxy = nil
+	}
+
+	return &dialer
+}
+
+// overridesDialer tells whether p overrides any setting of the dialer.
+func (p OpenConnectionParams) overridesDialer() bool {
+	return p.NetDial != nil || p.TLSConfig != nil || p.HandshakeTimeout > 0 || p.Proxy != nil || p.ProxyURL != nil
+}
+
+// netDialOf returns the function dialer establishes connections with.
+func netDialOf(dialer *websocket.Dialer) netDialContext {
+	if dialer.NetDialContext != nil {
+		return dialer.NetDialContext
+	}
+	if netDial := dialer.NetDial; netDial != nil {
+		return func(_ context.Context, network, addr string) (net.Conn, error) { return netDial(network, addr) }
+	}
+
+	var d net.Dialer
+	return d.DialContext
+}
+
+// resolvingDial returns a NetDialContext resolving the address as configured before dialing it as dialer would.
+func (w *WsConnection) resolvingDial(dialer *websocket.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := netDialOf(dialer)
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		addrs, err := w.resolve(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resolved := range addrs {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, resolved); err == nil {
+				return conn, nil
+			}
+		}
+
+		return nil, err
+	}
+}
+
+// resolve returns the addresses to try for addr: the pinned one, if any, or those found by the resolver.
+func (w *WsConnection) resolve(ctx context.Context, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned, ok := w.pinnedAddrs[addr]
+	if !ok {
+		pinned, ok = w.pinnedAddrs[host]
+	}
+	if ok {
+		if _, _, err := net.SplitHostPort(pinned); err != nil {
+			pinned = net.JoinHostPort(pinned, port)
+		}
+		return []string{pinned}, nil
+	}
+
+	if w.resolver == nil || net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+
+	ips, err := w.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	return addrs, nil
+}
+
+// UnixSocketDial returns an OpenConnectionParams.NetDial connecting to the Unix domain socket at path, whatever the
+// address being dialed.
+func UnixSocketDial(path string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+}
+
+// redirectTarget returns the websocket URL resp redirects from to, if following redirects is enabled.
+func (w *WsConnection) redirectTarget(from url.URL, resp *http.Response) (url.URL, bool) {
+	if w.maxRedirects == 0 || resp == nil || resp.StatusCode < 300 || resp.StatusCode > 399 {
+		return url.URL{}, false
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return url.URL{}, false
+	}
+
+	ref, err := url.Parse(location)
+	if err != nil {
+		return url.URL{}, false
+	}
+
+	next := *from.ResolveReference(ref)
+	switch next.Scheme {
+	case "http":
+		next.Scheme = "ws"
+	case "https":
+		next.Scheme = "wss"
+	}
+
+	return next, true
+}
+
+// parseRetryAfter returns the wait asked for by a Retry-After header value, either a number of seconds or an HTTP
+// date, relative to now. It is zero when the value is missing, invalid or in the past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// newDialHTTPError reads the beginning of the body of a rejected handshake response.
+func newDialHTTPError(resp *http.Response) *DialHTTPError {
+	httpErr := &DialHTTPError{StatusCode: resp.StatusCode, Header: resp.Header}
+	if resp.Body != nil {
+		// An error reading the body merely leaves it truncated.
+		httpErr.Body, _ = io.ReadAll(io.LimitReader(resp.Body, maxDialErrorBody))
+	}
+	return httpErr
+}
+
+func (w *WsConnection) handleDialError(conn *websocket.Conn, resp *http.Response, err error) error {
+	if resp != nil && resp.Body != nil {
+		defer func() { _ = resp.Body.Close() }()
+	}
+
+	if adapters := w.control.ErrorAdapters(); adapters.OnDial != nil {
+		return adapters.OnDial(conn, resp, err)
+	}
+
+	// 1. Check HTTP errors first
+	if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		httpErr := newDialHTTPError(resp)
 		if resp.StatusCode == http.StatusTooManyRequests {
-			return errors.Wrap(ErrRateLimit, msg)
+			return &RateLimitError{
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+				Err:        fmt.Errorf("%w: %w", ErrRateLimit, httpErr),
+			}
 		}
+		return httpErr
+	}
+
+	// 2. Proxy errors, told apart from the endpoint being unreachable
+	if errors.Is(err, ErrProxy) {
+		return err
 	}
 
-	// 2. Network errors
+	// 3. Network errors
 	if err != nil {
-		return errors.Wrap(ErrCannotConnect, err.Error())
+		return fmt.Errorf("%w: %w", ErrCannotConnect, err)
 	}
 
 	return nil
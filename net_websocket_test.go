@@ -0,0 +1,1575 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestWsServer starts a websocket server which runs serve for every accepted connection.
+func newTestWsServer(t testing.TB, serve func(conn *websocket.Conn)) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serve(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func testWsURL(t testing.TB, srv *httptest.Server) url.URL {
+	t.Helper()
+
+	u, err := url.Parse("ws" + strings.TrimPrefix(srv.URL, "http"))
+	require.NoError(t, err)
+	return *u
+}
+
+// newTestWsConnection returns a WsConnection dialing srv, along with the channel receiving its inbound messages.
+func newTestWsConnection(t testing.TB, srv *httptest.Server, opts ...WsConnectionOption) (*WsConnection, chan Message) {
+	t.Helper()
+
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	recv := make(chan Message, 64)
+	return NewWebsocketConnection(websocket.DefaultDialer, repo, logger, recv, ErrorAdapters{}, opts...), recv
+}
+
+// serveUntilClosed keeps reading from conn until the peer goes away.
+func serveUntilClosed(conn *websocket.Conn) {
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func TestWsConnection_ConnContext(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+	conn, _ := newTestWsConnection(t, srv)
+
+	clientCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.Nil(t, conn.ConnContext())
+	require.NoError(t, conn.Open(clientCtx))
+
+	connCtx := conn.ConnContext()
+	require.NotNil(t, connCtx)
+	require.NoError(t, connCtx.Err())
+
+	conn.Close()
+
+	select {
+	case <-connCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection context must be cancelled on close")
+	}
+	require.NoError(t, clientCtx.Err())
+}
+
+func TestWsConnection_HandshakeResponse(t *testing.T) {
+	var (
+		cookies     = make(chan string, 2)
+		connections atomic.Int32
+	)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookies <- r.Header.Get("Cookie")
+		conn, err := upgrader.Upgrade(w, r, http.Header{
+			"X-Region":   {"ap-northeast-1"},
+			"Set-Cookie": {"session=sticky-" + strconv.Itoa(int(connections.Add(1)))},
+		})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// The first connections are dropped, for the client to reconnect.
+		if connections.Load() > 2 {
+			serveUntilClosed(conn)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _ := newTestWsConnection(t, srv)
+	require.Nil(t, conn.HandshakeResponse())
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	resp := conn.HandshakeResponse()
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "ap-northeast-1", resp.Header.Get("X-Region"))
+	require.Equal(t, http.NoBody, resp.Body)
+	require.Nil(t, resp.Request)
+	require.Equal(t, "", <-cookies)
+
+	// Cookies set on a handshake are echoed on the next one.
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, srv)
+	var session atomic.Pointer[http.Cookie]
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		p := OpenConnectionParams{URL: u, Header: http.Header{}}
+		if c := session.Load(); c != nil {
+			p.Header.Set("Cookie", c.String())
+		}
+		return p, nil
+	})
+	cli := newBasicClient(
+		NewBackoffConnectionHandlerFactory(nil,
+			NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})),
+			func(int) time.Duration { return 0 }, time.Minute),
+		func(Client, Message) {},
+		func(Client, EventType) {},
+	)
+	listenEvent(cli, EventConnect, listenInline, func(EventType) {
+		control, _ := Handle[*ConnectionControl](cli)
+		info, _ := control.LatestInfo()
+		session.Store(info.Handshake.Cookies()[0])
+	})
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	require.Equal(t, "", <-cookies)
+	require.Equal(t, "session=sticky-2", <-cookies)
+}
+
+func TestWsConnection_HotPathLoggingDoesNotAllocate(t *testing.T) {
+	var buf strings.Builder
+	w := &WsConnection{logger: newLeveledTestLogger(&buf, LevelInfo)}
+	data := NewDataMessage([]byte(`{"e":"trade","p":"42.0"}`))
+	pong := NewPongMessage(nil)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		w.logInbound(DataMessage, data.Data())
+		w.logInbound(PingMessage, nil)
+		w.logOutbound(data)
+		w.logOutbound(pong)
+	})
+	require.Zero(t, allocs)
+	require.Empty(t, buf.String())
+
+	w.logger = newLeveledTestLogger(&buf, LevelDebug)
+	w.logInbound(DataMessage, data.Data())
+	w.logOutbound(data)
+	require.Contains(t, buf.String(), `<= [DATA] {"e":"trade","p":"42.0"}`)
+	require.Contains(t, buf.String(), `=> [DATA] {"e":"trade","p":"42.0"}`)
+}
+
+func BenchmarkWsConnection_HotPathLogging(b *testing.B) {
+	data := NewDataMessage([]byte(`{"e":"trade","p":"42.0"}`))
+
+	for _, level := range []LogLevel{LevelInfo, LevelDebug} {
+		w := &WsConnection{logger: newLeveledTestLogger(io.Discard, level)}
+
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w.logInbound(DataMessage, data.Data())
+				w.logOutbound(data)
+			}
+		})
+	}
+}
+
+func TestWsConnection_CloseReasonPrecedence(t *testing.T) {
+	peerErr := CloseCodeTable(nil).classify(websocket.CloseGoingAway, "restarting")
+
+	for i := 0; i < 1000; i++ {
+		w := &WsConnection{}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w.setCloseReason(ErrTerminated)
+		}()
+		go func() {
+			defer wg.Done()
+			w.setCloseReason(peerErr)
+		}()
+		wg.Wait()
+
+		require.Equal(t, peerErr, w.CloseErr(), "iteration %d", i)
+	}
+}
+
+func TestWsConnection_CloseReasonWindow(t *testing.T) {
+	w := &WsConnection{}
+
+	w.setCloseReason(ErrTerminated)
+	w.closeReasonAt = w.closeReasonAt.Add(-2 * closeReasonWindow)
+	w.setCloseReason(ErrConnectionClosed)
+
+	require.Equal(t, ErrTerminated, w.CloseErr())
+}
+
+// newRedirectingTestServer serves websocket connections on /ws and redirects every other path as told by routes.
+func newRedirectingTestServer(t *testing.T, routes map[string]string) (*httptest.Server, *atomic.Value) {
+	t.Helper()
+
+	var lastHeader atomic.Value
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := routes[r.URL.Path]; ok {
+			http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+			return
+		}
+
+		lastHeader.Store(r.Header.Clone())
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveUntilClosed(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &lastHeader
+}
+
+func newRedirectTestConnection(t *testing.T, srv *httptest.Server, path string, opts ...WsConnectionOption) *WsConnection {
+	t.Helper()
+
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, srv)
+	u.Path = path
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u, Header: http.Header{"X-Api-Key": {"key"}}}, nil
+	})
+
+	conn := NewWebsocketConnection(websocket.DefaultDialer, repo, logger, make(chan Message, 8), ErrorAdapters{}, opts...)
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestWsConnection_FollowRedirects(t *testing.T) {
+	srv, lastHeader := newRedirectingTestServer(t, map[string]string{"/a": "/b", "/b": "/ws"})
+
+	var signed []string
+	conn := newRedirectTestConnection(t, srv, "/a",
+		WithFollowRedirects(3),
+		WithRedirectSigner(func(p OpenConnectionParams) (OpenConnectionParams, error) {
+			signed = append(signed, p.URL.Path)
+			p.URL.RawQuery = "signature=" + p.URL.Path
+			return p, nil
+		}),
+	)
+
+	require.NoError(t, conn.Open(context.Background()))
+
+	info := conn.Info()
+	require.Equal(t, 2, info.Redirects)
+	require.Equal(t, "/ws", info.URL.Path)
+	require.Equal(t, "signature=/ws", info.URL.RawQuery)
+	require.Equal(t, []string{"/b", "/ws"}, signed)
+	require.Equal(t, "key", lastHeader.Load().(http.Header).Get("X-Api-Key"))
+}
+
+func TestWsConnection_RedirectErrors(t *testing.T) {
+	srv, _ := newRedirectingTestServer(t, map[string]string{"/a": "/b", "/b": "/ws", "/x": "/y", "/y": "/x"})
+
+	err := newRedirectTestConnection(t, srv, "/x", WithFollowRedirects(5)).Open(context.Background())
+	require.ErrorIs(t, err, ErrRedirectLoop)
+
+	err = newRedirectTestConnection(t, srv, "/a", WithFollowRedirects(1)).Open(context.Background())
+	require.ErrorIs(t, err, ErrTooManyRedirects)
+
+	// Redirects are not followed unless enabled.
+	err = newRedirectTestConnection(t, srv, "/a").Open(context.Background())
+	require.ErrorIs(t, err, ErrCannotConnect)
+
+	errSigning := errors.New("signing key unavailable")
+	err = newRedirectTestConnection(t, srv, "/a", WithFollowRedirects(5),
+		WithRedirectSigner(func(p OpenConnectionParams) (OpenConnectionParams, error) {
+			return OpenConnectionParams{}, errSigning
+		}),
+	).Open(context.Background())
+	require.ErrorIs(t, err, errSigning)
+	var dialErr *DialError
+	require.ErrorAs(t, err, &dialErr)
+	require.Contains(t, dialErr.URL, "/b", "the redirect target is told")
+}
+
+func TestWsConnection_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sidecar.sock")
+	listener, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	hosts := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			mt, bts, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteMessage(mt, bts)
+		}
+	})}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{
+			URL:     url.URL{Scheme: "ws", Host: "market-data.local", Path: "/stream"},
+			NetDial: UnixSocketDial(path),
+		}, nil
+	})
+
+	received := make(chan Message, 1)
+	cli := NewBasicClientFactory(
+		NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})),
+		func(_ Client, m Message) { received <- m },
+		func(Client, EventType) {},
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	require.Equal(t, "market-data.local", <-hosts)
+
+	cli.Send(NewDataMessage([]byte("ping over unix")))
+	select {
+	case m := <-received:
+		require.Equal(t, "ping over unix", string(m.Data()))
+		require.EqualValues(t, 1, GenerationOf(m))
+	case <-time.After(time.Second):
+		t.Fatal("no echo received")
+	}
+}
+
+// closeInitiatingServer sends a close frame with code, then reports what the client answered and whether it shut down
+// the TCP connection afterwards.
+func closeInitiatingServer(code int, echoed chan<- error, shutdown chan<- error) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		msg := websocket.FormatCloseMessage(code, "bye")
+		if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+			echoed <- err
+			return
+		}
+
+		// Do not echo back the client close, as it would be answering our own close.
+		conn.SetCloseHandler(func(int, string) error { return nil })
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err := conn.ReadMessage()
+		echoed <- err
+
+		_, err = conn.UnderlyingConn().Read(make([]byte, 1))
+		shutdown <- err
+	}
+}
+
+func TestWsConnection_EchoesPeerClose(t *testing.T) {
+	echoed, shutdown := make(chan error, 1), make(chan error, 1)
+	srv := newTestWsServer(t, closeInitiatingServer(4001, echoed, shutdown))
+	conn, _ := newTestWsConnection(t, srv)
+
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, <-echoed, &closeErr)
+	require.Equal(t, 4001, closeErr.Code)
+	require.ErrorIs(t, <-shutdown, io.EOF)
+}
+
+func TestWsConnection_WithoutCloseEcho(t *testing.T) {
+	echoed, shutdown := make(chan error, 1), make(chan error, 1)
+	srv := newTestWsServer(t, closeInitiatingServer(4001, echoed, shutdown))
+	conn, _ := newTestWsConnection(t, srv, WithoutCloseEcho())
+
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	// The socket goes away without a close frame.
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, <-echoed, &closeErr)
+	require.Equal(t, websocket.CloseAbnormalClosure, closeErr.Code)
+}
+
+// newFakeResolver returns a resolver whose DNS server is unreachable, counting the queries it attempts.
+func newFakeResolver(queries *atomic.Int32) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			queries.Add(1)
+			return nil, fmt.Errorf("dns server unreachable")
+		},
+	}
+}
+
+// newHostTestConnection returns a WsConnection dialing srv through the given host name.
+func newHostTestConnection(t *testing.T, srv *httptest.Server, host string, opts ...WsConnectionOption) *WsConnection {
+	t.Helper()
+
+	u := testWsURL(t, srv)
+	u.Host = net.JoinHostPort(host, u.Port())
+
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	return NewWebsocketConnection(websocket.DefaultDialer, repo, logger, make(chan Message, 64), ErrorAdapters{}, opts...)
+}
+
+func TestWsConnection_PinnedAddrsBypassResolver(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+	target := strings.TrimPrefix(srv.URL, "http://")
+
+	var queries atomic.Int32
+	conn := newHostTestConnection(t, srv, "venue.invalid",
+		WithResolver(newFakeResolver(&queries)),
+		WithPinnedAddrs(map[string]string{"venue.invalid": target}),
+	)
+
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	require.Zero(t, queries.Load())
+	require.Equal(t, target, conn.Info().RemoteAddr)
+	info := conn.Info()
+	require.Equal(t, "venue.invalid", info.URL.Hostname())
+}
+
+func TestWsConnection_CustomResolver(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+
+	var queries atomic.Int32
+	conn := newHostTestConnection(t, srv, "venue.invalid", WithResolver(newFakeResolver(&queries)))
+
+	err := conn.Open(context.Background())
+	require.ErrorIs(t, err, ErrCannotConnect)
+	require.NotZero(t, queries.Load())
+}
+
+func TestWsConnection_PinnedBareIPKeepsPort(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+
+	conn := newHostTestConnection(t, srv, "venue.invalid", WithPinnedAddrs(map[string]string{"venue.invalid": "127.0.0.1"}))
+
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	require.Equal(t, strings.TrimPrefix(srv.URL, "http://"), conn.Info().RemoteAddr)
+}
+
+func TestConnectionControl_SwapErrorAdapters(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	u := testWsURL(t, srv)
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	unrecoverable := ErrorAdapters{OnDial: func(_ *websocket.Conn, resp *http.Response, err error) error {
+		return fmt.Errorf("%w: status %d", ErrTerminated, resp.StatusCode)
+	}}
+	recoverable := ErrorAdapters{OnDial: func(_ *websocket.Conn, resp *http.Response, err error) error {
+		return fmt.Errorf("%w: status %d", ErrCannotConnect, resp.StatusCode)
+	}}
+
+	cli := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	factory := NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, unrecoverable))
+
+	err := factory(cli, func(Client, Message) {}, nil).Connect(context.Background())
+	require.ErrorIs(t, err, ErrTerminated)
+
+	control, ok := Handle[*ConnectionControl](cli)
+	require.True(t, ok)
+	control.SetErrorAdapters(recoverable)
+
+	err = factory(cli, func(Client, Message) {}, nil).Connect(context.Background())
+	require.ErrorIs(t, err, ErrCannotConnect)
+}
+
+func TestErrorAdapters_OnRead(t *testing.T) {
+	invalidAuth := func(reason error) error {
+		var cce *CloseCodeError
+		if errors.As(reason, &cce) && cce.Code == 4001 {
+			return WrapErrorUnrecoverableConnection(reason, url.URL{Scheme: "ws", Host: "venue"})
+		}
+		return reason
+	}
+
+	tests := []struct {
+		name          string
+		onRead        func(error) error
+		wantReconnect bool
+	}{
+		{name: "identity", wantReconnect: true},
+		{name: "unrecoverable", onRead: invalidAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handshakes atomic.Int32
+			srv := newTestWsServer(t, func(conn *websocket.Conn) {
+				if handshakes.Add(1) == 1 {
+					msg := websocket.FormatCloseMessage(4001, "invalid auth")
+					_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+				}
+				serveUntilClosed(conn)
+			})
+			logger := newTestLogger(io.Discard)
+			u := testWsURL(t, srv)
+			repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+				return OpenConnectionParams{URL: u}, nil
+			})
+
+			base := NewBaseConnectionHandlerFactory(logger,
+				NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{OnRead: tt.onRead}))
+			cli := newBasicClient(
+				NewBackoffConnectionHandlerFactory(nil, base, func(int) time.Duration { return 0 }, time.Minute),
+				func(Client, Message) {},
+				func(Client, EventType) {},
+			)
+			require.NoError(t, cli.Open(context.Background()))
+			defer cli.Close()
+
+			if tt.wantReconnect {
+				require.Eventually(t, func() bool { return handshakes.Load() == 2 }, 2*time.Second, time.Millisecond)
+				require.False(t, isClosed(cli.CloseChan()))
+				return
+			}
+
+			select {
+			case <-cli.CloseChan():
+			case <-time.After(2 * time.Second):
+				t.Fatal("reconnecting after an unrecoverable close")
+			}
+			var unrecoverable *ErrUnrecoverableConnection
+			require.True(t, errors.As(cli.CloseErr(), &unrecoverable), "close reason: %v", cli.CloseErr())
+			var cce *CloseCodeError
+			require.True(t, errors.As(cli.CloseErr(), &cce))
+			require.Equal(t, "invalid auth", cce.Text)
+			require.EqualValues(t, 1, handshakes.Load())
+		})
+	}
+}
+
+// oversizeServer sends a normal frame, then the given oversized frames, then another normal frame.
+func oversizeServer(oversized int) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("before"))
+		for i := 0; i < oversized; i++ {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 4096)))
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("after"))
+		serveUntilClosed(conn)
+	}
+}
+
+func TestWsConnection_OversizeRecovery(t *testing.T) {
+	srv := newTestWsServer(t, oversizeServer(1))
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	var (
+		mu       sync.Mutex
+		received []string
+		skipped  atomic.Int32
+	)
+	cli := newBasicClient(
+		NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{},
+			WithMaxMessageSize(1024), WithOversizeRecovery(1))),
+		func(_ Client, m Message) {
+			mu.Lock()
+			received = append(received, string(m.Data()))
+			mu.Unlock()
+		},
+		func(Client, EventType) {},
+	)
+	listenEvent(cli, EventOversizeSkipped, listenInline, func(EventType) { skipped.Add(1) })
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []string{"before", "after"}, received)
+	require.EqualValues(t, 1, skipped.Load())
+	require.False(t, isClosed(cli.CloseChan()))
+}
+
+func TestWsConnection_OversizeRecoveryGivesUp(t *testing.T) {
+	srv := newTestWsServer(t, oversizeServer(2))
+	conn, recv := newTestWsConnection(t, srv, WithMaxMessageSize(1024), WithOversizeRecovery(1))
+	require.NoError(t, conn.Open(context.Background()))
+
+	require.Equal(t, "before", string((<-recv).Data()))
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("connection must close after too many oversized messages")
+	}
+	require.ErrorIs(t, conn.CloseErr(), ErrMessageTooLarge)
+	require.Equal(t, 2, conn.OversizeSkips())
+}
+
+func TestWsConnection_MaxMessageSizeWithoutRecovery(t *testing.T) {
+	srv := newTestWsServer(t, oversizeServer(1))
+	conn, recv := newTestWsConnection(t, srv, WithMaxMessageSize(1024))
+	require.NoError(t, conn.Open(context.Background()))
+
+	require.Equal(t, "before", string((<-recv).Data()))
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("connection must close on an oversized message")
+	}
+	require.ErrorIs(t, conn.CloseErr(), ErrMessageTooLarge)
+	require.ErrorIs(t, conn.CloseErr(), ErrConnectionClosed)
+}
+
+func TestWsConnection_WriteAfterClose(t *testing.T) {
+	t.Run("closed by us", func(t *testing.T) {
+		srv := newTestWsServer(t, serveUntilClosed)
+		conn, _ := newTestWsConnection(t, srv)
+		require.NoError(t, conn.Open(context.Background()))
+
+		conn.Close()
+		<-conn.CloseChan()
+
+		requireWriteReturns(t, conn, ErrConnectionClosed)
+	})
+
+	t.Run("closed by the peer", func(t *testing.T) {
+		srv := newTestWsServer(t, func(*websocket.Conn) {})
+		conn, _ := newTestWsConnection(t, srv)
+		require.NoError(t, conn.Open(context.Background()))
+		<-conn.CloseChan()
+
+		requireWriteReturns(t, conn, ErrConnectionClosed)
+	})
+}
+
+func requireWriteReturns(t *testing.T, conn *WsConnection, want error) {
+	t.Helper()
+
+	errC := make(chan error, 1)
+	go func() { errC <- conn.Write(NewDataMessage([]byte("late"))) }()
+
+	select {
+	case err := <-errC:
+		require.ErrorIs(t, err, want)
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on a closed connection")
+	}
+}
+
+func TestClient_SendErrorOnClosedConnection(t *testing.T) {
+	srv := newTestWsServer(t, func(*websocket.Conn) {})
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	rejected := make(chan error, 1)
+	cli := NewBasicClientFactory(
+		NewReopenIntervalConnFactory(
+			nil,
+			time.Hour,
+			NewBaseConnectionHandlerFactory(nil, NewWebsocketFactory(nil, websocket.DefaultDialer, repo, ErrorAdapters{})),
+		),
+		func(Client, Message) {},
+		func(Client, EventType) {},
+		WithOnSendError(func(_ Client, _ Message, err error) { rejected <- err }),
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	// The peer closes right away: once the connection is found closed, sending reports it through the layers.
+	require.Eventually(t, func() bool {
+		cli.Send(NewDataMessage([]byte("late")))
+		select {
+		case err := <-rejected:
+			require.ErrorIs(t, err, ErrConnectionClosed)
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
+
+func TestWsConnection_WriteTimeout(t *testing.T) {
+	// The server never reads, so that writes block once the socket buffers are full.
+	stalled := make(chan struct{})
+	t.Cleanup(func() { close(stalled) })
+	srv := newTestWsServer(t, func(*websocket.Conn) { <-stalled })
+
+	flood := func(conn *WsConnection) {
+		payload := NewDataMessage(bytes.Repeat([]byte("x"), 1<<20))
+		go func() {
+			for conn.Write(payload) == nil {
+			}
+		}()
+	}
+
+	t.Run("deadline applied", func(t *testing.T) {
+		conn, _ := newTestWsConnection(t, srv, WithWriteTimeout(50*time.Millisecond))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		start := time.Now()
+		flood(conn)
+
+		select {
+		case <-conn.CloseChan():
+		case <-time.After(5 * time.Second):
+			t.Fatal("stalled write not timed out")
+		}
+		require.Less(t, time.Since(start), 2*time.Second)
+		require.ErrorIs(t, conn.CloseErr(), ErrConnectionClosed)
+		require.ErrorContains(t, conn.CloseErr(), "timeout")
+	})
+
+	t.Run("no deadline", func(t *testing.T) {
+		conn, _ := newTestWsConnection(t, srv, WithWriteTimeout(0))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		flood(conn)
+
+		require.Never(t, func() bool { return isClosed(conn.CloseChan()) }, 1500*time.Millisecond, 10*time.Millisecond,
+			"writes blocked past the default timeout do not fail")
+	})
+}
+
+func TestWsConnection_WritesBinaryFrames(t *testing.T) {
+	type frame struct {
+		opcode int
+		data   string
+	}
+	frames := make(chan frame, 2)
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		for {
+			mt, bts, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			frames <- frame{opcode: mt, data: string(bts)}
+		}
+	})
+	conn, _ := newTestWsConnection(t, srv)
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	require.NoError(t, conn.Write(NewBinaryMessage([]byte{0x0a, 0x03, 'b', 't', 'c'})))
+	require.NoError(t, conn.Write(NewTextMessage([]byte("text"))))
+
+	for _, want := range []frame{
+		{opcode: websocket.BinaryMessage, data: "\x0a\x03btc"},
+		{opcode: websocket.TextMessage, data: "text"},
+	} {
+		select {
+		case got := <-frames:
+			require.Equal(t, want, got)
+		case <-time.After(time.Second):
+			t.Fatal("frame not received")
+		}
+	}
+
+	require.ErrorIs(t, conn.Write(NewCloseMessage(websocket.CloseNormalClosure, nil)), ErrInvalidOutbound)
+	require.ErrorIs(t, conn.Write(NewMessage(42, nil)), ErrInvalidOutbound)
+}
+
+func TestClient_KeepsFrameOpcodes(t *testing.T) {
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.BinaryMessage, []byte{0x1f, 0x8b})
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"ch":"depth"}`))
+		serveUntilClosed(conn)
+	})
+	logger := newTestLogger(io.Discard)
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	factory := NewBaseConnectionHandlerFactory(
+		logger,
+		NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}),
+	)
+
+	handled := make(chan Message, 2)
+	cli := newBasicClient(factory, func(_ Client, m Message) { handled <- m }, func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	for _, want := range []Message{NewBinaryMessage([]byte{0x1f, 0x8b}), NewTextMessage([]byte(`{"ch":"depth"}`))} {
+		select {
+		case got := <-handled:
+			require.Equal(t, want.Type(), got.Type())
+			require.Equal(t, want.Data(), got.Data())
+		case <-time.After(time.Second):
+			t.Fatalf("%s not handled", want)
+		}
+	}
+}
+
+func TestWsConnection_DialErrorRedactsURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	u := testWsURL(t, srv)
+	u.Path = "/stream"
+	u.RawQuery = "signature=s3cr3t&timestamp=1700000000"
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	cli := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	factory := NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		err := factory(cli, func(Client, Message) {}, nil).Connect(context.Background())
+		require.ErrorIs(t, err, ErrCannotConnect)
+
+		var dialErr *DialError
+		require.ErrorAs(t, err, &dialErr)
+		require.Equal(t, attempt, dialErr.Attempt)
+		require.Equal(t, "ws://"+u.Host+"/stream?signature=REDACTED&timestamp=REDACTED", dialErr.URL)
+		require.NotContains(t, err.Error(), "s3cr3t")
+		require.NotContains(t, err.Error(), "1700000000")
+	}
+
+	cli.recordDisconnect(EventReconnect)
+	records := cli.disconnects.snapshot()
+	require.Len(t, records, 1)
+	require.Equal(t, 2, records[0].Attempt)
+	require.Equal(t, "ws://"+u.Host+"/stream?signature=REDACTED&timestamp=REDACTED", records[0].URL)
+}
+
+// closeRecordingServer reports the close frame received from the client, replying to it as gorilla does by default.
+func closeRecordingServer(closes chan<- *websocket.CloseError) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		_, _, err := conn.ReadMessage()
+
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			closeErr = &websocket.CloseError{Code: -1, Text: fmt.Sprint(err)}
+		}
+		closes <- closeErr
+	}
+}
+
+func TestWsConnection_CloseWithReason(t *testing.T) {
+	closes := make(chan *websocket.CloseError, 1)
+	srv := newTestWsServer(t, closeRecordingServer(closes))
+	conn, _ := newTestWsConnection(t, srv)
+	require.NoError(t, conn.Open(context.Background()))
+
+	start := time.Now()
+	require.NoError(t, conn.CloseWithReason(websocket.CloseNormalClosure, "resubscribing"))
+	require.Less(t, time.Since(start), closeReplyTimeout, "the reply of the peer ends the wait")
+	require.True(t, isClosed(conn.CloseChan()))
+
+	got := <-closes
+	require.Equal(t, websocket.CloseNormalClosure, got.Code)
+	require.Equal(t, "resubscribing", got.Text)
+
+	require.ErrorIs(t, conn.CloseWithReason(websocket.CloseNormalClosure, "again"), ErrConnectionClosed)
+}
+
+// frameRecordingServer reports the data messages read, then the close frame received, as "close:<code>".
+func frameRecordingServer(frames chan<- string) func(conn *websocket.Conn) {
+	return func(conn *websocket.Conn) {
+		defer close(frames)
+		for {
+			_, data, err := conn.ReadMessage()
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				frames <- fmt.Sprintf("close:%d", closeErr.Code)
+			}
+			if err != nil {
+				return
+			}
+			frames <- string(data)
+		}
+	}
+}
+
+func TestWsConnection_CloseGraceful(t *testing.T) {
+	const queued = 10
+
+	frames := make(chan string, queued+1)
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, frameRecordingServer(frames)),
+		WithSendBufferSize(queued), WithWriteRateLimit(100, 1))
+	require.NoError(t, conn.Open(context.Background()))
+
+	expected := make([]string, 0, queued+1)
+	for i := range queued {
+		m := fmt.Sprintf("unsubscribe %d", i)
+		require.NoError(t, conn.Write(NewTextMessage([]byte(m))))
+		expected = append(expected, m)
+	}
+	expected = append(expected, fmt.Sprintf("close:%d", websocket.CloseNormalClosure))
+
+	closed := make(chan error, 1)
+	go func() { closed <- conn.CloseGraceful(context.Background()) }()
+	require.Eventually(t, conn.closing.Load, time.Second, time.Millisecond)
+	require.ErrorIs(t, conn.Write(NewTextMessage([]byte("late"))), ErrConnectionClosed)
+
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("graceful close did not return")
+	}
+	require.True(t, isClosed(conn.CloseChan()))
+
+	var got []string
+	for frame := range frames {
+		got = append(got, frame)
+	}
+	require.Equal(t, expected, got, "queued messages were not written before the close frame")
+}
+
+func TestWsConnection_CloseGracefulDeadline(t *testing.T) {
+	frames := make(chan string, 8)
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, frameRecordingServer(frames)),
+		WithSendBufferSize(8), WithWriteRateLimit(1, 1))
+	require.NoError(t, conn.Open(context.Background()))
+
+	for range 3 {
+		require.NoError(t, conn.Write(NewTextMessage([]byte("slow"))))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, conn.CloseGraceful(ctx), context.DeadlineExceeded)
+	require.True(t, isClosed(conn.CloseChan()))
+
+	var got []string
+	for frame := range frames {
+		got = append(got, frame)
+	}
+	require.Equal(t, []string{"slow", fmt.Sprintf("close:%d", websocket.CloseAbnormalClosure)}, got,
+		"the connection was not torn down right away")
+}
+
+func TestClient_CloseWithReason(t *testing.T) {
+	closes := make(chan *websocket.CloseError, 1)
+	srv := newTestWsServer(t, closeRecordingServer(closes))
+
+	u := testWsURL(t, srv)
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	cli := newBasicClient(
+		NewBackoffConnectionHandlerFactory(
+			logger,
+			NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})),
+			func(int) time.Duration { return 0 },
+			time.Minute,
+		),
+		func(Client, Message) {},
+		func(Client, EventType) {},
+	)
+	require.NoError(t, cli.Open(context.Background()))
+
+	CloseWithReason(cli, 4000, "resubscribing")
+
+	select {
+	case got := <-closes:
+		require.Equal(t, 4000, got.Code)
+		require.Equal(t, "resubscribing", got.Text)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no close frame received")
+	}
+}
+
+func TestWsConnection_SendBufferSize(t *testing.T) {
+	t.Run("unbuffered", func(t *testing.T) {
+		conn, _ := newTestWsConnection(t, newTestWsServer(t, serveUntilClosed))
+
+		// Nothing takes the message until the connection is open.
+		written := make(chan error, 1)
+		go func() { written <- conn.Write(NewDataMessage([]byte("hello"))) }()
+		select {
+		case <-written:
+			t.Fatal("unbuffered write returned before the write loop took the message")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		require.NoError(t, conn.Open(context.Background()))
+		require.NoError(t, <-written)
+
+		conn.Close()
+		<-conn.CloseChan()
+		require.Zero(t, conn.SendDropped())
+	})
+
+	t.Run("buffered", func(t *testing.T) {
+		conn, _ := newTestWsConnection(t, newTestWsServer(t, serveUntilClosed), WithSendBufferSize(8))
+
+		for i := range 5 {
+			require.NoError(t, conn.Write(NewDataMessage([]byte(fmt.Sprint(i)))))
+		}
+
+		conn.Close()
+		<-conn.CloseChan()
+		require.Equal(t, 5, conn.SendDropped())
+		require.ErrorIs(t, conn.Write(NewDataMessage([]byte("late"))), ErrConnectionClosed)
+	})
+
+	t.Run("buffered delivers in order", func(t *testing.T) {
+		received := make(chan string, 16)
+		srv := newTestWsServer(t, func(conn *websocket.Conn) {
+			for {
+				_, bts, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				received <- string(bts)
+			}
+		})
+		conn, _ := newTestWsConnection(t, srv, WithSendBufferSize(8))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		for i := range 10 {
+			require.NoError(t, conn.Write(NewDataMessage([]byte(fmt.Sprint(i)))))
+		}
+		for i := range 10 {
+			require.Equal(t, fmt.Sprint(i), <-received)
+		}
+	})
+}
+
+func TestClient_PongNotDelayedByCongestedData(t *testing.T) {
+	const (
+		queued   = baseConnectionRecvBufferSize
+		perData  = 10 * time.Millisecond
+		deadline = 10 * perData
+	)
+
+	ponged := make(chan time.Duration, 1)
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		for i := range queued {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprint(i)))
+		}
+
+		var pingedAt time.Time
+		conn.SetPongHandler(func(string) error {
+			ponged <- time.Since(pingedAt)
+			return nil
+		})
+		pingedAt = time.Now()
+		_ = conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second))
+		serveUntilClosed(conn)
+	})
+
+	u := testWsURL(t, srv)
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	cli := newBasicClient(
+		NewPassiveKeepAliveConnectionHandlerFactory(
+			NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})),
+			KeepAliveHandlerReplyPingWithPong,
+		),
+		func(Client, Message) { time.Sleep(perData) },
+		func(Client, EventType) {},
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	select {
+	case took := <-ponged:
+		require.Less(t, took, deadline, "pong delayed behind queued data")
+	case <-time.After(queued * perData):
+		t.Fatal("no pong before the queued data was handled")
+	}
+}
+
+func TestClient_PongNotDelayedByBusyHandler(t *testing.T) {
+	const (
+		pings    = 3 * baseConnectionControlBufferSize
+		interval = 10 * time.Millisecond
+		deadline = 100 * time.Millisecond
+	)
+
+	ponged := make(chan time.Duration, pings)
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("busy"))
+
+		pingedAt := make(chan time.Time, pings)
+		conn.SetPongHandler(func(string) error {
+			ponged <- time.Since(<-pingedAt)
+			return nil
+		})
+		go serveUntilClosed(conn)
+
+		for range pings {
+			pingedAt <- time.Now()
+			if err := conn.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+				return
+			}
+			time.Sleep(interval)
+		}
+	})
+
+	u := testWsURL(t, srv)
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	release := make(chan struct{})
+	cli := newBasicClient(
+		NewPassiveKeepAliveConnectionHandlerFactory(
+			NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})),
+			KeepAliveHandlerReplyPingWithPong,
+		),
+		func(Client, Message) { <-release },
+		func(Client, EventType) {},
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+	defer close(release)
+
+	for i := range pings {
+		select {
+		case took := <-ponged:
+			require.Less(t, took, deadline, "pong %d delayed by the busy handler", i)
+		case <-time.After(time.Second):
+			t.Fatalf("no pong to ping %d while the handler is busy", i)
+		}
+	}
+}
+
+func TestWsConnection_IdleReadTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	t.Run("silent peer", func(t *testing.T) {
+		srv := newTestWsServer(t, func(conn *websocket.Conn) {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+			serveUntilClosed(conn)
+		})
+		conn, recv := newTestWsConnection(t, srv, WithIdleReadTimeout(timeout))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		require.Equal(t, "hello", string((<-recv).Data()))
+		start := time.Now()
+		select {
+		case <-conn.CloseChan():
+		case <-time.After(5 * timeout):
+			t.Fatal("silent connection not closed")
+		}
+		require.Less(t, time.Since(start), 3*timeout)
+		require.ErrorIs(t, conn.CloseErr(), ErrReadIdleTimeout)
+	})
+
+	t.Run("pings keep the connection open", func(t *testing.T) {
+		srv := newTestWsServer(t, func(conn *websocket.Conn) {
+			go serveUntilClosed(conn)
+			for range 8 {
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+					return
+				}
+				time.Sleep(timeout / 3)
+			}
+		})
+		conn, _ := newTestWsConnection(t, srv, WithIdleReadTimeout(timeout))
+		require.NoError(t, conn.Open(context.Background()))
+		defer conn.Close()
+
+		time.Sleep(2 * timeout)
+		require.False(t, isClosed(conn.CloseChan()), "closed despite pings")
+	})
+}
+
+// newStalledListener returns the address of a listener accepting connections but never answering a handshake.
+func newStalledListener(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				_ = c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestWsConnection_OpenCancelledMidDial(t *testing.T) {
+	logger := newTestLogger(io.Discard)
+	addr := newStalledListener(t)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: url.URL{Scheme: "ws", Host: addr}}, nil
+	})
+	base := NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}))
+
+	handlers := map[string]ConnectionHandlerFactory{
+		"base":            base,
+		"backoff":         NewBackoffConnectionHandlerFactory(logger, base, func(int) time.Duration { return 0 }, time.Minute),
+		"reopen interval": NewReopenIntervalConnFactory(logger, time.Hour, base),
+	}
+
+	for name, factory := range handlers {
+		t.Run(name, func(t *testing.T) {
+			h := factory(&mockClient{}, func(Client, Message) {}, NewEventEmitter[EventType, EventType]())
+			defer h.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			err := h.Connect(ctx)
+			require.Less(t, time.Since(start), time.Second)
+			require.ErrorIs(t, err, ErrTerminated)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+		})
+	}
+}
+
+func TestWsConnection_CloseWithoutOpen(t *testing.T) {
+	awaitClosed := func(t *testing.T, conn *WsConnection) {
+		t.Helper()
+
+		select {
+		case <-conn.CloseChan():
+		case <-time.After(time.Second):
+			t.Fatal("close chan not closed")
+		}
+	}
+
+	t.Run("never opened", func(t *testing.T) {
+		conn, _ := newTestWsConnection(t, newTestWsServer(t, serveUntilClosed))
+
+		require.NotPanics(t, conn.Close)
+		awaitClosed(t, conn)
+		require.ErrorIs(t, conn.CloseErr(), ErrTerminated)
+		require.ErrorIs(t, conn.Open(context.Background()), ErrConnectionClosed, "opening once closed")
+	})
+
+	t.Run("failed dial", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		t.Cleanup(srv.Close)
+		conn, _ := newTestWsConnection(t, srv)
+
+		require.Error(t, conn.Open(context.Background()))
+		require.NotPanics(t, conn.Close)
+		awaitClosed(t, conn)
+	})
+
+	t.Run("failed params", func(t *testing.T) {
+		logger := newTestLogger(io.Discard)
+		repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+			return OpenConnectionParams{}, errors.New("no params")
+		})
+		conn := NewWebsocketConnection(websocket.DefaultDialer, repo, logger, make(chan Message, 1), ErrorAdapters{})
+
+		require.Error(t, conn.Open(context.Background()))
+		require.NotPanics(t, func() {
+			require.ErrorIs(t, conn.CloseWithReason(websocket.CloseNormalClosure, ""), ErrConnectionClosed)
+		})
+		awaitClosed(t, conn)
+	})
+}
+
+func TestWsConnection_DialHTTPError(t *testing.T) {
+	statuses := []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusServiceUnavailable}
+
+	for _, status := range statuses {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				_, _ = fmt.Fprintf(w, `{"code":%d}`, status)
+			}))
+			t.Cleanup(srv.Close)
+			conn, _ := newTestWsConnection(t, srv)
+
+			err := conn.Open(context.Background())
+
+			var httpErr *DialHTTPError
+			require.ErrorAs(t, err, &httpErr)
+			require.ErrorIs(t, err, ErrCannotConnect)
+			require.Equal(t, status, httpErr.StatusCode)
+			require.JSONEq(t, fmt.Sprintf(`{"code":%d}`, status), string(httpErr.Body))
+			require.Equal(t, "application/json", httpErr.Header.Get("Content-Type"))
+			require.Equal(t, status == http.StatusTooManyRequests, errors.Is(err, ErrRateLimit))
+		})
+	}
+}
+
+// closeRecorder is a response body telling whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestWsConnection_HandleDialErrorBody(t *testing.T) {
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, serveUntilClosed))
+	body := &closeRecorder{Reader: strings.NewReader(strings.Repeat("x", 2*maxDialErrorBody))}
+
+	err := conn.handleDialError(nil, &http.Response{StatusCode: http.StatusForbidden, Body: body}, websocket.ErrBadHandshake)
+
+	var httpErr *DialHTTPError
+	require.ErrorAs(t, err, &httpErr)
+	require.Len(t, httpErr.Body, maxDialErrorBody)
+	require.True(t, body.closed)
+}
+
+// pollCloseErr calls closeErr from a few goroutines until the returned function is called, which returns once they
+// stopped.
+func pollCloseErr(closeErr func() error) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !isClosed(done) {
+				_ = closeErr()
+			}
+		}()
+	}
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func TestWsConnection_CloseErrWhileTearingDown(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		// The peer drops the connection while it is closed locally, both racing to set the close reason.
+		srv := newTestWsServer(t, func(conn *websocket.Conn) { _ = conn.NetConn().Close() })
+		conn, _ := newTestWsConnection(t, srv)
+		stop := pollCloseErr(conn.CloseErr)
+
+		require.NoError(t, conn.Open(context.Background()))
+		conn.Close()
+		<-conn.CloseChan()
+		stop()
+
+		require.Error(t, conn.CloseErr(), "iteration %d", i)
+	}
+}
+
+func TestWsConnection_CloseWhileStarting(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+	conn, _ := newTestWsConnection(t, srv, WithWriteStallTimeout(time.Minute))
+
+	// Closed once connected, before its loops are started.
+	ctx := withOpenProgress(context.Background(), &openProgress{fn: func(stage OpenStage, _ string) {
+		if stage == OpenConnected {
+			conn.Close()
+		}
+	}})
+	_ = conn.Open(ctx)
+	<-conn.CloseChan()
+
+	require.Error(t, conn.CloseErr(), "closed before the loops exited")
+}
+
+func TestWsConnection_InfoWhileOpening(t *testing.T) {
+	srv := newTestWsServer(t, serveUntilClosed)
+	conn, _ := newTestWsConnection(t, srv)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for conn.HandshakeResponse() == nil {
+			_ = conn.Info()
+		}
+	}()
+	require.NoError(t, conn.Open(context.Background()))
+	<-done
+
+	require.NotNil(t, conn.Info().Handshake)
+	conn.Close()
+}
+
+// newTestClientCert returns a client certificate issued by a fresh CA, along with a pool trusting that CA.
+func newTestClientCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "collector"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestWsConnection_DialerOverrides(t *testing.T) {
+	clientCert, clientCAs := newTestClientCert(t)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serveUntilClosed(conn)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	u := testWsURL(t, srv)
+
+	// The dialer shared by all connections neither trusts the server nor has a client certificate.
+	newConn := func(p OpenConnectionParams) *WsConnection {
+		repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) { return p, nil })
+		conn := NewWebsocketConnection(websocket.DefaultDialer, repo, newTestLogger(io.Discard), make(chan Message, 8), ErrorAdapters{})
+		t.Cleanup(conn.Close)
+		return conn
+	}
+
+	t.Run("no overrides", func(t *testing.T) {
+		conn := newConn(NoopOpenConnectionParams)
+		require.Same(t, websocket.DefaultDialer, conn.dialerFor(NoopOpenConnectionParams))
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		err := newConn(OpenConnectionParams{URL: u, TLSConfig: &tls.Config{RootCAs: roots}}).Open(context.Background())
+		require.ErrorIs(t, err, ErrCannotConnect, "the server accepted a handshake without client certificate")
+
+		conn := newConn(OpenConnectionParams{URL: u, TLSConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{clientCert},
+		}})
+		require.NoError(t, conn.Open(context.Background()))
+		require.NoError(t, conn.Write(NewTextMessage([]byte("hi"))))
+	})
+
+	t.Run("handshake timeout", func(t *testing.T) {
+		// The listener accepts connections but never handshakes.
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = ln.Close() })
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer c.Close()
+			}
+		}()
+
+		start := time.Now()
+		conn := newConn(OpenConnectionParams{
+			URL:              url.URL{Scheme: "ws", Host: ln.Addr().String()},
+			HandshakeTimeout: 50 * time.Millisecond,
+		})
+		require.Error(t, conn.Open(context.Background()))
+		require.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		errProxy := errors.New("no proxy for this venue")
+		conn := newConn(OpenConnectionParams{URL: u, Proxy: func(*http.Request) (*url.URL, error) { return nil, errProxy }})
+		require.ErrorIs(t, conn.Open(context.Background()), errProxy)
+	})
+}
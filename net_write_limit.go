@@ -0,0 +1,81 @@
+package libws
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultWriteDelayThreshold is the delay of a paced write over which it is reported, see WithWriteDelayThreshold.
+const defaultWriteDelayThreshold = 100 * time.Millisecond
+
+// WithWriteRateLimit paces the data messages written to limit per second, with bursts of up to burst messages, as
+// venues cap the messages of a connection, e.g. 5 per second. The write loop waits for the rate before writing a
+// data message, later messages waiting behind it, while pings and pongs overtake them so that keep-alive never
+// starves. Close frames are not paced either. It is disabled by default.
+func WithWriteRateLimit(limit rate.Limit, burst int) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.writeLimiter = rate.NewLimiter(limit, max(burst, 1))
+	}
+}
+
+// WithWriteDelayThreshold sets the delay over which a write paced by WithWriteRateLimit is reported, with
+// EventWriteDelayed and DelayedWrites, 100ms by default.
+func WithWriteDelayThreshold(d time.Duration) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.writeDelayThreshold = d
+	}
+}
+
+// withWriteClock overrides the clock pacing writes.
+func withWriteClock(clk clock) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.writeClock = clk
+	}
+}
+
+// DelayedWrites returns how many writes were held back longer than the threshold of WithWriteDelayThreshold to stay
+// within the rate of WithWriteRateLimit.
+func (w *WsConnection) DelayedWrites() int {
+	return int(w.delayedWrites.Load())
+}
+
+// awaitWriteToken waits for the rate limit to allow writing a data message, writing the pings and pongs sent
+// meanwhile. It returns false once the write loop must stop, the close reason being recorded.
+func (w *WsConnection) awaitWriteToken(ctx context.Context) bool {
+	now := w.writeClock.Now()
+	delay := w.writeLimiter.ReserveN(now, 1).DelayFrom(now)
+	if delay <= 0 {
+		return true
+	}
+
+	if delay > w.writeDelayThreshold {
+		w.delayedWrites.Add(1)
+		w.logger.Debugf("write delayed by %s to stay within the rate limit", delay)
+		if w.emitter != nil {
+			w.emitter.Emit(EventWriteDelayed, EventWriteDelayed)
+		}
+	}
+
+	ready := make(chan struct{})
+	timer := w.writeClock.AfterFunc(delay, func() { close(ready) })
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ready:
+			return true
+		case msg := <-w.sendControl:
+			if !w.writeFrame(ctx, msg) {
+				return false
+			}
+		case <-w.stopC:
+			w.setCloseReason(ErrTerminated)
+			return false
+		case <-ctx.Done():
+			w.setCloseReason(ErrTerminated)
+			return false
+		}
+	}
+}
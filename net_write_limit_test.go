@@ -0,0 +1,100 @@
+package libws
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingServer records the data messages and pings it reads, in order.
+type recordingServer struct {
+	mu       sync.Mutex
+	received []string
+}
+
+func (s *recordingServer) serve(conn *websocket.Conn) {
+	conn.SetPingHandler(func(data string) error {
+		s.record("ping:" + data)
+		return nil
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.record(string(data))
+	}
+}
+
+func (s *recordingServer) record(m string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, m)
+}
+
+func (s *recordingServer) snapshot() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+func TestWsConnection_WriteRateLimit(t *testing.T) {
+	const messages = 100
+
+	clk := newFakeClock()
+	srv := &recordingServer{}
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, srv.serve),
+		WithWriteRateLimit(10, 1), WithWriteDelayThreshold(50*time.Millisecond), withWriteClock(clk))
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	start := clk.Now()
+	go func() {
+		for i := range messages {
+			_ = conn.Write(NewTextMessage([]byte(strconv.Itoa(i))))
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.snapshot()) < messages {
+		require.True(t, time.Now().Before(deadline), "received %d messages", len(srv.snapshot()))
+		if clk.Pending() > 0 {
+			clk.Advance(100 * time.Millisecond)
+			continue
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	require.Equal(t, (messages-1)*100*time.Millisecond, clk.Now().Sub(start))
+	expected := make([]string, messages)
+	for i := range expected {
+		expected[i] = strconv.Itoa(i)
+	}
+	require.Equal(t, expected, srv.snapshot())
+	require.Equal(t, messages-1, conn.DelayedWrites())
+}
+
+func TestWsConnection_WriteRateLimitControlBypass(t *testing.T) {
+	clk := newFakeClock()
+	srv := &recordingServer{}
+	conn, _ := newTestWsConnection(t, newTestWsServer(t, srv.serve), WithWriteRateLimit(1, 1), withWriteClock(clk))
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	require.NoError(t, conn.Write(NewTextMessage([]byte("first"))))
+	require.NoError(t, conn.Write(NewTextMessage([]byte("second"))))
+	require.NoError(t, conn.Write(NewPingMessage([]byte("keep-alive"))))
+
+	require.Eventually(t, func() bool { return len(srv.snapshot()) == 2 }, 2*time.Second, time.Millisecond)
+	require.Equal(t, []string{"first", "ping:keep-alive"}, srv.snapshot(), "the ping did not overtake the paced message")
+	require.Equal(t, 1, conn.DelayedWrites())
+
+	clk.Advance(time.Second)
+	require.Eventually(t, func() bool { return len(srv.snapshot()) == 3 }, 2*time.Second, time.Millisecond)
+	require.Equal(t, "second", srv.snapshot()[2])
+}
@@ -0,0 +1,66 @@
+package libws
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// writeStallFactor is the number of write timeouts a write may go without progress before it is deemed stalled, see
+// WithWriteStallTimeout.
+const writeStallFactor = 3
+
+// writeWatch tracks the progress of the write in progress, if any.
+type writeWatch struct {
+	// since is when the write in progress started or last made progress, in Unix nanoseconds, zero when idle
+	since atomic.Int64
+}
+
+// WithWriteStallTimeout closes the connection with ErrWriteStalled once a write made no progress for d, deadlines
+// notwithstanding, e.g. when one could not be set on the socket: the socket is closed to unwedge the write loop, and
+// the backoff layer reconnects. It defaults to three times the write timeout, see WithWriteTimeout. A negative d
+// disables it.
+func WithWriteStallTimeout(d time.Duration) WsConnectionOption {
+	return func(w *WsConnection) {
+		w.writeStallTimeout = d
+	}
+}
+
+// progress marks a write as started or progressing.
+func (ww *writeWatch) progress() {
+	ww.since.Store(time.Now().UnixNano())
+}
+
+// done marks the write in progress as over.
+func (ww *writeWatch) done() {
+	ww.since.Store(0)
+}
+
+// stalled reports whether a write made no progress for limit at now.
+func (ww *writeWatch) stalled(now time.Time, limit time.Duration) bool {
+	since := ww.since.Load()
+	return since != 0 && now.Sub(time.Unix(0, since)) >= limit
+}
+
+// watchWrites closes the connection once a write stalled, see WithWriteStallTimeout.
+func (w *WsConnection) watchWrites() {
+	defer w.loops.Done()
+
+	ticker := time.NewTicker(max(w.writeStallTimeout/4, time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stopC:
+			return
+		case now := <-ticker.C:
+			if !w.writeWatch.stalled(now, w.writeStallTimeout) {
+				continue
+			}
+
+			w.logger.Errorf("no write progress for %s, closing connection", w.writeStallTimeout)
+			w.CloseWithErr(fmt.Errorf("%w: no progress for %s", ErrWriteStalled, w.writeStallTimeout))
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// wedgingConn is a net.Conn whose writes hang once wedged, deadlines notwithstanding, until it is closed.
+type wedgingConn struct {
+	net.Conn
+	wedged    atomic.Bool
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *wedgingConn) Write(p []byte) (int, error) {
+	if c.wedged.Load() {
+		<-c.closed
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *wedgingConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// wedgingDials dials srv over wedgingConns, keeping track of them.
+type wedgingDials struct {
+	mu    sync.Mutex
+	conns []*wedgingConn
+}
+
+func (d *wedgingDials) repo(t *testing.T, srv *httptest.Server) OpenConnectionParamsRepo {
+	var dialer net.Dialer
+	u := testWsURL(t, srv)
+	netDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		wc := &wedgingConn{Conn: conn, closed: make(chan struct{})}
+		d.conns = append(d.conns, wc)
+		return wc, nil
+	}
+
+	return NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u, NetDial: netDial}, nil
+	})
+}
+
+func (d *wedgingDials) wedgeLast() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.conns[len(d.conns)-1].wedged.Store(true)
+}
+
+func TestWsConnection_WriteStalled(t *testing.T) {
+	const writeTimeout = 50 * time.Millisecond
+
+	dials := &wedgingDials{}
+	conn := NewWebsocketConnection(websocket.DefaultDialer, dials.repo(t, newTestWsServer(t, serveUntilClosed)),
+		nil, make(chan Message, 8), ErrorAdapters{}, WithWriteTimeout(writeTimeout))
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	dials.wedgeLast()
+	start := time.Now()
+	require.NoError(t, conn.Write(NewTextMessage([]byte("stuck"))))
+
+	select {
+	case <-conn.CloseChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("wedged connection not closed")
+	}
+	require.GreaterOrEqual(t, time.Since(start), writeStallFactor*writeTimeout)
+	require.ErrorIs(t, conn.CloseErr(), ErrWriteStalled)
+}
+
+func TestWsConnection_WriteStallReconnects(t *testing.T) {
+	var handshakes atomic.Int32
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		handshakes.Add(1)
+		serveUntilClosed(conn)
+	})
+
+	dials := &wedgingDials{}
+	logger := newTestLogger(io.Discard)
+	base := NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer,
+		dials.repo(t, srv), ErrorAdapters{}, WithWriteStallTimeout(100*time.Millisecond)))
+	cli := newBasicClient(
+		NewBackoffConnectionHandlerFactory(nil, base, func(int) time.Duration { return 0 }, time.Minute),
+		func(Client, Message) {},
+		func(Client, EventType) {},
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	dials.wedgeLast()
+	cli.Send(NewTextMessage([]byte("stuck")))
+
+	require.Eventually(t, func() bool { return handshakes.Load() == 2 }, time.Second, time.Millisecond)
+	require.False(t, isClosed(cli.CloseChan()), "the client gave up")
+}
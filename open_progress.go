@@ -0,0 +1,83 @@
+package libws
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// OpenStage is a stage of opening the initial connection of a client, see WithOpenProgress.
+type OpenStage int
+
+const (
+	// OpenParamsFetched is reported once the params of the connection were fetched, detailing the URL to dial.
+	OpenParamsFetched OpenStage = iota + 1
+	// OpenDialing is reported when the handshake starts, detailing its attempt number.
+	OpenDialing
+	// OpenConnected is reported once the handshake succeeded, detailing the address connected to.
+	OpenConnected
+	// OpenAuthenticating is reported by layers authenticating the connection, see ReportOpenProgress.
+	OpenAuthenticating
+	// OpenSubscribed is reported once subscriptions were sent, see ReplaySubscriptions and ReportOpenProgress.
+	OpenSubscribed
+	// OpenReady is reported last, once every layer is connected and Open is about to return successfully.
+	OpenReady
+)
+
+func (s OpenStage) String() string {
+	switch s {
+	case OpenParamsFetched:
+		return "params_fetched"
+	case OpenDialing:
+		return "dialing"
+	case OpenConnected:
+		return "connected"
+	case OpenAuthenticating:
+		return "authenticating"
+	case OpenSubscribed:
+		return "subscribed"
+	case OpenReady:
+		return "ready"
+	default:
+		return "unknown"
+	}
+}
+
+type (
+	// OpenProgressFunc is called as opening the initial connection of a client goes through its stages, with a
+	// human-readable detail, possibly empty.
+	OpenProgressFunc func(stage OpenStage, detail string)
+
+	// openProgress is the sink of the stages reported while a client opens, carried by the context given to the
+	// layers. It is silenced once Open returns, so that reconnections, sharing that context, report nothing.
+	openProgress struct {
+		fn   OpenProgressFunc
+		done atomic.Bool
+	}
+
+	openProgressKey struct{}
+)
+
+// WithOpenProgress makes Open report its progress to fn, from its goroutine, at the stages the layers of the client
+// go through. Stages the composition does not include are skipped, and stages repeat when opening is retried, e.g.
+// dialing. Nothing is reported once Open returned.
+func WithOpenProgress(fn OpenProgressFunc) ClientOption {
+	return func(b *basicClient) {
+		b.openProgress = fn
+	}
+}
+
+// withOpenProgress returns ctx carrying p, for the layers opening under ctx to report their stages to it.
+func withOpenProgress(ctx context.Context, p *openProgress) context.Context {
+	return context.WithValue(ctx, openProgressKey{}, p)
+}
+
+// ReportOpenProgress reports stage to the client opening under ctx, if it asked for it with WithOpenProgress. Layers
+// going through stages of their own, e.g. authenticating, call it from Connect.
+func ReportOpenProgress(ctx context.Context, stage OpenStage, detail string) {
+	p, ok := ctx.Value(openProgressKey{}).(*openProgress)
+	if !ok || p.done.Load() {
+		return
+	}
+
+	p.fn(stage, detail)
+}
@@ -0,0 +1,122 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// authenticatingHandler is a layer authenticating its connection, then subscribing, as venue-specific layers do.
+type authenticatingHandler struct {
+	ConnectionHandler
+	client Client
+}
+
+func (h *authenticatingHandler) Connect(ctx context.Context) error {
+	if err := h.ConnectionHandler.Connect(ctx); err != nil {
+		return err
+	}
+
+	ReportOpenProgress(ctx, OpenAuthenticating, "api key")
+	h.ConnectionHandler.Send(NewTextMessage([]byte("auth")))
+
+	return ReplaySubscriptions(ctx, h.client, []Message{NewTextMessage([]byte("subscribe"))}, nil)
+}
+
+func (h *authenticatingHandler) unwrapHandler() ConnectionHandler {
+	return h.ConnectionHandler
+}
+
+func TestClient_OpenProgress(t *testing.T) {
+	type report struct {
+		stage  OpenStage
+		detail string
+	}
+
+	noWait := func(int) time.Duration { return 0 }
+	compositions := map[string]struct {
+		build func(ConnectionHandlerFactory) ConnectionHandlerFactory
+		want  []OpenStage
+	}{
+		"dial": {
+			build: func(base ConnectionHandlerFactory) ConnectionHandlerFactory {
+				return NewBackoffConnectionHandlerFactory(nil, base, noWait, time.Minute)
+			},
+			want: []OpenStage{OpenParamsFetched, OpenDialing, OpenConnected, OpenReady},
+		},
+		"dial, auth and subscribe": {
+			build: func(base ConnectionHandlerFactory) ConnectionHandlerFactory {
+				authenticating := func(c Client, h MessageHandler, e emitter[EventType, EventType]) ConnectionHandler {
+					return &authenticatingHandler{ConnectionHandler: base(c, h, e), client: c}
+				}
+				return NewBackoffConnectionHandlerFactory(nil, authenticating, noWait, time.Minute)
+			},
+			want: []OpenStage{
+				OpenParamsFetched, OpenDialing, OpenConnected, OpenAuthenticating, OpenSubscribed, OpenReady,
+			},
+		},
+	}
+
+	for name, composition := range compositions {
+		t.Run(name, func(t *testing.T) {
+			// The first connection is dropped once open, the next ones are kept.
+			var connections atomic.Int32
+			srv := newTestWsServer(t, func(conn *websocket.Conn) {
+				if connections.Add(1) == 1 {
+					time.Sleep(50 * time.Millisecond)
+					return
+				}
+				serveUntilClosed(conn)
+			})
+			logger := newTestLogger(io.Discard)
+			u := testWsURL(t, srv)
+			repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+				return OpenConnectionParams{URL: u}, nil
+			})
+			base := NewBaseConnectionHandlerFactory(
+				logger,
+				NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}),
+			)
+
+			var (
+				mu      sync.Mutex
+				reports []report
+			)
+			stages := func() []OpenStage {
+				mu.Lock()
+				defer mu.Unlock()
+
+				s := make([]OpenStage, len(reports))
+				for i, r := range reports {
+					s[i] = r.stage
+				}
+				return s
+			}
+			cli := newBasicClient(
+				composition.build(base),
+				func(Client, Message) {},
+				func(Client, EventType) {},
+				WithOpenProgress(func(stage OpenStage, detail string) {
+					mu.Lock()
+					reports = append(reports, report{stage: stage, detail: detail})
+					mu.Unlock()
+				}),
+			)
+			require.NoError(t, cli.Open(context.Background()))
+			defer cli.Close()
+
+			require.Equal(t, composition.want, stages())
+			require.Equal(t, report{stage: OpenParamsFetched, detail: RedactURL(u)}, reports[0])
+			require.Equal(t, report{stage: OpenDialing, detail: "attempt 1"}, reports[1])
+
+			require.Eventually(t, func() bool { return connections.Load() == 2 }, 2*time.Second, time.Millisecond)
+			require.Equal(t, composition.want, stages(), "reconnecting reports nothing")
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package libws
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OutboundSplitter breaks an outbound message over maxSize bytes into several ones, each at most maxSize bytes, for
+// protocols allowing it. See WithOutboundSplitter.
+type OutboundSplitter func(m Message, maxSize int) []Message
+
+// OutboundValidator checks an outbound message before it is sent. A non-nil error prevents the message from
+// reaching the wire. Control frames are never validated.
+type OutboundValidator func(Message) error
+
+// ValidateJSON is an OutboundValidator rejecting messages whose payload is not valid JSON.
+func ValidateJSON(m Message) error {
+	if !json.Valid(m.Data()) {
+		return fmt.Errorf("payload is not valid JSON: %.64q", m.Data())
+	}
+
+	return nil
+}
+
+// checkOutboundSize returns an error wrapping ErrMessageTooLarge when the payload of m exceeds maxSize bytes. Control
+// frames and a zero maxSize are never checked.
+func checkOutboundSize(m Message, maxSize int) error {
+	if maxSize <= 0 || m.Type().IsControl() {
+		return nil
+	}
+
+	if size := len(m.Data()); size > maxSize {
+		return fmt.Errorf("%w: payload of %d bytes exceeds the maximum of %d", ErrMessageTooLarge, size, maxSize)
+	}
+
+	return nil
+}
+
+// checkWritable returns an error wrapping ErrSyntheticMessage when m is synthetic, see SyntheticMessageTypeMin, and
+// one wrapping ErrInvalidOutbound when m is of a type that cannot be written, close frames included, or a control frame
+// whose payload is over 125 bytes.
+func checkWritable(m Message) error {
+	switch t := m.Type(); {
+	case t.IsSynthetic():
+		return fmt.Errorf("%w: type %d", ErrSyntheticMessage, t)
+	case !t.IsData() && t != PingMessage && t != PongMessage:
+		return fmt.Errorf("%w: cannot write messages of type %d", ErrInvalidOutbound, t)
+	case t.IsControl() && len(m.Data()) > maxControlFramePayload:
+		return fmt.Errorf("%w: control payload of %d bytes exceeds the maximum of %d",
+			ErrInvalidOutbound, len(m.Data()), maxControlFramePayload)
+	}
+
+	return nil
+}
+
+// ValidateMaxSize returns an OutboundValidator rejecting messages whose payload exceeds n bytes.
+func ValidateMaxSize(n int) OutboundValidator {
+	return func(m Message) error {
+		if size := len(m.Data()); size > n {
+			return fmt.Errorf("payload of %d bytes exceeds the maximum of %d", size, n)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,143 @@
+package libws
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboundValidator(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+
+	type rejection struct {
+		msg Message
+		err error
+	}
+	var (
+		rejected []rejection
+		events   []EventType
+	)
+
+	cli := newBasicClient(
+		stubs.Factory,
+		func(Client, Message) {},
+		func(_ Client, e EventType) { events = append(events, e) },
+		WithOutboundValidator(ValidateJSON),
+		WithOutboundValidator(ValidateMaxSize(32)),
+		WithOnSendError(func(_ Client, m Message, err error) {
+			rejected = append(rejected, rejection{msg: m, err: err})
+		}),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+
+	valid := NewDataMessage([]byte(`{"op":"subscribe","args":["a"]}`))
+	malformed := NewDataMessage([]byte(`{"op":"subscribe","args":["a"]`))
+	oversized := NewDataMessage([]byte(`{"op":"subscribe","args":["a","b","c"]}`))
+	ping := NewPingMessage([]byte("not json at all, and longer than 32 bytes"))
+
+	for _, m := range []Message{valid, malformed, oversized, ping} {
+		cli.Send(m)
+	}
+
+	require.Equal(t, []Message{valid, ping}, stubs.Last().Sent(), "control frames skip validation")
+
+	require.Len(t, rejected, 2)
+	require.Equal(t, malformed, rejected[0].msg)
+	require.ErrorIs(t, rejected[0].err, ErrInvalidOutbound)
+	require.ErrorContains(t, rejected[0].err, "not valid JSON")
+	require.Equal(t, oversized, rejected[1].msg)
+	require.ErrorIs(t, rejected[1].err, ErrInvalidOutbound)
+	require.ErrorContains(t, rejected[1].err, "exceeds the maximum of 32")
+
+	require.EqualValues(t, 2, cli.OutboundRejected())
+	require.Equal(t, []EventType{EventOutboundRejected, EventOutboundRejected}, events)
+}
+
+func TestOutboundValidator_PassthroughWithoutValidators(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+
+	m := NewDataMessage([]byte("plain text"))
+	cli.Send(m)
+
+	require.Equal(t, []Message{m}, stubs.Last().Sent())
+	require.Zero(t, cli.OutboundRejected())
+}
+
+func TestMaxOutboundSize(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+
+	var rejected []error
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {},
+		WithMaxOutboundSize(8),
+		WithOnSendError(func(_ Client, _ Message, err error) { rejected = append(rejected, err) }),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	fits := NewDataMessage([]byte("12345678"))
+	oversized := NewDataMessage([]byte("123456789"))
+	ping := NewPingMessage([]byte("a ping payload over the limit"))
+
+	for _, m := range []Message{fits, oversized, ping} {
+		cli.Send(m)
+	}
+
+	require.Equal(t, []Message{fits, ping}, stubs.Last().Sent())
+	require.Len(t, rejected, 1)
+	require.ErrorIs(t, rejected[0], ErrMessageTooLarge)
+	require.EqualValues(t, 1, cli.OutboundRejected())
+}
+
+func TestMaxOutboundSize_Splitter(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+
+	var rejected []Message
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {},
+		WithMaxOutboundSize(4),
+		WithOutboundSplitter(func(m Message, maxSize int) []Message {
+			// Split on commas, as a protocol accepting batches of comma separated commands would.
+			var parts []Message
+			for _, part := range bytes.Split(m.Data(), []byte(",")) {
+				parts = append(parts, NewDataMessage(part))
+			}
+			return parts
+		}),
+		WithOnSendError(func(_ Client, m Message, _ error) { rejected = append(rejected, m) }),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	cli.Send(NewDataMessage([]byte("a,bb,toolong,ccc")))
+
+	require.Equal(t, []Message{
+		NewDataMessage([]byte("a")),
+		NewDataMessage([]byte("bb")),
+		NewDataMessage([]byte("ccc")),
+	}, stubs.Last().Sent())
+	require.Equal(t, []Message{NewDataMessage([]byte("toolong"))}, rejected)
+}
+
+func TestWsConnection_MaxWriteSize(t *testing.T) {
+	received := make(chan []byte, 4)
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		for {
+			_, bts, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- bts
+		}
+	})
+	conn, _ := newTestWsConnection(t, srv, WithMaxWriteSize(4))
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+
+	require.ErrorIs(t, conn.Write(NewDataMessage([]byte("too large"))), ErrMessageTooLarge)
+	require.NoError(t, conn.Write(NewDataMessage([]byte("fits"))))
+	require.Equal(t, []byte("fits"), <-received)
+}
@@ -0,0 +1,147 @@
+package libws
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type (
+	// Class names a class of inbound messages, as a MessageClassifier does.
+	Class = string
+
+	// OverloadShedder enforces per class inbound rate budgets, dropping the messages of a budgeted class in excess of
+	// its budget. Classes without a budget are protected: their messages always pass. Use it to shed low priority
+	// updates, e.g. tickers, during bursts rather than falling behind on the ones that matter, e.g. fills. Wrap the
+	// message handler with OverloadShedder.Wrap.
+	OverloadShedder struct {
+		classify func(Message) Class
+		onShed   func(Class, int)
+		clock    clock
+
+		mu      sync.Mutex
+		classes map[Class]*shedClass
+		// shedding is the number of classes currently shedding
+		shedding int
+	}
+
+	// shedClass is the budget of a class along with its counters.
+	shedClass struct {
+		limiter *rate.Limiter
+		shed    uint64
+		// streak is the number of messages shed since the class started shedding, zero when it is not
+		streak int
+	}
+)
+
+// NewOverloadShedder returns a shedder classifying messages with classify and holding the classes of budget to their
+// rate, in messages per second, with bursts of up to one second worth of messages. onShed, when not nil, is called
+// for every dropped message with its class and the number of messages of the class dropped since it started shedding.
+func NewOverloadShedder(classify func(Message) Class, budget map[Class]rate.Limit, onShed func(Class, int)) *OverloadShedder {
+	return newOverloadShedder(classify, budget, onShed, realClock{})
+}
+
+func newOverloadShedder(
+	classify func(Message) Class,
+	budget map[Class]rate.Limit,
+	onShed func(Class, int),
+	clk clock,
+) *OverloadShedder {
+	classes := make(map[Class]*shedClass, len(budget))
+	for class, limit := range budget {
+		classes[class] = &shedClass{limiter: rate.NewLimiter(limit, max(1, int(limit)))}
+	}
+
+	return &OverloadShedder{
+		classify: classify,
+		onShed:   onShed,
+		clock:    clk,
+		classes:  classes,
+	}
+}
+
+// Wrap returns a MessageHandler passing to next the messages within budget. EventSheddingStarted is emitted through
+// the client when a class starts shedding while none was, and EventSheddingStopped once no class is: a class stops
+// shedding as soon as any message, of whatever class, finds its budget refilled.
+func (s *OverloadShedder) Wrap(next MessageHandler) MessageHandler {
+	return func(cli Client, m Message) {
+		class := s.classify(m)
+		allowed, streak, stopped, started := s.admit(class)
+
+		if stopped {
+			emitEvent(cli, EventSheddingStopped)
+		}
+		if started {
+			emitEvent(cli, EventSheddingStarted)
+		}
+
+		if !allowed {
+			if s.onShed != nil {
+				s.onShed(class, streak)
+			}
+			discard(m)
+			return
+		}
+
+		next(cli, m)
+	}
+}
+
+// Shed returns how many messages were dropped so far, per budgeted class.
+func (s *OverloadShedder) Shed() map[Class]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shed := make(map[Class]uint64, len(s.classes))
+	for class, c := range s.classes {
+		shed[class] = c.shed
+	}
+	return shed
+}
+
+// admit decides whether a message of class passes. It also returns the shedding streak of the class and whether the
+// shedder as a whole stopped shedding, its classes having refilled their budget, and started again, the message being
+// shed.
+func (s *OverloadShedder) admit(class Class) (allowed bool, streak int, stopped, started bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	stopped = s.settle(now)
+
+	c, ok := s.classes[class]
+	if !ok || c.limiter.AllowN(now, 1) {
+		return true, 0, stopped, false
+	}
+
+	c.shed++
+	c.streak++
+	if c.streak > 1 {
+		return false, c.streak, stopped, false
+	}
+
+	s.shedding++
+	if s.shedding > 1 {
+		return false, c.streak, stopped, false
+	}
+
+	// A class stopping and starting again within the same message is no transition.
+	return false, c.streak, false, !stopped
+}
+
+// settle ends the streaks of the classes shedding whose budget refilled by now, reporting whether no class is
+// shedding anymore as a result. It must be called with mu held.
+func (s *OverloadShedder) settle(now time.Time) bool {
+	if s.shedding == 0 {
+		return false
+	}
+
+	for _, c := range s.classes {
+		if c.streak > 0 && c.limiter.TokensAt(now) >= 1 {
+			c.streak = 0
+			s.shedding--
+		}
+	}
+	return s.shedding == 0
+}
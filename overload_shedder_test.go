@@ -0,0 +1,114 @@
+package libws
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestOverloadShedder_ShedsOnlyBudgetedClass(t *testing.T) {
+	clk := newFakeClock()
+	c := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	defer c.Close()
+
+	var events []EventType
+	for _, event := range []EventType{EventSheddingStarted, EventSheddingStopped} {
+		listenEvent(c, event, listenInline, func(event EventType) { events = append(events, event) })
+	}
+
+	shedCalls := map[Class]int{}
+	lastStreak := 0
+	s := newOverloadShedder(
+		func(m Message) Class { return strings.SplitN(string(m.Data()), ":", 2)[0] },
+		map[Class]rate.Limit{"ticker": 10},
+		func(class Class, n int) {
+			shedCalls[class]++
+			lastStreak = n
+		},
+		clk,
+	)
+
+	passed := map[Class]int{}
+	handle := s.Wrap(func(_ Client, m Message) {
+		passed[strings.SplitN(string(m.Data()), ":", 2)[0]]++
+	})
+
+	// Both classes burst at 5x the ticker budget within the same instant.
+	for i := 0; i < 50; i++ {
+		handle(c, NewDataMessage([]byte("ticker:1")))
+		handle(c, NewDataMessage([]byte("fill:1")))
+	}
+
+	require.Equal(t, 50, passed["fill"])
+	require.Equal(t, 10, passed["ticker"])
+	require.Equal(t, map[Class]int{"ticker": 40}, shedCalls)
+	require.Equal(t, 40, lastStreak)
+	require.Equal(t, map[Class]uint64{"ticker": 40}, s.Shed())
+	require.Equal(t, []EventType{EventSheddingStarted}, events)
+
+	clk.Advance(time.Second)
+	handle(c, NewDataMessage([]byte("ticker:2")))
+	require.Equal(t, 11, passed["ticker"])
+	require.Equal(t, []EventType{EventSheddingStarted, EventSheddingStopped}, events)
+}
+
+func TestOverloadShedder_StopsOnOtherClasses(t *testing.T) {
+	clk := newFakeClock()
+	c := newBasicClient(nil, func(Client, Message) {}, func(Client, EventType) {})
+	defer c.Close()
+
+	var events []EventType
+	for _, event := range []EventType{EventSheddingStarted, EventSheddingStopped} {
+		listenEvent(c, event, listenInline, func(event EventType) { events = append(events, event) })
+	}
+
+	s := newOverloadShedder(
+		func(m Message) Class { return string(m.Data()) },
+		map[Class]rate.Limit{"ticker": 1, "book": 0.5},
+		nil,
+		clk,
+	)
+	handle := s.Wrap(func(Client, Message) {})
+
+	for i := 0; i < 2; i++ {
+		handle(c, NewDataMessage([]byte("ticker")))
+	}
+	require.Equal(t, []EventType{EventSheddingStarted}, events)
+
+	// The ticker refills while only fills arrive: shedding stops without waiting for the next ticker.
+	clk.Advance(time.Second)
+	handle(c, NewDataMessage([]byte("fill")))
+	require.Equal(t, []EventType{EventSheddingStarted, EventSheddingStopped}, events)
+
+	// Shedding lasts while any class is, the book refilling at half the pace of the ticker.
+	handle(c, NewDataMessage([]byte("ticker")))
+	handle(c, NewDataMessage([]byte("ticker")))
+	handle(c, NewDataMessage([]byte("book")))
+	handle(c, NewDataMessage([]byte("book")))
+	clk.Advance(time.Second)
+	handle(c, NewDataMessage([]byte("fill")))
+	require.Len(t, events, 3)
+	clk.Advance(time.Second)
+	handle(c, NewDataMessage([]byte("fill")))
+	require.Len(t, events, 4)
+
+	// The book starting to shed with the message finding the ticker refilled hands the shedding over, no transition.
+	handle(c, NewDataMessage([]byte("book")))
+	handle(c, NewDataMessage([]byte("ticker")))
+	handle(c, NewDataMessage([]byte("ticker")))
+	clk.Advance(time.Second)
+	handle(c, NewDataMessage([]byte("book")))
+	require.Len(t, events, 5)
+	clk.Advance(2 * time.Second)
+	handle(c, NewDataMessage([]byte("fill")))
+
+	require.Equal(t, []EventType{
+		EventSheddingStarted, EventSheddingStopped,
+		EventSheddingStarted, EventSheddingStopped,
+		EventSheddingStarted, EventSheddingStopped,
+	}, events)
+	require.Equal(t, map[Class]uint64{"ticker": 3, "book": 2}, s.Shed())
+}
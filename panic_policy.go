@@ -0,0 +1,75 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicPolicy decides what happens when a goroutine of the connection internals panics: the read and write loops of
+// the connection, the run loops of the connection handlers, and the MessageHandler and its middlewares, which are
+// called from them. A nil policy lets the panic crash the process, which is the default. Otherwise the panic is
+// recovered and logged along with its stack, then the policy is called with the recovered value and the stack, and
+// the connection or handler the goroutine belongs to is closed with an error wrapping ErrInternalPanic. A connection
+// closed that way is reopened by the backoff layer, if any. A policy may still crash the process by panicking.
+type PanicPolicy func(recovered any, stack []byte)
+
+type panicPolicyKey struct{}
+
+var defaultPanicPolicy atomic.Pointer[PanicPolicy]
+
+// PanicRecoverAndClose is the PanicPolicy recovering panics, doing nothing more than logging them.
+func PanicRecoverAndClose(any, []byte) {}
+
+// SetDefaultPanicPolicy sets the PanicPolicy of the clients not given one with WithPanicPolicy, and of connection
+// handlers used without a client. It applies to connections opened afterwards.
+func SetDefaultPanicPolicy(p PanicPolicy) {
+	defaultPanicPolicy.Store(&p)
+}
+
+// WithPanicPolicy sets the PanicPolicy of the client, overriding the one set with SetDefaultPanicPolicy.
+func WithPanicPolicy(p PanicPolicy) ClientOption {
+	return func(b *basicClient) {
+		b.panicPolicy = &p
+	}
+}
+
+// withPanicPolicy returns ctx carrying p, for the goroutines started under ctx to apply it.
+func withPanicPolicy(ctx context.Context, p PanicPolicy) context.Context {
+	return context.WithValue(ctx, panicPolicyKey{}, p)
+}
+
+// panicPolicyFrom returns the PanicPolicy carried by ctx, defaulting to the one set with SetDefaultPanicPolicy.
+func panicPolicyFrom(ctx context.Context) PanicPolicy {
+	if p, ok := ctx.Value(panicPolicyKey{}).(PanicPolicy); ok {
+		return p
+	}
+
+	if p := defaultPanicPolicy.Load(); p != nil {
+		return *p
+	}
+
+	return nil
+}
+
+// recoverPanic is deferred by the goroutines of the connection internals to apply the PanicPolicy carried by ctx.
+// Once a panic is recovered, closeWith is given an error wrapping ErrInternalPanic to close what the goroutine
+// belongs to.
+func recoverPanic(ctx context.Context, logger logger, closeWith func(error)) {
+	policy := panicPolicyFrom(ctx)
+	if policy == nil {
+		return
+	}
+
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	logger.Errorf("recovered from panic: %v\n%s", r, stack)
+	policy(r, stack)
+
+	closeWith(fmt.Errorf("%w: %v", ErrInternalPanic, r))
+}
@@ -0,0 +1,125 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// poisoned is a middleware panicking on the "poison" payload.
+func poisoned(next MessageHandler) MessageHandler {
+	return func(cli Client, m Message) {
+		if string(m.Data()) == "poison" {
+			panic("poisoned frame")
+		}
+		next(cli, m)
+	}
+}
+
+func TestPanicPolicy_RecoverAndCloseReconnects(t *testing.T) {
+	srv := newTestWsServer(t, func(conn *websocket.Conn) {
+		for {
+			mt, bts, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, bts); err != nil {
+				return
+			}
+		}
+	})
+
+	u := testWsURL(t, srv)
+	repo := NewOpenConnectionParamsRepo(nil, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+
+	var (
+		mu        sync.Mutex
+		recovered []any
+		stacks    [][]byte
+	)
+	received := make(chan string, 8)
+	reconnected := make(chan struct{}, 1)
+	cli := NewBasicClientFactory(
+		NewBackoffConnectionHandlerFactory(
+			nil,
+			NewBaseConnectionHandlerFactory(nil, NewWebsocketFactory(nil, websocket.DefaultDialer, repo, ErrorAdapters{})),
+			func(int) time.Duration { return 0 },
+			time.Minute,
+		),
+		poisoned(func(_ Client, m Message) { received <- string(m.Data()) }),
+		func(_ Client, e EventType) {
+			if e == EventReconnect {
+				reconnected <- struct{}{}
+			}
+		},
+		WithPanicPolicy(func(r any, stack []byte) {
+			mu.Lock()
+			recovered = append(recovered, r)
+			stacks = append(stacks, stack)
+			mu.Unlock()
+		}),
+	)()
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	cli.Send(NewDataMessage([]byte("poison")))
+
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not reopened after the panic")
+	}
+
+	mu.Lock()
+	require.Equal(t, []any{"poisoned frame"}, recovered)
+	require.Contains(t, string(stacks[0]), "poisoned")
+	mu.Unlock()
+
+	requireEcho(t, cli, received)
+}
+
+func TestPanicPolicy_ClosesWithErrInternalPanic(t *testing.T) {
+	source := NewSliceMessageSource([]TimedMessage{{Message: NewDataMessage([]byte("poison"))}})
+	h := newBaseConnectionHandler(
+		newTestLogger(io.Discard),
+		nil,
+		poisoned(func(Client, Message) {}),
+		nil,
+		NewSimulatedConnectionFactory(source, &MessageRecorder{}),
+	)
+
+	require.NoError(t, h.Connect(withPanicPolicy(context.Background(), PanicRecoverAndClose)))
+
+	select {
+	case <-h.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed after the panic")
+	}
+	require.ErrorIs(t, h.CloseErr(), ErrInternalPanic)
+	require.ErrorContains(t, h.CloseErr(), "poisoned frame")
+}
+
+func TestPanicPolicy_CrashByDefault(t *testing.T) {
+	require.Nil(t, panicPolicyFrom(context.Background()))
+
+	require.PanicsWithValue(t, "boom", func() {
+		defer recoverPanic(context.Background(), newTestLogger(io.Discard), func(error) {
+			t.Error("a crashing policy does not close")
+		})
+		panic("boom")
+	})
+
+	// A policy given to the client takes precedence over the default one, even when crashing.
+	SetDefaultPanicPolicy(PanicRecoverAndClose)
+	defer SetDefaultPanicPolicy(nil)
+
+	require.NotNil(t, panicPolicyFrom(context.Background()))
+	require.Nil(t, panicPolicyFrom(withPanicPolicy(context.Background(), nil)))
+}
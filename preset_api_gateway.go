@@ -0,0 +1,120 @@
+package libws
+
+import "time"
+
+const (
+	// APIGatewayIdleTimeout is how long AWS API Gateway keeps a WebSocket connection without traffic open.
+	APIGatewayIdleTimeout = 10 * time.Minute
+	// APIGatewayMaxLifetime is how long AWS API Gateway keeps a WebSocket connection open at most.
+	APIGatewayMaxLifetime = 2 * time.Hour
+)
+
+// apiGatewayLimitRatio is the fraction of a limit after which the preset acts, pinging or rotating, ahead of it.
+const apiGatewayLimitRatio = 0.95
+
+// APIGatewayCloseCodes describes the close codes sent by AWS API Gateway, to be passed to WithCloseCodeTable: it closes
+// connections reaching the idle timeout or their maximum lifetime with 1001 (going away), which merely calls for a
+// new connection.
+var APIGatewayCloseCodes = CloseCodeTable{
+	1001: {
+		Label:       "idle timeout or connection lifetime reached",
+		Reason:      DisconnectNormal,
+		Recoverable: true,
+	},
+}
+
+type (
+	// APIGatewayOption customizes the preset returned by NewAPIGatewayPreset.
+	APIGatewayOption func(*APIGatewayPreset)
+
+	// APIGatewayPreset composes the layers keeping a connection to an AWS API Gateway WebSocket API open across its
+	// limits: keep-alive messages just under the idle timeout and a rotation to a new connection just under the
+	// maximum lifetime.
+	APIGatewayPreset struct {
+		logger      logger
+		idleTimeout time.Duration
+		maxLifetime time.Duration
+		keepAlive   KeepAliveMessageFactory
+		clock       clock
+	}
+)
+
+// NewAPIGatewayPreset returns the preset of an AWS API Gateway WebSocket API, whose limits are APIGatewayIdleTimeout
+// and APIGatewayMaxLifetime unless WithAPIGatewayLimits says otherwise. Keep-alive messages are ping frames by
+// default, see WithAPIGatewayKeepAlive.
+func NewAPIGatewayPreset(opts ...APIGatewayOption) *APIGatewayPreset {
+	p := &APIGatewayPreset{
+		logger:      nopLogger{},
+		idleTimeout: APIGatewayIdleTimeout,
+		maxLifetime: APIGatewayMaxLifetime,
+		keepAlive:   NewKeepAliveMessageFactory(PingMessage, func() []byte { return nil }),
+		clock:       realClock{},
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// WithAPIGatewayLogger sets the logger of the layers composed by the preset.
+func WithAPIGatewayLogger(l logger) APIGatewayOption {
+	return func(p *APIGatewayPreset) {
+		p.logger = orNop(l)
+	}
+}
+
+// WithAPIGatewayLimits overrides the idle timeout and the maximum lifetime of connections, e.g. when the API is
+// configured with stricter ones.
+func WithAPIGatewayLimits(idleTimeout, maxLifetime time.Duration) APIGatewayOption {
+	return func(p *APIGatewayPreset) {
+		p.idleTimeout = idleTimeout
+		p.maxLifetime = maxLifetime
+	}
+}
+
+// WithAPIGatewayKeepAlive sets the keep-alive messages, e.g. a data message routed to a no-op route of the API.
+func WithAPIGatewayKeepAlive(f KeepAliveMessageFactory) APIGatewayOption {
+	return func(p *APIGatewayPreset) {
+		p.keepAlive = f
+	}
+}
+
+// withAPIGatewayClock overrides the clock driving keep-alive messages and rotations.
+func withAPIGatewayClock(clk clock) APIGatewayOption {
+	return func(p *APIGatewayPreset) {
+		p.clock = clk
+	}
+}
+
+// KeepAliveInterval returns the interval between keep-alive messages, just under the idle timeout.
+func (p *APIGatewayPreset) KeepAliveInterval() time.Duration {
+	return time.Duration(float64(p.idleTimeout) * apiGatewayLimitRatio)
+}
+
+// RotationInterval returns the interval between rotations to a new connection, just under the maximum lifetime.
+func (p *APIGatewayPreset) RotationInterval() time.Duration {
+	return time.Duration(float64(p.maxLifetime) * apiGatewayLimitRatio)
+}
+
+// ConnectionOptions returns the options of the connections to the API, to be passed to NewWebsocketFactory: closes
+// at the limits are classified with APIGatewayCloseCodes.
+func (p *APIGatewayPreset) ConnectionOptions() []WsConnectionOption {
+	return []WsConnectionOption{WithCloseCodeTable(APIGatewayCloseCodes)}
+}
+
+// Factory returns a factory of handlers sending keep-alive messages over a reopen interval layer rotating the
+// connections of factory, usually a base handler of a factory built with ConnectionOptions.
+func (p *APIGatewayPreset) Factory(factory ConnectionHandlerFactory) ConnectionHandlerFactory {
+	rotating := NewReopenIntervalConnFactory(p.logger, p.RotationInterval(), factory, withReopenClock(p.clock))
+
+	return newAdaptiveKeepAliveConnectionHandlerFactory(
+		p.logger,
+		rotating,
+		p.KeepAliveInterval(),
+		p.KeepAliveInterval(),
+		p.keepAlive,
+		p.clock,
+	)
+}
@@ -0,0 +1,198 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPIGateway is a websocket server enforcing the limits of API Gateway on a fake clock: connections are closed
+// with 1001 once idle for idleTimeout or maxLifetime old.
+type fakeAPIGateway struct {
+	clk         *fakeClock
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+
+	dials    atomic.Int32
+	open     atomic.Int32
+	received atomic.Int32
+	enforced atomic.Int32
+}
+
+func newFakeAPIGateway(t *testing.T, clk *fakeClock, idleTimeout, maxLifetime time.Duration) (*fakeAPIGateway, *httptest.Server) {
+	t.Helper()
+
+	gw := &fakeAPIGateway{clk: clk, idleTimeout: idleTimeout, maxLifetime: maxLifetime}
+	return gw, newTestWsServer(t, gw.serve)
+}
+
+func (gw *fakeAPIGateway) serve(conn *websocket.Conn) {
+	gw.dials.Add(1)
+	gw.open.Add(1)
+	defer gw.open.Add(-1)
+
+	var (
+		mu       sync.Mutex
+		once     sync.Once
+		idle     clockTimer
+		lifetime clockTimer
+	)
+	enforce := func() {
+		once.Do(func() {
+			gw.enforced.Add(1)
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "limit reached")
+			_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			_ = conn.Close()
+		})
+	}
+	active := func() {
+		gw.received.Add(1)
+
+		mu.Lock()
+		defer mu.Unlock()
+		idle.Stop()
+		idle = gw.clk.AfterFunc(gw.idleTimeout, enforce)
+	}
+
+	mu.Lock()
+	idle = gw.clk.AfterFunc(gw.idleTimeout, enforce)
+	lifetime = gw.clk.AfterFunc(gw.maxLifetime, enforce)
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		idle.Stop()
+		lifetime.Stop()
+	}()
+
+	conn.SetPingHandler(func(data string) error {
+		active()
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		active()
+	}
+}
+
+// newAPIGatewayClient returns a client of srv composed by preset, along with the handlers of its connections.
+func newAPIGatewayClient(t *testing.T, srv *httptest.Server, preset *APIGatewayPreset) (*basicClient, func() []ConnectionHandler) {
+	t.Helper()
+
+	u := testWsURL(t, srv)
+	logger := newTestLogger(io.Discard)
+	repo := NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+		return OpenConnectionParams{URL: u}, nil
+	})
+	base := NewBaseConnectionHandlerFactory(
+		logger,
+		NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}, preset.ConnectionOptions()...),
+	)
+
+	var (
+		mu       sync.Mutex
+		handlers []ConnectionHandler
+	)
+	recording := func(client Client, handler MessageHandler, emitter emitter[EventType, EventType]) ConnectionHandler {
+		h := base(client, handler, emitter)
+		mu.Lock()
+		handlers = append(handlers, h)
+		mu.Unlock()
+		return h
+	}
+
+	cli := newBasicClient(preset.Factory(recording), func(Client, Message) {}, func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+	t.Cleanup(cli.Close)
+
+	return cli, func() []ConnectionHandler {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]ConnectionHandler(nil), handlers...)
+	}
+}
+
+// countingPings returns a keep-alive message factory of ping frames, along with the number of pings made so far.
+func countingPings() (KeepAliveMessageFactory, *atomic.Int32) {
+	var pings atomic.Int32
+	return func() Message {
+		pings.Add(1)
+		return NewPingMessage(nil)
+	}, &pings
+}
+
+// driveAPIGateway advances clk by d in steps of 30 seconds, letting the client and gw settle after each step: a
+// single connection is open, dialed once plus once per rotation, every ping reached the server, and the keep-alive
+// timer, the reopen ticker and both limits of the server are pending.
+func driveAPIGateway(t *testing.T, clk *fakeClock, gw *fakeAPIGateway, pings *atomic.Int32, d time.Duration, dials func() int32) {
+	t.Helper()
+
+	const step = 30 * time.Second
+
+	settle := func() {
+		require.Eventually(t, func() bool {
+			return gw.dials.Load() == dials() &&
+				gw.open.Load() == 1 &&
+				gw.received.Load() == pings.Load() &&
+				clk.Pending() == 4
+		}, 2*time.Second, time.Millisecond, "at %s", clk.Now())
+	}
+
+	settle()
+	for range d / step {
+		clk.Advance(step)
+		settle()
+	}
+}
+
+func TestAPIGatewayPreset_StaysWithinLimits(t *testing.T) {
+	clk := newFakeClock()
+	gw, srv := newFakeAPIGateway(t, clk, APIGatewayIdleTimeout, APIGatewayMaxLifetime)
+	keepAlive, pings := countingPings()
+	preset := NewAPIGatewayPreset(withAPIGatewayClock(clk), WithAPIGatewayKeepAlive(keepAlive))
+	require.Equal(t, 9*time.Minute+30*time.Second, preset.KeepAliveInterval())
+	require.Equal(t, time.Hour+54*time.Minute, preset.RotationInterval())
+	newAPIGatewayClient(t, srv, preset)
+
+	start := clk.Now()
+	driveAPIGateway(t, clk, gw, pings, 5*time.Hour, func() int32 {
+		return 1 + int32(clk.Now().Sub(start)/preset.RotationInterval())
+	})
+
+	require.Zero(t, gw.enforced.Load(), "API Gateway closed a connection")
+	require.EqualValues(t, 3, gw.dials.Load())
+	require.EqualValues(t, 5*time.Hour/preset.KeepAliveInterval(), pings.Load())
+}
+
+func TestAPIGatewayPreset_GoingAwayIsNormal(t *testing.T) {
+	clk := newFakeClock()
+	// The API enforces a stricter lifetime than the preset expects.
+	gw, srv := newFakeAPIGateway(t, clk, APIGatewayIdleTimeout, time.Hour)
+	keepAlive, pings := countingPings()
+	cli, handlers := newAPIGatewayClient(t, srv, NewAPIGatewayPreset(withAPIGatewayClock(clk), WithAPIGatewayKeepAlive(keepAlive)))
+
+	start := clk.Now()
+	driveAPIGateway(t, clk, gw, pings, 90*time.Minute, func() int32 {
+		return 1 + int32(clk.Now().Sub(start)/time.Hour)
+	})
+
+	require.EqualValues(t, 1, gw.enforced.Load())
+	require.False(t, isClosed(cli.CloseChan()), "the client reconnected")
+
+	first := handlers()[0]
+	var closeErr *CloseCodeError
+	require.True(t, errors.As(first.CloseErr(), &closeErr), "close reason: %v", first.CloseErr())
+	require.Equal(t, websocket.CloseGoingAway, closeErr.Code)
+	require.Equal(t, DisconnectNormal, closeErr.Info.Reason)
+	require.True(t, closeErr.Info.Recoverable)
+}
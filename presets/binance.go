@@ -0,0 +1,148 @@
+package presets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/sonirico/libws"
+)
+
+const (
+	// BinanceMaxStreams is the number of streams a single Binance connection may subscribe to.
+	BinanceMaxStreams = 1024
+	// BinanceMaxURLLength bounds the length of combined stream URLs, as the handshake request line of longer ones is
+	// refused.
+	BinanceMaxURLLength = 8192
+)
+
+var (
+	ErrBinanceNoStreams        = errors.New("binance: no stream to combine")
+	ErrBinanceInvalidStream    = errors.New("binance: invalid stream name")
+	ErrBinanceTooManyStreams   = errors.New("binance: too many streams")
+	ErrBinanceURLTooLong       = errors.New("binance: combined stream URL too long")
+	ErrBinanceMalformedMessage = errors.New("binance: malformed combined stream message")
+	ErrBinanceUnknownStream    = errors.New("binance: message of an unknown stream")
+)
+
+type (
+	// BinanceStreamRouter routes the messages of a Binance combined stream connection, see BinanceCombinedURL, to the
+	// handler of their stream, which is passed the data of the envelope only. Register handlers before the client is
+	// opened, then use HandleMessage as the message handler of the client, see libws.NewBasicClientFactoryE.
+	BinanceStreamRouter struct {
+		mu       sync.RWMutex
+		handlers map[string]libws.MessageHandler
+		fallback libws.MessageHandler
+	}
+
+	// binanceEnvelope is the envelope of combined stream messages.
+	binanceEnvelope struct {
+		Stream string          `json:"stream"`
+		Data   json.RawMessage `json:"data"`
+	}
+)
+
+// BinanceCombinedURL returns the URL of the combined stream of streams, e.g. btcusdt@aggTrade, on the endpoint base,
+// e.g. wss://stream.binance.com:9443. Duplicate streams are only subscribed once. It fails with
+// ErrBinanceTooManyStreams past BinanceMaxStreams streams and with ErrBinanceURLTooLong past BinanceMaxURLLength: split
+// streams across connections then.
+func BinanceCombinedURL(base url.URL, streams []string) (url.URL, error) {
+	if len(streams) == 0 {
+		return url.URL{}, ErrBinanceNoStreams
+	}
+
+	seen := make(map[string]struct{}, len(streams))
+	unique := make([]string, 0, len(streams))
+	for _, stream := range streams {
+		if stream == "" || strings.ContainsAny(stream, "/&#? ") {
+			return url.URL{}, fmt.Errorf("%w: %q", ErrBinanceInvalidStream, stream)
+		}
+		if _, ok := seen[stream]; ok {
+			continue
+		}
+		seen[stream] = struct{}{}
+		unique = append(unique, stream)
+	}
+
+	if len(unique) > BinanceMaxStreams {
+		return url.URL{}, fmt.Errorf("%w: %d, at most %d per connection", ErrBinanceTooManyStreams, len(unique), BinanceMaxStreams)
+	}
+
+	u := base
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(u.Path, "/stream") {
+		u.Path += "/stream"
+	}
+	u.RawPath = ""
+	u.RawQuery = "streams=" + strings.Join(unique, "/")
+	u.Fragment = ""
+
+	if n := len(u.String()); n > BinanceMaxURLLength {
+		return url.URL{}, fmt.Errorf("%w: %d bytes, at most %d", ErrBinanceURLTooLong, n, BinanceMaxURLLength)
+	}
+
+	return u, nil
+}
+
+// NewBinanceStreamRouter returns a BinanceStreamRouter without handlers.
+func NewBinanceStreamRouter() *BinanceStreamRouter {
+	return &BinanceStreamRouter{handlers: make(map[string]libws.MessageHandler)}
+}
+
+// Handle routes the messages of stream, as named in the URL, to h.
+func (r *BinanceStreamRouter) Handle(stream string, h libws.MessageHandler) *BinanceStreamRouter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[stream] = h
+	return r
+}
+
+// HandleUnknown routes the messages of streams without a handler to h, which is passed the whole message rather than
+// its data, as it may not even be an envelope, e.g. the reply to a subscription request.
+func (r *BinanceStreamRouter) HandleUnknown(h libws.MessageHandler) *BinanceStreamRouter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fallback = h
+	return r
+}
+
+// HandleMessage is a libws.MessageHandlerE routing m to the handler of its stream. Messages which are not envelopes
+// fail with ErrBinanceMalformedMessage and those of streams without a handler with ErrBinanceUnknownStream, unless
+// there is a handler for unknown streams, see HandleUnknown, which is then passed both.
+func (r *BinanceStreamRouter) HandleMessage(c libws.Client, m libws.Message) error {
+	r.mu.RLock()
+	fallback := r.fallback
+	r.mu.RUnlock()
+
+	var envelope binanceEnvelope
+	if err := json.Unmarshal(m.Data(), &envelope); err != nil || envelope.Stream == "" || len(envelope.Data) == 0 {
+		if fallback != nil {
+			fallback(c, m)
+			return nil
+		}
+		if err == nil {
+			err = errors.New("stream or data missing")
+		}
+		return fmt.Errorf("%w: %s", ErrBinanceMalformedMessage, err)
+	}
+
+	r.mu.RLock()
+	h, ok := r.handlers[envelope.Stream]
+	r.mu.RUnlock()
+
+	if !ok {
+		if fallback != nil {
+			fallback(c, m)
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrBinanceUnknownStream, envelope.Stream)
+	}
+
+	h(c, libws.NewMessage(m.Type(), envelope.Data))
+	return nil
+}
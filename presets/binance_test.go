@@ -0,0 +1,112 @@
+package presets
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sonirico/libws"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseURL(t *testing.T, raw string) url.URL {
+	t.Helper()
+
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return *u
+}
+
+func TestBinanceCombinedURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		streams []string
+		want    string
+	}{
+		{
+			name:    "single stream",
+			base:    "wss://stream.binance.com:9443",
+			streams: []string{"btcusdt@aggTrade"},
+			want:    "wss://stream.binance.com:9443/stream?streams=btcusdt@aggTrade",
+		},
+		{
+			name:    "several streams, duplicates once",
+			base:    "wss://stream.binance.com:9443/",
+			streams: []string{"btcusdt@aggTrade", "ethusdt@depth@100ms", "btcusdt@aggTrade"},
+			want:    "wss://stream.binance.com:9443/stream?streams=btcusdt@aggTrade/ethusdt@depth@100ms",
+		},
+		{
+			name:    "base already pointing to the combined endpoint",
+			base:    "wss://fstream.binance.com/stream?streams=old",
+			streams: []string{"btcusdt@markPrice"},
+			want:    "wss://fstream.binance.com/stream?streams=btcusdt@markPrice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := BinanceCombinedURL(mustParseURL(t, tt.base), tt.streams)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, u.String())
+		})
+	}
+}
+
+func TestBinanceCombinedURL_Limits(t *testing.T) {
+	base := mustParseURL(t, "wss://stream.binance.com:9443")
+
+	_, err := BinanceCombinedURL(base, nil)
+	require.ErrorIs(t, err, ErrBinanceNoStreams)
+
+	for _, stream := range []string{"", "btcusdt/x", "btcusdt&x=1", "btc usdt"} {
+		_, err = BinanceCombinedURL(base, []string{"ethusdt@trade", stream})
+		require.ErrorIs(t, err, ErrBinanceInvalidStream, "stream %q", stream)
+	}
+
+	streams := make([]string, BinanceMaxStreams)
+	for i := range streams {
+		streams[i] = fmt.Sprintf("s%d", i)
+	}
+	_, err = BinanceCombinedURL(base, streams)
+	require.NoError(t, err)
+
+	_, err = BinanceCombinedURL(base, append(streams, "one-too-many"))
+	require.ErrorIs(t, err, ErrBinanceTooManyStreams)
+
+	long := make([]string, 200)
+	for i := range long {
+		long[i] = fmt.Sprintf("%s%d@depth@100ms", strings.Repeat("x", 30), i)
+	}
+	_, err = BinanceCombinedURL(base, long)
+	require.ErrorIs(t, err, ErrBinanceURLTooLong)
+}
+
+func TestBinanceStreamRouter(t *testing.T) {
+	var trades, depths []string
+	router := NewBinanceStreamRouter().
+		Handle("btcusdt@aggTrade", func(_ libws.Client, m libws.Message) { trades = append(trades, string(m.Data())) }).
+		Handle("btcusdt@depth", func(_ libws.Client, m libws.Message) { depths = append(depths, string(m.Data())) })
+
+	route := func(raw string) error {
+		return router.HandleMessage(nil, libws.NewDataMessage([]byte(raw)))
+	}
+
+	require.NoError(t, route(`{"stream":"btcusdt@aggTrade","data":{"e":"aggTrade","p":"1.0"}}`))
+	require.NoError(t, route(`{"stream":"btcusdt@depth","data":{"e":"depthUpdate"}}`))
+	require.Equal(t, []string{`{"e":"aggTrade","p":"1.0"}`}, trades)
+	require.Equal(t, []string{`{"e":"depthUpdate"}`}, depths)
+
+	require.ErrorIs(t, route(`{"stream":"ethusdt@aggTrade","data":{}}`), ErrBinanceUnknownStream)
+	for _, raw := range []string{`not json`, `{"data":{}}`, `{"stream":"btcusdt@aggTrade"}`, `{"result":null,"id":1}`} {
+		require.ErrorIs(t, route(raw), ErrBinanceMalformedMessage, raw)
+	}
+
+	var unknown []string
+	router.HandleUnknown(func(_ libws.Client, m libws.Message) { unknown = append(unknown, string(m.Data())) })
+	require.NoError(t, route(`{"result":null,"id":1}`))
+	require.NoError(t, route(`{"stream":"ethusdt@aggTrade","data":{}}`))
+	require.Equal(t, []string{`{"result":null,"id":1}`, `{"stream":"ethusdt@aggTrade","data":{}}`}, unknown)
+	require.Len(t, trades, 1)
+}
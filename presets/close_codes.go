@@ -0,0 +1,41 @@
+// Package presets holds ready-made configuration for well-known venues.
+package presets
+
+import "github.com/sonirico/libws"
+
+// The tables below are examples of venue-specific close code dictionaries, to be passed to libws.WithCloseCodeTable.
+// Venues change their behaviour without notice: check their documentation and extend the tables as needed.
+var (
+	// BinanceCloseCodes describes the close codes sent by Binance market and user data streams.
+	BinanceCloseCodes = libws.CloseCodeTable{
+		1001: {
+			Label:       "server restart or 24h connection lifetime reached",
+			Reason:      libws.DisconnectServerRestart,
+			Recoverable: true,
+		},
+		1008: {
+			Label:       "too many messages or subscriptions",
+			Reason:      libws.DisconnectRateLimit,
+			Recoverable: true,
+		},
+	}
+
+	// BybitCloseCodes describes the close codes sent by Bybit v5 public and private streams.
+	BybitCloseCodes = libws.CloseCodeTable{
+		1001: {
+			Label:       "server maintenance",
+			Reason:      libws.DisconnectServerRestart,
+			Recoverable: true,
+		},
+		1008: {
+			Label:       "request limit exceeded",
+			Reason:      libws.DisconnectRateLimit,
+			Recoverable: true,
+		},
+		4001: {
+			Label:       "authentication failed",
+			Reason:      libws.DisconnectAuth,
+			Recoverable: false,
+		},
+	}
+)
@@ -0,0 +1,209 @@
+package libws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Priorities of the outbound messages sharing a RateBudget, highest first.
+const (
+	// RatePriorityControl is the priority of control frames, keep-alive pings included.
+	RatePriorityControl RatePriority = iota
+	// RatePriorityReplay is the priority of subscriptions replayed with ReplaySubscriptions.
+	RatePriorityReplay
+	// RatePriorityUser is the priority of the messages sent with Send.
+	RatePriorityUser
+
+	ratePriorities = 3
+)
+
+type (
+	// RatePriority is the class of an outbound message drawing from a RateBudget. When the budget is exhausted,
+	// waiting messages of a higher class are granted first, then messages of the same class in order.
+	RatePriority int
+
+	// RateBudget is an outbound rate limit of limit messages per sliding window of per, shared by every sender of a
+	// client, as venues count all outbound messages against a single limit. Give it to a client with WithRateBudget:
+	// Send, ReplaySubscriptions and active keep-alive pings then all draw from it. It is safe for concurrent use.
+	RateBudget struct {
+		limit int
+		per   time.Duration
+		clock clock
+
+		mu sync.Mutex
+		// grants holds the times of the grants within the current window, oldest first
+		grants  []time.Time
+		waiters [ratePriorities][]*rateWaiter
+		timer   clockTimer
+		granted [ratePriorities]uint64
+		// onGrant, when set, observes every grant along with the time it was requested
+		onGrant func(p RatePriority, requested, granted time.Time)
+	}
+
+	rateWaiter struct {
+		c         chan struct{}
+		requested time.Time
+		granted   bool
+	}
+
+	// RateBudgetStats is a snapshot of a RateBudget.
+	RateBudgetStats struct {
+		// Limit is the number of messages allowed per window.
+		Limit int
+		// Remaining is the number of messages which can be sent right away.
+		Remaining int
+		// Granted counts the messages granted so far, per priority.
+		Granted map[RatePriority]uint64
+		// Waiting counts the messages waiting for the budget, per priority.
+		Waiting map[RatePriority]int
+	}
+)
+
+// NewRateBudget returns a RateBudget of limit messages per sliding window of per.
+func NewRateBudget(limit int, per time.Duration) *RateBudget {
+	return newRateBudget(limit, per, realClock{})
+}
+
+func newRateBudget(limit int, per time.Duration, clk clock) *RateBudget {
+	return &RateBudget{limit: max(limit, 1), per: per, clock: clk}
+}
+
+// WithRateBudget makes every outbound message of the client draw from b, see RateBudget. Send blocks while the budget
+// is exhausted. b is registered as a handle of the client, retrieve it with Handle[*RateBudget](client).
+func WithRateBudget(b *RateBudget) ClientOption {
+	return func(c *basicClient) {
+		c.rateBudget = registerHandle(c, b)
+	}
+}
+
+func (p RatePriority) String() string {
+	switch p {
+	case RatePriorityControl:
+		return "control"
+	case RatePriorityReplay:
+		return "replay"
+	case RatePriorityUser:
+		return "user"
+	default:
+		return "unknown"
+	}
+}
+
+// Wait blocks until a message of priority p may be sent, or ctx is done, in which case it returns the error of ctx.
+func (b *RateBudget) Wait(ctx context.Context, p RatePriority) error {
+	p = min(max(p, RatePriorityControl), RatePriorityUser)
+
+	b.mu.Lock()
+	w := &rateWaiter{c: make(chan struct{}), requested: b.clock.Now()}
+	b.waiters[p] = append(b.waiters[p], w)
+	b.dispatchLocked()
+	b.mu.Unlock()
+
+	select {
+	case <-w.c:
+		return nil
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if w.granted {
+		return nil
+	}
+
+	for i, other := range b.waiters[p] {
+		if other == w {
+			b.waiters[p] = append(b.waiters[p][:i], b.waiters[p][i+1:]...)
+			break
+		}
+	}
+
+	return ctx.Err()
+}
+
+// Remaining returns the number of messages which can be sent right away.
+func (b *RateBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pruneLocked(b.clock.Now())
+	if b.waitingLocked() > 0 {
+		return 0
+	}
+
+	return b.limit - len(b.grants)
+}
+
+// Stats returns a snapshot of the budget.
+func (b *RateBudget) Stats() RateBudgetStats {
+	remaining := b.Remaining()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := RateBudgetStats{
+		Limit:     b.limit,
+		Remaining: remaining,
+		Granted:   make(map[RatePriority]uint64, ratePriorities),
+		Waiting:   make(map[RatePriority]int, ratePriorities),
+	}
+	for p := range ratePriorities {
+		stats.Granted[RatePriority(p)] = b.granted[p]
+		stats.Waiting[RatePriority(p)] = len(b.waiters[p])
+	}
+
+	return stats
+}
+
+// dispatchLocked grants the budget left to the waiters, highest priority first, and arms the timer granting the next
+// ones once the oldest grant leaves the window.
+func (b *RateBudget) dispatchLocked() {
+	now := b.clock.Now()
+	b.pruneLocked(now)
+
+	for p := range ratePriorities {
+		for len(b.waiters[p]) > 0 && len(b.grants) < b.limit {
+			w := b.waiters[p][0]
+			b.waiters[p][0] = nil
+			b.waiters[p] = b.waiters[p][1:]
+
+			w.granted = true
+			close(w.c)
+			b.grants = append(b.grants, now)
+			b.granted[p]++
+			if b.onGrant != nil {
+				b.onGrant(RatePriority(p), w.requested, now)
+			}
+		}
+	}
+
+	if b.waitingLocked() == 0 || b.timer != nil {
+		return
+	}
+
+	b.timer = b.clock.AfterFunc(b.grants[0].Add(b.per).Sub(now), func() {
+		b.mu.Lock()
+		b.timer = nil
+		b.dispatchLocked()
+		b.mu.Unlock()
+	})
+}
+
+// pruneLocked forgets the grants which left the window.
+func (b *RateBudget) pruneLocked(now time.Time) {
+	i := 0
+	for i < len(b.grants) && !b.grants[i].Add(b.per).After(now) {
+		i++
+	}
+	b.grants = b.grants[i:]
+}
+
+func (b *RateBudget) waitingLocked() int {
+	waiting := 0
+	for p := range ratePriorities {
+		waiting += len(b.waiters[p])
+	}
+	return waiting
+}
@@ -0,0 +1,178 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// grantLog records the grants of a RateBudget.
+type grantLog struct {
+	mu     sync.Mutex
+	grants []rateGrant
+}
+
+type rateGrant struct {
+	priority           RatePriority
+	requested, granted time.Time
+}
+
+func (l *grantLog) observe(b *RateBudget) {
+	b.onGrant = func(p RatePriority, requested, granted time.Time) {
+		l.mu.Lock()
+		l.grants = append(l.grants, rateGrant{priority: p, requested: requested, granted: granted})
+		l.mu.Unlock()
+	}
+}
+
+func (l *grantLog) priorities() []RatePriority {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	priorities := make([]RatePriority, len(l.grants))
+	for i, g := range l.grants {
+		priorities[i] = g.priority
+	}
+	return priorities
+}
+
+func (l *grantLog) snapshot() []rateGrant {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]rateGrant(nil), l.grants...)
+}
+
+func TestRateBudget_Priorities(t *testing.T) {
+	clk := newFakeClock()
+	budget := newRateBudget(2, time.Second, clk)
+	log := &grantLog{}
+	log.observe(budget)
+
+	require.NoError(t, budget.Wait(context.Background(), RatePriorityUser))
+	require.NoError(t, budget.Wait(context.Background(), RatePriorityUser))
+	require.Zero(t, budget.Remaining())
+
+	// Queue, in this order, a user, a replay and a control message.
+	for _, p := range []RatePriority{RatePriorityUser, RatePriorityReplay, RatePriorityControl} {
+		go func() { _ = budget.Wait(context.Background(), p) }()
+		require.Eventually(t, func() bool { return budget.Stats().Waiting[p] == 1 }, time.Second, time.Millisecond)
+	}
+
+	clk.Advance(time.Second)
+	require.Equal(t, []RatePriority{RatePriorityUser, RatePriorityUser, RatePriorityControl, RatePriorityReplay}, log.priorities())
+
+	clk.Advance(time.Second)
+	require.Equal(t, RatePriorityUser, log.priorities()[4])
+
+	stats := budget.Stats()
+	require.Equal(t, 2, stats.Limit)
+	require.Equal(t, 1, stats.Remaining)
+	require.Equal(t, map[RatePriority]uint64{RatePriorityControl: 1, RatePriorityReplay: 1, RatePriorityUser: 3}, stats.Granted)
+}
+
+func TestRateBudget_WaitCancelled(t *testing.T) {
+	budget := newRateBudget(1, time.Second, newFakeClock())
+	require.NoError(t, budget.Wait(context.Background(), RatePriorityUser))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, budget.Wait(ctx, RatePriorityUser), context.DeadlineExceeded)
+	require.Zero(t, budget.Stats().Waiting[RatePriorityUser], "cancelled waiters are forgotten")
+}
+
+func TestRateBudget_PostReconnectBurst(t *testing.T) {
+	const (
+		limit    = 10
+		per      = time.Second
+		interval = 500 * time.Millisecond
+	)
+
+	clk := newFakeClock()
+	budget := newRateBudget(limit, per, clk)
+	log := &grantLog{}
+	log.observe(budget)
+
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(
+		newAdaptiveKeepAliveConnectionHandlerFactory(
+			nil, stubs.Factory, interval, interval,
+			NewKeepAliveMessageFactory(PingMessage, func() []byte { return nil }), clk,
+		),
+		func(Client, Message) {},
+		func(Client, EventType) {},
+		WithRateBudget(budget),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	got, ok := Handle[*RateBudget](cli)
+	require.True(t, ok)
+	require.Same(t, budget, got)
+
+	// Right after a reconnection, subscriptions are replayed while the user keeps sending.
+	subs := make([]Message, 20)
+	for i := range subs {
+		subs[i] = NewDataMessage([]byte(fmt.Sprintf("sub-%d", i)))
+	}
+	var senders sync.WaitGroup
+	senders.Add(2)
+	go func() {
+		defer senders.Done()
+		require.NoError(t, ReplaySubscriptions(context.Background(), cli, subs, nil))
+	}()
+	go func() {
+		defer senders.Done()
+		for i := range 30 {
+			cli.Send(NewDataMessage([]byte(fmt.Sprintf("order-%d", i))))
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		senders.Wait()
+		close(done)
+	}()
+
+	for sent := false; !sent; {
+		select {
+		case <-done:
+			sent = true
+		case <-time.After(time.Millisecond):
+			clk.Advance(50 * time.Millisecond)
+		}
+	}
+
+	grants := log.snapshot()
+	var pings int
+	for i, g := range grants {
+		// The aggregate never exceeds the limit over any window.
+		if i >= limit {
+			require.GreaterOrEqual(t, g.granted.Sub(grants[i-limit].granted), per, "grant #%d exceeds the budget", i)
+		}
+
+		// Control frames wait at most for the oldest grant to leave the window.
+		if g.priority == RatePriorityControl {
+			pings++
+			require.LessOrEqual(t, g.granted.Sub(g.requested), per, "ping starved")
+		}
+	}
+	require.Positive(t, pings)
+
+	stats := budget.Stats()
+	require.EqualValues(t, 20, stats.Granted[RatePriorityReplay])
+	require.EqualValues(t, 30, stats.Granted[RatePriorityUser])
+
+	var data int
+	for _, m := range stubs.Last().Sent() {
+		if m.Type().IsData() {
+			data++
+		}
+	}
+	require.Equal(t, 50, data)
+}
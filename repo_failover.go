@@ -0,0 +1,170 @@
+package libws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultFailoverCooldown is how long a rate limited endpoint is skipped when it did not tell for how long.
+const defaultFailoverCooldown = 30 * time.Second
+
+type (
+	// FailoverRepoOption customizes the repo returned by NewFailoverRepo.
+	FailoverRepoOption func(*failoverRepo)
+
+	// EndpointStatus describes an endpoint of a repo returned by NewFailoverRepo, see OpenConnectionParamsRepo.Endpoints.
+	EndpointStatus struct {
+		// Index is the position of the endpoint among those given to NewFailoverRepo.
+		Index int
+		// Current tells whether the endpoint is the one dialed next, unless cooling down.
+		Current bool
+		// CooldownUntil is when the endpoint stops being skipped after it rate limited us, zero when it is not.
+		CooldownUntil time.Time
+	}
+
+	// failoverRepo serves the params of its current endpoint, moving on to the next one whenever dialing fails and
+	// skipping the endpoints cooling down after a rate limit.
+	failoverRepo struct {
+		logger          logger
+		endpoints       []OpenConnectionParamsRepo
+		defaultCooldown time.Duration
+		clock           clock
+
+		mu      sync.Mutex
+		current int
+		// served is the endpoint of the params last served
+		served        int
+		cooldownUntil []time.Time
+	}
+)
+
+// NewFailoverRepo returns a repo serving the params of the first of endpoints until dialing them fails, then those of
+// the next one, wrapping around. An endpoint rejecting the handshake with ErrRateLimit is not retried before the
+// Retry-After it answered with, or 30 seconds unless WithFailoverCooldown says otherwise: the next endpoint is dialed
+// right away instead of waiting on the same host. When every endpoint is cooling down, the one whose cooldown ends
+// first is served. Inspect the endpoints with Endpoints.
+func NewFailoverRepo(endpoints []OpenConnectionParamsRepo, opts ...FailoverRepoOption) OpenConnectionParamsRepo {
+	if len(endpoints) == 0 {
+		panic("libws: NewFailoverRepo requires at least one endpoint")
+	}
+
+	r := &failoverRepo{
+		logger:          orNop(endpoints[0].logger),
+		endpoints:       endpoints,
+		defaultCooldown: defaultFailoverCooldown,
+		clock:           realClock{},
+		cooldownUntil:   make([]time.Time, len(endpoints)),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return OpenConnectionParamsRepo{
+		logger:    r.logger,
+		getter:    r.get,
+		onDial:    r.onDial,
+		reset:     r.reset,
+		endpoints: r.status,
+	}
+}
+
+// WithFailoverCooldown sets how long an endpoint rate limiting us is skipped when it does not answer with a
+// Retry-After header.
+func WithFailoverCooldown(d time.Duration) FailoverRepoOption {
+	return func(r *failoverRepo) {
+		r.defaultCooldown = d
+	}
+}
+
+// withFailoverClock overrides the clock used by the repo.
+func withFailoverClock(clk clock) FailoverRepoOption {
+	return func(r *failoverRepo) {
+		r.clock = clk
+	}
+}
+
+func (r *failoverRepo) get(ctx context.Context) (OpenConnectionParams, error) {
+	r.mu.Lock()
+	r.served = r.pickLocked()
+	endpoint := r.endpoints[r.served]
+	r.mu.Unlock()
+
+	return endpoint.Get(ctx)
+}
+
+// pickLocked returns the first endpoint from the current one not cooling down, or the one whose cooldown ends first.
+func (r *failoverRepo) pickLocked() int {
+	now := r.clock.Now()
+
+	soonest := r.current
+	for i := range r.endpoints {
+		idx := (r.current + i) % len(r.endpoints)
+		if !r.cooldownUntil[idx].After(now) {
+			return idx
+		}
+		if r.cooldownUntil[idx].Before(r.cooldownUntil[soonest]) {
+			soonest = idx
+		}
+	}
+
+	return soonest
+}
+
+func (r *failoverRepo) onDial(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	endpoint := r.endpoints[r.served]
+	endpoint.reportDial(err)
+
+	switch {
+	case err == nil:
+		r.current = r.served
+		r.cooldownUntil[r.served] = time.Time{}
+		return
+	case errors.Is(err, ErrTerminated):
+		// The dial was aborted on our side, which tells nothing about the endpoint.
+		return
+	}
+
+	if errors.Is(err, ErrRateLimit) {
+		cooldown, ok := retryAfterOf(err)
+		if !ok {
+			cooldown = r.defaultCooldown
+		}
+		r.cooldownUntil[r.served] = r.clock.Now().Add(cooldown)
+		r.logger.Warnf("endpoint #%d rate limited the handshake, skipping it for %s", r.served, cooldown)
+	}
+
+	r.current = (r.served + 1) % len(r.endpoints)
+}
+
+func (r *failoverRepo) reset() {
+	r.mu.Lock()
+	r.current = 0
+	clear(r.cooldownUntil)
+	r.mu.Unlock()
+
+	for _, endpoint := range r.endpoints {
+		endpoint.Reset()
+	}
+}
+
+func (r *failoverRepo) status() []EndpointStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	res := make([]EndpointStatus, len(r.endpoints))
+	for i := range r.endpoints {
+		res[i] = EndpointStatus{Index: i, Current: i == r.current}
+		if r.cooldownUntil[i].After(now) {
+			res[i].CooldownUntil = r.cooldownUntil[i]
+		}
+	}
+	return res
+}
@@ -0,0 +1,133 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+func newEndpointRepos(hosts ...string) []OpenConnectionParamsRepo {
+	logger := newTestLogger(io.Discard)
+
+	repos := make([]OpenConnectionParamsRepo, len(hosts))
+	for i, host := range hosts {
+		repos[i] = NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+			return OpenConnectionParams{URL: url.URL{Scheme: "ws", Host: host}}, nil
+		})
+	}
+	return repos
+}
+
+// dialedHost gets params from repo and reports err as the outcome of dialing them, returning the host served.
+func dialedHost(t *testing.T, repo OpenConnectionParamsRepo, err error) string {
+	t.Helper()
+
+	p, getErr := repo.Get(context.Background())
+	require.NoError(t, getErr)
+	repo.reportDial(err)
+
+	return p.URL.Host
+}
+
+func TestFailoverRepo_RateLimitCooldown(t *testing.T) {
+	clk := newFakeClock()
+	repo := NewFailoverRepo(newEndpointRepos("a", "b"), withFailoverClock(clk))
+	rateLimited := &RateLimitError{RetryAfter: 10 * time.Second, Err: ErrRateLimit}
+	refused := errors.New("connection refused")
+
+	require.Equal(t, "a", dialedHost(t, repo, rateLimited))
+	require.Equal(t, []EndpointStatus{
+		{Index: 0, CooldownUntil: clk.Now().Add(10 * time.Second)},
+		{Index: 1, Current: true},
+	}, repo.Endpoints())
+
+	require.Equal(t, "b", dialedHost(t, repo, nil), "the next dial hits b right away")
+	require.Equal(t, "b", dialedHost(t, repo, refused))
+	require.Equal(t, "b", dialedHost(t, repo, refused), "a is skipped while cooling down")
+
+	clk.Advance(10 * time.Second)
+	require.Equal(t, "a", dialedHost(t, repo, nil), "a is dialed again once cooled down")
+	require.Equal(t, "a", dialedHost(t, repo, nil))
+	require.Equal(t, []EndpointStatus{{Index: 0, Current: true}, {Index: 1}}, repo.Endpoints())
+}
+
+func TestFailoverRepo_DefaultCooldown(t *testing.T) {
+	clk := newFakeClock()
+	repo := NewFailoverRepo(newEndpointRepos("a", "b"), withFailoverClock(clk), WithFailoverCooldown(time.Minute))
+
+	require.Equal(t, "a", dialedHost(t, repo, ErrRateLimit))
+	require.Equal(t, "b", dialedHost(t, repo, ErrRateLimit))
+
+	// Both are cooling down, the one cooling down first is served.
+	clk.Advance(time.Second)
+	require.Equal(t, "a", dialedHost(t, repo, ErrRateLimit))
+	require.Equal(t, "b", dialedHost(t, repo, errors.New("connection refused")))
+
+	clk.Advance(time.Minute)
+	require.Equal(t, "a", dialedHost(t, repo, errors.New("connection refused")))
+	require.Equal(t, "b", dialedHost(t, repo, ErrRateLimit))
+
+	repo.Reset()
+	require.Equal(t, "a", dialedHost(t, repo, nil))
+}
+
+func TestFailoverRepo_IgnoresAbortedDials(t *testing.T) {
+	repo := NewFailoverRepo(newEndpointRepos("a", "b"))
+
+	require.Equal(t, "a", dialedHost(t, repo, fmt.Errorf("%w: %w", ErrTerminated, context.Canceled)))
+	require.Equal(t, "a", dialedHost(t, repo, nil))
+}
+
+func TestFailoverRepo_WsConnection(t *testing.T) {
+	var limitedHits atomic.Int32
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitedHits.Add(1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(limited.Close)
+	healthy := newTestWsServer(t, serveUntilClosed)
+
+	logger := newTestLogger(io.Discard)
+	endpoint := func(srv *httptest.Server) OpenConnectionParamsRepo {
+		u := testWsURL(t, srv)
+		return NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+			return OpenConnectionParams{URL: u}, nil
+		})
+	}
+	repo := NewFailoverRepo([]OpenConnectionParamsRepo{endpoint(limited), endpoint(healthy)})
+	factory := NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})
+
+	err := factory(context.Background(), make(chan Message, 1)).Open(context.Background())
+	var rateLimit *RateLimitError
+	require.ErrorAs(t, err, &rateLimit)
+	require.ErrorIs(t, err, ErrRateLimit)
+	require.Equal(t, 30*time.Second, rateLimit.RetryAfter)
+	require.False(t, repo.Endpoints()[0].CooldownUntil.IsZero())
+
+	conn := factory(context.Background(), make(chan Message, 1))
+	require.NoError(t, conn.Open(context.Background()))
+	defer conn.Close()
+	require.EqualValues(t, 1, limitedHits.Load())
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, 30*time.Second, parseRetryAfter("30", now))
+	require.Equal(t, 90*time.Second, parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now))
+	require.Zero(t, parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	require.Zero(t, parseRetryAfter("-5", now))
+	require.Zero(t, parseRetryAfter("soon", now))
+	require.Zero(t, parseRetryAfter("", now))
+}
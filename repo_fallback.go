@@ -0,0 +1,113 @@
+package libws
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// FallbackRepoOption customizes the repo returned by NewFallbackOnErrorRepo.
+	FallbackRepoOption func(*fallbackRepo)
+
+	// fallbackRepo serves the params of primary until dialing them fails as told by shouldFallback, then those of
+	// secondary.
+	fallbackRepo struct {
+		primary, secondary OpenConnectionParamsRepo
+		shouldFallback     func(error) bool
+		// probeEvery makes one in probeEvery Gets serve primary while fallen back, disabled when zero
+		probeEvery int
+
+		mu       sync.Mutex
+		fellBack bool
+		// servedPrimary tells whether the params last served are those of primary
+		servedPrimary bool
+		sinceProbe    int
+	}
+)
+
+// NewFallbackOnErrorRepo returns a repo serving the params of primary, e.g. those of the newest protocol version, until
+// dialing them fails with an error for which shouldFallback returns true. Get serves the params of secondary from then
+// on, until Reset is called or, see WithPrimaryProbeEvery, primary is dialed successfully again.
+func NewFallbackOnErrorRepo(
+	primary, secondary OpenConnectionParamsRepo,
+	shouldFallback func(error) bool,
+	opts ...FallbackRepoOption,
+) OpenConnectionParamsRepo {
+	r := &fallbackRepo{
+		primary:        primary,
+		secondary:      secondary,
+		shouldFallback: shouldFallback,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return OpenConnectionParamsRepo{
+		logger: primary.logger,
+		getter: r.get,
+		onDial: r.onDial,
+		reset:  r.reset,
+	}
+}
+
+// WithPrimaryProbeEvery makes one in n Gets serve the params of primary while fallen back, probing whether they can be
+// dialed again. A successful probe ends the fallback.
+func WithPrimaryProbeEvery(n int) FallbackRepoOption {
+	return func(r *fallbackRepo) {
+		r.probeEvery = n
+	}
+}
+
+func (r *fallbackRepo) get(ctx context.Context) (OpenConnectionParams, error) {
+	r.mu.Lock()
+	usePrimary := !r.fellBack
+	if r.fellBack && r.probeEvery > 0 {
+		r.sinceProbe++
+		if r.sinceProbe >= r.probeEvery {
+			r.sinceProbe = 0
+			usePrimary = true
+		}
+	}
+	r.servedPrimary = usePrimary
+	r.mu.Unlock()
+
+	if usePrimary {
+		return r.primary.Get(ctx)
+	}
+
+	return r.secondary.Get(ctx)
+}
+
+func (r *fallbackRepo) onDial(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.servedPrimary {
+		r.secondary.reportDial(err)
+		return
+	}
+	r.primary.reportDial(err)
+
+	switch {
+	case err == nil:
+		if r.fellBack {
+			r.primary.logger.Infof("primary connection params dialed again, ending fallback")
+		}
+		r.fellBack = false
+	case !r.fellBack && r.shouldFallback(err):
+		r.primary.logger.Warnf("falling back to secondary connection params after %s", err)
+		r.fellBack = true
+		r.sinceProbe = 0
+	}
+}
+
+func (r *fallbackRepo) reset() {
+	r.mu.Lock()
+	r.fellBack = false
+	r.sinceProbe = 0
+	r.mu.Unlock()
+
+	r.primary.Reset()
+	r.secondary.Reset()
+}
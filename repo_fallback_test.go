@@ -0,0 +1,119 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+var errV2Rejected = errors.New("v2 rejected")
+
+func newVersionRepos(t *testing.T) (v2, v1 OpenConnectionParamsRepo) {
+	t.Helper()
+
+	logger := newTestLogger(io.Discard)
+	paramsFor := func(path string) OpenConnectionParamsRepo {
+		return NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+			return OpenConnectionParams{URL: url.URL{Scheme: "ws", Host: "gateway", Path: path}}, nil
+		})
+	}
+
+	return paramsFor("/v2"), paramsFor("/v1")
+}
+
+// dialedPath gets params from repo and reports err as the outcome of dialing them, returning the path served.
+func dialedPath(t *testing.T, repo OpenConnectionParamsRepo, err error) string {
+	t.Helper()
+
+	p, getErr := repo.Get(context.Background())
+	require.NoError(t, getErr)
+	repo.reportDial(err)
+
+	return p.URL.Path
+}
+
+func isV2Rejection(err error) bool {
+	return errors.Is(err, errV2Rejected)
+}
+
+func TestFallbackOnErrorRepo_Sticky(t *testing.T) {
+	v2, v1 := newVersionRepos(t)
+	repo := NewFallbackOnErrorRepo(v2, v1, isV2Rejection)
+
+	require.Equal(t, "/v2", dialedPath(t, repo, errors.New("connection refused")), "unrelated errors do not fall back")
+	require.Equal(t, "/v2", dialedPath(t, repo, errV2Rejected))
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, "/v1", dialedPath(t, repo, nil))
+	}
+
+	repo.Reset()
+	require.Equal(t, "/v2", dialedPath(t, repo, nil))
+	require.Equal(t, "/v2", dialedPath(t, repo, nil))
+}
+
+func TestFallbackOnErrorRepo_PrimaryProbe(t *testing.T) {
+	v2, v1 := newVersionRepos(t)
+	repo := NewFallbackOnErrorRepo(v2, v1, isV2Rejection, WithPrimaryProbeEvery(3))
+
+	require.Equal(t, "/v2", dialedPath(t, repo, errV2Rejected))
+	require.Equal(t, "/v1", dialedPath(t, repo, nil))
+	require.Equal(t, "/v1", dialedPath(t, repo, nil))
+	require.Equal(t, "/v2", dialedPath(t, repo, errV2Rejected), "failed probe")
+	require.Equal(t, "/v1", dialedPath(t, repo, nil))
+	require.Equal(t, "/v1", dialedPath(t, repo, nil))
+	require.Equal(t, "/v2", dialedPath(t, repo, nil), "successful probe")
+	require.Equal(t, "/v2", dialedPath(t, repo, nil))
+}
+
+func TestFallbackOnErrorRepo_WsConnection(t *testing.T) {
+	var paths []string
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/v2" {
+			http.Error(w, "protocol v2 not supported", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		serveUntilClosed(conn)
+	}))
+	t.Cleanup(srv.Close)
+
+	logger := newTestLogger(io.Discard)
+	paramsFor := func(path string) OpenConnectionParamsRepo {
+		u := testWsURL(t, srv)
+		u.Path = path
+		return NewOpenConnectionParamsRepo(logger, func(context.Context) (OpenConnectionParams, error) {
+			return OpenConnectionParams{URL: u}, nil
+		})
+	}
+
+	repo := NewFallbackOnErrorRepo(paramsFor("/v2"), paramsFor("/v1"), func(err error) bool {
+		return errors.Is(err, ErrCannotConnect)
+	})
+	factory := NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{})
+
+	ctx := context.Background()
+	recv := make(chan Message, 1)
+	require.Error(t, factory(ctx, recv).Open(ctx))
+
+	conn := factory(ctx, recv)
+	require.NoError(t, conn.Open(ctx))
+	conn.Close()
+
+	require.Equal(t, []string{"/v2", "/v1"}, paths)
+}
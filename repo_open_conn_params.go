@@ -10,6 +10,17 @@ type (
 	OpenConnectionParamsRepo struct {
 		logger logger
 		getter OpenConnectionParamsGetter
+		// onDial is told the outcome of dialing the params last served, nil unless the repo adapts to it
+		onDial func(error)
+		// reset clears the state kept by the repo, nil unless it keeps any
+		reset func()
+		// endpoints describes the endpoints served, nil unless the repo serves several, see NewFailoverRepo
+		endpoints func() []EndpointStatus
+	}
+
+	// dialReporter is implemented by params repos told the outcome of dialing the params they serve.
+	dialReporter interface {
+		reportDial(err error)
 	}
 )
 
@@ -23,9 +34,32 @@ func (r OpenConnectionParamsRepo) Get(
 	return
 }
 
+// Reset clears any state kept by the repo, such as the fallback of NewFallbackOnErrorRepo.
+func (r OpenConnectionParamsRepo) Reset() {
+	if r.reset != nil {
+		r.reset()
+	}
+}
+
+// Endpoints describes the endpoints served by a repo returned by NewFailoverRepo, e.g. which ones are cooling down
+// after rate limiting us. It returns nil for other repos.
+func (r OpenConnectionParamsRepo) Endpoints() []EndpointStatus {
+	if r.endpoints == nil {
+		return nil
+	}
+	return r.endpoints()
+}
+
+// reportDial tells the repo the outcome of dialing the params it served, err being nil on success.
+func (r OpenConnectionParamsRepo) reportDial(err error) {
+	if r.onDial != nil {
+		r.onDial(err)
+	}
+}
+
 func NewOpenConnectionParamsRepo(
 	logger logger,
 	getter OpenConnectionParamsGetter,
 ) OpenConnectionParamsRepo {
-	return OpenConnectionParamsRepo{getter: getter, logger: logger}
+	return OpenConnectionParamsRepo{getter: getter, logger: orNop(logger)}
 }
@@ -0,0 +1,59 @@
+package libws
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// retryingRepo gets the params of inner, retrying on failure.
+type retryingRepo struct {
+	logger   logger
+	inner    OpenConnectionParamsRepo
+	attempts int
+	backoff  func(attempt int) time.Duration
+}
+
+// NewRetryingParamsRepo returns a repo getting the params of inner, trying up to attempts times in all and waiting
+// backoff(n) after the n-th failure, so that a flaky source of params, e.g. a REST API handing out tokens, does not
+// fail the connection attempt. These retries are not charged to the reconnection backoff, no dial having happened.
+// Dial outcomes, Reset and Endpoints are passed through to inner.
+func NewRetryingParamsRepo(inner OpenConnectionParamsRepo, attempts int, backoff func(attempt int) time.Duration) OpenConnectionParamsRepo {
+	r := &retryingRepo{
+		logger:   orNop(inner.logger),
+		inner:    inner,
+		attempts: max(attempts, 1),
+		backoff:  backoff,
+	}
+
+	return OpenConnectionParamsRepo{
+		logger:    inner.logger,
+		getter:    r.get,
+		onDial:    inner.onDial,
+		reset:     inner.reset,
+		endpoints: inner.endpoints,
+	}
+}
+
+func (r *retryingRepo) get(ctx context.Context) (OpenConnectionParams, error) {
+	for attempt := 1; ; attempt++ {
+		p, err := r.inner.Get(ctx)
+		if err == nil {
+			return p, nil
+		}
+		if attempt >= r.attempts {
+			return OpenConnectionParams{}, fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+
+		wait := r.backoff(attempt)
+		r.logger.Infof("retrying to get connection params after %s", wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return OpenConnectionParams{}, fmt.Errorf("%w, then waiting to retry: %w", err, ctx.Err())
+		}
+	}
+}
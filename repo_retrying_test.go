@@ -0,0 +1,138 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyParamsGetter fails its first failures calls, then serves the URL of srv.
+type flakyParamsGetter struct {
+	failures int32
+	err      error
+	calls    atomic.Int32
+}
+
+func (g *flakyParamsGetter) get(u OpenConnectionParams) OpenConnectionParamsGetter {
+	return func(context.Context) (OpenConnectionParams, error) {
+		if g.calls.Add(1) <= g.failures {
+			return OpenConnectionParams{}, g.err
+		}
+		return u, nil
+	}
+}
+
+// recordedCalls records the arguments a backoff function is called with.
+type recordedCalls struct {
+	mu    sync.Mutex
+	calls []int
+}
+
+func (r *recordedCalls) record(n int) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, n)
+	return 0
+}
+
+func (r *recordedCalls) snapshot() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.calls...)
+}
+
+func TestRetryingParamsRepo(t *testing.T) {
+	errFlaky := errors.New("503 service unavailable")
+
+	testCases := []struct {
+		name            string
+		failures        int32
+		attempts        int
+		wantGets        int32
+		wantRetries     []int
+		wantReconnectBy []int
+	}{
+		{
+			name:        "retried within the dial attempt",
+			failures:    2,
+			attempts:    3,
+			wantGets:    3,
+			wantRetries: []int{1, 2},
+		},
+		{
+			name:            "exhausted retries are not charged",
+			failures:        3,
+			attempts:        2,
+			wantGets:        4,
+			wantRetries:     []int{1, 1},
+			wantReconnectBy: []int{1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var handshakes atomic.Int32
+			srv := newTestWsServer(t, func(conn *websocket.Conn) {
+				handshakes.Add(1)
+				serveUntilClosed(conn)
+			})
+			logger := newTestLogger(io.Discard)
+			getter := &flakyParamsGetter{failures: tc.failures, err: errFlaky}
+			retries, reconnects := &recordedCalls{}, &recordedCalls{}
+
+			repo := NewRetryingParamsRepo(
+				NewOpenConnectionParamsRepo(logger, getter.get(OpenConnectionParams{URL: testWsURL(t, srv)})),
+				tc.attempts,
+				retries.record,
+			)
+			base := NewBaseConnectionHandlerFactory(logger, NewWebsocketFactory(logger, websocket.DefaultDialer, repo, ErrorAdapters{}))
+			cli := newBasicClient(
+				NewBackoffConnectionHandlerFactory(nil, base, reconnects.record, time.Minute),
+				func(Client, Message) {},
+				func(Client, EventType) {},
+			)
+			require.NoError(t, cli.Open(context.Background()))
+			defer cli.Close()
+
+			require.Equal(t, tc.wantGets, getter.calls.Load())
+			require.Equal(t, tc.wantRetries, retries.snapshot())
+			require.Equal(t, tc.wantReconnectBy, reconnects.snapshot())
+			require.EqualValues(t, 1, handshakes.Load(), "dialed more than once")
+
+			control, ok := Handle[*ConnectionControl](cli)
+			require.True(t, ok)
+			dial, ok := control.latestDial()
+			require.True(t, ok)
+			require.Equal(t, 1, dial.attempt, "params failures were counted as dials")
+		})
+	}
+}
+
+func TestRetryingParamsRepo_Unavailable(t *testing.T) {
+	errFlaky := errors.New("503 service unavailable")
+	getter := &flakyParamsGetter{failures: 10, err: errFlaky}
+	repo := NewRetryingParamsRepo(
+		NewOpenConnectionParamsRepo(nil, getter.get(OpenConnectionParams{})),
+		3,
+		func(int) time.Duration { return time.Hour },
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := repo.Get(ctx)
+	require.ErrorIs(t, err, errFlaky)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.EqualValues(t, 1, getter.calls.Load())
+
+	conn := NewWebsocketConnection(websocket.DefaultDialer, NewRetryingParamsRepo(repo, 1, nil), nil, make(chan Message), ErrorAdapters{})
+	err = conn.Open(ctx)
+	require.ErrorIs(t, err, ErrParamsUnavailable)
+	require.ErrorIs(t, err, errFlaky)
+}
@@ -0,0 +1,193 @@
+package libws
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// SeamlessRotation stitches the inbound streams of the outgoing and incoming connections of a reopen interval
+	// rotation, so that handlers see neither duplicated nor missing sequenced messages. Install it with
+	// WithSeamlessRotation; a SeamlessRotation serves a single client.
+	//
+	// While both connections are open, sequenced messages of the new connection are buffered and the old one keeps
+	// delivering. Once the buffer picks up right after the last sequence delivered by the old connection, the
+	// buffered messages not yet delivered are flushed, the old connection is closed and EventRotationStitched is
+	// emitted. Sequences are expected to be consecutive within a connection. Messages without a sequence are delivered
+	// as they come, whichever connection they are read from.
+	SeamlessRotation struct {
+		extractSeq func(Message) (uint64, bool)
+
+		mu sync.Mutex
+		// handler receives the stitched stream
+		handler MessageHandler
+		// ids identify connections, active being the one delivering and pending the one buffered during overlap
+		ids     uint64
+		active  uint64
+		pending uint64
+		// lastSeq is the last sequence delivered by the active connection, valid when hasSeq is set
+		lastSeq  uint64
+		hasSeq   bool
+		buffer   []stitchEntry
+		stitched chan struct{}
+
+		stitchPoint atomic.Uint64
+		stitches    atomic.Uint64
+	}
+
+	stitchEntry struct {
+		seq    uint64
+		client Client
+		m      Message
+	}
+)
+
+// NewSeamlessRotation returns a SeamlessRotation reading the sequence of messages with extractSeq, which reports false
+// for messages that are not sequenced.
+func NewSeamlessRotation(extractSeq func(Message) (uint64, bool)) *SeamlessRotation {
+	return &SeamlessRotation{extractSeq: extractSeq}
+}
+
+// WithSeamlessRotation makes rotations overlap the old and new connections until their sequenced streams are stitched
+// by s. The overlap is bounded by the rotation readiness timeout, after which the new connection takes over anyway.
+func WithSeamlessRotation(s *SeamlessRotation) ReopenOption {
+	return func(h *reopenIntervalConnectionHandler) {
+		h.seamless = s
+	}
+}
+
+// StitchPoint returns the last sequence delivered by the old connection at the latest stitch: the new connection
+// delivered every sequenced message after it.
+func (s *SeamlessRotation) StitchPoint() uint64 {
+	return s.stitchPoint.Load()
+}
+
+// Stitches returns how many rotations have been stitched so far.
+func (s *SeamlessRotation) Stitches() uint64 {
+	return s.stitches.Load()
+}
+
+// bind sets the handler receiving the stitched stream.
+func (s *SeamlessRotation) bind(handler MessageHandler) {
+	s.mu.Lock()
+	s.handler = handler
+	s.mu.Unlock()
+}
+
+// direct returns the handler of a connection which delivers right away, replacing any other. It is meant for the
+// initial connection and for reconnections, which have nothing to be stitched with.
+func (s *SeamlessRotation) direct() MessageHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.abortLocked()
+	s.ids++
+	s.active = s.ids
+	s.hasSeq = false
+
+	return s.handlerFor(s.ids)
+}
+
+// overlap returns the handler of a connection whose stream is buffered until stitched to the active one, and a
+// channel closed once that happens.
+func (s *SeamlessRotation) overlap() (MessageHandler, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.abortLocked()
+	s.ids++
+	s.pending = s.ids
+	s.stitched = make(chan struct{})
+
+	return s.handlerFor(s.ids), s.stitched
+}
+
+// abort discards the pending connection and its buffer.
+func (s *SeamlessRotation) abort() {
+	s.mu.Lock()
+	s.abortLocked()
+	s.mu.Unlock()
+}
+
+// force stitches the pending connection regardless of the gap with the active one, if it is not stitched yet.
+func (s *SeamlessRotation) force() {
+	s.mu.Lock()
+	if s.pending != 0 {
+		s.stitchLocked()
+	}
+	s.mu.Unlock()
+}
+
+func (s *SeamlessRotation) abortLocked() {
+	s.pending = 0
+	s.buffer = nil
+}
+
+func (s *SeamlessRotation) handlerFor(id uint64) MessageHandler {
+	return func(cli Client, m Message) {
+		s.deliver(id, cli, m)
+	}
+}
+
+// deliver passes m, read from connection id, to the handler when it belongs to the stitched stream. The lock is held
+// while delivering to keep the stream ordered across connections.
+func (s *SeamlessRotation) deliver(id uint64, cli Client, m Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, sequenced := s.extractSeq(m)
+
+	switch {
+	case !sequenced && (id == s.active || id == s.pending):
+		s.handler(cli, m)
+	case !sequenced:
+		discard(m)
+	case id == s.active:
+		s.lastSeq, s.hasSeq = seq, true
+		s.handler(cli, m)
+		s.tryStitchLocked()
+	case id == s.pending:
+		s.buffer = append(s.buffer, stitchEntry{seq: seq, client: cli, m: hold(m)})
+		s.tryStitchLocked()
+	default:
+		discard(m)
+	}
+}
+
+// tryStitchLocked discards the buffered messages already delivered by the active connection and stitches the pending
+// one once its buffer follows on from them.
+func (s *SeamlessRotation) tryStitchLocked() {
+	if s.pending == 0 {
+		return
+	}
+
+	if s.hasSeq {
+		i := 0
+		for i < len(s.buffer) && s.buffer[i].seq <= s.lastSeq {
+			i++
+		}
+		s.buffer = s.buffer[i:]
+	}
+
+	if len(s.buffer) == 0 || (s.hasSeq && s.buffer[0].seq > s.lastSeq+1) {
+		return
+	}
+
+	s.stitchLocked()
+}
+
+// stitchLocked makes the pending connection the active one, flushing its buffer.
+func (s *SeamlessRotation) stitchLocked() {
+	s.stitchPoint.Store(s.lastSeq)
+	s.stitches.Add(1)
+
+	for _, e := range s.buffer {
+		s.lastSeq, s.hasSeq = e.seq, true
+		s.handler(e.client, e.m)
+	}
+
+	s.active = s.pending
+	s.pending = 0
+	s.buffer = nil
+	close(s.stitched)
+}
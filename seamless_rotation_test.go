@@ -0,0 +1,209 @@
+package libws
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// seqMessage returns a sequenced data message whose payload is its sequence.
+func seqMessage(seq uint64) Message {
+	return NewMessage(DataMessage, []byte(strconv.FormatUint(seq, 10)))
+}
+
+func extractTestSeq(m Message) (uint64, bool) {
+	seq, err := strconv.ParseUint(string(m.Data()), 10, 64)
+	return seq, err == nil
+}
+
+type seamlessHarness struct {
+	h        *reopenIntervalConnectionHandler
+	stubs    *stubConnectionHandlerFactory
+	seamless *SeamlessRotation
+	events   chan EventType
+
+	mu       sync.Mutex
+	received []string
+}
+
+func newSeamlessHarness(t *testing.T, readinessTimeout time.Duration) *seamlessHarness {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	sh := &seamlessHarness{
+		stubs:    &stubConnectionHandlerFactory{},
+		seamless: NewSeamlessRotation(extractTestSeq),
+		events:   make(chan EventType, 1),
+	}
+
+	emitter := NewEventEmitter[EventType, EventType]()
+	emitter.On(EventRotationStitched, func(e EventType) { sh.events <- e })
+
+	sh.h = newReopenIntervalConn(
+		newTestLogger(io.Discard),
+		nil,
+		time.Hour,
+		func(_ Client, m Message) {
+			sh.mu.Lock()
+			sh.received = append(sh.received, string(m.Data()))
+			sh.mu.Unlock()
+		},
+		emitter,
+		sh.stubs.Factory,
+		WithSeamlessRotation(sh.seamless),
+		WithRotationReadinessTimeout(readinessTimeout),
+	)
+	require.NoError(t, sh.h.Connect(ctx))
+	t.Cleanup(sh.h.Close)
+
+	return sh
+}
+
+// rotate starts a rotation and returns the outgoing and incoming connections.
+func (sh *seamlessHarness) rotate(t *testing.T) (old, next *stubConnectionHandler) {
+	t.Helper()
+
+	old = sh.stubs.Last()
+	sh.h.rotateC <- struct{}{}
+	require.Eventually(t, func() bool { return len(sh.stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+
+	return old, sh.stubs.Last()
+}
+
+func (sh *seamlessHarness) Received() []string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return append([]string(nil), sh.received...)
+}
+
+func deliverRange(s *stubConnectionHandler, from, to uint64) {
+	for seq := from; seq <= to; seq++ {
+		s.Deliver(seqMessage(seq))
+	}
+}
+
+func seqRange(from, to uint64) []string {
+	var out []string
+	for seq := from; seq <= to; seq++ {
+		out = append(out, strconv.FormatUint(seq, 10))
+	}
+	return out
+}
+
+func TestSeamlessRotation_Stitch(t *testing.T) {
+	tests := []struct {
+		name string
+		// script interleaves the streams of the old and new connections during overlap
+		script      func(old, next *stubConnectionHandler)
+		stitchPoint uint64
+	}{
+		{
+			name: "new connection starts behind",
+			script: func(old, next *stubConnectionHandler) {
+				deliverRange(next, 5, 10)
+				old.Deliver(seqMessage(11))
+				deliverRange(next, 11, 12)
+				// The old connection goes on until it is closed, its messages are duplicates by now.
+				old.Deliver(seqMessage(12))
+				deliverRange(next, 13, 15)
+			},
+			stitchPoint: 11,
+		},
+		{
+			name: "new connection starts ahead",
+			script: func(old, next *stubConnectionHandler) {
+				deliverRange(next, 13, 14)
+				deliverRange(old, 11, 12)
+				old.Deliver(seqMessage(13))
+				deliverRange(next, 15, 15)
+			},
+			stitchPoint: 12,
+		},
+		{
+			name: "new connection starts right after",
+			script: func(old, next *stubConnectionHandler) {
+				deliverRange(next, 11, 15)
+			},
+			stitchPoint: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh := newSeamlessHarness(t, time.Minute)
+
+			deliverRange(sh.stubs.Last(), 1, 10)
+			old, next := sh.rotate(t)
+			tt.script(old, next)
+
+			select {
+			case event := <-sh.events:
+				require.Equal(t, EventRotationStitched, event)
+			case <-time.After(time.Second):
+				t.Fatal("no stitch event emitted")
+			}
+
+			require.Equal(t, seqRange(1, 15), sh.Received(), "stream must have neither duplicates nor gaps")
+			require.Equal(t, tt.stitchPoint, sh.seamless.StitchPoint())
+			require.Equal(t, uint64(1), sh.seamless.Stitches())
+			require.Eventually(t, func() bool { return isClosed(old.CloseChan()) }, time.Second, time.Millisecond)
+			require.Equal(t, next, sh.h.unwrapHandler())
+		})
+	}
+}
+
+func TestSeamlessRotation_UnsequencedMessagesPassThrough(t *testing.T) {
+	sh := newSeamlessHarness(t, time.Minute)
+
+	deliverRange(sh.stubs.Last(), 1, 2)
+	old, next := sh.rotate(t)
+
+	next.Deliver(NewMessage(DataMessage, []byte("subscribed")))
+	deliverRange(next, 2, 3)
+	old.Deliver(NewMessage(DataMessage, []byte("heartbeat")))
+
+	require.Equal(t, []string{"1", "2", "subscribed", "3"}, sh.Received())
+	require.Eventually(t, func() bool { return sh.h.unwrapHandler() == next }, time.Second, time.Millisecond)
+
+	old.Deliver(NewMessage(DataMessage, []byte("late")))
+	require.Equal(t, []string{"1", "2", "subscribed", "3"}, sh.Received(), "the old connection is silenced once stitched")
+}
+
+func TestSeamlessRotation_OverlapTimeout(t *testing.T) {
+	sh := newSeamlessHarness(t, 20*time.Millisecond)
+
+	deliverRange(sh.stubs.Last(), 1, 3)
+	old, next := sh.rotate(t)
+	deliverRange(next, 6, 7)
+
+	select {
+	case <-sh.events:
+	case <-time.After(time.Second):
+		t.Fatal("no stitch event emitted")
+	}
+
+	require.Equal(t, []string{"1", "2", "3", "6", "7"}, sh.Received())
+	require.Equal(t, uint64(3), sh.seamless.StitchPoint())
+	require.True(t, isClosed(old.CloseChan()))
+	require.Equal(t, next, sh.h.unwrapHandler())
+}
+
+func TestSeamlessRotation_ReconnectIsNotStitched(t *testing.T) {
+	sh := newSeamlessHarness(t, time.Minute)
+
+	deliverRange(sh.stubs.Last(), 1, 3)
+	sh.stubs.Last().Kill(ErrConnectionClosed)
+	require.Eventually(t, func() bool { return len(sh.stubs.Handlers()) == 2 }, time.Second, time.Millisecond)
+
+	// The server may restart its sequences, messages are delivered right away.
+	deliverRange(sh.stubs.Last(), 1, 2)
+	require.Equal(t, []string{"1", "2", "3", "1", "2"}, sh.Received())
+	require.Zero(t, sh.seamless.Stitches())
+}
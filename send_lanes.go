@@ -0,0 +1,209 @@
+package libws
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultLaneName is the lane Client.Send enqueues to once lanes are in use.
+	DefaultLaneName = "default"
+	// defaultLaneQueueSize bounds the messages queued by each lane.
+	defaultLaneQueueSize = 256
+)
+
+type (
+	// SendLane is a named outbound queue sharing the connection with other lanes in proportion to its weight. Create
+	// them with the Lane method of the client.
+	SendLane interface {
+		// Send enqueues m, dropping it when the lane is full.
+		Send(m Message)
+		// Stats returns the counters of the lane.
+		Stats() LaneStats
+	}
+
+	// LaneStats holds the counters of a SendLane.
+	LaneStats struct {
+		Name    string
+		Weight  int
+		Depth   int
+		Sent    uint64
+		Dropped uint64
+	}
+
+	// sendLane is a bounded FIFO queue of outbound messages.
+	sendLane struct {
+		name      string
+		weight    int
+		capacity  int
+		scheduler *laneScheduler
+
+		mu    sync.Mutex
+		queue []Message
+
+		sent    atomic.Uint64
+		dropped atomic.Uint64
+	}
+
+	// laneScheduler dispatches the messages queued by its lanes with weighted round robin: every round, each lane
+	// dispatches up to weight messages. Dispatching blocks while the connection applies backpressure, so that queues
+	// build up in the lanes rather than in the connection.
+	laneScheduler struct {
+		dispatch func(Message)
+		logger   logger
+
+		mu    sync.RWMutex
+		lanes []*sendLane
+
+		wake      chan struct{}
+		stopC     chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+func newLaneScheduler(logger logger, dispatch func(Message)) *laneScheduler {
+	s := &laneScheduler{
+		dispatch: dispatch,
+		logger:   logger,
+		wake:     make(chan struct{}, 1),
+		stopC:    make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// lane returns the lane named name, creating it with weight if it does not exist yet.
+func (s *laneScheduler) lane(name string, weight int) *sendLane {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, l := range s.lanes {
+		if l.name == name {
+			return l
+		}
+	}
+
+	l := &sendLane{name: name, weight: max(1, weight), capacity: defaultLaneQueueSize, scheduler: s}
+	s.lanes = append(s.lanes, l)
+	return l
+}
+
+func (s *laneScheduler) stats() []LaneStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := make([]LaneStats, len(s.lanes))
+	for i, l := range s.lanes {
+		stats[i] = l.Stats()
+	}
+	return stats
+}
+
+func (s *laneScheduler) run() {
+	for {
+		s.mu.RLock()
+		lanes := s.lanes
+		s.mu.RUnlock()
+
+		dispatched := false
+		for _, l := range lanes {
+			for i := 0; i < l.weight; i++ {
+				m, ok := l.pop()
+				if !ok {
+					break
+				}
+
+				select {
+				case <-s.stopC:
+					return
+				default:
+				}
+
+				s.dispatch(m)
+				l.sent.Add(1)
+				dispatched = true
+			}
+		}
+
+		if dispatched {
+			continue
+		}
+
+		select {
+		case <-s.stopC:
+			return
+		case <-s.wake:
+		}
+	}
+}
+
+// close stops dispatching. Messages still queued are discarded.
+func (s *laneScheduler) close() {
+	s.closeOnce.Do(func() { close(s.stopC) })
+}
+
+func (l *sendLane) Send(m Message) {
+	if !l.trySend(m) {
+		l.dropped.Add(1)
+		l.scheduler.logger.Warnf("send lane %s is full, dropping message", l.name)
+	}
+}
+
+// sendContext enqueues m, returning ErrBackpressure rather than dropping it when the lane is full. Lanes do not wait
+// for room, whatever ctx.
+func (l *sendLane) sendContext(_ context.Context, m Message) error {
+	if !l.trySend(m) {
+		return ErrBackpressure
+	}
+
+	return nil
+}
+
+// trySend enqueues m unless the lane is full.
+func (l *sendLane) trySend(m Message) bool {
+	l.mu.Lock()
+	if len(l.queue) >= l.capacity {
+		l.mu.Unlock()
+		return false
+	}
+	l.queue = append(l.queue, m)
+	l.mu.Unlock()
+
+	select {
+	case l.scheduler.wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+func (l *sendLane) Stats() LaneStats {
+	l.mu.Lock()
+	depth := len(l.queue)
+	l.mu.Unlock()
+
+	return LaneStats{
+		Name:    l.name,
+		Weight:  l.weight,
+		Depth:   depth,
+		Sent:    l.sent.Load(),
+		Dropped: l.dropped.Load(),
+	}
+}
+
+func (l *sendLane) pop() (Message, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.queue) == 0 {
+		return nil, false
+	}
+
+	m := l.queue[0]
+	l.queue[0] = nil
+	l.queue = l.queue[1:]
+	return m, true
+}
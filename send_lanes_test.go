@@ -0,0 +1,112 @@
+package libws
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newGatedClient returns a client whose connection only writes a message when a token is sent on the returned
+// channel, recording the writes.
+func newGatedClient(t *testing.T) (*basicClient, chan<- struct{}, func() []string) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		written []string
+		gate    = make(chan struct{})
+		closeC  = make(CloseChan)
+	)
+	conn := &mockConnectionHandler{
+		ConnectFunc: func(context.Context) error { return nil },
+		CloseFunc:   func() {},
+		SendFunc: func(m Message) {
+			<-gate
+			mu.Lock()
+			written = append(written, string(m.Data()))
+			mu.Unlock()
+		},
+		RecvFunc:      func(Message) {},
+		CloseChanFunc: func() CloseChan { return closeC },
+		CloseErrFunc:  func() error { return nil },
+	}
+
+	cli := newBasicClient(
+		func(Client, MessageHandler, emitter[EventType, EventType]) ConnectionHandler { return conn },
+		func(Client, Message) {},
+		func(Client, EventType) {},
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	t.Cleanup(cli.Close)
+
+	return cli, gate, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), written...)
+	}
+}
+
+func TestSendLanes_WeightedShare(t *testing.T) {
+	cli, gate, written := newGatedClient(t)
+
+	quotes := cli.Lane("quotes", 3)
+	bulk := cli.Lane("bulk", 1)
+
+	// The bulk job saturates its lane before quotes start flowing.
+	for i := 0; i < 100; i++ {
+		bulk.Send(NewDataMessage([]byte("bulk")))
+	}
+	for i := 0; i < 100; i++ {
+		quotes.Send(NewDataMessage([]byte("quote")))
+	}
+
+	for i := 0; i < 41; i++ {
+		gate <- struct{}{}
+	}
+	require.Eventually(t, func() bool { return len(written()) == 41 }, time.Second, time.Millisecond)
+
+	// The first write may have been dispatched before quotes were queued, the next ones follow the weights.
+	counts := map[string]int{}
+	for _, w := range written()[1:] {
+		counts[w]++
+	}
+	require.InDelta(t, 30, counts["quote"], 1)
+	require.InDelta(t, 10, counts["bulk"], 1)
+
+	stats := cli.LaneStats()
+	require.Len(t, stats, 2)
+	require.Equal(t, "quotes", stats[0].Name)
+	require.Equal(t, 3, stats[0].Weight)
+	// One message may be held by the dispatcher, waiting for the connection.
+	require.InDelta(t, 100, int(stats[0].Sent)+stats[0].Depth, 1)
+}
+
+func TestSendLanes_DefaultLaneAndDrops(t *testing.T) {
+	cli, gate, written := newGatedClient(t)
+
+	lane := cli.Lane("orders", 2)
+	cli.Send(NewDataMessage([]byte("unclaimed")))
+	// Control frames bypass the lanes, hence are written from the sending goroutine.
+	go cli.Send(NewPingMessage(nil))
+
+	gate <- struct{}{}
+	gate <- struct{}{}
+	require.Eventually(t, func() bool { return len(written()) == 2 }, time.Second, time.Millisecond)
+	require.ElementsMatch(t, []string{"unclaimed", ""}, written())
+
+	// Saturate the lane: one message is being written, the queue holds the next 256.
+	lane.Send(NewDataMessage([]byte("order")))
+	require.Eventually(t, func() bool { return lane.Stats().Depth == 0 }, time.Second, time.Millisecond)
+	for i := 0; i < defaultLaneQueueSize+1; i++ {
+		lane.Send(NewDataMessage([]byte("order")))
+	}
+	require.Eventually(t, func() bool { return lane.Stats().Depth == defaultLaneQueueSize }, time.Second, time.Millisecond)
+	require.EqualValues(t, 1, lane.Stats().Dropped)
+
+	stats := cli.LaneStats()
+	require.Equal(t, []string{"orders", DefaultLaneName}, []string{stats[0].Name, stats[1].Name})
+	require.EqualValues(t, 1, stats[1].Sent)
+}
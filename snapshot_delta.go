@@ -0,0 +1,179 @@
+package libws
+
+import (
+	"sort"
+	"sync"
+)
+
+// defaultMaxBufferedDeltas bounds the deltas buffered while waiting for a snapshot.
+const defaultMaxBufferedDeltas = 1024
+
+type (
+	// SnapshotDeltaCoordinator maintains a state of type T, typically an order book, from a feed made of snapshots and
+	// sequenced deltas. Deltas arriving before the snapshot are buffered, those the snapshot already covers are
+	// dropped and the rest are applied in sequence order. Afterwards, deltas are applied as they come, skipping stale
+	// ones. Wire Handle as the MessageHandler, or call it from yours, and HandleEvent from the EventHandler so that the
+	// state is reset on reconnection.
+	SnapshotDeltaCoordinator[T any] struct {
+		isSnapshot func(Message) bool
+		isDelta    func(Message) bool
+		seqOf      func(Message) uint64
+		apply      func(T, Message) T
+
+		maxBuffered     int
+		requestSnapshot func(Client)
+
+		mu       sync.Mutex
+		state    T
+		synced   bool
+		lastSeq  uint64
+		buffered []Message
+		// requested tells whether the snapshot has been requested since the last reset
+		requested bool
+		dropped   uint64
+	}
+
+	// SnapshotDeltaOption customizes a SnapshotDeltaCoordinator.
+	SnapshotDeltaOption func(*snapshotDeltaOptions)
+
+	snapshotDeltaOptions struct {
+		maxBuffered     int
+		requestSnapshot func(Client)
+	}
+)
+
+// WithMaxBufferedDeltas bounds the deltas buffered while waiting for a snapshot, 1024 by default. The oldest deltas
+// are dropped first, as the snapshot is more likely to cover them. At least one delta is buffered.
+func WithMaxBufferedDeltas(n int) SnapshotDeltaOption {
+	return func(o *snapshotDeltaOptions) {
+		o.maxBuffered = max(n, 1)
+	}
+}
+
+// WithSnapshotRequest sets a callback asking for a snapshot, e.g. by sending a message through the client or
+// fetching it over REST and passing it to Handle. It is called on the first delta received while out of sync.
+func WithSnapshotRequest(request func(Client)) SnapshotDeltaOption {
+	return func(o *snapshotDeltaOptions) {
+		o.requestSnapshot = request
+	}
+}
+
+// NewSnapshotDeltaCoordinator returns a coordinator recognizing snapshots and deltas with isSnapshot and isDelta,
+// ordering them with seqOf and folding them into the state with apply. Snapshots are applied onto the zero value of T.
+func NewSnapshotDeltaCoordinator[T any](
+	isSnapshot, isDelta func(Message) bool,
+	seqOf func(Message) uint64,
+	apply func(T, Message) T,
+	opts ...SnapshotDeltaOption,
+) *SnapshotDeltaCoordinator[T] {
+	o := snapshotDeltaOptions{maxBuffered: defaultMaxBufferedDeltas}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return &SnapshotDeltaCoordinator[T]{
+		isSnapshot:      isSnapshot,
+		isDelta:         isDelta,
+		seqOf:           seqOf,
+		apply:           apply,
+		maxBuffered:     o.maxBuffered,
+		requestSnapshot: o.requestSnapshot,
+	}
+}
+
+// Handle is the MessageHandler of the coordinator. Messages which are neither snapshots nor deltas are ignored.
+func (c *SnapshotDeltaCoordinator[T]) Handle(cli Client, m Message) {
+	switch {
+	case c.isSnapshot(m):
+		c.applySnapshot(m)
+	case c.isDelta(m):
+		if request := c.applyDelta(m); request && c.requestSnapshot != nil {
+			c.requestSnapshot(cli)
+		}
+	}
+}
+
+// HandleEvent resets the coordinator on reconnection, as deltas may have been lost. Call it from the EventHandler.
+func (c *SnapshotDeltaCoordinator[T]) HandleEvent(_ Client, event EventType) {
+	if event == EventReconnect || event == EventStandbyPromoted {
+		c.Reset()
+	}
+}
+
+// Reset drops the state and any buffered delta, waiting for a new snapshot.
+func (c *SnapshotDeltaCoordinator[T]) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero T
+	c.state = zero
+	c.synced = false
+	c.lastSeq = 0
+	c.buffered = nil
+	c.requested = false
+}
+
+// State returns the current state and whether it is in sync, that is, a snapshot has been applied since the last
+// reset.
+func (c *SnapshotDeltaCoordinator[T]) State() (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state, c.synced
+}
+
+// Dropped returns how many buffered deltas were dropped because the buffer was full.
+func (c *SnapshotDeltaCoordinator[T]) Dropped() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.dropped
+}
+
+func (c *SnapshotDeltaCoordinator[T]) applySnapshot(m Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero T
+	c.state = c.apply(zero, m)
+	c.lastSeq = c.seqOf(m)
+	c.synced = true
+
+	sort.SliceStable(c.buffered, func(i, j int) bool { return c.seqOf(c.buffered[i]) < c.seqOf(c.buffered[j]) })
+	for _, delta := range c.buffered {
+		c.applyInSync(delta)
+	}
+	c.buffered = nil
+}
+
+// applyDelta applies or buffers m, returning whether a snapshot should be requested.
+func (c *SnapshotDeltaCoordinator[T]) applyDelta(m Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.synced {
+		c.applyInSync(m)
+		return false
+	}
+
+	if len(c.buffered) >= c.maxBuffered {
+		c.buffered = c.buffered[1:]
+		c.dropped++
+	}
+	c.buffered = append(c.buffered, m)
+
+	request := !c.requested
+	c.requested = true
+	return request
+}
+
+// applyInSync applies m unless the state already covers it.
+func (c *SnapshotDeltaCoordinator[T]) applyInSync(m Message) {
+	seq := c.seqOf(m)
+	if seq <= c.lastSeq {
+		return
+	}
+
+	c.state = c.apply(c.state, m)
+	c.lastSeq = seq
+}
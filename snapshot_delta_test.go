@@ -0,0 +1,94 @@
+package libws
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test messages are "S:<seq>" snapshots and "D:<seq>" deltas, the state being the list of sequences applied.
+func testSnapshot(seq int) Message { return NewDataMessage([]byte("S:" + strconv.Itoa(seq))) }
+func testDelta(seq int) Message    { return NewDataMessage([]byte("D:" + strconv.Itoa(seq))) }
+
+func newTestCoordinator(opts ...SnapshotDeltaOption) *SnapshotDeltaCoordinator[[]uint64] {
+	seqOf := func(m Message) uint64 {
+		seq, _ := strconv.ParseUint(string(m.Data()[2:]), 10, 64)
+		return seq
+	}
+
+	return NewSnapshotDeltaCoordinator(
+		func(m Message) bool { return bytes.HasPrefix(m.Data(), []byte("S:")) },
+		func(m Message) bool { return bytes.HasPrefix(m.Data(), []byte("D:")) },
+		seqOf,
+		func(state []uint64, m Message) []uint64 { return append(state, seqOf(m)) },
+		opts...,
+	)
+}
+
+func TestSnapshotDeltaCoordinator_DeltasBeforeSnapshot(t *testing.T) {
+	requests := 0
+	c := newTestCoordinator(WithSnapshotRequest(func(Client) { requests++ }))
+
+	// Deltas race ahead of the snapshot, out of order.
+	for _, seq := range []int{9, 11, 10, 12} {
+		c.Handle(nil, testDelta(seq))
+	}
+	state, synced := c.State()
+	require.False(t, synced)
+	require.Empty(t, state)
+	require.Equal(t, 1, requests)
+
+	c.Handle(nil, testSnapshot(10))
+	state, synced = c.State()
+	require.True(t, synced)
+	require.Equal(t, []uint64{10, 11, 12}, state)
+
+	// Live deltas stream through, stale ones are skipped.
+	c.Handle(nil, testDelta(12))
+	c.Handle(nil, testDelta(13))
+	c.Handle(nil, NewPongMessage(nil))
+	state, _ = c.State()
+	require.Equal(t, []uint64{10, 11, 12, 13}, state)
+}
+
+func TestSnapshotDeltaCoordinator_ResetOnReconnect(t *testing.T) {
+	requests := 0
+	c := newTestCoordinator(WithSnapshotRequest(func(Client) { requests++ }))
+
+	c.Handle(nil, testDelta(1))
+	c.Handle(nil, testSnapshot(1))
+	c.Handle(nil, testDelta(2))
+
+	c.HandleEvent(nil, EventReconnect)
+	state, synced := c.State()
+	require.False(t, synced)
+	require.Empty(t, state)
+
+	c.Handle(nil, testDelta(51))
+	c.Handle(nil, testSnapshot(50))
+	state, _ = c.State()
+	require.Equal(t, []uint64{50, 51}, state)
+	require.Equal(t, 2, requests)
+}
+
+func TestSnapshotDeltaCoordinator_BoundedBuffer(t *testing.T) {
+	c := newTestCoordinator(WithMaxBufferedDeltas(2))
+
+	for _, seq := range []int{1, 2, 3, 4} {
+		c.Handle(nil, testDelta(seq))
+	}
+	require.EqualValues(t, 2, c.Dropped())
+
+	c.Handle(nil, testSnapshot(2))
+	state, _ := c.State()
+	require.Equal(t, []uint64{2, 3, 4}, state)
+
+	for _, n := range []int{0, -1} {
+		c := newTestCoordinator(WithMaxBufferedDeltas(n))
+		c.Handle(nil, testDelta(1))
+		c.Handle(nil, testDelta(2))
+		require.EqualValues(t, 1, c.Dropped(), "WithMaxBufferedDeltas(%d)", n)
+	}
+}
@@ -0,0 +1,139 @@
+package libws
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+type (
+	// Batcher merges individual subscription messages into as few messages as the venue allows. Replay paths call
+	// Compact before sending subscriptions again after a reconnection.
+	Batcher interface {
+		Compact(subs []Message) []Message
+	}
+
+	// jsonArrayBatcher merges JSON messages which only differ in the contents of an array, such as
+	// `{"op":"subscribe","args":["t1"]}` and `{"op":"subscribe","args":["t2"]}`.
+	jsonArrayBatcher struct {
+		path     []string
+		maxBatch int
+	}
+
+	jsonBatchGroup struct {
+		envelope map[string]any
+		mt       MessageType
+		items    []any
+	}
+)
+
+// NewJSONArrayBatcher returns a Batcher merging JSON messages whose only difference is the array found at path, a
+// dot-separated list of object keys such as "args" or "params.channels". Merged messages carry at most maxBatch
+// array items. Messages are grouped by everything but the array, so subscribes and unsubscribes are compacted
+// independently and symmetrically. Messages which are not JSON objects or lack the array are kept as is.
+// Note that merged messages are re-encoded, hence their keys are sorted.
+func NewJSONArrayBatcher(path string, maxBatch int) Batcher {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+
+	return &jsonArrayBatcher{path: strings.Split(path, "."), maxBatch: maxBatch}
+}
+
+func (b *jsonArrayBatcher) Compact(subs []Message) []Message {
+	var (
+		byKey = make(map[string]*jsonBatchGroup)
+		// order keeps the relative order between groups and messages left untouched
+		order []any
+	)
+
+	for _, m := range subs {
+		envelope, items, ok := b.split(m)
+		if !ok {
+			order = append(order, m)
+			continue
+		}
+
+		key, err := json.Marshal(envelope)
+		if err != nil {
+			order = append(order, m)
+			continue
+		}
+
+		group, found := byKey[string(key)]
+		if !found {
+			group = &jsonBatchGroup{envelope: envelope, mt: m.Type()}
+			byKey[string(key)] = group
+			order = append(order, group)
+		}
+
+		group.items = append(group.items, items...)
+	}
+
+	res := make([]Message, 0, len(subs))
+	for _, entry := range order {
+		switch v := entry.(type) {
+		case Message:
+			res = append(res, v)
+		case *jsonBatchGroup:
+			res = append(res, b.build(v)...)
+		}
+	}
+
+	return res
+}
+
+// split decodes m and detaches the array at the batcher path from the rest of the message. Numbers are kept as
+// json.Number, for large integers such as ids or nonces to be encoded again as they were.
+func (b *jsonArrayBatcher) split(m Message) (map[string]any, []any, bool) {
+	dec := json.NewDecoder(bytes.NewReader(m.Data()))
+	dec.UseNumber()
+
+	var envelope map[string]any
+	if err := dec.Decode(&envelope); err != nil || dec.More() {
+		return nil, nil, false
+	}
+
+	parent := envelope
+	for _, key := range b.path[:len(b.path)-1] {
+		next, ok := parent[key].(map[string]any)
+		if !ok {
+			return nil, nil, false
+		}
+		parent = next
+	}
+
+	last := b.path[len(b.path)-1]
+	items, ok := parent[last].([]any)
+	if !ok {
+		return nil, nil, false
+	}
+
+	delete(parent, last)
+
+	return envelope, items, true
+}
+
+func (b *jsonArrayBatcher) build(group *jsonBatchGroup) []Message {
+	var res []Message
+
+	// A group made of empty arrays still yields one message.
+	for start := 0; start == 0 || start < len(group.items); start += b.maxBatch {
+		end := min(start+b.maxBatch, len(group.items))
+
+		parent := group.envelope
+		for _, key := range b.path[:len(b.path)-1] {
+			parent = parent[key].(map[string]any)
+		}
+		parent[b.path[len(b.path)-1]] = group.items[start:end]
+
+		bts, err := json.Marshal(group.envelope)
+		if err != nil {
+			continue
+		}
+
+		res = append(res, NewMessage(group.mt, bts))
+	}
+
+	return res
+}
@@ -0,0 +1,113 @@
+package libws
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func topicsOf(t *testing.T, m Message) (string, []string) {
+	t.Helper()
+
+	var payload struct {
+		Op   string   `json:"op"`
+		Args []string `json:"args"`
+	}
+	require.NoError(t, json.Unmarshal(m.Data(), &payload))
+	return payload.Op, payload.Args
+}
+
+func subscriptionMessages(op string, topics ...string) []Message {
+	res := make([]Message, 0, len(topics))
+	for _, topic := range topics {
+		res = append(res, NewDataMessage([]byte(fmt.Sprintf(`{"op":%q,"args":[%q]}`, op, topic))))
+	}
+	return res
+}
+
+func TestJSONArrayBatcher_Compact(t *testing.T) {
+	topics := make([]string, 250)
+	for i := range topics {
+		topics[i] = fmt.Sprintf("trades.%d", i)
+	}
+
+	batcher := NewJSONArrayBatcher("args", 100)
+
+	compacted := batcher.Compact(subscriptionMessages("subscribe", topics...))
+	require.Len(t, compacted, 3)
+
+	var got []string
+	for i, m := range compacted {
+		op, args := topicsOf(t, m)
+		require.Equal(t, "subscribe", op)
+		require.Equal(t, DataMessage, m.Type())
+		if i < 2 {
+			require.Len(t, args, 100)
+		}
+		got = append(got, args...)
+	}
+	require.Equal(t, topics, got)
+
+	// Unsubscribing a member leaves the remaining set compacting the same way.
+	remaining := append(append([]string(nil), topics[:42]...), topics[43:]...)
+	compacted = batcher.Compact(subscriptionMessages("subscribe", remaining...))
+	require.Len(t, compacted, 3)
+
+	got = nil
+	for _, m := range compacted {
+		_, args := topicsOf(t, m)
+		got = append(got, args...)
+	}
+	require.Equal(t, remaining, got)
+	require.NotContains(t, got, topics[42])
+}
+
+func TestJSONArrayBatcher_Symmetric(t *testing.T) {
+	batcher := NewJSONArrayBatcher("args", 2)
+
+	in := append(subscriptionMessages("subscribe", "a", "b", "c"), subscriptionMessages("unsubscribe", "x", "y")...)
+	compacted := batcher.Compact(in)
+	require.Len(t, compacted, 3)
+
+	op, args := topicsOf(t, compacted[0])
+	require.Equal(t, "subscribe", op)
+	require.Equal(t, []string{"a", "b"}, args)
+
+	op, args = topicsOf(t, compacted[1])
+	require.Equal(t, "subscribe", op)
+	require.Equal(t, []string{"c"}, args)
+
+	op, args = topicsOf(t, compacted[2])
+	require.Equal(t, "unsubscribe", op)
+	require.Equal(t, []string{"x", "y"}, args)
+}
+
+func TestJSONArrayBatcher_NestedPathAndPassthrough(t *testing.T) {
+	batcher := NewJSONArrayBatcher("params.channels", 10)
+
+	raw := NewDataMessage([]byte("not json"))
+	in := []Message{
+		NewDataMessage([]byte(`{"method":"sub","params":{"channels":["a"]}}`)),
+		raw,
+		NewDataMessage([]byte(`{"method":"sub","params":{"channels":["b"]}}`)),
+	}
+
+	compacted := batcher.Compact(in)
+	require.Len(t, compacted, 2)
+	require.JSONEq(t, `{"method":"sub","params":{"channels":["a","b"]}}`, string(compacted[0].Data()))
+	require.Equal(t, raw, compacted[1])
+}
+
+func TestJSONArrayBatcher_LargeNumbers(t *testing.T) {
+	batcher := NewJSONArrayBatcher("args", 10)
+
+	compacted := batcher.Compact([]Message{
+		NewDataMessage([]byte(`{"id":9007199254740993,"nonce":1.50,"args":[18446744073709551615]}`)),
+		NewDataMessage([]byte(`{"id":9007199254740993,"nonce":1.50,"args":[9007199254740995]}`)),
+	})
+	require.Len(t, compacted, 1)
+	require.Equal(t, `{"args":[18446744073709551615,9007199254740995],"id":9007199254740993,"nonce":1.50}`,
+		string(compacted[0].Data()))
+}
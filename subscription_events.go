@@ -0,0 +1,142 @@
+package libws
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// SubPending is the state of a subscription just recorded, awaiting its acknowledgement, see
+	// SubscriptionManager.Ack.
+	SubPending SubState = iota
+	// SubActive is the state of a subscription the venue acknowledged.
+	SubActive
+	// SubLost is the state of a subscription removed, failing to be replayed or left unacknowledged for longer than
+	// the ack timeout, see WithSubAckTimeout: what it fed is stale.
+	SubLost
+	// SubReplayed is the state of a subscription sent again, e.g. after a reconnection, awaiting its acknowledgement:
+	// what it fed is to be built again. Connections overlapping during a rotation replay nothing.
+	SubReplayed
+)
+
+type (
+	// SubState is the lifecycle state of a subscription of a SubscriptionManager.
+	SubState int
+
+	// SubEvent tells the subscription recorded under Key entered State, see SubscriptionManager.OnSubEvent.
+	SubEvent struct {
+		Key   string
+		State SubState
+	}
+
+	// subAck is the ack timer of a subscription awaiting its acknowledgement.
+	subAck struct {
+		timer clockTimer
+	}
+)
+
+func (s SubState) String() string {
+	switch s {
+	case SubPending:
+		return "pending"
+	case SubActive:
+		return "active"
+	case SubLost:
+		return "lost"
+	case SubReplayed:
+		return "replayed"
+	default:
+		return fmt.Sprintf("SubState(%d)", int(s))
+	}
+}
+
+// WithSubAckTimeout makes subscriptions pending or replayed for longer than timeout without being acknowledged with
+// SubscriptionManager.Ack lost, see SubLost. Subscriptions wait for their acknowledgement forever by default.
+func WithSubAckTimeout(timeout time.Duration) SubscriptionOption {
+	return func(m *SubscriptionManager) {
+		m.ackTimeout = timeout
+	}
+}
+
+func withSubscriptionClock(clk clock) SubscriptionOption {
+	return func(m *SubscriptionManager) {
+		m.clock = clk
+	}
+}
+
+// OnSubEvent registers listener for the lifecycle events of the subscription recorded under key, e.g. to clear the
+// cache it feeds whenever it is replayed or lost. Listeners are called synchronously, without the manager locked.
+func (m *SubscriptionManager) OnSubEvent(key string, listener func(SubEvent)) {
+	m.events.On(key, listener)
+}
+
+// Ack marks the subscription recorded under key acknowledged by the venue, see SubActive. The library does not tell
+// the acknowledgements of venues apart: call it from the message handler. Unknown keys are ignored.
+func (m *SubscriptionManager) Ack(key string) {
+	m.mu.Lock()
+	state, ok := m.states[key]
+	if !ok || state == SubActive {
+		m.mu.Unlock()
+		return
+	}
+
+	m.stopAckLocked(key)
+	m.states[key] = SubActive
+	m.mu.Unlock()
+
+	m.emit(SubEvent{Key: key, State: SubActive})
+}
+
+// awaitAckLocked moves the subscription recorded under key to state, pending or replayed, arming its ack timer. It
+// must be called with mu held.
+func (m *SubscriptionManager) awaitAckLocked(key string, state SubState) SubEvent {
+	m.states[key] = state
+	m.stopAckLocked(key)
+
+	if m.ackTimeout > 0 {
+		ack := &subAck{}
+		ack.timer = m.clock.AfterFunc(m.ackTimeout, func() { m.ackTimedOut(key, ack) })
+		m.acks[key] = ack
+	}
+
+	return SubEvent{Key: key, State: state}
+}
+
+// loseLocked moves the subscription recorded under key to SubLost, returning the event to emit unless it was lost
+// already. It must be called with mu held.
+func (m *SubscriptionManager) loseLocked(key string) []SubEvent {
+	m.stopAckLocked(key)
+	if m.states[key] == SubLost {
+		return nil
+	}
+
+	m.states[key] = SubLost
+	return []SubEvent{{Key: key, State: SubLost}}
+}
+
+func (m *SubscriptionManager) stopAckLocked(key string) {
+	if ack, ok := m.acks[key]; ok {
+		ack.timer.Stop()
+		delete(m.acks, key)
+	}
+}
+
+// ackTimedOut loses the subscription recorded under key, unless acknowledged, replaced or removed since ack was armed.
+func (m *SubscriptionManager) ackTimedOut(key string, ack *subAck) {
+	m.mu.Lock()
+	if m.acks[key] != ack {
+		m.mu.Unlock()
+		return
+	}
+
+	events := m.loseLocked(key)
+	m.mu.Unlock()
+
+	m.emit(events...)
+}
+
+func (m *SubscriptionManager) emit(events ...SubEvent) {
+	for _, ev := range events {
+		m.events.Emit(ev.Key, ev)
+	}
+}
@@ -0,0 +1,59 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// prioritySender is implemented by clients able to send a message with a given RatePriority.
+type prioritySender interface {
+	sendAs(ctx context.Context, m Message, p RatePriority) error
+}
+
+// ReplaySubscriptions sends subs again through c, e.g. after a reconnection, compacted by batcher unless nil. When c
+// has a RateBudget, see WithRateBudget, subscriptions draw from it with RatePriorityReplay: ahead of the messages
+// sent with Send, behind control frames. It returns the error of ctx when done before every subscription was sent, or
+// the reasons the ones the client refused were rejected, joined, see WithOnSendError. Once they all were sent,
+// OpenSubscribed is reported to the client opening under ctx, if any, see WithOpenProgress.
+func ReplaySubscriptions(ctx context.Context, c Client, subs []Message, batcher Batcher) error {
+	_, err := replaySubscriptions(ctx, c, subs, batcher)
+	return err
+}
+
+// replaySubscriptions replays subs as ReplaySubscriptions does, also returning the reason each of subs was rejected,
+// nil for the ones sent. The reasons are only told apart when subs were not compacted, nor ctx done, nil otherwise.
+func replaySubscriptions(ctx context.Context, c Client, subs []Message, batcher Batcher) ([]error, error) {
+	if batcher != nil {
+		subs = batcher.Compact(subs)
+	}
+
+	rejected := make([]error, len(subs))
+	for i, m := range subs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if s, ok := c.(prioritySender); ok {
+			rejected[i] = s.sendAs(ctx, m, RatePriorityReplay)
+			continue
+		}
+
+		if budget, ok := Handle[*RateBudget](c); ok {
+			if err := budget.Wait(ctx, RatePriorityReplay); err != nil {
+				return nil, err
+			}
+		}
+		c.Send(m)
+	}
+
+	if err := errors.Join(rejected...); err != nil {
+		if batcher != nil {
+			return nil, err
+		}
+		return rejected, err
+	}
+	ReportOpenProgress(ctx, OpenSubscribed, fmt.Sprintf("%d subscriptions", len(subs)))
+
+	return nil, nil
+}
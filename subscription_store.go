@@ -0,0 +1,310 @@
+package libws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// StoredSub is a subscription as persisted by a SubscriptionStore: the message subscribing, under the key the
+	// application tracks it with, e.g. the channel name.
+	StoredSub struct {
+		Key  string      `json:"key"`
+		Type MessageType `json:"type"`
+		Data []byte      `json:"data"`
+	}
+
+	// SubscriptionStore persists the subscriptions of a SubscriptionManager across restarts. Save is given every
+	// subscription on every change, in the order they were added.
+	SubscriptionStore interface {
+		Save(subs []StoredSub) error
+		Load() ([]StoredSub, error)
+	}
+
+	// SubscriptionRewriter rewrites a subscription restored from a SubscriptionStore before it is replayed, e.g. to
+	// give it a fresh nonce. Subscriptions it fails on are dropped.
+	SubscriptionRewriter func(StoredSub) (StoredSub, error)
+
+	// SubscriptionOption customizes the manager returned by NewSubscriptionManager.
+	SubscriptionOption func(*SubscriptionManager)
+
+	// SubscriptionManager keeps the subscriptions in flight, to be sent again after a reconnection, see Replay, and,
+	// with a SubscriptionStore, after a restart. Install it with WithSubscriptionManager, which restores the persisted
+	// subscriptions on Open when enabled with WithRestoredSubscriptions. Retrieve it with
+	// Handle[*SubscriptionManager](client).
+	//
+	// Subscriptions go through lifecycle states, see SubState, told per key to the listeners registered with
+	// OnSubEvent: pending once added, active once acknowledged with Ack, replayed once sent again with Replay, and
+	// lost once removed or, with WithSubAckTimeout, left unacknowledged.
+	SubscriptionManager struct {
+		mu      sync.Mutex
+		subs    []StoredSub
+		store   SubscriptionStore
+		restore bool
+		rewrite SubscriptionRewriter
+		// restored tells whether the stored subscriptions were restored, saving being deferred until then
+		restored bool
+
+		events     *EventEmitterCallback[string, SubEvent]
+		ackTimeout time.Duration
+		clock      clock
+		// states are the lifecycle states of the subscriptions, acks the ack timers of the ones awaiting it
+		states map[string]SubState
+		acks   map[string]*subAck
+	}
+
+	// jsonFileSubscriptionStore is a SubscriptionStore keeping subscriptions as JSON in a file.
+	jsonFileSubscriptionStore struct {
+		path string
+	}
+)
+
+// NewSubscriptionManager returns a manager of no subscription.
+func NewSubscriptionManager(opts ...SubscriptionOption) *SubscriptionManager {
+	m := &SubscriptionManager{
+		events: NewEventEmitter[string, SubEvent](),
+		clock:  realClock{},
+		states: make(map[string]SubState),
+		acks:   make(map[string]*subAck),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// WithSubscriptionStore makes the manager save its subscriptions to store on every change.
+func WithSubscriptionStore(store SubscriptionStore) SubscriptionOption {
+	return func(m *SubscriptionManager) {
+		m.store = store
+	}
+}
+
+// WithRestoredSubscriptions makes the client installing the manager load the subscriptions of its store on Open,
+// ahead of the first connection, rewritten by rewrite unless nil. Changes made before are saved along with them on
+// Open. Restoring is disabled by default, as some venues refuse subscriptions sent again as is.
+func WithRestoredSubscriptions(rewrite SubscriptionRewriter) SubscriptionOption {
+	return func(m *SubscriptionManager) {
+		m.restore = true
+		m.rewrite = rewrite
+	}
+}
+
+// WithSubscriptionManager installs m on the client, see SubscriptionManager.
+func WithSubscriptionManager(m *SubscriptionManager) ClientOption {
+	return func(b *basicClient) {
+		b.subscriptions = registerHandle(b, m)
+	}
+}
+
+// Add records the subscription m under key, replacing the one recorded under the same key, if any, in place, and
+// makes it pending, see SubPending. It returns the error of the store, the subscription being recorded regardless.
+func (m *SubscriptionManager) Add(key string, msg Message) error {
+	m.mu.Lock()
+	err := m.addLocked(StoredSub{Key: key, Type: msg.Type(), Data: msg.Data()})
+	ev := m.awaitAckLocked(key, SubPending)
+	m.mu.Unlock()
+
+	m.emit(ev)
+	return err
+}
+
+func (m *SubscriptionManager) addLocked(sub StoredSub) error {
+	for i := range m.subs {
+		if m.subs[i].Key == sub.Key {
+			m.subs[i] = sub
+			return m.saveLocked()
+		}
+	}
+
+	m.subs = append(m.subs, sub)
+	return m.saveLocked()
+}
+
+// Remove forgets the subscription recorded under key, if any, which is lost, see SubLost. It returns the error of the
+// store.
+func (m *SubscriptionManager) Remove(key string) error {
+	m.mu.Lock()
+	for i := range m.subs {
+		if m.subs[i].Key == key {
+			m.subs = append(m.subs[:i:i], m.subs[i+1:]...)
+			err := m.saveLocked()
+			events := m.loseLocked(key)
+			delete(m.states, key)
+			m.mu.Unlock()
+
+			m.emit(events...)
+			return err
+		}
+	}
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Snapshot returns a copy of the subscriptions recorded, in the order they were added.
+func (m *SubscriptionManager) Snapshot() []StoredSub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]StoredSub(nil), m.subs...)
+}
+
+// Messages returns the messages of the subscriptions recorded, in the order they were added, to be replayed.
+func (m *SubscriptionManager) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msgs := make([]Message, len(m.subs))
+	for i, sub := range m.subs {
+		msgs[i] = NewMessage(sub.Type, sub.Data)
+	}
+	return msgs
+}
+
+// Replay sends the subscriptions recorded through c, see ReplaySubscriptions. They are replayed once sent, see
+// SubReplayed, or lost when rejected, or all of them when Replay fails otherwise, e.g. with ctx done or compacted by
+// batcher.
+func (m *SubscriptionManager) Replay(ctx context.Context, c Client, batcher Batcher) error {
+	m.mu.Lock()
+	keys := make([]string, len(m.subs))
+	msgs := make([]Message, len(m.subs))
+	for i, sub := range m.subs {
+		keys[i] = sub.Key
+		msgs[i] = NewMessage(sub.Type, sub.Data)
+	}
+	m.mu.Unlock()
+
+	rejected, err := replaySubscriptions(ctx, c, msgs, batcher)
+
+	m.mu.Lock()
+	events := make([]SubEvent, 0, len(keys))
+	for i, key := range keys {
+		if _, recorded := m.states[key]; !recorded {
+			// Removed meanwhile.
+			continue
+		}
+		if rejected != nil && rejected[i] != nil || rejected == nil && err != nil {
+			events = append(events, m.loseLocked(key)...)
+		} else {
+			events = append(events, m.awaitAckLocked(key, SubReplayed))
+		}
+	}
+	m.mu.Unlock()
+
+	m.emit(events...)
+	return err
+}
+
+// restoreStored loads the subscriptions of the store, when restoring is enabled, see WithRestoredSubscriptions. The
+// ones recorded meanwhile are kept, taking precedence.
+func (m *SubscriptionManager) restoreStored() error {
+	if !m.restore || m.store == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	restored := m.restored
+	m.mu.Unlock()
+	if restored {
+		return nil
+	}
+
+	stored, err := m.store.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load subscriptions: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recorded := make(map[string]struct{}, len(m.subs))
+	for _, sub := range m.subs {
+		recorded[sub.Key] = struct{}{}
+	}
+
+	subs := make([]StoredSub, 0, len(stored)+len(m.subs))
+	for _, sub := range stored {
+		if _, ok := recorded[sub.Key]; ok {
+			continue
+		}
+		if m.rewrite != nil {
+			if sub, err = m.rewrite(sub); err != nil {
+				continue
+			}
+		}
+		subs = append(subs, sub)
+	}
+	m.subs = append(subs, m.subs...)
+	m.restored = true
+	for _, sub := range subs {
+		if _, ok := m.states[sub.Key]; !ok {
+			m.states[sub.Key] = SubPending
+		}
+	}
+
+	return m.saveLocked()
+}
+
+func (m *SubscriptionManager) saveLocked() error {
+	if m.store == nil || m.restore && !m.restored {
+		return nil
+	}
+
+	if err := m.store.Save(append([]StoredSub(nil), m.subs...)); err != nil {
+		return fmt.Errorf("cannot save subscriptions: %w", err)
+	}
+	return nil
+}
+
+// NewJSONFileSubscriptionStore returns a SubscriptionStore keeping subscriptions as JSON in the file at path. The file
+// is replaced atomically on Save, and loading a missing file yields no subscription.
+func NewJSONFileSubscriptionStore(path string) SubscriptionStore {
+	return &jsonFileSubscriptionStore{path: path}
+}
+
+func (s *jsonFileSubscriptionStore) Save(subs []StoredSub) error {
+	bts, err := json.Marshal(subs)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bts); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *jsonFileSubscriptionStore) Load() ([]StoredSub, error) {
+	bts, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []StoredSub
+	if err := json.Unmarshal(bts, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
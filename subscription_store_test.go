@@ -0,0 +1,239 @@
+package libws
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type failingSubscriptionStore struct {
+	err error
+}
+
+func (s failingSubscriptionStore) Save([]StoredSub) error     { return s.err }
+func (s failingSubscriptionStore) Load() ([]StoredSub, error) { return nil, s.err }
+
+// openWithSubscriptions opens a client over stub handlers installing m, returning the stubs once Open is done.
+func openWithSubscriptions(t *testing.T, m *SubscriptionManager) (*basicClient, *stubConnectionHandlerFactory, error) {
+	t.Helper()
+
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {}, WithSubscriptionManager(m))
+	err := cli.Open(context.Background())
+	t.Cleanup(cli.Close)
+
+	return cli, stubs, err
+}
+
+func TestSubscriptionManager_Restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+
+	before := NewSubscriptionManager(WithSubscriptionStore(NewJSONFileSubscriptionStore(path)))
+	require.NoError(t, before.Add("trades", NewTextMessage([]byte(`{"sub":"trades"}`))))
+	require.NoError(t, before.Add("book", NewTextMessage([]byte(`{"sub":"book"}`))))
+	require.NoError(t, before.Add("ticker", NewBinaryMessage([]byte{0x01, 0x02})))
+	require.NoError(t, before.Remove("book"))
+	require.NoError(t, before.Add("trades", NewTextMessage([]byte(`{"sub":"trades","depth":5}`))))
+
+	after := NewSubscriptionManager(WithSubscriptionStore(NewJSONFileSubscriptionStore(path)), WithRestoredSubscriptions(nil))
+	cli, stubs, err := openWithSubscriptions(t, after)
+	require.NoError(t, err)
+	handle, ok := Handle[*SubscriptionManager](cli)
+	require.True(t, ok)
+	require.Same(t, after, handle)
+	require.Equal(t, before.Snapshot(), after.Snapshot())
+
+	require.NoError(t, after.Replay(context.Background(), cli, nil))
+	require.Equal(t, []Message{
+		NewTextMessage([]byte(`{"sub":"trades","depth":5}`)),
+		NewBinaryMessage([]byte{0x01, 0x02}),
+	}, stubs.Last().Sent())
+}
+
+func TestSubscriptionManager_Restore(t *testing.T) {
+	stored := []StoredSub{
+		{Key: "trades", Type: TextMessage, Data: []byte(`{"sub":"trades","nonce":1}`)},
+		{Key: "book", Type: TextMessage, Data: []byte(`{"sub":"book","nonce":2}`)},
+	}
+
+	testCases := []struct {
+		name     string
+		opts     []SubscriptionOption
+		recorded []StoredSub
+		expected []StoredSub
+	}{
+		{
+			name:     "not restored unless enabled",
+			expected: nil,
+		},
+		{
+			name:     "restored as is",
+			opts:     []SubscriptionOption{WithRestoredSubscriptions(nil)},
+			expected: stored,
+		},
+		{
+			name: "rewritten",
+			opts: []SubscriptionOption{WithRestoredSubscriptions(func(sub StoredSub) (StoredSub, error) {
+				if sub.Key == "book" {
+					return sub, errors.New("expired")
+				}
+				sub.Data = []byte(strings.Replace(string(sub.Data), `"nonce":1`, `"nonce":3`, 1))
+				return sub, nil
+			})},
+			expected: []StoredSub{
+				{Key: "trades", Type: TextMessage, Data: []byte(`{"sub":"trades","nonce":3}`)},
+			},
+		},
+		{
+			name:     "recorded before open take precedence",
+			opts:     []SubscriptionOption{WithRestoredSubscriptions(nil)},
+			recorded: []StoredSub{{Key: "trades", Type: TextMessage, Data: []byte(`{"sub":"trades","nonce":4}`)}},
+			expected: []StoredSub{
+				{Key: "book", Type: TextMessage, Data: []byte(`{"sub":"book","nonce":2}`)},
+				{Key: "trades", Type: TextMessage, Data: []byte(`{"sub":"trades","nonce":4}`)},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "subscriptions.json")
+			store := NewJSONFileSubscriptionStore(path)
+			require.NoError(t, store.Save(stored))
+
+			m := NewSubscriptionManager(append([]SubscriptionOption{WithSubscriptionStore(store)}, tc.opts...)...)
+			for _, sub := range tc.recorded {
+				require.NoError(t, m.Add(sub.Key, NewMessage(sub.Type, sub.Data)))
+			}
+			_, _, err := openWithSubscriptions(t, m)
+			require.NoError(t, err)
+
+			snapshot := m.Snapshot()
+			if len(tc.expected) == 0 {
+				require.Empty(t, snapshot)
+				return
+			}
+			require.Equal(t, tc.expected, snapshot)
+
+			persisted, err := store.Load()
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, persisted)
+		})
+	}
+}
+
+func TestSubscriptionManager_StoreErrors(t *testing.T) {
+	errStore := errors.New("disk full")
+	store := failingSubscriptionStore{err: errStore}
+
+	m := NewSubscriptionManager(WithSubscriptionStore(store))
+	err := m.Add("trades", NewTextMessage([]byte("trades")))
+	require.ErrorIs(t, err, errStore)
+	require.Len(t, m.Snapshot(), 1, "the subscription is recorded regardless")
+
+	m = NewSubscriptionManager(WithSubscriptionStore(store), WithRestoredSubscriptions(nil))
+	require.NoError(t, m.Add("trades", NewTextMessage([]byte("trades"))), "saved ahead of restoring")
+	_, stubs, err := openWithSubscriptions(t, m)
+	require.ErrorIs(t, err, errStore)
+	require.Empty(t, stubs.Handlers(), "connected despite failing to restore")
+}
+
+func TestJSONFileSubscriptionStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewJSONFileSubscriptionStore(filepath.Join(dir, "subscriptions.json"))
+
+	subs, err := store.Load()
+	require.NoError(t, err)
+	require.Empty(t, subs)
+
+	expected := []StoredSub{{Key: "trades", Type: TextMessage, Data: []byte("trades")}}
+	require.NoError(t, store.Save(expected))
+	require.NoError(t, store.Save(expected))
+
+	subs, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, expected, subs)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary files left behind")
+}
+
+func TestSubscriptionManager_SubEvents(t *testing.T) {
+	clk := newFakeClock()
+	m := NewSubscriptionManager(WithSubAckTimeout(5*time.Second), withSubscriptionClock(clk))
+	cli, stubs, err := openWithSubscriptions(t, m)
+	require.NoError(t, err)
+
+	events := map[string][]SubState{}
+	for _, key := range []string{"book", "trades"} {
+		m.OnSubEvent(key, func(ev SubEvent) {
+			require.Equal(t, key, ev.Key)
+			events[key] = append(events[key], ev.State)
+		})
+	}
+
+	// Connected: subscribed and acknowledged.
+	require.NoError(t, m.Add("book", NewTextMessage([]byte(`{"sub":"book"}`))))
+	require.NoError(t, m.Add("trades", NewTextMessage([]byte(`{"sub":"trades"}`))))
+	m.Ack("book")
+	m.Ack("book")
+	m.Ack("trades")
+	m.Ack("unknown")
+	clk.Advance(time.Minute)
+
+	// Reconnected: replayed, only the book is acknowledged again.
+	require.NoError(t, m.Replay(context.Background(), cli, nil))
+	require.Len(t, stubs.Last().Sent(), 2)
+	m.Ack("book")
+	clk.Advance(5 * time.Second)
+	m.Ack("trades")
+
+	// Replay failed, then the book is unsubscribed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, m.Replay(ctx, cli, nil), context.Canceled)
+	require.NoError(t, m.Remove("book"))
+	require.NoError(t, m.Remove("book"))
+
+	require.Equal(t, map[string][]SubState{
+		"book":   {SubPending, SubActive, SubReplayed, SubActive, SubLost},
+		"trades": {SubPending, SubActive, SubReplayed, SubLost, SubActive, SubLost},
+	}, events)
+	require.Zero(t, clk.Pending(), "an ack timer was left running")
+}
+
+func TestSubscriptionManager_ReplayRejected(t *testing.T) {
+	m := NewSubscriptionManager()
+	stubs := &stubConnectionHandlerFactory{}
+	var progress []OpenStage
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {},
+		WithSubscriptionManager(m),
+		WithMaxOutboundSize(20),
+		WithOnSendError(func(Client, Message, error) {}),
+	)
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	states := map[string]SubState{}
+	for _, key := range []string{"book", "trades"} {
+		m.OnSubEvent(key, func(ev SubEvent) { states[ev.Key] = ev.State })
+	}
+	require.NoError(t, m.Add("book", NewTextMessage([]byte(`{"sub":"book","depth":1000}`))))
+	require.NoError(t, m.Add("trades", NewTextMessage([]byte(`{"sub":"trades"}`))))
+
+	ctx := withOpenProgress(context.Background(), &openProgress{fn: func(stage OpenStage, _ string) {
+		progress = append(progress, stage)
+	}})
+	require.ErrorIs(t, m.Replay(ctx, cli, nil), ErrMessageTooLarge)
+
+	require.Len(t, stubs.Last().Sent(), 1)
+	require.Equal(t, map[string]SubState{"book": SubLost, "trades": SubReplayed}, states)
+	require.Empty(t, progress, "subscribed reported despite a rejected subscription")
+}
@@ -0,0 +1,91 @@
+package libws
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// fakeClock is a manually advanced clock. Timers fire synchronously from Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0).UTC()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward and runs, in order, every timer that became due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+
+		if len(c.timers) == 0 || c.timers[0].at.After(target) {
+			break
+		}
+
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.at
+
+		if t.stopped {
+			continue
+		}
+		t.stopped = true
+
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+// Pending returns how many timers are yet to fire.
+func (c *fakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := 0
+	for _, t := range c.timers {
+		if !t.stopped {
+			pending++
+		}
+	}
+	return pending
+}
@@ -1,6 +1,10 @@
 package libws
 
-import "context"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 type mockConnectionHandler struct {
 	ConnectFunc   func(ctx context.Context) error
@@ -42,3 +46,148 @@ type mockMessageHandler struct {
 func (m *mockMessageHandler) HandleMessage(msg Message) {
 	m.HandleMessageFunc(msg)
 }
+
+// stubConnectionHandler is a ConnectionHandler which connects successfully, records every message sent through it
+// and can be killed to simulate a dropped connection.
+type stubConnectionHandler struct {
+	mu        sync.Mutex
+	sent      []Message
+	dropped   []Message
+	recv      []Message
+	closeC    CloseChan
+	closeOnce sync.Once
+	closeErr  error
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	client  Client
+	handler MessageHandler
+
+	connectDelay time.Duration
+	connectErr   error
+}
+
+func newStubConnectionHandler() *stubConnectionHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &stubConnectionHandler{closeC: make(CloseChan), ctx: ctx, cancel: cancel}
+}
+
+func (s *stubConnectionHandler) ConnContext() context.Context { return s.ctx }
+
+// Deliver passes an inbound message to the handler given by the factory, as if it was read from the wire.
+func (s *stubConnectionHandler) Deliver(m Message) {
+	s.handler(s.client, m)
+}
+
+func (s *stubConnectionHandler) Connect(context.Context) error {
+	time.Sleep(s.connectDelay)
+	return s.connectErr
+}
+
+// Send records the message as sent, or as dropped when the handler has been closed.
+func (s *stubConnectionHandler) Send(m Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closeErr != nil {
+		s.dropped = append(s.dropped, m)
+		return
+	}
+	s.sent = append(s.sent, m)
+}
+
+func (s *stubConnectionHandler) Recv(m Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recv = append(s.recv, m)
+}
+
+func (s *stubConnectionHandler) Close() { s.Kill(ErrTerminated) }
+
+// CloseWithErr closes the handler with err as its close reason, see errCloser.
+func (s *stubConnectionHandler) CloseWithErr(err error) { s.Kill(err) }
+
+func (s *stubConnectionHandler) CloseChan() CloseChan { return s.closeC }
+
+func (s *stubConnectionHandler) CloseErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeErr
+}
+
+// Kill closes the handler with the given reason, as if the underlying connection dropped.
+func (s *stubConnectionHandler) Kill(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closeErr = err
+		s.mu.Unlock()
+		s.cancel()
+		close(s.closeC)
+	})
+}
+
+func (s *stubConnectionHandler) Sent() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.sent...)
+}
+
+func (s *stubConnectionHandler) Received() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.recv...)
+}
+
+func (s *stubConnectionHandler) Dropped() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.dropped...)
+}
+
+// stubConnectionHandlerFactory is a ConnectionHandlerFactory creating stubConnectionHandlers and keeping track of them.
+type stubConnectionHandlerFactory struct {
+	mu       sync.Mutex
+	handlers []*stubConnectionHandler
+
+	// ConnectDelay simulates the time taken to dial
+	ConnectDelay time.Duration
+	// FailConnects is the number of handlers, the first ones, failing to connect with ConnectErr
+	FailConnects int
+	ConnectErr   error
+	// FirstGeneration, when set, is the connection generation the context of the first handler carries, the next
+	// handlers carrying the following ones, see ConnContext
+	FirstGeneration uint64
+}
+
+func (f *stubConnectionHandlerFactory) Factory(client Client, handler MessageHandler, _ emitter[EventType, EventType]) ConnectionHandler {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h := newStubConnectionHandler()
+	h.client = client
+	h.handler = handler
+	h.connectDelay = f.ConnectDelay
+	if len(f.handlers) < f.FailConnects {
+		h.connectErr = f.ConnectErr
+	}
+	if f.FirstGeneration > 0 {
+		h.ctx = withConnGeneration(h.ctx, f.FirstGeneration+uint64(len(f.handlers)))
+	}
+	f.handlers = append(f.handlers, h)
+	return h
+}
+
+func (f *stubConnectionHandlerFactory) Handlers() []*stubConnectionHandler {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*stubConnectionHandler(nil), f.handlers...)
+}
+
+func (f *stubConnectionHandlerFactory) Last() *stubConnectionHandler {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.handlers) == 0 {
+		return nil
+	}
+	return f.handlers[len(f.handlers)-1]
+}
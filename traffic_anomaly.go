@@ -0,0 +1,119 @@
+package libws
+
+import (
+	"fmt"
+	"sync"
+)
+
+type (
+	// TrafficMix counts inbound frames per kind over a window.
+	TrafficMix struct {
+		Text      uint64
+		Binary    uint64
+		Control   uint64
+		Synthetic uint64
+	}
+
+	// TrafficAnomaly describes a shift of the mix of inbound frames from a window to the next one, see
+	// WithTrafficAnomalyDetection.
+	TrafficAnomaly struct {
+		Before TrafficMix
+		After  TrafficMix
+		// Shift is the largest change of the share of a kind of frames between both windows, from 0 to 1.
+		Shift float64
+	}
+
+	// trafficAnomalyDetector compares the mix of inbound frames of consecutive windows of a fixed number of frames.
+	trafficAnomalyDetector struct {
+		mu        sync.Mutex
+		window    uint64
+		threshold float64
+		current   TrafficMix
+		previous  TrafficMix
+		// last is the latest anomaly detected, nil until one is
+		last *TrafficAnomaly
+	}
+)
+
+func newTrafficAnomalyDetector(window int, threshold float64) *trafficAnomalyDetector {
+	return &trafficAnomalyDetector{window: uint64(window), threshold: threshold}
+}
+
+// observe accounts for m in the current window. When m completes a window whose mix shifted beyond the threshold
+// from the previous one, the anomaly is returned.
+func (d *trafficAnomalyDetector) observe(m Message) (TrafficAnomaly, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch t := m.Type(); {
+	case t.IsText():
+		d.current.Text++
+	case t.IsBinary():
+		d.current.Binary++
+	case t.IsControl():
+		d.current.Control++
+	default:
+		d.current.Synthetic++
+	}
+
+	if d.current.Total() < d.window {
+		return TrafficAnomaly{}, false
+	}
+
+	before, after := d.previous, d.current
+	d.previous, d.current = d.current, TrafficMix{}
+	if before.Total() == 0 {
+		return TrafficAnomaly{}, false
+	}
+
+	shift := after.shiftFrom(before)
+	if shift <= d.threshold {
+		return TrafficAnomaly{}, false
+	}
+
+	anomaly := TrafficAnomaly{Before: before, After: after, Shift: shift}
+	d.last = &anomaly
+	return anomaly, true
+}
+
+// lastAnomaly returns the latest anomaly detected, if any.
+func (d *trafficAnomalyDetector) lastAnomaly() (TrafficAnomaly, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last == nil {
+		return TrafficAnomaly{}, false
+	}
+	return *d.last, true
+}
+
+// Total returns the number of frames counted.
+func (m TrafficMix) Total() uint64 {
+	return m.Text + m.Binary + m.Control + m.Synthetic
+}
+
+// shiftFrom returns the largest change of the share of a kind of frames from before to m.
+func (m TrafficMix) shiftFrom(before TrafficMix) float64 {
+	share := func(n, total uint64) float64 { return float64(n) / float64(total) }
+	mt, bt := m.Total(), before.Total()
+
+	var shift float64
+	for _, kind := range [][2]uint64{
+		{m.Text, before.Text},
+		{m.Binary, before.Binary},
+		{m.Control, before.Control},
+		{m.Synthetic, before.Synthetic},
+	} {
+		d := share(kind[0], mt) - share(kind[1], bt)
+		shift = max(shift, d, -d)
+	}
+	return shift
+}
+
+func (m TrafficMix) String() string {
+	total := max(m.Total(), 1)
+	pct := func(n uint64) float64 { return 100 * float64(n) / float64(total) }
+
+	return fmt.Sprintf("text=%.0f%% binary=%.0f%% control=%.0f%% synthetic=%.0f%%",
+		pct(m.Text), pct(m.Binary), pct(m.Control), pct(m.Synthetic))
+}
@@ -0,0 +1,59 @@
+package libws
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_TrafficAnomaly(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	var anomalies atomic.Int32
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(_ Client, event EventType) {
+		if event == EventTrafficAnomaly {
+			anomalies.Add(1)
+		}
+	}, WithTrafficAnomalyDetection(10, 0.5))
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	deliver := func(n int, m Message) {
+		for range n {
+			stubs.Last().Deliver(m)
+		}
+	}
+	text, binary := NewDataMessage([]byte("{}")), NewBinaryMessage([]byte{0})
+
+	// Two windows of text frames with a few pongs: a steady mix.
+	deliver(9, text)
+	deliver(1, NewPongMessage(nil))
+	deliver(8, text)
+	deliver(2, NewPongMessage(nil))
+	_, ok := cli.LastTrafficAnomaly()
+	require.False(t, ok)
+
+	// Binary frames take over: 60% of the third window, then all of them.
+	deliver(4, text)
+	deliver(6, binary)
+	deliver(20, binary)
+
+	require.EqualValues(t, 1, anomalies.Load())
+	anomaly, ok := cli.LastTrafficAnomaly()
+	require.True(t, ok)
+	require.Equal(t, TrafficMix{Text: 8, Control: 2}, anomaly.Before)
+	require.Equal(t, TrafficMix{Text: 4, Binary: 6}, anomaly.After)
+	require.InDelta(t, 0.6, anomaly.Shift, 1e-9)
+}
+
+func TestClient_TrafficAnomalyOffByDefault(t *testing.T) {
+	stubs := &stubConnectionHandlerFactory{}
+	cli := newBasicClient(stubs.Factory, func(Client, Message) {}, func(Client, EventType) {})
+	require.NoError(t, cli.Open(context.Background()))
+	defer cli.Close()
+
+	stubs.Last().Deliver(NewBinaryMessage(nil))
+	_, ok := cli.LastTrafficAnomaly()
+	require.False(t, ok)
+}